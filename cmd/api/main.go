@@ -3,47 +3,107 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
-	"os/signal"
-	"syscall"
-	"time"
+	"os"
+	"strconv"
 
+	"google.golang.org/grpc"
+
+	"langlite-ingestion/internal/lifecycle"
+	"langlite-ingestion/internal/logging"
 	"langlite-ingestion/internal/server"
+	"langlite-ingestion/internal/telemetry"
 )
 
-func gracefulShutdown(apiServer *http.Server, done chan bool) {
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
-
-	<-ctx.Done()
-
-	log.Println("shutting down gracefully, press Ctrl+C again to force")
-	stop()
+// stopGRPC lets in-flight calls finish until ctx is done, then cancels them.
+func stopGRPC(ctx context.Context, grpcServer *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := apiServer.Shutdown(ctx); err != nil {
-		log.Printf("Server forced to shutdown with error: %v", err)
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		grpcServer.Stop()
+		return fmt.Errorf("gRPC calls still running, forced to stop: %w", ctx.Err())
 	}
+}
 
-	log.Println("Server exiting")
+// role reads LANGLITE_RUN_WORKERS: set it to false to serve only the API and
+// leave the queue to cmd/worker processes.
+func role() server.Role {
+	v := os.Getenv("LANGLITE_RUN_WORKERS")
+	if v == "" {
+		return server.RoleAll
+	}
 
-	done <- true
+	run, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("Invalid LANGLITE_RUN_WORKERS, running workers", "value", v)
+		return server.RoleAll
+	}
+	if !run {
+		return server.RoleAPI
+	}
+	return server.RoleAll
 }
 
 func main() {
-	server := server.NewServer()
+	logging.Setup()
+
+	// Shutdown runs in reverse: HTTP and gRPC stop first, the workers
+	// drain, and tracing flushes last so it sees every other step.
+	lc := lifecycle.New()
+
+	shutdownTracing, err := telemetry.Setup(context.Background())
+	if err != nil {
+		slog.Error("Failed to set up tracing, continuing without it", "error", err)
+	} else {
+		lc.OnShutdown("tracing", shutdownTracing)
+	}
+
+	srv := server.NewServer(lc, role())
+
+	apiServer := srv.HTTPServer()
+	grpcServer := srv.GRPCServer()
+
+	lc.OnShutdown("grpc", func(ctx context.Context) error { return stopGRPC(ctx, grpcServer) })
+	lc.OnShutdown("http", apiServer.Shutdown)
+
+	// Bind before serving HTTP so a taken port stops the process cleanly
+	// instead of leaving it up without gRPC.
+	lis, err := net.Listen("tcp", srv.GRPCAddr())
+	if err != nil {
+		slog.Error("Failed to listen for gRPC, exiting", "addr", srv.GRPCAddr(), "error", err)
+		_ = lc.Shutdown(context.Background())
+		os.Exit(1)
+	}
 
 	done := make(chan bool, 1)
 
-	go gracefulShutdown(server, done)
+	go lc.ShutdownOnSignal(done)
 
-	err := server.ListenAndServe()
+	go func() {
+		// Serve only returns an error when the listener fails; after
+		// GracefulStop or Stop it returns nil.
+		if err := grpcServer.Serve(lis); err != nil {
+			slog.Error("gRPC server failed, shutting down", "error", err)
+			_ = lc.Shutdown(context.Background())
+			os.Exit(1)
+		}
+	}()
+
+	err = apiServer.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
 		panic(fmt.Sprintf("http server error: %s", err))
 	}
 
 	<-done
-	log.Println("Graceful shutdown complete.")
+
+	slog.Info("Graceful shutdown complete")
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"langlite-ingestion/internal/lifecycle"
+	"langlite-ingestion/internal/logging"
+	"langlite-ingestion/internal/server"
+	"langlite-ingestion/internal/telemetry"
+)
+
+// The worker process runs the queue workers without the ingest API, so the
+// two can be scaled separately. It serves probes, /metrics and /worker-status
+// on PORT.
+func main() {
+	logging.Setup()
+
+	lc := lifecycle.New()
+
+	shutdownTracing, err := telemetry.Setup(context.Background())
+	if err != nil {
+		slog.Error("Failed to set up tracing, continuing without it", "error", err)
+	} else {
+		lc.OnShutdown("tracing", shutdownTracing)
+	}
+
+	srv := server.NewServer(lc, server.RoleWorker)
+	if !srv.WorkersRunning() {
+		slog.Error("Workers need a queue backend, exiting")
+		_ = lc.Shutdown(context.Background())
+		os.Exit(1)
+	}
+
+	httpServer := srv.HTTPServer()
+	lc.OnShutdown("http", httpServer.Shutdown)
+
+	done := make(chan bool, 1)
+
+	go lc.ShutdownOnSignal(done)
+
+	err = httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		panic(fmt.Sprintf("http server error: %s", err))
+	}
+
+	<-done
+
+	slog.Info("Graceful shutdown complete")
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (s *service) RecordAudit(entry AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+
+	query := `INSERT INTO audit_log (id, created_at, actor_type, actor_id, project_id, action, target, request_id, ip, outcome, status_code)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), $10, NULLIF($11, 0))`
+
+	_, err := s.db.ExecContext(ctx, query, entry.ID, entry.CreatedAt, entry.ActorType, entry.ActorID, entry.ProjectID,
+		entry.Action, entry.Target, entry.RequestID, entry.IP, entry.Outcome, entry.StatusCode)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// ListAuditLog returns audit entries matching q, newest first.
+func (s *service) ListAuditLog(q AuditQuery) ([]AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conditions := []string{"created_at >= $1", "created_at < $2"}
+	args := []any{q.From, q.To}
+
+	filters := []struct {
+		column string
+		value  string
+	}{
+		{"project_id", q.ProjectID},
+		{"actor_id", q.ActorID},
+		{"action", q.Action},
+		{"target", q.Target},
+		{"outcome", q.Outcome},
+	}
+	for _, f := range filters {
+		if f.value == "" {
+			continue
+		}
+		args = append(args, f.value)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", f.column, len(args)))
+	}
+
+	args = append(args, q.Limit, q.Offset)
+	query := fmt.Sprintf(`SELECT id, created_at, actor_type, COALESCE(actor_id, ''), COALESCE(project_id, ''), action,
+			COALESCE(target, ''), COALESCE(request_id, ''), COALESCE(ip, ''), outcome, COALESCE(status_code, 0)
+		FROM audit_log
+		WHERE %s
+		ORDER BY created_at DESC, id
+		LIMIT $%d OFFSET $%d`, strings.Join(conditions, " AND "), len(args)-1, len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.ActorType, &e.ActorID, &e.ProjectID, &e.Action,
+			&e.Target, &e.RequestID, &e.IP, &e.Outcome, &e.StatusCode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list audit log: %w", err)
+	}
+
+	return entries, nil
+}
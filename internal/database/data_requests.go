@@ -0,0 +1,423 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// redactedText replaces free text that can't be nulled when anonymising.
+const redactedText = "[redacted]"
+
+// erasureBatchSize is how many traces an erasure handles per transaction.
+const erasureBatchSize = 500
+
+const dataRequestColumns = `id, project_id, kind, COALESCE(mode, ''), COALESCE(user_id, ''), subject_hash,
+	status, COALESCE(requested_by, ''), receipt, COALESCE(error, ''), created_at, started_at, completed_at`
+
+func scanDataRequest(row rowScanner) (DataRequest, error) {
+	var req DataRequest
+	var receipt []byte
+	var startedAt, completedAt sql.NullTime
+
+	err := row.Scan(&req.ID, &req.ProjectID, &req.Kind, &req.Mode, &req.UserID, &req.SubjectHash,
+		&req.Status, &req.RequestedBy, &receipt, &req.Error, &req.CreatedAt, &startedAt, &completedAt)
+	if err != nil {
+		return req, err
+	}
+
+	if receipt != nil {
+		req.Receipt = &DataRequestReceipt{}
+		if err := json.Unmarshal(receipt, req.Receipt); err != nil {
+			return req, fmt.Errorf("failed to unmarshal receipt: %w", err)
+		}
+	}
+	if startedAt.Valid {
+		req.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		req.CompletedAt = &completedAt.Time
+	}
+
+	return req, nil
+}
+
+func (s *service) CreateDataRequest(req DataRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if req.CreatedAt.IsZero() {
+		req.CreatedAt = time.Now().UTC()
+	}
+
+	query := `INSERT INTO data_requests (id, project_id, kind, mode, user_id, subject_hash, status, requested_by, created_at, started_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, NULLIF($8, ''), $9, $10)`
+
+	_, err := s.db.ExecContext(ctx, query, req.ID, req.ProjectID, req.Kind, req.Mode, req.UserID,
+		req.SubjectHash, req.Status, req.RequestedBy, req.CreatedAt, req.StartedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create data request: %w", err)
+	}
+
+	return nil
+}
+
+func (s *service) GetDataRequest(projectID, requestID string) (*DataRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + dataRequestColumns + ` FROM data_requests WHERE id = $1 AND project_id = $2`
+
+	req, err := scanDataRequest(s.db.QueryRowContext(ctx, query, requestID, projectID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDataRequestNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data request: %w", err)
+	}
+
+	return &req, nil
+}
+
+// ListDataRequests returns the project's data requests, newest first.
+func (s *service) ListDataRequests(projectID string, limit, offset int) ([]DataRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + dataRequestColumns + `
+		FROM data_requests
+		WHERE project_id = $1
+		ORDER BY created_at DESC, id
+		LIMIT $2 OFFSET $3`
+
+	rows, err := s.db.QueryContext(ctx, query, projectID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data requests: %w", err)
+	}
+	defer rows.Close()
+
+	requests := []DataRequest{}
+	for rows.Next() {
+		req, err := scanDataRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan data request: %w", err)
+		}
+		requests = append(requests, req)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list data requests: %w", err)
+	}
+
+	return requests, nil
+}
+
+// FinishDataRequest records the outcome of a data request: completed with its
+// receipt, or failed with failure. A completed erasure forgets the user id.
+func (s *service) FinishDataRequest(requestID string, receipt DataRequestReceipt, failure error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	receiptJSON, err := json.Marshal(receipt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal receipt: %w", err)
+	}
+
+	status, message := DataRequestCompleted, ""
+	if failure != nil {
+		status, message = DataRequestFailed, failure.Error()
+	}
+
+	query := `UPDATE data_requests SET
+			status = $2,
+			receipt = $3,
+			error = NULLIF($4, ''),
+			user_id = CASE WHEN $2 = 'completed' AND kind = 'erasure' THEN NULL ELSE user_id END,
+			completed_at = NOW()
+		WHERE id = $1`
+
+	_, err = s.db.ExecContext(ctx, query, requestID, status, receiptJSON, message)
+	if err != nil {
+		return fmt.Errorf("failed to finish data request: %w", err)
+	}
+
+	return nil
+}
+
+// ExportUserData emits everything stored for an end user as JSON objects:
+// each "trace" row holds the trace with its spans, generations, events and
+// scores, followed by the user's "session" rows. It returns ErrUserNotFound,
+// before emitting anything, if the user has neither.
+func (s *service) ExportUserData(projectID, userID string, emit func(section string, row json.RawMessage) error) (DataRequestReceipt, error) {
+	var receipt DataRequestReceipt
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT
+			EXISTS(SELECT 1 FROM traces WHERE project_id = $1 AND user_id = $2)
+			OR EXISTS(SELECT 1 FROM sessions WHERE project_id = $1 AND user_id = $2)`,
+		projectID, userID).Scan(&exists)
+	if err != nil {
+		return receipt, fmt.Errorf("failed to check user existence: %w", err)
+	}
+	if !exists {
+		return receipt, ErrUserNotFound
+	}
+
+	tracesQuery := `SELECT json_build_object(
+			'trace', to_jsonb(t),
+			'spans', COALESCE((SELECT json_agg(s ORDER BY s.start_time) FROM spans s WHERE s.trace_id = t.id), '[]'),
+			'generations', COALESCE((SELECT json_agg(g ORDER BY g.start_time) FROM generations g WHERE g.trace_id = t.id), '[]'),
+			'events', COALESCE((SELECT json_agg(e ORDER BY e.timestamp) FROM events e WHERE e.trace_id = t.id), '[]'),
+			'scores', COALESCE((SELECT json_agg(sc ORDER BY sc.timestamp) FROM scores sc
+				WHERE sc.trace_id = t.id
+				   OR sc.generation_id IN (SELECT id FROM generations WHERE trace_id = t.id)), '[]')
+		), (SELECT COUNT(*) FROM spans WHERE trace_id = t.id),
+		(SELECT COUNT(*) FROM generations WHERE trace_id = t.id),
+		(SELECT COUNT(*) FROM events WHERE trace_id = t.id),
+		(SELECT COUNT(*) FROM scores sc
+			WHERE sc.trace_id = t.id
+			   OR sc.generation_id IN (SELECT id FROM generations WHERE trace_id = t.id))
+		FROM traces t
+		WHERE t.project_id = $1 AND t.user_id = $2
+		ORDER BY t.start_time, t.id`
+
+	rows, err := s.db.QueryContext(ctx, tracesQuery, projectID, userID)
+	if err != nil {
+		return receipt, fmt.Errorf("failed to export traces: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row []byte
+		var spans, generations, events, scores int64
+		if err := rows.Scan(&row, &spans, &generations, &events, &scores); err != nil {
+			return receipt, fmt.Errorf("failed to scan exported trace: %w", err)
+		}
+		if err := emit("trace", row); err != nil {
+			return receipt, err
+		}
+
+		receipt.Traces++
+		receipt.Spans += spans
+		receipt.Generations += generations
+		receipt.Events += events
+		receipt.Scores += scores
+	}
+
+	if err := rows.Err(); err != nil {
+		return receipt, fmt.Errorf("failed to export traces: %w", err)
+	}
+
+	sessionRows, err := s.db.QueryContext(ctx, `SELECT to_jsonb(s) FROM sessions s
+		WHERE s.project_id = $1 AND s.user_id = $2
+		ORDER BY s.first_seen, s.id`, projectID, userID)
+	if err != nil {
+		return receipt, fmt.Errorf("failed to export sessions: %w", err)
+	}
+	defer sessionRows.Close()
+
+	for sessionRows.Next() {
+		var row []byte
+		if err := sessionRows.Scan(&row); err != nil {
+			return receipt, fmt.Errorf("failed to scan exported session: %w", err)
+		}
+		if err := emit("session", row); err != nil {
+			return receipt, err
+		}
+		receipt.Sessions++
+	}
+
+	if err := sessionRows.Err(); err != nil {
+		return receipt, fmt.Errorf("failed to export sessions: %w", err)
+	}
+
+	return receipt, nil
+}
+
+// EraseUserData carries out an erasure request, deleting or anonymising the
+// user's traces and everything under them in batches, and records the outcome
+// on the request. Running it again after a failure picks up where it stopped.
+func (s *service) EraseUserData(requestID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + dataRequestColumns + ` FROM data_requests WHERE id = $1 AND kind = 'erasure'`
+
+	req, err := scanDataRequest(s.db.QueryRowContext(ctx, query, requestID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDataRequestNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get data request: %w", err)
+	}
+	if req.Status == DataRequestCompleted {
+		return nil
+	}
+
+	_, err = s.db.ExecContext(ctx, `UPDATE data_requests
+		SET status = 'running', started_at = COALESCE(started_at, NOW()), error = NULL
+		WHERE id = $1`, requestID)
+	if err != nil {
+		return fmt.Errorf("failed to start erasure: %w", err)
+	}
+
+	// A retry adds to what earlier attempts already erased.
+	var receipt DataRequestReceipt
+	if req.Receipt != nil {
+		receipt = *req.Receipt
+	}
+
+	err = s.eraseTraces(req.ProjectID, req.UserID, req.Mode, &receipt)
+	if err == nil {
+		err = s.eraseSessions(req.ProjectID, req.UserID, req.Mode, &receipt)
+	}
+
+	if finishErr := s.FinishDataRequest(requestID, receipt, err); finishErr != nil {
+		return errors.Join(err, finishErr)
+	}
+
+	return err
+}
+
+func (s *service) eraseTraces(projectID, userID, mode string, receipt *DataRequestReceipt) error {
+	for {
+		n, err := s.eraseBatch(projectID, userID, mode, receipt)
+		if err != nil {
+			return err
+		}
+		if n < erasureBatchSize {
+			return nil
+		}
+	}
+}
+
+// eraseBatch deletes or anonymises up to erasureBatchSize of the user's
+// traces in one transaction and adds what it touched to receipt.
+func (s *service) eraseBatch(projectID, userID, mode string, receipt *DataRequestReceipt) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var idsJSON []byte
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(json_agg(id), '[]') FROM (
+			SELECT id FROM traces WHERE project_id = $1 AND user_id = $2
+			ORDER BY id LIMIT $3 FOR UPDATE
+		) batch`, projectID, userID, erasureBatchSize).Scan(&idsJSON)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select traces: %w", err)
+	}
+
+	var ids []string
+	if err := json.Unmarshal(idsJSON, &ids); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal trace ids: %w", err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// Scores outlive their trace unless removed explicitly, and must be
+	// matched through generations before those go.
+	scores := `trace_id = ANY($1) OR generation_id IN (SELECT id FROM generations WHERE trace_id = ANY($1))`
+
+	var batch DataRequestReceipt
+	if mode == ErasureAnonymize {
+		steps := []struct {
+			count *int64
+			query string
+		}{
+			{&batch.Scores, `UPDATE scores SET comment = NULL, metadata = NULL WHERE ` + scores},
+			{&batch.Events, `UPDATE events SET message = '` + redactedText + `', metadata = NULL WHERE trace_id = ANY($1)`},
+			{&batch.Generations, `UPDATE generations SET input = '` + redactedText + `', output = NULL, metadata = NULL, updated_at = NOW() WHERE trace_id = ANY($1)`},
+			{&batch.Spans, `UPDATE spans SET metadata = NULL, updated_at = NOW() WHERE trace_id = ANY($1)`},
+			{&batch.Traces, `UPDATE traces SET user_id = NULL, metadata = NULL, updated_at = NOW() WHERE id = ANY($1)`},
+		}
+		for _, step := range steps {
+			if *step.count, err = execCount(ctx, tx, step.query, ids); err != nil {
+				return 0, fmt.Errorf("failed to anonymise user data: %w", err)
+			}
+		}
+	} else {
+		// Spans, generations and events cascade from their trace, so they
+		// are counted rather than deleted.
+		err = tx.QueryRowContext(ctx, `SELECT
+				(SELECT COUNT(*) FROM spans WHERE trace_id = ANY($1)),
+				(SELECT COUNT(*) FROM generations WHERE trace_id = ANY($1)),
+				(SELECT COUNT(*) FROM events WHERE trace_id = ANY($1))`, ids).
+			Scan(&batch.Spans, &batch.Generations, &batch.Events)
+		if err != nil {
+			return 0, fmt.Errorf("failed to count user data: %w", err)
+		}
+		if batch.Scores, err = execCount(ctx, tx, `DELETE FROM scores WHERE `+scores, ids); err != nil {
+			return 0, fmt.Errorf("failed to delete scores: %w", err)
+		}
+		rows, err := tx.QueryContext(ctx, `DELETE FROM traces WHERE id = ANY($1) RETURNING id, session_id`, ids)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete traces: %w", err)
+		}
+		deleted, sessionIDs, err := scanPurged(rows)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete traces: %w", err)
+		}
+		batch.Traces = int64(len(deleted))
+
+		// Sessions shared with other users keep their remaining traces'
+		// totals; sessions left empty are erased with the traces.
+		if batch.Sessions, err = recomputeSessions(ctx, tx, projectID, sessionIDs); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit erasure batch: %w", err)
+	}
+
+	receipt.Traces += batch.Traces
+	receipt.Spans += batch.Spans
+	receipt.Generations += batch.Generations
+	receipt.Events += batch.Events
+	receipt.Scores += batch.Scores
+	receipt.Sessions += batch.Sessions
+
+	return len(ids), nil
+}
+
+func execCount(ctx context.Context, tx *sql.Tx, query string, args ...any) (int64, error) {
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *service) eraseSessions(projectID, userID, mode string, receipt *DataRequestReceipt) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := `DELETE FROM sessions WHERE project_id = $1 AND user_id = $2`
+	if mode == ErasureAnonymize {
+		query = `UPDATE sessions SET user_id = NULL, updated_at = NOW() WHERE project_id = $1 AND user_id = $2`
+	}
+
+	res, err := s.db.ExecContext(ctx, query, projectID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to erase sessions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to erase sessions: %w", err)
+	}
+	receipt.Sessions += n
+
+	return nil
+}
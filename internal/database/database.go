@@ -4,15 +4,21 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/XSAM/otelsql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/joho/godotenv/autoload"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 // Service represents a service that interacts with a database.
@@ -21,20 +27,92 @@ type Service interface {
 	CreateTrace(TraceRequest) error
 	CreateGeneration(GenerationRequest) error
 	CreateSpan(SpanRequest) error
-	UpdateSpan(spanID string, req SpanUpdateRequest) error
-	TraceExists(traceID string) bool
-	SpanExists(spanID string) bool
+	UpdateSpan(projectID, spanID string, req SpanUpdateRequest) error
+	TraceExists(projectID, traceID string) bool
+	SpanExists(projectID, spanID string) bool
 	CreateEvent(EventRequest) error
 	CreateScore(ScoreRequest) error
-	GenerationExists(generationID string) bool
+	GenerationExists(projectID, generationID string) bool
+
+	ListSessions(projectID string, opts SessionListOptions) ([]Session, error)
+	GetSession(projectID, sessionID string) (*SessionDetail, error)
+
+	ListUsers(projectID string, opts UserListOptions) ([]UserSummary, error)
+	GetUserMetrics(projectID, userID string, opts UserMetricsOptions) ([]UserMetricsBucket, error)
+
+	RefreshUsageRollups() (int, error)
+	QueryTimeseries(projectID string, q TimeseriesQuery) ([]TimeseriesRow, error)
+
+	GetRetentionSettings(projectID string) (RetentionSettings, error)
+	SetRetentionSettings(projectID string, settings RetentionSettings) error
+	ListRetentionPolicies() ([]RetentionPolicy, error)
+	PurgeExpired(projectID, entity string, before time.Time, limit int) (int64, error)
+
+	GetEnrichmentSettings(projectID string) (*EnrichmentSettings, error)
+	SetEnrichmentSettings(projectID string, settings EnrichmentSettings) error
+	SetTraceEnrichment(projectID, traceID string, enrichment map[string]any) error
+
+	CreateDataRequest(req DataRequest) error
+	GetDataRequest(projectID, requestID string) (*DataRequest, error)
+	ListDataRequests(projectID string, limit, offset int) ([]DataRequest, error)
+	FinishDataRequest(requestID string, receipt DataRequestReceipt, failure error) error
+	ExportUserData(projectID, userID string, emit func(section string, row json.RawMessage) error) (DataRequestReceipt, error)
+	EraseUserData(requestID string) error
+
+	CreateWebhook(w Webhook) error
+	GetWebhook(projectID, webhookID string) (*Webhook, error)
+	ListWebhooks(projectID string) ([]Webhook, error)
+	UpdateWebhook(projectID, webhookID string, req WebhookRequest) (*Webhook, error)
+	DeleteWebhook(projectID, webhookID string) error
+	RecordWebhookDelivery(d WebhookDelivery, disableAfter int) (bool, error)
+	ListWebhookDeliveries(projectID, webhookID string, limit, offset int) ([]WebhookDelivery, error)
+	PurgeWebhookDeliveries(before time.Time) (int64, error)
+
+	RecordAudit(entry AuditEntry) error
+	ListAuditLog(q AuditQuery) ([]AuditEntry, error)
+
+	EnsurePartitions(from time.Time, monthsAhead int) ([]string, error)
+	DropPartitionsBefore(cutoff time.Time) ([]string, error)
 
 	ValidateAPIKey(keyHash string) (*APIKey, error)
 	UpdateAPIKeyLastUsed(keyID string) error
 	GetProject(projectID string) (*Project, error)
 
+	// DB is the connection pool, for packages that keep tables of their
+	// own, such as the Postgres queue backend.
+	DB() *sql.DB
+
 	Close() error
 }
 
+// ErrSpanNotFound is returned by UpdateSpan when the span doesn't exist in
+// the project.
+var ErrSpanNotFound = errors.New("span not found")
+
+// ErrTraceNotFound is returned by SetTraceEnrichment when the trace doesn't
+// exist in the project, or hasn't been stored yet.
+var ErrTraceNotFound = errors.New("trace not found")
+
+// ErrAlreadyExists is returned when creating a trace, generation, span, event
+// or score whose id is already taken.
+var ErrAlreadyExists = errors.New("already exists")
+
+// ErrDataRequestNotFound is returned by GetDataRequest when the request
+// doesn't exist in the project.
+var ErrDataRequestNotFound = errors.New("data request not found")
+
+// ErrWebhookNotFound is returned when the webhook doesn't exist in the
+// project.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// ErrSessionNotFound is returned by GetSession when the session doesn't exist
+// in the project.
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrUserNotFound is returned by GetUserMetrics when the project has no traces
+// for the user.
+var ErrUserNotFound = errors.New("user not found")
+
 type service struct {
 	db *sql.DB
 }
@@ -49,15 +127,34 @@ var (
 	dbInstance *service
 )
 
-func New() Service {
+// New connects to Postgres. When recorder is non-nil every statement's
+// duration and outcome is reported to it.
+func New(recorder QueryRecorder) Service {
 	if dbInstance != nil {
 		return dbInstance
 	}
 	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable&search_path=%s", username, password, host, port, database, schema)
-	db, err := sql.Open("pgx", connStr)
+	config, err := pgx.ParseConfig(connStr)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	connector := stdlib.GetConnector(*config)
+	if recorder != nil {
+		connector = instrumentedConnector{Connector: connector, recorder: recorder}
+	}
+
+	// Service methods don't take a context yet, so query spans start their
+	// own traces rather than joining the request's.
+	db := otelsql.OpenDB(connector,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitConnPrepare:      true,
+			OmitRows:             true,
+			OmitConnectorConnect: true,
+		}),
+	)
 	dbInstance = &service{
 		db: db,
 	}
@@ -80,11 +177,53 @@ func (s *service) CreateTrace(tr TraceRequest) error {
 		}
 	}
 
-	_, err = s.db.ExecContext(ctx, query, tr.ID, tr.ProjectID, tr.Name, metadata, tr.Tags, tr.UserID, tr.SessionID, tr.StartTime, tr.EndTime)
+	var userID, sessionID interface{}
+	if tr.UserID != "" {
+		userID = tr.UserID
+	}
+	if tr.SessionID != "" {
+		sessionID = tr.SessionID
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, tr.ID, tr.ProjectID, tr.Name, metadata, tr.Tags, userID, sessionID, tr.StartTime, tr.EndTime)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("trace %s: %w", tr.ID, ErrAlreadyExists)
+	}
 	if err != nil {
 		return fmt.Errorf("Failed to create trace: %w", err)
 	}
 
+	if tr.SessionID != "" {
+		lastSeen := tr.StartTime
+		if tr.EndTime != nil && tr.EndTime.After(lastSeen) {
+			lastSeen = *tr.EndTime
+		}
+
+		sessionQuery := `INSERT INTO sessions (project_id, id, user_id, first_seen, last_seen, trace_count)
+			VALUES ($1, $2, $3, $4, $5, 1)
+			ON CONFLICT (project_id, id) DO UPDATE SET
+				user_id = COALESCE(EXCLUDED.user_id, sessions.user_id),
+				first_seen = LEAST(sessions.first_seen, EXCLUDED.first_seen),
+				last_seen = GREATEST(sessions.last_seen, EXCLUDED.last_seen),
+				trace_count = sessions.trace_count + 1,
+				updated_at = NOW()`
+
+		_, err = tx.ExecContext(ctx, sessionQuery, tr.ProjectID, tr.SessionID, userID, tr.StartTime, lastSeen)
+		if err != nil {
+			return fmt.Errorf("Failed to update session: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Failed to create trace: %w", err)
+	}
+
 	return nil
 }
 
@@ -92,8 +231,8 @@ func (s *service) CreateGeneration(gr GenerationRequest) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	query := `INSERT INTO generations (id, trace_id, name, input, output, model, prompt_tokens, completion_tokes, total_tokens, metadata, start_time, end_time)
-          VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	query := `INSERT INTO generations (id, trace_id, name, input, output, model, prompt_tokens, completion_tokens, total_tokens, total_cost, metadata, start_time, end_time)
+          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
 
 	var metadata []byte
 	var err error
@@ -104,19 +243,58 @@ func (s *service) CreateGeneration(gr GenerationRequest) error {
 		}
 	}
 
-	var promptTokens, completionTokens, totalTokens interface{}
+	var promptTokens, completionTokens, totalTokens, totalCost interface{}
 	if gr.Usage != nil {
 		promptTokens = gr.Usage.PromptTokens
 		completionTokens = gr.Usage.CompletionTokens
 		totalTokens = gr.Usage.TotalTokens
+		totalCost = gr.Usage.TotalCost
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkIDFree(ctx, tx, "generations", gr.ID); err != nil {
+		return err
 	}
 
-	_, err = s.db.ExecContext(ctx, query, gr.ID, gr.TraceID, gr.Name, gr.Input, gr.Output, gr.Model,
-		promptTokens, completionTokens, totalTokens, metadata, gr.StartTime, gr.EndTime)
+	_, err = tx.ExecContext(ctx, query, gr.ID, gr.TraceID, gr.Name, gr.Input, gr.Output, gr.Model,
+		promptTokens, completionTokens, totalTokens, totalCost, metadata, gr.StartTime, gr.EndTime)
 	if err != nil {
 		return fmt.Errorf("failed to create generation: %w", err)
 	}
 
+	lastSeen := gr.StartTime
+	if gr.EndTime != nil && gr.EndTime.After(lastSeen) {
+		lastSeen = *gr.EndTime
+	}
+
+	var cost float64
+	if gr.Usage != nil {
+		cost = gr.Usage.TotalCost
+	}
+
+	// Traces without a session_id match no row here.
+	sessionQuery := `UPDATE sessions s SET
+			total_tokens = s.total_tokens + $2,
+			total_cost = s.total_cost + $3,
+			last_seen = GREATEST(s.last_seen, $4),
+			updated_at = NOW()
+		FROM traces t
+		WHERE t.id = $1 AND s.project_id = t.project_id AND s.id = t.session_id`
+
+	_, err = tx.ExecContext(ctx, sessionQuery, gr.TraceID, gr.Usage.Tokens(), cost, lastSeen)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to create generation: %w", err)
+	}
+
 	return nil
 }
 
@@ -141,25 +319,69 @@ func (s *service) CreateSpan(sr SpanRequest) error {
 		parentID = sr.ParentID
 	}
 
-	_, err = s.db.ExecContext(ctx, query, sr.ID, sr.TraceID, parentID, sr.Name, sr.Type, metadata, sr.StartTime, sr.EndTime)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkIDFree(ctx, tx, "spans", sr.ID); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, sr.ID, sr.TraceID, parentID, sr.Name, sr.Type, metadata, sr.StartTime, sr.EndTime)
 	if err != nil {
 		return fmt.Errorf("Failed to create span: %w", err)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Failed to create span: %w", err)
+	}
+
 	return nil
 }
 
-func (s *service) UpdateSpan(spanID string, req SpanUpdateRequest) error {
+// checkIDFree returns ErrAlreadyExists if table has a row with id. The
+// partitioned tables' primary keys include their start time, so the database
+// doesn't enforce unique ids on its own. The check takes a transaction-level
+// advisory lock on the id, so two writers of the same id are serialised until
+// the first commits its insert; the caller must insert in the same tx.
+func checkIDFree(ctx context.Context, tx *sql.Tx, table, id string) error {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", table+":"+id); err != nil {
+		return fmt.Errorf("failed to lock %s id: %w", table, err)
+	}
+
+	var exists bool
+	query := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE id = $1)", table)
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check %s id: %w", table, err)
+	}
+	if exists {
+		return fmt.Errorf("%s %s: %w", strings.TrimSuffix(table, "s"), id, ErrAlreadyExists)
+	}
+	return nil
+}
+
+// isUniqueViolation reports whether err is Postgres rejecting a duplicate key.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func (s *service) UpdateSpan(projectID, spanID string, req SpanUpdateRequest) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	var exists bool
-	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM spans WHERE id = $1)", spanID).Scan(&exists)
+	query := `SELECT EXISTS(
+		SELECT 1 FROM spans s JOIN traces t ON t.id = s.trace_id
+		WHERE s.id = $1 AND t.project_id = $2)`
+	err := s.db.QueryRowContext(ctx, query, spanID, projectID).Scan(&exists)
 	if err != nil {
 		return fmt.Errorf("Failed to check span existence: %w", err)
 	}
 	if !exists {
-		return fmt.Errorf("Span not found")
+		return ErrSpanNotFound
 	}
 
 	setParts := []string{}
@@ -190,7 +412,7 @@ func (s *service) UpdateSpan(spanID string, req SpanUpdateRequest) error {
 	args = append(args, time.Now().UTC())
 	argIndex++
 
-	query := fmt.Sprintf("UPDATE spans SET %s WHERE id = $%d",
+	query = fmt.Sprintf("UPDATE spans SET %s WHERE id = $%d",
 		strings.Join(setParts, ", "), argIndex)
 
 	args = append(args, spanID)
@@ -203,30 +425,32 @@ func (s *service) UpdateSpan(spanID string, req SpanUpdateRequest) error {
 	return nil
 }
 
-func (s *service) TraceExists(traceID string) bool {
+func (s *service) TraceExists(projectID, traceID string) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 
 	var exists bool
-	query := "SELECT EXISTS(SELECT 1 FROM traces WHERE id = $1)"
-	err := s.db.QueryRowContext(ctx, query, traceID).Scan(&exists)
+	query := "SELECT EXISTS(SELECT 1 FROM traces WHERE id = $1 AND project_id = $2)"
+	err := s.db.QueryRowContext(ctx, query, traceID, projectID).Scan(&exists)
 	if err != nil {
-		log.Printf("Error checking trace existence: %v", err)
+		slog.Error("Error checking trace existence", "error", err)
 		return false
 	}
 
 	return exists
 }
 
-func (s *service) SpanExists(spanID string) bool {
+func (s *service) SpanExists(projectID, spanID string) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 
 	var exists bool
-	query := "SELECT EXISTS(SELECT 1 FROM spans WHERE id = $1)"
-	err := s.db.QueryRowContext(ctx, query, spanID).Scan(&exists)
+	query := `SELECT EXISTS(
+		SELECT 1 FROM spans s JOIN traces t ON t.id = s.trace_id
+		WHERE s.id = $1 AND t.project_id = $2)`
+	err := s.db.QueryRowContext(ctx, query, spanID, projectID).Scan(&exists)
 	if err != nil {
-		log.Printf("Error checking span existence: %v", err)
+		slog.Error("Error checking span existence", "error", err)
 		return false
 	}
 	return exists
@@ -253,8 +477,22 @@ func (s *service) CreateEvent(er EventRequest) error {
 		spanID = er.SpanID
 	}
 
-	_, err = s.db.ExecContext(ctx, query, er.ID, er.TraceID, spanID, er.Name, er.Level, er.Message, metadata, er.Timestamp)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
+		return fmt.Errorf("Failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkIDFree(ctx, tx, "events", er.ID); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, er.ID, er.TraceID, spanID, er.Name, er.Level, er.Message, metadata, er.Timestamp)
+	if err != nil {
+		return fmt.Errorf("Failed to create event: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("Failed to create event: %w", err)
 	}
 
@@ -265,8 +503,15 @@ func (s *service) CreateScore(scr ScoreRequest) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	query := `INSERT INTO scores (id, trace_id, generation_id, name, value, source, comment, metadata, timestamp)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	// The project is copied from the trace or generation so the score
+	// survives their retention.
+	query := `INSERT INTO scores (id, project_id, trace_id, generation_id, name, value, source, comment, metadata, timestamp)
+		VALUES ($1,
+			COALESCE(
+				(SELECT project_id FROM traces WHERE id = $2),
+				(SELECT t.project_id FROM generations g JOIN traces t ON t.id = g.trace_id WHERE g.id = $3)
+			),
+			$2, $3, $4, $5, $6, $7, $8, $9)`
 
 	var metadata []byte
 	var err error
@@ -288,6 +533,9 @@ func (s *service) CreateScore(scr ScoreRequest) error {
 	}
 
 	_, err = s.db.ExecContext(ctx, query, scr.ID, traceID, generationID, scr.Name, scr.Value, scr.Source, scr.Comment, metadata, scr.Timestamp)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("score %s: %w", scr.ID, ErrAlreadyExists)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create score: %w", err)
 	}
@@ -295,15 +543,17 @@ func (s *service) CreateScore(scr ScoreRequest) error {
 	return nil
 }
 
-func (s *service) GenerationExists(generationID string) bool {
+func (s *service) GenerationExists(projectID, generationID string) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
 
 	var exists bool
-	query := "SELECT EXISTS(SELECT 1 FROM generations WHERE id = $1)"
-	err := s.db.QueryRowContext(ctx, query, generationID).Scan(&exists)
+	query := `SELECT EXISTS(
+		SELECT 1 FROM generations g JOIN traces t ON t.id = g.trace_id
+		WHERE g.id = $1 AND t.project_id = $2)`
+	err := s.db.QueryRowContext(ctx, query, generationID, projectID).Scan(&exists)
 	if err != nil {
-		log.Printf("Error checking generation existence: %v", err)
+		slog.Error("Error checking generation existence", "error", err)
 		return false
 	}
 
@@ -320,7 +570,6 @@ func (s *service) Health() map[string]string {
 	if err != nil {
 		stats["status"] = "down"
 		stats["error"] = fmt.Sprintf("db down: %v", err)
-		log.Fatalf("db down: %v", err)
 		return stats
 	}
 
@@ -436,7 +685,11 @@ func (s *service) GetProject(projectID string) (*Project, error) {
 	return &project, nil
 }
 
+func (s *service) DB() *sql.DB {
+	return s.db
+}
+
 func (s *service) Close() error {
-	log.Printf("Disconnected from database: %s", database)
+	slog.Info("Disconnected from database", "database", database)
 	return s.db.Close()
 }
@@ -2,7 +2,12 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -36,6 +41,7 @@ func mustStartPostgresContainer() (func(context.Context, ...testcontainers.Termi
 	database = dbName
 	password = dbPwd
 	username = dbUser
+	schema = "langlite"
 
 	dbHost, err := dbContainer.Host(context.Background())
 	if err != nil {
@@ -67,14 +73,14 @@ func TestMain(m *testing.M) {
 }
 
 func TestNew(t *testing.T) {
-	srv := New()
+	srv := New(nil)
 	if srv == nil {
 		t.Fatal("New() returned nil")
 	}
 }
 
 func TestHealth(t *testing.T) {
-	srv := New()
+	srv := New(nil)
 
 	stats := srv.Health()
 
@@ -92,9 +98,307 @@ func TestHealth(t *testing.T) {
 }
 
 func TestClose(t *testing.T) {
-	srv := New()
+	srv := New(nil)
 
 	if srv.Close() != nil {
 		t.Fatalf("expected Close() to return nil")
 	}
 }
+
+var applySchemaOnce sync.Once
+
+// mustApplySchema loads sql/schema.sql into the test database once.
+func mustApplySchema(t *testing.T) *service {
+	t.Helper()
+	srv := New(nil).(*service)
+	applySchemaOnce.Do(func() {
+		ddl, err := os.ReadFile("../../sql/schema.sql")
+		if err != nil {
+			t.Fatalf("failed to read schema: %v", err)
+		}
+		if _, err := srv.db.Exec(string(ddl)); err != nil {
+			t.Fatalf("failed to apply schema: %v", err)
+		}
+	})
+	return srv
+}
+
+func TestCreateChildConcurrentDuplicateID(t *testing.T) {
+	srv := mustApplySchema(t)
+
+	if _, err := srv.db.Exec(`INSERT INTO projects (id, name) VALUES ('proj-dup', 'dup')
+		ON CONFLICT DO NOTHING`); err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+	if _, err := srv.db.Exec(`INSERT INTO traces (id, project_id, name) VALUES ('trace-dup', 'proj-dup', 'dup')
+		ON CONFLICT DO NOTHING`); err != nil {
+		t.Fatalf("failed to create trace: %v", err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		table  string
+		create func(id string) error
+	}{
+		{"span", "spans", func(id string) error {
+			return srv.CreateSpan(SpanRequest{ID: id, TraceID: "trace-dup", Name: "s", StartTime: now})
+		}},
+		{"event", "events", func(id string) error {
+			return srv.CreateEvent(EventRequest{ID: id, TraceID: "trace-dup", Name: "e", Level: "info", Timestamp: now})
+		}},
+		{"generation", "generations", func(id string) error {
+			return srv.CreateGeneration(GenerationRequest{ID: id, TraceID: "trace-dup", Name: "g", Input: "in", Model: "m", StartTime: now})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const writers = 8
+			id := fmt.Sprintf("%s-dup", tt.name)
+
+			var wg sync.WaitGroup
+			errs := make([]error, writers)
+			for i := range writers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs[i] = tt.create(id)
+				}()
+			}
+			wg.Wait()
+
+			created := 0
+			for _, err := range errs {
+				switch {
+				case err == nil:
+					created++
+				case !errors.Is(err, ErrAlreadyExists):
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if created != 1 {
+				t.Fatalf("expected exactly one insert to succeed, got %d", created)
+			}
+
+			var rows int
+			query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id = $1", tt.table)
+			if err := srv.db.QueryRow(query, id).Scan(&rows); err != nil {
+				t.Fatalf("failed to count rows: %v", err)
+			}
+			if rows != 1 {
+				t.Fatalf("expected 1 %s row, got %d", tt.name, rows)
+			}
+		})
+	}
+}
+
+func TestPurgeExpiredRecomputesSessions(t *testing.T) {
+	srv := mustApplySchema(t)
+
+	if _, err := srv.db.Exec(`INSERT INTO projects (id, name) VALUES ('proj-purge', 'purge')
+		ON CONFLICT DO NOTHING`); err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	recent := time.Now()
+	for _, tr := range []TraceRequest{
+		{ID: "purge-old", ProjectID: "proj-purge", Name: "old", SessionID: "sess-purge", StartTime: old},
+		{ID: "purge-new", ProjectID: "proj-purge", Name: "new", SessionID: "sess-purge", StartTime: recent},
+	} {
+		if err := srv.CreateTrace(tr); err != nil {
+			t.Fatalf("failed to create trace: %v", err)
+		}
+	}
+	for _, gr := range []GenerationRequest{
+		{ID: "purge-gen-old", TraceID: "purge-old", Name: "g", Input: "in", Model: "m", StartTime: old,
+			Usage: &UsageMetrics{TotalTokens: 100, TotalCost: 1}},
+		{ID: "purge-gen-new", TraceID: "purge-new", Name: "g", Input: "in", Model: "m", StartTime: recent,
+			Usage: &UsageMetrics{PromptTokens: 5, CompletionTokens: 5, TotalCost: 0.5}},
+	} {
+		if err := srv.CreateGeneration(gr); err != nil {
+			t.Fatalf("failed to create generation: %v", err)
+		}
+	}
+
+	session := func() *SessionDetail {
+		t.Helper()
+		detail, err := srv.GetSession("proj-purge", "sess-purge")
+		if errors.Is(err, ErrSessionNotFound) {
+			return nil
+		}
+		if err != nil {
+			t.Fatalf("failed to get session: %v", err)
+		}
+		return detail
+	}
+
+	if got := session(); got == nil || got.TraceCount != 2 || got.TotalTokens != 110 {
+		t.Fatalf("expected 2 traces and 110 tokens before the purge, got %+v", got)
+	}
+
+	n, err := srv.PurgeExpired("proj-purge", "traces", time.Now().Add(-24*time.Hour), 100)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 trace purged, got %d %v", n, err)
+	}
+	got := session()
+	if got == nil || got.TraceCount != 1 || got.TotalTokens != 10 || got.TotalCost != 0.5 {
+		t.Fatalf("expected the session to count only the remaining trace, got %+v", got)
+	}
+	if got.FirstSeen.Sub(recent).Abs() > time.Millisecond {
+		t.Errorf("expected first_seen to move to the remaining trace's start %v, got %v", recent, got.FirstSeen)
+	}
+
+	if _, err := srv.PurgeExpired("proj-purge", "traces", time.Now().Add(time.Hour), 100); err != nil {
+		t.Fatalf("failed to purge: %v", err)
+	}
+	if got := session(); got != nil {
+		t.Fatalf("expected the empty session to be dropped, got %+v", got)
+	}
+}
+
+// danglingReferences counts the project's events, spans and scores that point
+// at spans or generations which no longer exist.
+func danglingReferences(t *testing.T, srv *service, projectID string) int {
+	t.Helper()
+	var n int
+	err := srv.db.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM events e JOIN traces t ON t.id = e.trace_id
+			WHERE t.project_id = $1 AND e.span_id IS NOT NULL
+				AND NOT EXISTS(SELECT 1 FROM spans s WHERE s.id = e.span_id)) +
+		(SELECT COUNT(*) FROM spans c JOIN traces t ON t.id = c.trace_id
+			WHERE t.project_id = $1 AND c.parent_id IS NOT NULL
+				AND NOT EXISTS(SELECT 1 FROM spans s WHERE s.id = c.parent_id)) +
+		(SELECT COUNT(*) FROM scores sc
+			WHERE sc.project_id = $1 AND sc.generation_id IS NOT NULL
+				AND NOT EXISTS(SELECT 1 FROM generations g WHERE g.id = sc.generation_id))`,
+		projectID).Scan(&n)
+	if err != nil {
+		t.Fatalf("failed to count dangling references: %v", err)
+	}
+	return n
+}
+
+// seedReferences creates a trace whose old span has a newer child span and
+// event, and whose old generation has a score.
+func seedReferences(t *testing.T, srv *service, projectID string, old time.Time) {
+	t.Helper()
+	if _, err := srv.db.Exec(`INSERT INTO projects (id, name) VALUES ($1, $1) ON CONFLICT DO NOTHING`, projectID); err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+
+	recent := time.Now()
+	traceID := projectID + "-trace"
+	steps := []func() error{
+		func() error {
+			return srv.CreateTrace(TraceRequest{ID: traceID, ProjectID: projectID, Name: "t", StartTime: recent})
+		},
+		func() error {
+			return srv.CreateSpan(SpanRequest{ID: projectID + "-parent", TraceID: traceID, Name: "parent", StartTime: old})
+		},
+		func() error {
+			return srv.CreateSpan(SpanRequest{ID: projectID + "-child", TraceID: traceID, ParentID: projectID + "-parent", Name: "child", StartTime: recent})
+		},
+		func() error {
+			return srv.CreateEvent(EventRequest{ID: projectID + "-event", TraceID: traceID, SpanID: projectID + "-parent", Name: "e", Level: "info", Timestamp: recent})
+		},
+		func() error {
+			return srv.CreateGeneration(GenerationRequest{ID: projectID + "-gen", TraceID: traceID, Name: "g", Input: "in", Model: "m", StartTime: old})
+		},
+		func() error {
+			return srv.CreateScore(ScoreRequest{ID: projectID + "-score", GenerationID: projectID + "-gen", Name: "quality", Value: 1, Source: "human", Timestamp: recent})
+		},
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("failed to seed: %v", err)
+		}
+	}
+}
+
+func TestPurgeExpiredReleasesReferences(t *testing.T) {
+	srv := mustApplySchema(t)
+	seedReferences(t, srv, "proj-refs", time.Now().Add(-48*time.Hour))
+
+	cutoff := time.Now().Add(-24 * time.Hour)
+	for _, entity := range []string{"spans", "generations"} {
+		if n, err := srv.PurgeExpired("proj-refs", entity, cutoff, 100); err != nil || n != 1 {
+			t.Fatalf("expected 1 %s purged, got %d %v", entity, n, err)
+		}
+	}
+
+	if n := danglingReferences(t, srv, "proj-refs"); n != 0 {
+		t.Errorf("expected no dangling references after the purge, got %d", n)
+	}
+
+	var parentID sql.NullString
+	if err := srv.db.QueryRow(`SELECT parent_id FROM spans WHERE id = 'proj-refs-child'`).Scan(&parentID); err != nil {
+		t.Fatalf("expected the child span to survive: %v", err)
+	}
+	if parentID.Valid {
+		t.Errorf("expected the child span to become a root, got parent %q", parentID.String)
+	}
+}
+
+func TestDropPartitionsReleasesReferences(t *testing.T) {
+	srv := mustApplySchema(t)
+	month := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	seedReferences(t, srv, "proj-partition-refs", month.Add(24*time.Hour))
+
+	if _, err := srv.EnsurePartitions(month, 0); err != nil {
+		t.Fatalf("failed to create partitions: %v", err)
+	}
+	dropped, err := srv.DropPartitionsBefore(month.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatalf("failed to drop partitions: %v", err)
+	}
+	if len(dropped) != len(partitionedTables) {
+		t.Fatalf("expected every table's January partition dropped, got %v", dropped)
+	}
+
+	if n := danglingReferences(t, srv, "proj-partition-refs"); n != 0 {
+		t.Errorf("expected no dangling references after dropping partitions, got %d", n)
+	}
+}
+
+func TestDropPartitionsRecomputesSessions(t *testing.T) {
+	srv := mustApplySchema(t)
+
+	if _, err := srv.db.Exec(`INSERT INTO projects (id, name) VALUES ('proj-partition-sess', 'p')
+		ON CONFLICT DO NOTHING`); err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+
+	month := time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Now()
+	if err := srv.CreateTrace(TraceRequest{ID: "partition-sess-trace", ProjectID: "proj-partition-sess", Name: "t",
+		SessionID: "sess-partition", StartTime: recent}); err != nil {
+		t.Fatalf("failed to create trace: %v", err)
+	}
+	for _, gr := range []GenerationRequest{
+		{ID: "partition-sess-old", TraceID: "partition-sess-trace", Name: "g", Input: "in", Model: "m",
+			StartTime: month.Add(time.Hour), Usage: &UsageMetrics{TotalTokens: 100, TotalCost: 1}},
+		{ID: "partition-sess-new", TraceID: "partition-sess-trace", Name: "g", Input: "in", Model: "m",
+			StartTime: recent, Usage: &UsageMetrics{TotalTokens: 10, TotalCost: 0.5}},
+	} {
+		if err := srv.CreateGeneration(gr); err != nil {
+			t.Fatalf("failed to create generation: %v", err)
+		}
+	}
+
+	if _, err := srv.EnsurePartitions(month, 0); err != nil {
+		t.Fatalf("failed to create partitions: %v", err)
+	}
+	if _, err := srv.DropPartitionsBefore(month.AddDate(0, 1, 0)); err != nil {
+		t.Fatalf("failed to drop partitions: %v", err)
+	}
+
+	got, err := srv.GetSession("proj-partition-sess", "sess-partition")
+	if err != nil {
+		t.Fatalf("failed to get session: %v", err)
+	}
+	if got.TraceCount != 1 || got.TotalTokens != 10 || got.TotalCost != 0.5 {
+		t.Errorf("expected the session to count only the remaining generation, got %+v", got.Session)
+	}
+}
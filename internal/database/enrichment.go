@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GetEnrichmentSettings returns the enrichers the project chose, or nil if
+// it hasn't chosen and runs every enricher offered.
+func (s *service) GetEnrichmentSettings(projectID string) (*EnrichmentSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var enrichers []byte
+	err := s.db.QueryRowContext(ctx,
+		"SELECT array_to_json(enrichers) FROM project_enrichers WHERE project_id = $1", projectID).Scan(&enrichers)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get enrichment settings: %w", err)
+	}
+
+	settings := &EnrichmentSettings{Enrichers: []string{}}
+	if err := json.Unmarshal(enrichers, &settings.Enrichers); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal enrichers: %w", err)
+	}
+	return settings, nil
+}
+
+// SetEnrichmentSettings replaces the project's enrichers. Nil Enrichers goes
+// back to every enricher offered; an empty list turns enrichment off.
+func (s *service) SetEnrichmentSettings(projectID string, settings EnrichmentSettings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if settings.Enrichers == nil {
+		if _, err := s.db.ExecContext(ctx, "DELETE FROM project_enrichers WHERE project_id = $1", projectID); err != nil {
+			return fmt.Errorf("failed to reset enrichment settings: %w", err)
+		}
+		return nil
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO project_enrichers (project_id, enrichers) VALUES ($1, $2)
+		ON CONFLICT (project_id) DO UPDATE SET enrichers = EXCLUDED.enrichers, updated_at = NOW()`,
+		projectID, settings.Enrichers)
+	if err != nil {
+		return fmt.Errorf("failed to set enrichment settings: %w", err)
+	}
+	return nil
+}
+
+// SetTraceEnrichment stores the fields derived for a trace, replacing any
+// from an earlier run.
+func (s *service) SetTraceEnrichment(projectID, traceID string, enrichment map[string]any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data, err := json.Marshal(enrichment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal enrichment: %w", err)
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE traces SET enrichment = $3, enriched_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND project_id = $2`,
+		traceID, projectID, data)
+	if err != nil {
+		return fmt.Errorf("failed to store enrichment: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to store enrichment: %w", err)
+	}
+	if n == 0 {
+		return ErrTraceNotFound
+	}
+	return nil
+}
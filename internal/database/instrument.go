@@ -0,0 +1,222 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"time"
+)
+
+// QueryRecorder receives the duration and outcome of every statement sent to
+// Postgres. *metrics.Metrics implements it.
+type QueryRecorder interface {
+	RecordDatabaseQuery(ctx context.Context, operation, table, status string, duration time.Duration)
+}
+
+// instrumentedConnector wraps the pgx connector so that every statement is
+// measured, including those run inside transactions.
+type instrumentedConnector struct {
+	driver.Connector
+	recorder QueryRecorder
+}
+
+func (c instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{Conn: conn, recorder: c.recorder}, nil
+}
+
+// instrumentedConn forwards to the driver's connection. It has to implement
+// every optional interface pgx's connection does, or database/sql would fall
+// back to slower or lossy paths (e.g. converting []string arguments itself).
+type instrumentedConn struct {
+	driver.Conn
+	recorder QueryRecorder
+}
+
+func (c *instrumentedConn) record(ctx context.Context, query string, start time.Time, err error) {
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+	operation, table := queryLabels(query)
+	c.recorder.RecordDatabaseQuery(ctx, operation, table, status, time.Since(start))
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		c.record(ctx, query, start, err)
+	}
+	return result, err
+}
+
+// QueryContext measures the time until the first rows are available, not the
+// time the caller spends reading them.
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		c.record(ctx, query, start, err)
+	}
+	return rows, err
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *instrumentedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// queryLabels derives the metric labels for a statement: the top-level verb
+// and the table it reads from or writes to. Only words outside parentheses
+// count, so CTEs and subqueries don't decide the labels. Anything that isn't a
+// plain identifier becomes "unknown" to keep the label set bounded.
+func queryLabels(query string) (operation, table string) {
+	var words []string
+	depth := 0
+	inString := false
+	start := -1
+
+	flush := func(end int) {
+		if start >= 0 {
+			if depth == 0 {
+				words = append(words, strings.ToLower(query[start:end]))
+			}
+			start = -1
+		}
+	}
+
+	for i, r := range query {
+		switch {
+		case inString:
+			if r == '\'' {
+				inString = false
+			}
+		case r == '\'':
+			flush(i)
+			inString = true
+		case r == '(':
+			flush(i)
+			// Mark the subquery so "FROM (SELECT ...) alias" doesn't
+			// label the query with the alias.
+			if depth == 0 {
+				words = append(words, "(")
+			}
+			depth++
+		case r == ')':
+			flush(i)
+			if depth > 0 {
+				depth--
+			}
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == ',' || r == ';':
+			flush(i)
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	flush(len(query))
+
+	if len(words) == 0 {
+		return "unknown", "unknown"
+	}
+
+	operation = words[0]
+	for _, w := range words {
+		if w == "select" || w == "insert" || w == "update" || w == "delete" {
+			operation = w
+			break
+		}
+	}
+	if !isIdentifier(operation) {
+		operation = "unknown"
+	}
+
+	after := "from"
+	switch operation {
+	case "insert":
+		after = "into"
+	case "update":
+		after = "update"
+	}
+
+	table = "unknown"
+	for i, w := range words[:len(words)-1] {
+		if w != after {
+			continue
+		}
+		name := words[i+1]
+		if _, unqualified, ok := strings.Cut(name, "."); ok {
+			name = unqualified
+		}
+		if isIdentifier(name) {
+			table = name
+		}
+		break
+	}
+
+	return operation, table
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
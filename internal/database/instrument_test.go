@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestQueryLabels(t *testing.T) {
+	cases := []struct {
+		query     string
+		operation string
+		table     string
+	}{
+		{`INSERT INTO traces (id, name) VALUES ($1, $2)`, "insert", "traces"},
+		{`UPDATE spans SET end_time = $1 WHERE id = $2`, "update", "spans"},
+		{`DELETE FROM langlite.events WHERE id = $1`, "delete", "events"},
+		{`SELECT EXTRACT(EPOCH FROM start_time), name FROM generations WHERE id = $1`, "select", "generations"},
+		{`WITH user_traces AS (SELECT * FROM traces) SELECT count(*) FROM user_traces`, "select", "user_traces"},
+		{`WITH moved AS (DELETE FROM events RETURNING *) INSERT INTO events_2026_01 SELECT * FROM moved`, "insert", "events_2026_01"},
+		{`SELECT COALESCE(json_agg(id), '[]') FROM (SELECT id FROM traces) batch`, "select", "unknown"},
+		{`SELECT 'from x' FROM scores`, "select", "scores"},
+		{`CREATE TABLE events_2026_02 (LIKE events INCLUDING DEFAULTS)`, "create", "unknown"},
+		{`SELECT 1`, "select", "unknown"},
+		{``, "unknown", "unknown"},
+	}
+
+	for _, c := range cases {
+		operation, table := queryLabels(c.query)
+		if operation != c.operation || table != c.table {
+			t.Errorf("%q: expected %s/%s; got %s/%s", c.query, c.operation, c.table, operation, table)
+		}
+	}
+}
+
+// fakeConnector hands out connections whose statements fail when the query
+// mentions "missing".
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{}, nil }
+func (fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+func (fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if query == "DELETE FROM missing" {
+		return nil, errors.New("relation does not exist")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return fakeRows{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return []string{"id"} }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+type queryRecord struct {
+	operation, table, status string
+}
+
+type fakeRecorder struct {
+	queries []queryRecord
+}
+
+func (r *fakeRecorder) RecordDatabaseQuery(ctx context.Context, operation, table, status string, duration time.Duration) {
+	r.queries = append(r.queries, queryRecord{operation, table, status})
+}
+
+func TestInstrumentedConnectorRecordsQueries(t *testing.T) {
+	rec := &fakeRecorder{}
+	db := sql.OpenDB(instrumentedConnector{Connector: fakeConnector{}, recorder: rec})
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "INSERT INTO traces (id) VALUES ($1)", "t1"); err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM missing"); err == nil {
+		t.Fatalf("expected exec to fail")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM spans")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	rows.Close()
+	tx.Commit()
+
+	want := []queryRecord{
+		{"insert", "traces", "success"},
+		{"delete", "missing", "error"},
+		{"select", "spans", "success"},
+	}
+	if len(rec.queries) != len(want) {
+		t.Fatalf("expected %d queries; got %+v", len(want), rec.queries)
+	}
+	for i := range want {
+		if rec.queries[i] != want[i] {
+			t.Errorf("query %d: expected %+v; got %+v", i, want[i], rec.queries[i])
+		}
+	}
+}
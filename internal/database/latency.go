@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyWeight is how much each statement moves the moving average.
+	latencyWeight = 0.05
+
+	// maxLatencySample caps a single statement's contribution, so one slow
+	// report query doesn't read as Postgres being overloaded.
+	maxLatencySample = time.Second
+)
+
+// LatencyTracker keeps a moving average of how long reads and writes take,
+// so callers can back off while Postgres is struggling. It passes every
+// statement on to next, if set.
+type LatencyTracker struct {
+	next QueryRecorder
+
+	mu      sync.Mutex
+	average float64
+}
+
+func NewLatencyTracker(next QueryRecorder) *LatencyTracker {
+	return &LatencyTracker{next: next}
+}
+
+func (t *LatencyTracker) RecordDatabaseQuery(ctx context.Context, operation, table, status string, duration time.Duration) {
+	if t.next != nil {
+		t.next.RecordDatabaseQuery(ctx, operation, table, status, duration)
+	}
+
+	// Schema changes and rollup refreshes are slow by nature.
+	switch operation {
+	case "select", "insert", "update", "delete":
+	default:
+		return
+	}
+
+	sample := float64(min(duration, maxLatencySample))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.average == 0 {
+		t.average = sample
+		return
+	}
+	t.average += latencyWeight * (sample - t.average)
+}
+
+// Latency is the moving average of recent statement durations, or 0 before
+// any statement ran.
+func (t *LatencyTracker) Latency() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Duration(t.average)
+}
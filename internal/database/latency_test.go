@@ -0,0 +1,34 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	next := &fakeRecorder{}
+	tracker := NewLatencyTracker(next)
+	ctx := context.Background()
+
+	if tracker.Latency() != 0 {
+		t.Errorf("expected no latency before any statement")
+	}
+
+	tracker.RecordDatabaseQuery(ctx, "insert", "traces", "success", 10*time.Millisecond)
+	if got := tracker.Latency(); got != 10*time.Millisecond {
+		t.Errorf("expected the first sample to set the average; got %s", got)
+	}
+
+	// Maintenance statements don't count, and slow ones are capped.
+	tracker.RecordDatabaseQuery(ctx, "refresh", "unknown", "success", time.Minute)
+	tracker.RecordDatabaseQuery(ctx, "select", "traces", "success", time.Minute)
+	want := 10*time.Millisecond + time.Duration(latencyWeight*float64(maxLatencySample-10*time.Millisecond))
+	if got := tracker.Latency(); (got - want).Abs() > time.Microsecond {
+		t.Errorf("expected about %s; got %s", want, got)
+	}
+
+	if len(next.queries) != 3 {
+		t.Errorf("expected every statement to be passed on; got %v", next.queries)
+	}
+}
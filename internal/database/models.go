@@ -2,8 +2,14 @@ package database
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"math"
+	"net/netip"
+	"net/url"
+	"slices"
 	"strings"
 	"time"
 	"unicode"
@@ -77,13 +83,30 @@ func (gr GenerationRequest) Valid(ctx context.Context) map[string]string {
 		problems["model"] = "model is required"
 	}
 
+	if gr.Usage != nil && gr.Usage.TotalCost < 0 {
+		problems["usage.total_cost"] = "total_cost cannot be negative"
+	}
+
 	return problems
 }
 
 type UsageMetrics struct {
-	PromptTokens     int `json:"prompt_tokens,omitempty"`
-	CompletionTokens int `json:"completion_tokens,omitempty"`
-	TotalTokens      int `json:"total_tokens,omitempty"`
+	PromptTokens     int     `json:"prompt_tokens,omitempty"`
+	CompletionTokens int     `json:"completion_tokens,omitempty"`
+	TotalTokens      int     `json:"total_tokens,omitempty"`
+	TotalCost        float64 `json:"total_cost,omitempty"`
+}
+
+// Tokens returns TotalTokens, or the sum of prompt and completion tokens when
+// the SDK didn't report a total.
+func (u *UsageMetrics) Tokens() int {
+	if u == nil {
+		return 0
+	}
+	if u.TotalTokens != 0 {
+		return u.TotalTokens
+	}
+	return u.PromptTokens + u.CompletionTokens
 }
 
 type SpanRequest struct {
@@ -359,10 +382,11 @@ type SuccessResponse struct {
 }
 
 type ErrorResponse struct {
-	Error    string            `json:"error"`
-	Message  string            `json:"message"`
-	Code     int               `json:"code"`
-	Problems map[string]string `json:"problems,omitempty"`
+	Error     string            `json:"error"`
+	Message   string            `json:"message"`
+	Code      int               `json:"code"`
+	Problems  map[string]string `json:"problems,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
 }
 
 func isAlphanumeric(s string) bool {
@@ -453,6 +477,614 @@ func (br BatchRequest) Valid(ctx context.Context) map[string]string {
 	return problems
 }
 
+// StreamItem is a single line of an NDJSON ingest stream.
+type StreamItem struct {
+	Type string          `json:"type"` // "trace", "span", "generation", "event", "score"
+	Body json.RawMessage `json:"body"`
+}
+
+// StreamSummary is the final line written to an NDJSON ingest stream.
+type StreamSummary struct {
+	Summary BatchSummary `json:"summary"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// Session is the rollup of all traces sharing a session_id within a project.
+type Session struct {
+	ID          string    `json:"id"`
+	ProjectID   string    `json:"project_id"`
+	UserID      string    `json:"user_id,omitempty"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	TraceCount  int       `json:"trace_count"`
+	TotalTokens int64     `json:"total_tokens"`
+	TotalCost   float64   `json:"total_cost"`
+}
+
+// SessionTrace is a trace within a session, with the usage of its
+// generations summed up.
+type SessionTrace struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	UserID      string         `json:"user_id,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+	Metadata    map[string]any `json:"metadata,omitempty"`
+	StartTime   time.Time      `json:"start_time"`
+	EndTime     *time.Time     `json:"end_time,omitempty"`
+	Enrichment  map[string]any `json:"enrichment,omitempty"`
+	TotalTokens int64          `json:"total_tokens"`
+	TotalCost   float64        `json:"total_cost"`
+}
+
+type SessionDetail struct {
+	Session
+	Traces []SessionTrace `json:"traces"`
+}
+
+type SessionListOptions struct {
+	UserID string
+	Limit  int
+	Offset int
+}
+
+type SessionListResponse struct {
+	Sessions []Session `json:"sessions"`
+	Limit    int       `json:"limit"`
+	Offset   int       `json:"offset"`
+}
+
+// UserMetrics aggregates an end user's activity. Generations, error events and
+// scores are attributed to the bucket of the trace they belong to.
+type UserMetrics struct {
+	TraceCount      int64    `json:"trace_count"`
+	GenerationCount int64    `json:"generation_count"`
+	TotalTokens     int64    `json:"total_tokens"`
+	TotalCost       float64  `json:"total_cost"`
+	ErrorCount      int64    `json:"error_count"`
+	ScoreCount      int64    `json:"score_count"`
+	AvgScore        *float64 `json:"avg_score"`
+}
+
+// Add accumulates other into m, weighting AvgScore by ScoreCount.
+func (m *UserMetrics) Add(other UserMetrics) {
+	if other.AvgScore != nil && other.ScoreCount > 0 {
+		sum := float64(other.ScoreCount) * *other.AvgScore
+		if m.AvgScore != nil {
+			sum += float64(m.ScoreCount) * *m.AvgScore
+		}
+		avg := sum / float64(m.ScoreCount+other.ScoreCount)
+		m.AvgScore = &avg
+	}
+
+	m.TraceCount += other.TraceCount
+	m.GenerationCount += other.GenerationCount
+	m.TotalTokens += other.TotalTokens
+	m.TotalCost += other.TotalCost
+	m.ErrorCount += other.ErrorCount
+	m.ScoreCount += other.ScoreCount
+}
+
+type UserSummary struct {
+	UserID    string    `json:"user_id"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	UserMetrics
+}
+
+type UserListOptions struct {
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+type UserListResponse struct {
+	Users  []UserSummary `json:"users"`
+	From   time.Time     `json:"from"`
+	To     time.Time     `json:"to"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+}
+
+type UserMetricsOptions struct {
+	From     time.Time
+	To       time.Time
+	Interval string // "hour", "day" or "week"
+}
+
+type UserMetricsBucket struct {
+	Start time.Time `json:"start"`
+	UserMetrics
+}
+
+type UserMetricsResponse struct {
+	UserID   string              `json:"user_id"`
+	From     time.Time           `json:"from"`
+	To       time.Time           `json:"to"`
+	Interval string              `json:"interval"`
+	Totals   UserMetrics         `json:"totals"`
+	Buckets  []UserMetricsBucket `json:"buckets"`
+}
+
+// LatencyBucketBoundsMs are the upper bounds, in milliseconds, of the latency
+// histogram kept in usage_rollups. The histogram has one more bucket than
+// there are bounds; the last one counts everything above 120s.
+var LatencyBucketBoundsMs = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000}
+
+// LatencyPercentile estimates the p-th percentile (0 < p <= 1) of a latency
+// histogram laid out as LatencyBucketBoundsMs, interpolating linearly within
+// the bucket it falls in. maxMs caps the open-ended last bucket. It returns
+// nil for an empty histogram.
+func LatencyPercentile(counts []int64, maxMs, p float64) *float64 {
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return nil
+	}
+
+	rank := p * float64(total)
+	var seen float64
+	for i, c := range counts {
+		if c == 0 || seen+float64(c) < rank {
+			seen += float64(c)
+			continue
+		}
+
+		lower, upper := 0.0, maxMs
+		if i > 0 {
+			lower = LatencyBucketBoundsMs[i-1]
+		}
+		if i < len(LatencyBucketBoundsMs) && LatencyBucketBoundsMs[i] < upper {
+			upper = LatencyBucketBoundsMs[i]
+		}
+		lower = min(lower, upper)
+
+		v := lower + (upper-lower)*(rank-seen)/float64(c)
+		return &v
+	}
+
+	return &maxMs
+}
+
+// TimeseriesQuery selects usage rollups for one dimension. Values, when set,
+// restricts the result to those dimension values.
+type TimeseriesQuery struct {
+	GroupBy  string // "model", "span_type", "trace_name" or "tag"
+	From     time.Time
+	To       time.Time
+	Interval string // "hour", "day" or "week"
+	Values   []string
+}
+
+// TimeseriesRow is the rollup for one group over one interval bucket.
+type TimeseriesRow struct {
+	Start            time.Time
+	Group            string
+	Count            int64
+	LatencyCount     int64
+	LatencySumMs     float64
+	LatencyMaxMs     float64
+	Histogram        []int64
+	PromptTokens     int64
+	CompletionTokens int64
+	TotalTokens      int64
+	TotalCost        float64
+	ErrorCount       int64
+}
+
+type TimeseriesPoint struct {
+	Start            time.Time `json:"start"`
+	Count            int64     `json:"count"`
+	LatencyAvgMs     *float64  `json:"latency_avg_ms"`
+	LatencyP50Ms     *float64  `json:"latency_p50_ms"`
+	LatencyP95Ms     *float64  `json:"latency_p95_ms"`
+	LatencyP99Ms     *float64  `json:"latency_p99_ms"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	TotalTokens      int64     `json:"total_tokens"`
+	TotalCost        float64   `json:"total_cost"`
+	ErrorCount       int64     `json:"error_count"`
+}
+
+type TimeseriesSeries struct {
+	Group  string            `json:"group"`
+	Points []TimeseriesPoint `json:"points"`
+}
+
+type TimeseriesResponse struct {
+	GroupBy  string             `json:"group_by"`
+	Interval string             `json:"interval"`
+	From     time.Time          `json:"from"`
+	To       time.Time          `json:"to"`
+	Limit    int                `json:"limit"`
+	Offset   int                `json:"offset"`
+	Series   []TimeseriesSeries `json:"series"`
+}
+
+// RetentionEntities are the entities a project can set a retention period for.
+// Purging a trace takes its spans, generations and events with it; scores are
+// detached from it and kept until their own retention expires.
+var RetentionEntities = []string{"traces", "spans", "generations", "events", "scores"}
+
+// maxRetentionDays is ten years.
+const maxRetentionDays = 3650
+
+// RetentionSettings maps an entity to the number of days it is kept. Entities
+// that aren't listed are kept forever.
+type RetentionSettings map[string]int
+
+func (rs RetentionSettings) Valid(ctx context.Context) map[string]string {
+	problems := make(map[string]string)
+
+	for entity, days := range rs {
+		if !slices.Contains(RetentionEntities, entity) {
+			problems[entity] = "entity must be one of: " + strings.Join(RetentionEntities, ", ")
+			continue
+		}
+		if days < 1 || days > maxRetentionDays {
+			problems[entity] = fmt.Sprintf("retention must be between 1 and %d days", maxRetentionDays)
+		}
+	}
+
+	return problems
+}
+
+type RetentionResponse struct {
+	ProjectID string            `json:"project_id"`
+	Retention RetentionSettings `json:"retention"`
+}
+
+// EnrichmentSettings lists the enrichers that run on a project's traces, by
+// name. Nil Enrichers means every enricher the deployment offers.
+type EnrichmentSettings struct {
+	Enrichers []string `json:"enrichers"`
+}
+
+func (es EnrichmentSettings) Valid(ctx context.Context) map[string]string {
+	problems := make(map[string]string)
+
+	seen := make(map[string]bool, len(es.Enrichers))
+	for _, name := range es.Enrichers {
+		if strings.TrimSpace(name) == "" {
+			problems["enrichers"] = "enricher names can't be empty"
+		} else if seen[name] {
+			problems["enrichers"] = fmt.Sprintf("%s is listed twice", name)
+		}
+		seen[name] = true
+	}
+
+	return problems
+}
+
+type EnrichmentResponse struct {
+	ProjectID string   `json:"project_id"`
+	Enrichers []string `json:"enrichers"`
+	// Default is true when the project runs every enricher offered.
+	Default bool `json:"default"`
+}
+
+// WebhookEntityTypes lists the kinds of ingested item a webhook rule can
+// match.
+var WebhookEntityTypes = []string{"trace", "span", "generation", "event", "score"}
+
+// maxWebhookRules caps how many rules one webhook has.
+const maxWebhookRules = 20
+
+// WebhookRule matches ingested items of EntityType. Every other condition
+// that's set must hold too, so a rule with only EntityType matches every
+// item of that type.
+type WebhookRule struct {
+	EntityType string `json:"entity_type"`
+	// Levels matches events whose level is one of them.
+	Levels []string `json:"levels,omitempty"`
+	// ScoreName matches scores of that name, and ScoreBelow and ScoreAbove
+	// scores whose value is below or above them.
+	ScoreName  string   `json:"score_name,omitempty"`
+	ScoreBelow *float64 `json:"score_below,omitempty"`
+	ScoreAbove *float64 `json:"score_above,omitempty"`
+	// Tags matches traces carrying every one of them.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// problems adds the rule's problems to problems, prefixed with field.
+func (wr WebhookRule) problems(field string, problems map[string]string) {
+	if !slices.Contains(WebhookEntityTypes, wr.EntityType) {
+		problems[field+".entity_type"] = "entity_type must be one of: " + strings.Join(WebhookEntityTypes, ", ")
+	}
+
+	if len(wr.Levels) > 0 && wr.EntityType != "event" {
+		problems[field+".levels"] = "levels only applies to events"
+	}
+	for _, level := range wr.Levels {
+		if !slices.Contains([]string{"debug", "info", "warn", "error"}, level) {
+			problems[field+".levels"] = "levels must be debug, info, warn or error"
+		}
+	}
+
+	if (wr.ScoreName != "" || wr.ScoreBelow != nil || wr.ScoreAbove != nil) && wr.EntityType != "score" {
+		problems[field] = "score_name, score_below and score_above only apply to scores"
+	}
+	for name, bound := range map[string]*float64{"score_below": wr.ScoreBelow, "score_above": wr.ScoreAbove} {
+		if bound != nil && (math.IsNaN(*bound) || math.IsInf(*bound, 0)) {
+			problems[field+"."+name] = name + " must be a valid number"
+		}
+	}
+
+	if len(wr.Tags) > 0 && wr.EntityType != "trace" {
+		problems[field+".tags"] = "tags only applies to traces"
+	}
+	for _, tag := range wr.Tags {
+		if strings.TrimSpace(tag) == "" {
+			problems[field+".tags"] = "tags cannot be empty"
+		}
+	}
+}
+
+// Webhook is a project's subscription to ingested items matching any of its
+// rules. Deliveries are signed with Secret, which is only returned when the
+// webhook is created.
+type Webhook struct {
+	ID          string        `json:"id"`
+	ProjectID   string        `json:"project_id"`
+	URL         string        `json:"url"`
+	Description string        `json:"description,omitempty"`
+	Rules       []WebhookRule `json:"rules"`
+	Secret      string        `json:"secret,omitempty"`
+	Enabled     bool          `json:"enabled"`
+	// ConsecutiveFailures counts failed delivery attempts since the last
+	// success. DisabledAt and DisabledReason are set when too many of them
+	// disabled the webhook.
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason      string     `json:"disabled_reason,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// WebhookRequest creates or replaces a webhook. Enabled is kept as it was
+// when left out, or true for a new webhook; enabling a webhook clears its
+// failures.
+type WebhookRequest struct {
+	URL         string        `json:"url"`
+	Description string        `json:"description,omitempty"`
+	Rules       []WebhookRule `json:"rules"`
+	Enabled     *bool         `json:"enabled,omitempty"`
+}
+
+func (wr WebhookRequest) Valid(ctx context.Context) map[string]string {
+	problems := make(map[string]string)
+
+	if u, err := url.Parse(wr.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems["url"] = "url must be an absolute http or https URL"
+	} else if !privateWebhookURLsAllowed(ctx) && isPrivateHost(u.Hostname()) {
+		problems["url"] = "url must not point at a private, loopback or link-local address"
+	}
+
+	if len(wr.Description) > 1000 {
+		problems["description"] = "description cannot exceed 1000 characters"
+	}
+
+	if len(wr.Rules) == 0 {
+		problems["rules"] = "at least one rule is required"
+	}
+	if len(wr.Rules) > maxWebhookRules {
+		problems["rules"] = fmt.Sprintf("a webhook can have at most %d rules", maxWebhookRules)
+	}
+	for i, rule := range wr.Rules {
+		rule.problems(fmt.Sprintf("rules[%d]", i), problems)
+	}
+
+	return problems
+}
+
+type privateWebhookURLsKey struct{}
+
+// WithPrivateWebhookURLs lets WebhookRequests validated with the returned
+// context point at private addresses, for deployments that deliver to local
+// test stubs.
+func WithPrivateWebhookURLs(ctx context.Context) context.Context {
+	return context.WithValue(ctx, privateWebhookURLsKey{}, true)
+}
+
+func privateWebhookURLsAllowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(privateWebhookURLsKey{}).(bool)
+	return allowed
+}
+
+// isPrivateHost reports whether host is localhost or a literal address
+// webhooks may not reach. Names that resolve to one are refused when the
+// webhook is delivered.
+func isPrivateHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && !PublicWebhookAddr(addr)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, 100.64.0.0/10, and
+// thisNetwork is 0.0.0.0/8.
+var (
+	sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+	thisNetwork        = netip.MustParsePrefix("0.0.0.0/8")
+)
+
+// PublicWebhookAddr reports whether webhooks may be delivered to addr: it
+// isn't loopback, private, link-local (which includes cloud metadata
+// endpoints such as 169.254.169.254), multicast or unspecified.
+func PublicWebhookAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		!addr.IsLoopback() &&
+		!addr.IsPrivate() &&
+		!addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() &&
+		!addr.IsMulticast() &&
+		!addr.IsUnspecified() &&
+		!sharedAddressSpace.Contains(addr) &&
+		!thisNetwork.Contains(addr)
+}
+
+type WebhookListResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// WebhookDelivery records one attempt to deliver an event to a webhook.
+type WebhookDelivery struct {
+	ID        string `json:"id"`
+	WebhookID string `json:"webhook_id"`
+	ProjectID string `json:"project_id"`
+	EventID   string `json:"event_id"`
+	EventType string `json:"event_type"`
+	Attempt   int    `json:"attempt"`
+	// StatusCode is 0 when no response arrived.
+	StatusCode int    `json:"status_code,omitempty"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+	// Response is the start of the response body.
+	Response   string    `json:"response,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type WebhookDeliveryListResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	Limit      int               `json:"limit"`
+	Offset     int               `json:"offset"`
+}
+
+// RetentionPolicy is one project's retention for one entity.
+type RetentionPolicy struct {
+	ProjectID string
+	Entity    string
+	Days      int
+}
+
+// Data request kinds, erasure modes and statuses, see data_requests.
+const (
+	DataRequestExport  = "export"
+	DataRequestErasure = "erasure"
+
+	ErasureDelete    = "delete"
+	ErasureAnonymize = "anonymize"
+
+	DataRequestPending   = "pending"
+	DataRequestRunning   = "running"
+	DataRequestCompleted = "completed"
+	DataRequestFailed    = "failed"
+)
+
+// DataRequest is the audit record of an export or erasure of one end user's
+// data. UserID is cleared once an erasure completes.
+type DataRequest struct {
+	ID          string              `json:"id"`
+	ProjectID   string              `json:"project_id"`
+	Kind        string              `json:"kind"`
+	Mode        string              `json:"mode,omitempty"`
+	UserID      string              `json:"user_id,omitempty"`
+	SubjectHash string              `json:"subject_hash"`
+	Status      string              `json:"status"`
+	RequestedBy string              `json:"requested_by,omitempty"`
+	Receipt     *DataRequestReceipt `json:"receipt,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	CreatedAt   time.Time           `json:"created_at"`
+	StartedAt   *time.Time          `json:"started_at,omitempty"`
+	CompletedAt *time.Time          `json:"completed_at,omitempty"`
+}
+
+// DataRequestReceipt counts the rows a data request exported, deleted or
+// anonymised.
+type DataRequestReceipt struct {
+	Traces      int64 `json:"traces"`
+	Spans       int64 `json:"spans"`
+	Generations int64 `json:"generations"`
+	Events      int64 `json:"events"`
+	Scores      int64 `json:"scores"`
+	Sessions    int64 `json:"sessions"`
+}
+
+// HashSubject returns the SHA-256 hex digest of an end user id within a
+// project, which is all data_requests keeps once the user's data is gone.
+func HashSubject(projectID, userID string) string {
+	sum := sha256.Sum256([]byte(projectID + "\x00" + userID))
+	return hex.EncodeToString(sum[:])
+}
+
+type ErasureRequest struct {
+	Mode string `json:"mode,omitempty"`
+}
+
+func (er ErasureRequest) Valid(ctx context.Context) map[string]string {
+	problems := make(map[string]string)
+
+	if er.Mode != "" && er.Mode != ErasureDelete && er.Mode != ErasureAnonymize {
+		problems["mode"] = "mode must be one of: delete, anonymize"
+	}
+
+	return problems
+}
+
+type DataRequestListResponse struct {
+	DataRequests []DataRequest `json:"data_requests"`
+	Limit        int           `json:"limit"`
+	Offset       int           `json:"offset"`
+}
+
+// Audit actor types and outcomes, see audit_log.
+const (
+	AuditActorAPIKey    = "api_key"
+	AuditActorAdmin     = "admin"
+	AuditActorAnonymous = "anonymous"
+
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// AuditOutcomes lists the outcomes an audit entry can have.
+var AuditOutcomes = []string{AuditSuccess, AuditFailure, AuditDenied}
+
+// AuditEntry records one privileged operation: who did what to which target,
+// and how it ended.
+type AuditEntry struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ActorType  string    `json:"actor_type"`
+	ActorID    string    `json:"actor_id,omitempty"`
+	ProjectID  string    `json:"project_id,omitempty"`
+	Action     string    `json:"action"`
+	Target     string    `json:"target,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	Outcome    string    `json:"outcome"`
+	StatusCode int       `json:"status_code,omitempty"`
+}
+
+// AuditQuery filters the audit log. Empty fields match everything.
+type AuditQuery struct {
+	ProjectID string
+	ActorID   string
+	Action    string
+	Target    string
+	Outcome   string
+	From      time.Time
+	To        time.Time
+	Limit     int
+	Offset    int
+}
+
+type AuditListResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	From    time.Time    `json:"from"`
+	To      time.Time    `json:"to"`
+	Limit   int          `json:"limit"`
+	Offset  int          `json:"offset"`
+}
+
 type Project struct {
 	ID          string    `json:"id"`
 	Name        string    `json:"name"`
@@ -475,6 +1107,12 @@ type APIKey struct {
 	UpdatedAt          time.Time  `json:"updated_at"`
 }
 
+// HashAPIKey returns the SHA-256 hex digest stored in api_keys.key_hash.
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
 type AuthContext struct {
 	ProjectID string
 	APIKeyID  string
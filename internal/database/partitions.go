@@ -0,0 +1,274 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// partitionedTables are range partitioned by month on their key column, see
+// migrations/008_partition_spans_events_generations.sql. Each has monthly
+// partitions named <table>_pYYYY_MM and a <table>_default partition.
+var partitionedTables = []struct {
+	name string
+	key  string
+}{
+	{name: "generations", key: "start_time"},
+	{name: "spans", key: "start_time"},
+	{name: "events", key: "timestamp"},
+}
+
+// partitionMonth is the layout of the month suffix in partition names.
+const partitionMonth = "2006_01"
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func partitionName(table string, month time.Time) string {
+	return table + "_p" + month.Format(partitionMonth)
+}
+
+// EnsurePartitions creates any missing monthly partitions from the month
+// containing from through monthsAhead months after it, and returns the ones
+// it created. Rows already sitting in the default partition for a new month
+// are moved into it.
+func (s *service) EnsurePartitions(from time.Time, monthsAhead int) ([]string, error) {
+	created := []string{}
+
+	for _, table := range partitionedTables {
+		existing, err := s.listPartitions(table.name)
+		if err != nil {
+			return created, err
+		}
+
+		first := monthStart(from)
+		for i := 0; i <= monthsAhead; i++ {
+			month := first.AddDate(0, i, 0)
+			if _, ok := existing[month]; ok {
+				continue
+			}
+
+			ok, err := s.createPartition(table.name, table.key, month)
+			if err != nil {
+				return created, err
+			}
+			if ok {
+				created = append(created, partitionName(table.name, month))
+			}
+		}
+	}
+
+	return created, nil
+}
+
+// DropPartitionsBefore detaches and drops the monthly partitions whose whole
+// month is before cutoff, and returns the ones it dropped. Rows that ended up
+// in a default partition are left alone. References to the dropped rows are
+// released, and session rollups recomputed, as a retention purge would.
+func (s *service) DropPartitionsBefore(cutoff time.Time) ([]string, error) {
+	dropped := []string{}
+
+	for _, table := range partitionedTables {
+		existing, err := s.listPartitions(table.name)
+		if err != nil {
+			return dropped, err
+		}
+
+		for month, name := range existing {
+			if month.AddDate(0, 1, 0).After(cutoff) {
+				continue
+			}
+
+			ok, err := s.dropPartition(table.name, name)
+			if err != nil {
+				return dropped, err
+			}
+			if ok {
+				dropped = append(dropped, name)
+			}
+		}
+	}
+
+	return dropped, nil
+}
+
+// listPartitions returns table's monthly partitions keyed by month.
+func (s *service) listPartitions(table string) (map[time.Time]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT c.relname
+		FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = $1::regclass`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s partitions: %w", table, err)
+	}
+	defer rows.Close()
+
+	partitions := make(map[time.Time]string)
+	prefix := table + "_p"
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan %s partition: %w", table, err)
+		}
+
+		suffix, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+		month, err := time.Parse(partitionMonth, suffix)
+		if err != nil {
+			continue
+		}
+		partitions[month] = name
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list %s partitions: %w", table, err)
+	}
+
+	return partitions, nil
+}
+
+// createPartition creates and attaches the partition of table for month. It
+// reports false if another instance created it first.
+func (s *service) createPartition(table, key string, month time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	name := partitionName(table, month)
+	lower := month.Format(time.RFC3339)
+	upper := month.AddDate(0, 1, 0).Format(time.RFC3339)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", "partitions:"+table); err != nil {
+		return false, fmt.Errorf("failed to lock %s partitions: %w", table, err)
+	}
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check partition %s: %w", name, err)
+	}
+	if exists {
+		return false, nil
+	}
+
+	// Creating the partition standalone and attaching it afterwards lets rows
+	// for the month move out of the default partition first; attaching fails
+	// while the default partition still holds any.
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS)", name, table),
+		fmt.Sprintf(`WITH moved AS (
+				DELETE FROM %[1]s_default WHERE %[2]s >= '%[3]s' AND %[2]s < '%[4]s' RETURNING *
+			)
+			INSERT INTO %[5]s SELECT * FROM moved`, table, key, lower, upper, name),
+		fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')", table, name, lower, upper),
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return false, fmt.Errorf("failed to create partition %s: %w", name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit partition %s: %w", name, err)
+	}
+
+	return true, nil
+}
+
+// dropPartition detaches and drops one partition of table. It reports false
+// if another instance dropped it first.
+func (s *service) dropPartition(table, name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", "partitions:"+table); err != nil {
+		return false, fmt.Errorf("failed to lock %s partitions: %w", table, err)
+	}
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check partition %s: %w", name, err)
+	}
+	if !exists {
+		return false, nil
+	}
+
+	// Sessions count generations, so note whose rollups the drop changes.
+	var sessions map[string][]string
+	if table == "generations" {
+		if sessions, err = partitionSessions(ctx, tx, name); err != nil {
+			return false, err
+		}
+	}
+
+	if err := releaseReferences(ctx, tx, table, "SELECT id FROM "+name); err != nil {
+		return false, err
+	}
+
+	statements := []string{
+		fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", table, name),
+		fmt.Sprintf("DROP TABLE %s", name),
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return false, fmt.Errorf("failed to drop partition %s: %w", name, err)
+		}
+	}
+
+	for projectID, sessionIDs := range sessions {
+		if _, err := recomputeSessions(ctx, tx, projectID, sessionIDs); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit dropping partition %s: %w", name, err)
+	}
+
+	return true, nil
+}
+
+// partitionSessions returns the sessions, by project, of the traces with
+// generations in partition.
+func partitionSessions(ctx context.Context, tx *sql.Tx, partition string) (map[string][]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT t.project_id, t.session_id
+		FROM `+partition+` g JOIN traces t ON t.id = g.trace_id
+		WHERE t.session_id IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions in %s: %w", partition, err)
+	}
+	defer rows.Close()
+
+	sessions := make(map[string][]string)
+	for rows.Next() {
+		var projectID, sessionID string
+		if err := rows.Scan(&projectID, &sessionID); err != nil {
+			return nil, fmt.Errorf("failed to scan session in %s: %w", partition, err)
+		}
+		sessions[projectID] = append(sessions[projectID], sessionID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions in %s: %w", partition, err)
+	}
+
+	return sessions, nil
+}
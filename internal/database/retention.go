@@ -0,0 +1,267 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// retentionPurges select at most $3 rows of an entity in project $1 that
+// started before $2 and delete them, oldest first. Rows locked by a
+// concurrent purge are skipped so instances never wait on each other.
+// "sessions" isn't user configurable; it follows the traces policy. The
+// entities in cleanupPurges return the id of each deleted row and the session
+// it counted towards, if any.
+var retentionPurges = map[string]string{
+	// Scores outlive their trace, but the generations cascading with it
+	// can't null scores.generation_id on their own.
+	"traces": `WITH purged AS (
+			SELECT id FROM traces
+			WHERE project_id = $1 AND start_time < $2
+			ORDER BY start_time
+			LIMIT $3 FOR UPDATE SKIP LOCKED
+		), detached AS (
+			UPDATE scores SET generation_id = NULL WHERE generation_id IN (
+				SELECT g.id FROM generations g WHERE g.trace_id IN (SELECT id FROM purged))
+		)
+		DELETE FROM traces WHERE id IN (SELECT id FROM purged)
+		RETURNING id, session_id`,
+	"spans": `DELETE FROM spans WHERE start_time < $2 AND id IN (
+		SELECT s.id FROM spans s JOIN traces t ON t.id = s.trace_id
+		WHERE t.project_id = $1 AND s.start_time < $2
+		LIMIT $3 FOR UPDATE OF s SKIP LOCKED)
+		RETURNING id, NULL::text`,
+	"generations": `DELETE FROM generations USING traces tr
+		WHERE tr.id = generations.trace_id AND generations.start_time < $2 AND generations.id IN (
+		SELECT g.id FROM generations g JOIN traces t ON t.id = g.trace_id
+		WHERE t.project_id = $1 AND g.start_time < $2
+		LIMIT $3 FOR UPDATE OF g SKIP LOCKED)
+		RETURNING generations.id, tr.session_id`,
+	"events": `DELETE FROM events WHERE timestamp < $2 AND id IN (
+		SELECT e.id FROM events e JOIN traces t ON t.id = e.trace_id
+		WHERE t.project_id = $1 AND e.timestamp < $2
+		LIMIT $3 FOR UPDATE OF e SKIP LOCKED)`,
+	"scores": `DELETE FROM scores WHERE id IN (
+		SELECT id FROM scores
+		WHERE project_id = $1 AND timestamp < $2
+		ORDER BY timestamp
+		LIMIT $3 FOR UPDATE SKIP LOCKED)`,
+	"sessions": `DELETE FROM sessions WHERE (project_id, id) IN (
+		SELECT project_id, id FROM sessions
+		WHERE project_id = $1 AND last_seen < $2
+		LIMIT $3 FOR UPDATE SKIP LOCKED)`,
+}
+
+// cleanupPurges are the entities that other rows reference without a
+// foreign key, or that are counted in session rollups. Their purges fix those
+// up in the same transaction.
+var cleanupPurges = map[string]bool{
+	"traces":      true,
+	"spans":       true,
+	"generations": true,
+}
+
+// releaseReferences stands in for the foreign keys partitioning removed (see
+// migrations/008_partition_spans_events_generations.sql) once the rows of
+// table listed by deleted are gone: events of a deleted span are deleted, its
+// child spans become roots, and scores of a deleted generation keep only
+// their trace. deleted is a query returning the ids.
+func releaseReferences(ctx context.Context, tx *sql.Tx, table, deleted string, args ...any) error {
+	var statements []string
+	switch table {
+	case "spans":
+		statements = []string{
+			`DELETE FROM events WHERE span_id IN (` + deleted + `)`,
+			`UPDATE spans SET parent_id = NULL, updated_at = NOW() WHERE parent_id IN (` + deleted + `)`,
+		}
+	case "generations":
+		statements = []string{
+			`UPDATE scores SET generation_id = NULL WHERE generation_id IN (` + deleted + `)`,
+		}
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, args...); err != nil {
+			return fmt.Errorf("failed to release %s references: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// scanPurged collects the ids in rows of (id, session_id) and the distinct,
+// non-null session ids.
+func scanPurged(rows *sql.Rows) (ids, sessionIDs []string, err error) {
+	defer rows.Close()
+
+	seen := map[string]bool{}
+	for rows.Next() {
+		var id string
+		var sessionID sql.NullString
+		if err := rows.Scan(&id, &sessionID); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		if sessionID.Valid && !seen[sessionID.String] {
+			seen[sessionID.String] = true
+			sessionIDs = append(sessionIDs, sessionID.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return ids, sessionIDs, nil
+}
+
+func (s *service) GetRetentionSettings(projectID string) (RetentionSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT entity, retention_days FROM retention_policies WHERE project_id = $1", projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get retention settings: %w", err)
+	}
+	defer rows.Close()
+
+	settings := RetentionSettings{}
+	for rows.Next() {
+		var entity string
+		var days int
+		if err := rows.Scan(&entity, &days); err != nil {
+			return nil, fmt.Errorf("failed to scan retention policy: %w", err)
+		}
+		settings[entity] = days
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get retention settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+// SetRetentionSettings replaces the project's retention settings; entities
+// missing from settings go back to being kept forever.
+func (s *service) SetRetentionSettings(projectID string, settings RetentionSettings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM retention_policies WHERE project_id = $1", projectID); err != nil {
+		return fmt.Errorf("failed to clear retention settings: %w", err)
+	}
+
+	for entity, days := range settings {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO retention_policies (project_id, entity, retention_days) VALUES ($1, $2, $3)",
+			projectID, entity, days)
+		if err != nil {
+			return fmt.Errorf("failed to set %s retention: %w", entity, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit retention settings: %w", err)
+	}
+
+	return nil
+}
+
+// ListRetentionPolicies returns every project's retention policies.
+func (s *service) ListRetentionPolicies() ([]RetentionPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT project_id, entity, retention_days FROM retention_policies ORDER BY project_id, entity")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list retention policies: %w", err)
+	}
+	defer rows.Close()
+
+	policies := []RetentionPolicy{}
+	for rows.Next() {
+		var policy RetentionPolicy
+		if err := rows.Scan(&policy.ProjectID, &policy.Entity, &policy.Days); err != nil {
+			return nil, fmt.Errorf("failed to scan retention policy: %w", err)
+		}
+		policies = append(policies, policy)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list retention policies: %w", err)
+	}
+
+	return policies, nil
+}
+
+// PurgeExpired deletes up to limit rows of entity in the project that are
+// older than before and returns how many it deleted. Each call is its own
+// short transaction; callers loop until it returns fewer than limit. Rows
+// removed by ON DELETE CASCADE aren't counted. Purging traces or generations
+// recomputes the rollups of the sessions they belonged to in the same
+// transaction.
+func (s *service) PurgeExpired(projectID, entity string, before time.Time, limit int) (int64, error) {
+	query, ok := retentionPurges[entity]
+	if !ok {
+		return 0, fmt.Errorf("unknown retention entity %q", entity)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if cleanupPurges[entity] {
+		return s.purgeWithCleanup(ctx, projectID, entity, query, before, limit)
+	}
+
+	res, err := s.db.ExecContext(ctx, query, projectID, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge %s: %w", entity, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge %s: %w", entity, err)
+	}
+
+	return n, nil
+}
+
+func (s *service) purgeWithCleanup(ctx context.Context, projectID, entity, query string, before time.Time, limit int) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, projectID, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge %s: %w", entity, err)
+	}
+	ids, sessionIDs, err := scanPurged(rows)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge %s: %w", entity, err)
+	}
+
+	if len(ids) > 0 {
+		if err := releaseReferences(ctx, tx, entity, `SELECT unnest($1::text[])`, ids); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := recomputeSessions(ctx, tx, projectID, sessionIDs); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to purge %s: %w", entity, err)
+	}
+
+	return int64(len(ids)), nil
+}
@@ -0,0 +1,284 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// usageRollupsWatermark names the usage_rollups row in rollup_watermarks.
+const usageRollupsWatermark = "usage_rollups"
+
+// rollupSettleDelay keeps the refresh away from rows whose transactions may
+// not have committed yet; created_at is the transaction start time, so a row
+// can become visible after a later watermark has already passed it.
+const rollupSettleDelay = "1 minute"
+
+// markDirtyHours records every (project, hour) whose rollups are affected by
+// rows written in ($1, $2]. A new generation also dirties the hours of its
+// trace's error events, which are counted against its model.
+const markDirtyHours = `
+	INSERT INTO dirty_hours (project_id, hour)
+	SELECT DISTINCT project_id, date_trunc('hour', ts, 'UTC') FROM (
+		SELECT t.project_id, t.start_time AS ts
+		FROM traces t
+		WHERE t.created_at > $1 AND t.created_at <= $2
+		UNION ALL
+		SELECT t.project_id, g.start_time
+		FROM generations g JOIN traces t ON t.id = g.trace_id
+		WHERE g.created_at > $1 AND g.created_at <= $2
+		UNION ALL
+		SELECT t.project_id, e.timestamp
+		FROM generations g
+		JOIN traces t ON t.id = g.trace_id
+		JOIN events e ON e.trace_id = g.trace_id AND e.level = 'error'
+		WHERE g.created_at > $1 AND g.created_at <= $2
+		UNION ALL
+		SELECT t.project_id, s.start_time
+		FROM spans s JOIN traces t ON t.id = s.trace_id
+		WHERE s.updated_at > $1 AND s.updated_at <= $2
+		UNION ALL
+		SELECT t.project_id, e.timestamp
+		FROM events e JOIN traces t ON t.id = e.trace_id
+		WHERE e.level = 'error' AND e.created_at > $1 AND e.created_at <= $2
+	) changed`
+
+// rollupContributions yields one row per thing counted in usage_rollups:
+// generations by model, spans by type, traces by name and tag, generation
+// usage by trace name and tag, and error events by every dimension of the
+// trace (or span) they belong to. Rows are limited to the dirty time range.
+const rollupContributions = `
+	WITH win AS (
+		SELECT MIN(hour) AS lo, MAX(hour) + interval '1 hour' AS hi FROM dirty_hours
+	), gens AS (
+		SELECT t.project_id, t.name AS trace_name, t.tags, g.model, g.start_time AS ts,
+			CASE WHEN g.end_time >= g.start_time THEN EXTRACT(EPOCH FROM g.end_time - g.start_time) * 1000 END AS latency_ms,
+			COALESCE(g.prompt_tokens, 0) AS prompt_tokens,
+			COALESCE(g.completion_tokens, 0) AS completion_tokens,
+			COALESCE(NULLIF(g.total_tokens, 0), COALESCE(g.prompt_tokens, 0) + COALESCE(g.completion_tokens, 0)) AS total_tokens,
+			COALESCE(g.total_cost, 0) AS total_cost
+		FROM generations g JOIN traces t ON t.id = g.trace_id, win
+		WHERE g.start_time >= win.lo AND g.start_time < win.hi
+	), errs AS (
+		SELECT t.project_id, t.id AS trace_id, t.name AS trace_name, t.tags, e.span_id, e.timestamp AS ts
+		FROM events e JOIN traces t ON t.id = e.trace_id, win
+		WHERE e.level = 'error' AND e.timestamp >= win.lo AND e.timestamp < win.hi
+	)
+	SELECT project_id, 'model'::text AS dimension, model::text AS dimension_value, ts,
+		1::bigint AS items, latency_ms::float8 AS latency_ms,
+		prompt_tokens::bigint AS prompt_tokens, completion_tokens::bigint AS completion_tokens,
+		total_tokens::bigint AS total_tokens, total_cost::numeric AS total_cost, 0::bigint AS errors
+	FROM gens
+	UNION ALL
+	SELECT project_id, 'trace_name', trace_name, ts, 0, NULL, prompt_tokens, completion_tokens, total_tokens, total_cost, 0
+	FROM gens
+	UNION ALL
+	SELECT project_id, 'tag', tag, ts, 0, NULL, prompt_tokens, completion_tokens, total_tokens, total_cost, 0
+	FROM gens CROSS JOIN LATERAL (SELECT DISTINCT tag FROM unnest(gens.tags) AS tag) tg
+	UNION ALL
+	SELECT t.project_id, 'span_type', s.type, s.start_time, 1,
+		CASE WHEN s.end_time >= s.start_time THEN EXTRACT(EPOCH FROM s.end_time - s.start_time) * 1000 END,
+		0, 0, 0, 0, 0
+	FROM spans s JOIN traces t ON t.id = s.trace_id, win
+	WHERE s.type IS NOT NULL AND s.start_time >= win.lo AND s.start_time < win.hi
+	UNION ALL
+	SELECT t.project_id, 'trace_name', t.name, t.start_time, 1,
+		CASE WHEN t.end_time >= t.start_time THEN EXTRACT(EPOCH FROM t.end_time - t.start_time) * 1000 END,
+		0, 0, 0, 0, 0
+	FROM traces t, win
+	WHERE t.start_time >= win.lo AND t.start_time < win.hi
+	UNION ALL
+	SELECT t.project_id, 'tag', tag, t.start_time, 1,
+		CASE WHEN t.end_time >= t.start_time THEN EXTRACT(EPOCH FROM t.end_time - t.start_time) * 1000 END,
+		0, 0, 0, 0, 0
+	FROM traces t CROSS JOIN LATERAL (SELECT DISTINCT tag FROM unnest(t.tags) AS tag) tg, win
+	WHERE t.start_time >= win.lo AND t.start_time < win.hi
+	UNION ALL
+	SELECT errs.project_id, 'model', m.model, errs.ts, 0, NULL, 0, 0, 0, 0, 1
+	FROM errs CROSS JOIN LATERAL (SELECT DISTINCT model FROM generations WHERE trace_id = errs.trace_id) m
+	UNION ALL
+	SELECT errs.project_id, 'span_type', s.type, errs.ts, 0, NULL, 0, 0, 0, 0, 1
+	FROM errs JOIN spans s ON s.id = errs.span_id
+	WHERE s.type IS NOT NULL
+	UNION ALL
+	SELECT project_id, 'trace_name', trace_name, ts, 0, NULL, 0, 0, 0, 0, 1
+	FROM errs
+	UNION ALL
+	SELECT errs.project_id, 'tag', tag, errs.ts, 0, NULL, 0, 0, 0, 0, 1
+	FROM errs CROSS JOIN LATERAL (SELECT DISTINCT tag FROM unnest(errs.tags) AS tag) tg`
+
+// latencyBucketsSQL renders LatencyBucketBoundsMs as a float8[] literal for
+// width_bucket, whose result (0 through len(bounds)) indexes the histogram.
+func latencyBucketsSQL() string {
+	bounds := make([]string, len(LatencyBucketBoundsMs))
+	for i, b := range LatencyBucketBoundsMs {
+		bounds[i] = strconv.FormatFloat(b, 'f', -1, 64)
+	}
+	return "ARRAY[" + strings.Join(bounds, ", ") + "]::float8[]"
+}
+
+// histogramSQL builds an ARRAY[...] with one element per histogram bucket,
+// rendering each with column(i). SQL arrays are 1-based.
+func histogramSQL(column func(i int) string) string {
+	elems := make([]string, len(LatencyBucketBoundsMs)+1)
+	for i := range elems {
+		elems[i] = column(i)
+	}
+	return "ARRAY[" + strings.Join(elems, ", ") + "]"
+}
+
+// RefreshUsageRollups recomputes the hourly usage rollups touched by rows
+// written since the last refresh and returns how many (project, hour) pairs
+// it rebuilt. Rebuilding whole hours from the source tables keeps late and
+// updated rows correct. Concurrent refreshes from other instances are
+// skipped rather than waited on.
+func (s *service) RefreshUsageRollups() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock(hashtext($1))", usageRollupsWatermark).Scan(&locked); err != nil {
+		return 0, fmt.Errorf("failed to lock usage rollups: %w", err)
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	var since, until time.Time
+	err = tx.QueryRowContext(ctx, `SELECT
+			COALESCE((SELECT watermark FROM rollup_watermarks WHERE name = $1), 'epoch'::timestamptz),
+			now() - interval '`+rollupSettleDelay+`'`,
+		usageRollupsWatermark).Scan(&since, &until)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read rollup watermark: %w", err)
+	}
+	if !until.After(since) {
+		return 0, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "CREATE TEMP TABLE dirty_hours (project_id VARCHAR(255), hour TIMESTAMP WITH TIME ZONE) ON COMMIT DROP"); err != nil {
+		return 0, fmt.Errorf("failed to create dirty hours table: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, markDirtyHours, since, until)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find changed hours: %w", err)
+	}
+	dirty, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find changed hours: %w", err)
+	}
+
+	if dirty > 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM usage_rollups r USING dirty_hours d
+			WHERE r.project_id = d.project_id AND r.bucket_start = d.hour`)
+		if err != nil {
+			return 0, fmt.Errorf("failed to clear usage rollups: %w", err)
+		}
+
+		histogram := histogramSQL(func(i int) string {
+			return fmt.Sprintf("COUNT(*) FILTER (WHERE c.latency_bucket = %d)", i)
+		})
+		insert := `INSERT INTO usage_rollups (project_id, dimension, dimension_value, bucket_start,
+				item_count, latency_count, latency_sum_ms, latency_max_ms, latency_histogram,
+				prompt_tokens, completion_tokens, total_tokens, total_cost, error_count)
+			SELECT c.project_id, c.dimension, c.dimension_value, d.hour,
+				SUM(c.items), COUNT(c.latency_ms), COALESCE(SUM(c.latency_ms), 0), COALESCE(MAX(c.latency_ms), 0),
+				` + histogram + `,
+				SUM(c.prompt_tokens), SUM(c.completion_tokens), SUM(c.total_tokens), SUM(c.total_cost), SUM(c.errors)
+			FROM (
+				SELECT rc.*, width_bucket(rc.latency_ms, ` + latencyBucketsSQL() + `) AS latency_bucket
+				FROM (` + rollupContributions + `
+				) rc
+			) c
+			JOIN dirty_hours d ON d.project_id = c.project_id AND d.hour = date_trunc('hour', c.ts, 'UTC')
+			GROUP BY c.project_id, c.dimension, c.dimension_value, d.hour`
+
+		if _, err := tx.ExecContext(ctx, insert); err != nil {
+			return 0, fmt.Errorf("failed to build usage rollups: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO rollup_watermarks (name, watermark) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET watermark = EXCLUDED.watermark`,
+		usageRollupsWatermark, until)
+	if err != nil {
+		return 0, fmt.Errorf("failed to advance rollup watermark: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit usage rollups: %w", err)
+	}
+
+	return int(dirty), nil
+}
+
+// QueryTimeseries returns the rollups for one dimension merged into interval
+// buckets, ordered by group then bucket start. Buckets without data are
+// omitted. Rollups are hourly, so the range is widened to whole hours.
+func (s *service) QueryTimeseries(projectID string, q TimeseriesQuery) ([]TimeseriesRow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	histogram := histogramSQL(func(i int) string {
+		return fmt.Sprintf("COALESCE(SUM(latency_histogram[%d]), 0)::bigint", i+1)
+	})
+	query := `SELECT date_trunc($3, bucket_start AT TIME ZONE 'UTC') AS start, dimension_value,
+			SUM(item_count)::bigint, SUM(latency_count)::bigint,
+			SUM(latency_sum_ms)::float8, MAX(latency_max_ms)::float8,
+			array_to_json(` + histogram + `),
+			SUM(prompt_tokens)::bigint, SUM(completion_tokens)::bigint, SUM(total_tokens)::bigint,
+			SUM(total_cost)::float8, SUM(error_count)::bigint
+		FROM usage_rollups
+		WHERE project_id = $1 AND dimension = $2
+		  AND bucket_start >= date_trunc('hour', $4::timestamptz, 'UTC') AND bucket_start < $5
+		  AND ($6::text[] IS NULL OR dimension_value = ANY($6))
+		GROUP BY 1, 2
+		ORDER BY 2, 1`
+
+	var values any
+	if len(q.Values) > 0 {
+		values = q.Values
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, projectID, q.GroupBy, q.Interval, q.From, q.To, values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query timeseries: %w", err)
+	}
+	defer rows.Close()
+
+	result := []TimeseriesRow{}
+	for rows.Next() {
+		var row TimeseriesRow
+		var histogram []byte
+
+		err := rows.Scan(&row.Start, &row.Group, &row.Count, &row.LatencyCount,
+			&row.LatencySumMs, &row.LatencyMaxMs, &histogram,
+			&row.PromptTokens, &row.CompletionTokens, &row.TotalTokens,
+			&row.TotalCost, &row.ErrorCount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan timeseries row: %w", err)
+		}
+		if err := json.Unmarshal(histogram, &row.Histogram); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal latency histogram: %w", err)
+		}
+		row.Start = row.Start.UTC()
+
+		result = append(result, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query timeseries: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const sessionColumns = `id, project_id, COALESCE(user_id, ''), first_seen, last_seen,
+	trace_count, total_tokens, total_cost::float8`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row rowScanner) (Session, error) {
+	var session Session
+	err := row.Scan(&session.ID, &session.ProjectID, &session.UserID, &session.FirstSeen, &session.LastSeen,
+		&session.TraceCount, &session.TotalTokens, &session.TotalCost)
+	return session, err
+}
+
+// ListSessions returns the project's sessions, most recently active first.
+func (s *service) ListSessions(projectID string, opts SessionListOptions) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE project_id = $1 AND ($2::text = '' OR user_id = $2)
+		ORDER BY last_seen DESC, id
+		LIMIT $3 OFFSET $4`
+
+	rows, err := s.db.QueryContext(ctx, query, projectID, opts.UserID, opts.Limit, opts.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// GetSession returns the session together with its traces in the order they
+// started.
+func (s *service) GetSession(projectID, sessionID string) (*SessionDetail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE project_id = $1 AND id = $2`
+
+	session, err := scanSession(s.db.QueryRowContext(ctx, query, projectID, sessionID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	tracesQuery := `SELECT t.id, t.name, COALESCE(t.user_id, ''), COALESCE(array_to_json(t.tags), '[]'),
+			t.metadata, t.start_time, t.end_time, t.enrichment,
+			COALESCE(SUM(COALESCE(NULLIF(g.total_tokens, 0), COALESCE(g.prompt_tokens, 0) + COALESCE(g.completion_tokens, 0))), 0),
+			COALESCE(SUM(g.total_cost), 0)::float8
+		FROM traces t
+		LEFT JOIN generations g ON g.trace_id = t.id
+		WHERE t.project_id = $1 AND t.session_id = $2
+		GROUP BY t.id
+		ORDER BY t.start_time, t.id`
+
+	rows, err := s.db.QueryContext(ctx, tracesQuery, projectID, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session traces: %w", err)
+	}
+	defer rows.Close()
+
+	detail := &SessionDetail{
+		Session: session,
+		Traces:  []SessionTrace{},
+	}
+
+	for rows.Next() {
+		var trace SessionTrace
+		var tags, metadata, enrichment []byte
+		var endTime sql.NullTime
+
+		err := rows.Scan(&trace.ID, &trace.Name, &trace.UserID, &tags, &metadata,
+			&trace.StartTime, &endTime, &enrichment, &trace.TotalTokens, &trace.TotalCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan trace: %w", err)
+		}
+
+		if err := json.Unmarshal(tags, &trace.Tags); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal tags: %w", err)
+		}
+		if metadata != nil {
+			if err := json.Unmarshal(metadata, &trace.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+			}
+		}
+		if enrichment != nil {
+			if err := json.Unmarshal(enrichment, &trace.Enrichment); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal enrichment: %w", err)
+			}
+		}
+		if endTime.Valid {
+			trace.EndTime = &endTime.Time
+		}
+
+		detail.Traces = append(detail.Traces, trace)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get session traces: %w", err)
+	}
+
+	return detail, nil
+}
+
+// recomputeSessions rebuilds the rollups of the project's sessions in
+// sessionIDs from the traces and generations left after a deletion, and
+// drops the sessions that have no traces left. It returns how many sessions
+// it dropped. It runs in the deleting transaction so readers never see
+// rollups that count deleted rows.
+func recomputeSessions(ctx context.Context, tx *sql.Tx, projectID string, sessionIDs []string) (int64, error) {
+	if len(sessionIDs) == 0 {
+		return 0, nil
+	}
+
+	dropped, err := execCount(ctx, tx, `DELETE FROM sessions s
+		WHERE s.project_id = $1 AND s.id = ANY($2)
+			AND NOT EXISTS(SELECT 1 FROM traces t WHERE t.project_id = s.project_id AND t.session_id = s.id)`,
+		projectID, sessionIDs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to drop empty sessions: %w", err)
+	}
+
+	// Tokens follow UsageMetrics.Tokens: the total, or prompt plus
+	// completion when no total was reported. last_seen follows CreateTrace and
+	// CreateGeneration: the later of each row's start and end.
+	_, err = tx.ExecContext(ctx, `WITH rollup AS (
+			SELECT t.session_id AS id,
+				COUNT(DISTINCT t.id) AS trace_count,
+				COALESCE(SUM(COALESCE(NULLIF(g.total_tokens, 0), COALESCE(g.prompt_tokens, 0) + COALESCE(g.completion_tokens, 0))), 0) AS total_tokens,
+				COALESCE(SUM(g.total_cost), 0) AS total_cost,
+				MIN(t.start_time) AS first_seen,
+				GREATEST(MAX(GREATEST(t.start_time, t.end_time)), MAX(GREATEST(g.start_time, g.end_time))) AS last_seen
+			FROM traces t LEFT JOIN generations g ON g.trace_id = t.id
+			WHERE t.project_id = $1 AND t.session_id = ANY($2)
+			GROUP BY t.session_id
+		)
+		UPDATE sessions s SET
+			trace_count = r.trace_count,
+			total_tokens = r.total_tokens,
+			total_cost = r.total_cost,
+			first_seen = r.first_seen,
+			last_seen = r.last_seen,
+			updated_at = NOW()
+		FROM rollup r
+		WHERE s.project_id = $1 AND s.id = r.id`, projectID, sessionIDs)
+	if err != nil {
+		return 0, fmt.Errorf("failed to recompute sessions: %w", err)
+	}
+
+	return dropped, nil
+}
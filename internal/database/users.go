@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// userTraceMetrics selects one row per trace in the user_traces CTE with the
+// usage of its generations, its error events and its scores. Scores may hang
+// off the trace or off one of its generations.
+const userTraceMetrics = `
+	SELECT ut.id, ut.user_id, ut.start_time, ut.last_seen,
+		COALESCE(g.generation_count, 0) AS generation_count,
+		COALESCE(g.total_tokens, 0) AS total_tokens,
+		COALESCE(g.total_cost, 0) AS total_cost,
+		COALESCE(e.error_count, 0) AS error_count,
+		COALESCE(sc.score_count, 0) AS score_count,
+		COALESCE(sc.score_sum, 0) AS score_sum
+	FROM user_traces ut
+	LEFT JOIN LATERAL (
+		SELECT COUNT(*) AS generation_count,
+			SUM(COALESCE(NULLIF(total_tokens, 0), COALESCE(prompt_tokens, 0) + COALESCE(completion_tokens, 0))) AS total_tokens,
+			SUM(COALESCE(total_cost, 0)) AS total_cost
+		FROM generations WHERE trace_id = ut.id
+	) g ON true
+	LEFT JOIN LATERAL (
+		SELECT COUNT(*) AS error_count
+		FROM events WHERE trace_id = ut.id AND level = 'error'
+	) e ON true
+	LEFT JOIN LATERAL (
+		SELECT COUNT(*) AS score_count, SUM(value) AS score_sum
+		FROM scores
+		WHERE trace_id = ut.id
+		   OR generation_id IN (SELECT id FROM generations WHERE trace_id = ut.id)
+	) sc ON true`
+
+// userMetricsAggregates sums the per-trace rows of userTraceMetrics.
+const userMetricsAggregates = `
+	COUNT(m.id),
+	COALESCE(SUM(m.generation_count), 0),
+	COALESCE(SUM(m.total_tokens), 0),
+	COALESCE(SUM(m.total_cost), 0)::float8,
+	COALESCE(SUM(m.error_count), 0),
+	COALESCE(SUM(m.score_count), 0),
+	(SUM(m.score_sum) / NULLIF(SUM(m.score_count), 0))::float8`
+
+func userMetricsDest(m *UserMetrics, avgScore *sql.NullFloat64) []any {
+	return []any{&m.TraceCount, &m.GenerationCount, &m.TotalTokens, &m.TotalCost,
+		&m.ErrorCount, &m.ScoreCount, avgScore}
+}
+
+// ListUsers returns the end users with traces in the window, most recently
+// active first.
+func (s *service) ListUsers(projectID string, opts UserListOptions) ([]UserSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	query := `WITH user_traces AS (
+			SELECT id, user_id, start_time, COALESCE(end_time, start_time) AS last_seen
+			FROM traces
+			WHERE project_id = $1 AND user_id <> ''
+			  AND start_time >= $2 AND start_time < $3
+		), m AS (` + userTraceMetrics + `
+		)
+		SELECT m.user_id, MIN(m.start_time), MAX(m.last_seen),` + userMetricsAggregates + `
+		FROM m
+		GROUP BY m.user_id
+		ORDER BY MAX(m.last_seen) DESC, m.user_id
+		LIMIT $4 OFFSET $5`
+
+	rows, err := s.db.QueryContext(ctx, query, projectID, opts.From, opts.To, opts.Limit, opts.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []UserSummary{}
+	for rows.Next() {
+		var user UserSummary
+		var avgScore sql.NullFloat64
+
+		dest := append([]any{&user.UserID, &user.FirstSeen, &user.LastSeen}, userMetricsDest(&user.UserMetrics, &avgScore)...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		if avgScore.Valid {
+			user.AvgScore = &avgScore.Float64
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return users, nil
+}
+
+// GetUserMetrics returns one bucket per interval between opts.From and
+// opts.To, including empty ones, bucketed by trace start time in UTC.
+func (s *service) GetUserMetrics(projectID, userID string, opts UserMetricsOptions) ([]UserMetricsBucket, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM traces WHERE project_id = $1 AND user_id = $2)",
+		projectID, userID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check user existence: %w", err)
+	}
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+
+	query := `WITH user_traces AS (
+			SELECT id, user_id, start_time, COALESCE(end_time, start_time) AS last_seen
+			FROM traces
+			WHERE project_id = $1 AND user_id = $2
+			  AND start_time >= $3 AND start_time < $4
+		), m AS (` + userTraceMetrics + `
+		), buckets AS (
+			SELECT generate_series(
+				date_trunc($5, $3::timestamptz AT TIME ZONE 'UTC'),
+				$4::timestamptz AT TIME ZONE 'UTC' - interval '1 microsecond',
+				('1 ' || $5)::interval
+			) AS start
+		)
+		SELECT b.start,` + userMetricsAggregates + `
+		FROM buckets b
+		LEFT JOIN m ON date_trunc($5, m.start_time AT TIME ZONE 'UTC') = b.start
+		GROUP BY b.start
+		ORDER BY b.start`
+
+	rows, err := s.db.QueryContext(ctx, query, projectID, userID, opts.From, opts.To, opts.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user metrics: %w", err)
+	}
+	defer rows.Close()
+
+	buckets := []UserMetricsBucket{}
+	for rows.Next() {
+		var bucket UserMetricsBucket
+		var avgScore sql.NullFloat64
+
+		dest := append([]any{&bucket.Start}, userMetricsDest(&bucket.UserMetrics, &avgScore)...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan user metrics: %w", err)
+		}
+		if avgScore.Valid {
+			bucket.AvgScore = &avgScore.Float64
+		}
+		bucket.Start = bucket.Start.UTC()
+
+		buckets = append(buckets, bucket)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get user metrics: %w", err)
+	}
+
+	return buckets, nil
+}
@@ -0,0 +1,277 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const webhookColumns = `id, project_id, url, COALESCE(description, ''), rules, secret, enabled,
+	consecutive_failures, disabled_at, COALESCE(disabled_reason, ''), created_at, updated_at`
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var w Webhook
+	var rules []byte
+	var disabledAt sql.NullTime
+
+	err := row.Scan(&w.ID, &w.ProjectID, &w.URL, &w.Description, &rules, &w.Secret, &w.Enabled,
+		&w.ConsecutiveFailures, &disabledAt, &w.DisabledReason, &w.CreatedAt, &w.UpdatedAt)
+	if err != nil {
+		return w, err
+	}
+
+	if err := json.Unmarshal(rules, &w.Rules); err != nil {
+		return w, fmt.Errorf("failed to unmarshal webhook rules: %w", err)
+	}
+	if disabledAt.Valid {
+		w.DisabledAt = &disabledAt.Time
+	}
+
+	return w, nil
+}
+
+func (s *service) CreateWebhook(w Webhook) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rules, err := json.Marshal(w.Rules)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook rules: %w", err)
+	}
+	if w.CreatedAt.IsZero() {
+		w.CreatedAt = time.Now().UTC()
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO webhooks (id, project_id, url, description, rules, secret, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $8)`,
+		w.ID, w.ProjectID, w.URL, w.Description, rules, w.Secret, w.Enabled, w.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	return nil
+}
+
+func (s *service) GetWebhook(projectID, webhookID string) (*Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE id = $1 AND project_id = $2`
+
+	w, err := scanWebhook(s.db.QueryRowContext(ctx, query, webhookID, projectID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+
+	return &w, nil
+}
+
+// ListWebhooks returns the project's webhooks, oldest first.
+func (s *service) ListWebhooks(projectID string) ([]Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE project_id = $1 ORDER BY created_at, id`
+
+	rows, err := s.db.QueryContext(ctx, query, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces the webhook's URL, description and rules. Enabling
+// it clears its failures; disabling it by hand clears why it was disabled.
+func (s *service) UpdateWebhook(projectID, webhookID string, req WebhookRequest) (*Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rules, err := json.Marshal(req.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal webhook rules: %w", err)
+	}
+
+	var enabled sql.NullBool
+	if req.Enabled != nil {
+		enabled = sql.NullBool{Bool: *req.Enabled, Valid: true}
+	}
+
+	query := `UPDATE webhooks SET
+			url = $3,
+			description = NULLIF($4, ''),
+			rules = $5,
+			enabled = COALESCE($6, enabled),
+			consecutive_failures = CASE WHEN $6 THEN 0 ELSE consecutive_failures END,
+			disabled_at = CASE WHEN $6 IS NULL THEN disabled_at END,
+			disabled_reason = CASE WHEN $6 IS NULL THEN disabled_reason END,
+			updated_at = NOW()
+		WHERE id = $1 AND project_id = $2
+		RETURNING ` + webhookColumns
+
+	w, err := scanWebhook(s.db.QueryRowContext(ctx, query, webhookID, projectID, req.URL, req.Description, rules, enabled))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+
+	return &w, nil
+}
+
+// DeleteWebhook deletes the webhook along with its delivery log.
+func (s *service) DeleteWebhook(projectID, webhookID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1 AND project_id = $2", webhookID, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// RecordWebhookDelivery logs a delivery attempt and counts it towards the
+// webhook's consecutive failures, or resets them on success. It disables
+// the webhook once disableAfter attempts in a row have failed, unless
+// disableAfter is 0, and reports whether this attempt disabled it.
+func (s *service) RecordWebhookDelivery(d WebhookDelivery, disableAfter int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = time.Now().UTC()
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var enabled bool
+	var failures int
+	err = tx.QueryRowContext(ctx,
+		"SELECT enabled, consecutive_failures FROM webhooks WHERE id = $1 AND project_id = $2 FOR UPDATE",
+		d.WebhookID, d.ProjectID).Scan(&enabled, &failures)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrWebhookNotFound
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get webhook: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (id, webhook_id, project_id, event_id, event_type, attempt,
+			status_code, success, error, response, duration_ms, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0), $8, NULLIF($9, ''), NULLIF($10, ''), $11, $12)`,
+		d.ID, d.WebhookID, d.ProjectID, d.EventID, d.EventType, d.Attempt,
+		d.StatusCode, d.Success, d.Error, d.Response, d.DurationMS, d.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+
+	failures++
+	if d.Success {
+		failures = 0
+	}
+	disable := enabled && disableAfter > 0 && failures >= disableAfter
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE webhooks SET
+			consecutive_failures = $3,
+			enabled = enabled AND NOT $4,
+			disabled_at = CASE WHEN $4 THEN NOW() ELSE disabled_at END,
+			disabled_reason = CASE WHEN $4 THEN $5 ELSE disabled_reason END,
+			updated_at = NOW()
+		WHERE id = $1 AND project_id = $2`,
+		d.WebhookID, d.ProjectID, failures, disable,
+		fmt.Sprintf("%d consecutive failed deliveries, the last: %s", failures, d.Error))
+	if err != nil {
+		return false, fmt.Errorf("failed to update webhook failures: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return disable, nil
+}
+
+// ListWebhookDeliveries returns the webhook's delivery attempts, newest
+// first.
+func (s *service) ListWebhookDeliveries(projectID, webhookID string, limit, offset int) ([]WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT id, webhook_id, project_id, event_id, event_type, attempt, COALESCE(status_code, 0),
+			success, COALESCE(error, ''), COALESCE(response, ''), duration_ms, created_at
+		FROM webhook_deliveries
+		WHERE project_id = $1 AND webhook_id = $2
+		ORDER BY created_at DESC, id
+		LIMIT $3 OFFSET $4`
+
+	rows, err := s.db.QueryContext(ctx, query, projectID, webhookID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.ProjectID, &d.EventID, &d.EventType, &d.Attempt, &d.StatusCode,
+			&d.Success, &d.Error, &d.Response, &d.DurationMS, &d.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+// PurgeWebhookDeliveries deletes delivery attempts logged before before.
+func (s *service) PurgeWebhookDeliveries(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE created_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge webhook deliveries: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,60 @@
+package enrich
+
+import (
+	"context"
+	"time"
+)
+
+// DurationEnricher records how long a finished trace took, as duration_ms.
+type DurationEnricher struct{}
+
+func (DurationEnricher) Name() string { return "duration" }
+
+func (DurationEnricher) Enrich(ctx context.Context, in Input) (map[string]any, error) {
+	trace := in.Trace
+	if trace.EndTime == nil || trace.StartTime.IsZero() {
+		return nil, nil
+	}
+	return map[string]any{"duration_ms": trace.EndTime.Sub(trace.StartTime).Milliseconds()}, nil
+}
+
+// ReceivedAtEnricher records when the service received the trace, as
+// server_received_at, so client clock skew shows against start_time.
+type ReceivedAtEnricher struct{}
+
+func (ReceivedAtEnricher) Name() string { return "received_at" }
+
+func (ReceivedAtEnricher) Enrich(ctx context.Context, in Input) (map[string]any, error) {
+	if in.ReceivedAt.IsZero() {
+		return nil, nil
+	}
+	return map[string]any{"server_received_at": in.ReceivedAt.UTC().Format(time.RFC3339Nano)}, nil
+}
+
+// ClientEnricher records the client's IP, User-Agent and SDK, as client.
+type ClientEnricher struct{}
+
+func (ClientEnricher) Name() string { return "client" }
+
+func (ClientEnricher) Enrich(ctx context.Context, in Input) (map[string]any, error) {
+	if in.Client == (Client{}) {
+		return nil, nil
+	}
+	return map[string]any{"client": in.Client}, nil
+}
+
+// GeoEnricher looks the client's IP up in GeoIP and records its country, as
+// geo. Private and unknown addresses are left out.
+type GeoEnricher struct {
+	GeoIP *GeoIP
+}
+
+func (GeoEnricher) Name() string { return "geo" }
+
+func (e GeoEnricher) Enrich(ctx context.Context, in Input) (map[string]any, error) {
+	country, ok := e.GeoIP.Country(in.Client.IP)
+	if !ok {
+		return nil, nil
+	}
+	return map[string]any{"geo": map[string]any{"country": country}}, nil
+}
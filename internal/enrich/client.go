@@ -0,0 +1,33 @@
+package enrich
+
+import "context"
+
+// Headers SDKs send to identify themselves. gRPC clients send the same keys
+// as metadata, in lower case.
+const (
+	SDKNameHeader    = "X-Langlite-Sdk-Name"
+	SDKVersionHeader = "X-Langlite-Sdk-Version"
+)
+
+// Client describes who sent an item: the address it came from, its
+// User-Agent and the SDK that built it.
+type Client struct {
+	IP         string `json:"ip,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	SDKName    string `json:"sdk_name,omitempty"`
+	SDKVersion string `json:"sdk_version,omitempty"`
+}
+
+type clientKey struct{}
+
+// WithClient attaches the client a request came from to ctx, so items
+// enqueued while handling it carry it to enrichment.
+func WithClient(ctx context.Context, c Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// ClientFromContext returns the client attached to ctx, or the zero Client.
+func ClientFromContext(ctx context.Context) Client {
+	c, _ := ctx.Value(clientKey{}).(Client)
+	return c
+}
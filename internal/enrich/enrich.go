@@ -0,0 +1,101 @@
+// Package enrich derives fields for ingested traces: how long they took, when
+// and how they reached the service, and where from. Enrichers are pluggable:
+// a Registry holds the ones available, and each project picks which of them
+// run on its traces.
+package enrich
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"langlite-ingestion/internal/database"
+)
+
+// Input is what an enricher sees of a trace: the trace as ingested, and the
+// client and time it arrived with.
+type Input struct {
+	Trace      database.TraceRequest
+	Client     Client
+	ReceivedAt time.Time
+}
+
+// Enricher derives fields for a trace. The fields of every enricher that
+// runs are merged into the trace's enrichment, so each enricher should use
+// its own keys.
+type Enricher interface {
+	// Name identifies the enricher in project settings.
+	Name() string
+	Enrich(ctx context.Context, in Input) (map[string]any, error)
+}
+
+// Registry holds the enrichers a deployment offers.
+type Registry struct {
+	enrichers map[string]Enricher
+	// names keeps the registration order, which is the order they run in.
+	names []string
+}
+
+func NewRegistry(enrichers ...Enricher) *Registry {
+	r := &Registry{enrichers: make(map[string]Enricher)}
+	for _, e := range enrichers {
+		r.Register(e)
+	}
+	return r
+}
+
+// DefaultRegistry offers the built-in enrichers: duration, received_at,
+// client and, if geo is set, geo.
+func DefaultRegistry(geo *GeoIP) *Registry {
+	r := NewRegistry(DurationEnricher{}, ReceivedAtEnricher{}, ClientEnricher{})
+	if geo != nil {
+		r.Register(GeoEnricher{GeoIP: geo})
+	}
+	return r
+}
+
+// Register adds e, replacing any enricher of the same name.
+func (r *Registry) Register(e Enricher) {
+	if _, ok := r.enrichers[e.Name()]; !ok {
+		r.names = append(r.names, e.Name())
+	}
+	r.enrichers[e.Name()] = e
+}
+
+// Names lists the registered enrichers in the order they run.
+func (r *Registry) Names() []string {
+	return append([]string(nil), r.names...)
+}
+
+func (r *Registry) Has(name string) bool {
+	_, ok := r.enrichers[name]
+	return ok
+}
+
+// Enrich runs the named enrichers, or every registered one if names is nil,
+// and merges their fields. Names that aren't registered are skipped. When
+// enrichers fail, it returns the others' fields along with their errors.
+func (r *Registry) Enrich(ctx context.Context, names []string, in Input) (map[string]any, error) {
+	if names == nil {
+		names = r.names
+	}
+
+	fields := make(map[string]any)
+	var errs []error
+	for _, name := range r.names {
+		if !slices.Contains(names, name) {
+			continue
+		}
+
+		derived, err := r.enrichers[name].Enrich(ctx, in)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		maps.Copy(fields, derived)
+	}
+	return fields, errors.Join(errs...)
+}
@@ -0,0 +1,154 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"langlite-ingestion/internal/database"
+)
+
+// fieldEnricher sets one field, or fails with err.
+type fieldEnricher struct {
+	name, key string
+	value     any
+	err       error
+}
+
+func (e fieldEnricher) Name() string { return e.name }
+
+func (e fieldEnricher) Enrich(ctx context.Context, in Input) (map[string]any, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	return map[string]any{e.key: e.value}, nil
+}
+
+func TestRegistryEnrich(t *testing.T) {
+	r := NewRegistry(
+		fieldEnricher{name: "a", key: "shared", value: "a"},
+		fieldEnricher{name: "b", key: "shared", value: "b"},
+		fieldEnricher{name: "c", key: "c", value: 3},
+		fieldEnricher{name: "broken", err: errors.New("boom")},
+	)
+
+	t.Run("every enricher in order", func(t *testing.T) {
+		fields, err := r.Enrich(context.Background(), nil, Input{})
+		if err == nil || !strings.Contains(err.Error(), "broken: boom") {
+			t.Errorf("expected broken's error; got %v", err)
+		}
+		// b runs after a, so its value wins.
+		if fields["shared"] != "b" || fields["c"] != 3 {
+			t.Errorf("unexpected fields %v", fields)
+		}
+	})
+
+	t.Run("named enrichers", func(t *testing.T) {
+		fields, err := r.Enrich(context.Background(), []string{"c", "a", "unknown"}, Input{})
+		if err != nil {
+			t.Fatalf("Enrich: %v", err)
+		}
+		if len(fields) != 2 || fields["shared"] != "a" || fields["c"] != 3 {
+			t.Errorf("unexpected fields %v", fields)
+		}
+	})
+
+	t.Run("none", func(t *testing.T) {
+		fields, err := r.Enrich(context.Background(), []string{}, Input{})
+		if err != nil || len(fields) != 0 {
+			t.Errorf("expected no fields; got %v, %v", fields, err)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		r := NewRegistry(fieldEnricher{name: "a", key: "k", value: 1}, fieldEnricher{name: "b", key: "b", value: 2})
+		r.Register(fieldEnricher{name: "a", key: "k", value: 10})
+		if names := r.Names(); len(names) != 2 || names[0] != "a" {
+			t.Errorf("expected a to keep its place; got %v", names)
+		}
+		if fields, _ := r.Enrich(context.Background(), []string{"a"}, Input{}); fields["k"] != 10 {
+			t.Errorf("expected the replacement to run; got %v", fields)
+		}
+	})
+}
+
+func TestBuiltinEnrichers(t *testing.T) {
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Second)
+	in := Input{
+		Trace:      database.TraceRequest{ID: "t1", StartTime: start, EndTime: &end},
+		Client:     Client{IP: "2001:db8::1", SDKName: "langlite-js", SDKVersion: "0.4.1"},
+		ReceivedAt: start.Add(3 * time.Second),
+	}
+
+	geo, err := LoadGeoIP(strings.NewReader("2001:db8::,2001:db8::ffff,DE\n"))
+	if err != nil {
+		t.Fatalf("LoadGeoIP: %v", err)
+	}
+	fields, err := DefaultRegistry(geo).Enrich(context.Background(), nil, in)
+	if err != nil {
+		t.Fatalf("Enrich: %v", err)
+	}
+
+	if fields["duration_ms"] != int64(2000) {
+		t.Errorf("expected duration_ms 2000; got %v", fields["duration_ms"])
+	}
+	if fields["server_received_at"] != "2026-03-01T10:00:03Z" {
+		t.Errorf("unexpected server_received_at %v", fields["server_received_at"])
+	}
+	if fields["client"] != in.Client {
+		t.Errorf("unexpected client %v", fields["client"])
+	}
+	if geo, _ := fields["geo"].(map[string]any); geo["country"] != "DE" {
+		t.Errorf("expected country DE; got %v", fields["geo"])
+	}
+
+	// An unfinished trace from an unknown client gets none of them.
+	fields, _ = DefaultRegistry(geo).Enrich(context.Background(), nil, Input{Trace: database.TraceRequest{StartTime: start}})
+	if len(fields) != 0 {
+		t.Errorf("expected no fields; got %v", fields)
+	}
+}
+
+func TestGeoIPCountry(t *testing.T) {
+	csv := `# start_ip,end_ip,country_code
+81.2.69.0,81.2.69.255,GB
+1.0.0.0,1.0.0.255,AU
+10.0.0.0,10.255.255.255,ZZ
+2a02:c7c::,2a02:c7f:ffff:ffff:ffff:ffff:ffff:ffff,gb
+`
+	geo, err := LoadGeoIP(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("LoadGeoIP: %v", err)
+	}
+	if geo.Len() != 3 {
+		t.Errorf("expected 3 ranges; got %d", geo.Len())
+	}
+
+	tests := []struct {
+		ip      string
+		country string
+	}{
+		{"81.2.69.160", "GB"},
+		{"81.2.69.0", "GB"},
+		{"1.0.0.255", "AU"},
+		{"1.0.1.0", ""},
+		{"10.1.2.3", ""},
+		{"0.0.0.1", ""},
+		{"2a02:c7d:1234::1", "GB"},
+		{"::ffff:81.2.69.1", "GB"},
+		{"not an ip", ""},
+	}
+	for _, tt := range tests {
+		country, ok := geo.Country(tt.ip)
+		if country != tt.country || ok != (tt.country != "") {
+			t.Errorf("Country(%q) = %q, %v; want %q", tt.ip, country, ok, tt.country)
+		}
+	}
+
+	if _, err := LoadGeoIP(strings.NewReader("1.0.0.0,AU\n")); err == nil {
+		t.Error("expected an error for a short row")
+	}
+}
@@ -0,0 +1,158 @@
+package enrich
+
+import (
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// geoipFiles is the offline GeoIP database built into the binary: every .csv
+// file under geoip/. `make geoip` downloads one before a release build.
+//
+//go:embed geoip
+var geoipFiles embed.FS
+
+// GeoIP maps IP addresses to countries offline.
+type GeoIP struct {
+	// ranges are sorted by start. IPv4 addresses are kept as IPv4-mapped
+	// IPv6 so both families sort together.
+	ranges []ipRange
+}
+
+type ipRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// LoadGeoIP reads rows of start_ip,end_ip,country_code, the layout of
+// DB-IP's "IP to Country Lite" CSV. Extra columns are ignored, as are lines
+// starting with '#' and the unknown country "ZZ".
+func LoadGeoIP(r io.Reader) (*GeoIP, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	g := &GeoIP{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("line %d: expected start_ip,end_ip,country_code", line)
+		}
+
+		country := strings.ToUpper(strings.TrimSpace(record[2]))
+		if country == "" || country == "ZZ" {
+			continue
+		}
+		start, err := netip.ParseAddr(strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, err
+		}
+		end, err := netip.ParseAddr(strings.TrimSpace(record[1]))
+		if err != nil {
+			return nil, err
+		}
+		g.ranges = append(g.ranges, ipRange{start: as16(start), end: as16(end), country: country})
+	}
+
+	slices.SortFunc(g.ranges, func(a, b ipRange) int { return a.start.Compare(b.start) })
+	return g, nil
+}
+
+// EmbeddedGeoIP loads the database built into the binary. It's empty unless
+// the build embedded one.
+func EmbeddedGeoIP() (*GeoIP, error) {
+	g := &GeoIP{}
+	err := fs.WalkDir(geoipFiles, "geoip", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) != ".csv" {
+			return err
+		}
+
+		data, err := geoipFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		loaded, err := LoadGeoIP(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		g.ranges = append(g.ranges, loaded.ranges...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(g.ranges, func(a, b ipRange) int { return a.start.Compare(b.start) })
+	return g, nil
+}
+
+// OpenGeoIP loads a database from a CSV file, gunzipping it first if its
+// name ends in .gz.
+func OpenGeoIP(name string) (*GeoIP, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return LoadGeoIP(r)
+}
+
+// Len is the number of address ranges the database knows.
+func (g *GeoIP) Len() int {
+	return len(g.ranges)
+}
+
+// Country returns the ISO 3166 country code of ip, or false if the address
+// is invalid or not in the database.
+func (g *GeoIP) Country(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+	addr = as16(addr)
+
+	// The last range starting at or before addr is the only one that can
+	// hold it.
+	i, found := slices.BinarySearchFunc(g.ranges, addr, func(r ipRange, addr netip.Addr) int {
+		return r.start.Compare(addr)
+	})
+	if !found {
+		i--
+	}
+	if i < 0 || g.ranges[i].end.Less(addr) {
+		return "", false
+	}
+	return g.ranges[i].country, true
+}
+
+func as16(addr netip.Addr) netip.Addr {
+	return netip.AddrFrom16(addr.As16())
+}
@@ -0,0 +1,158 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/enrich"
+	"langlite-ingestion/internal/lifecycle"
+	"langlite-ingestion/internal/logging"
+)
+
+type contextKey string
+
+const authContextKey contextKey = "auth"
+
+// unauthenticatedPrefixes are the infrastructure services that don't need an
+// API key, mirroring the open /health endpoint on the HTTP side.
+var unauthenticatedPrefixes = []string{
+	"/grpc.health.v1.Health/",
+	"/grpc.reflection.",
+}
+
+type authenticator struct {
+	db database.Service
+	// background tracks the last-used updates so shutdown waits for them.
+	background *lifecycle.Group
+}
+
+func (a *authenticator) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if skipAuth(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authenticator) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if skipAuth(info.FullMethod) {
+		return handler(srv, ss)
+	}
+
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticate validates the API key carried in the call metadata, either as
+// "authorization: Bearer <key>" or "x-api-key: <key>", and attaches the
+// x-request-id metadata, or a new request ID, for logs and queued jobs.
+func (a *authenticator) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var apiKey string
+	if values := md.Get("authorization"); len(values) > 0 {
+		parts := strings.SplitN(values[0], " ", 2)
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return nil, status.Error(codes.Unauthenticated, "authorization metadata must be 'Bearer <token>'")
+		}
+		apiKey = parts[1]
+	} else if values := md.Get("x-api-key"); len(values) > 0 {
+		apiKey = values[0]
+	}
+
+	if apiKey == "" {
+		return nil, status.Error(codes.Unauthenticated, "API key is required")
+	}
+
+	validatedKey, err := a.db.ValidateAPIKey(database.HashAPIKey(apiKey))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "the provided API key is invalid or expired")
+	}
+
+	// Update last used timestamp (async to not slow down the call)
+	a.background.Go(func(context.Context) {
+		_ = a.db.UpdateAPIKeyLastUsed(validatedKey.ID)
+	})
+
+	authCtx := database.AuthContext{
+		ProjectID: validatedKey.ProjectID,
+		APIKeyID:  validatedKey.ID,
+	}
+
+	var requestID string
+	if values := md.Get("x-request-id"); len(values) > 0 {
+		requestID = values[0]
+	}
+	ctx = logging.WithRequestID(ctx, logging.NormalizeRequestID(requestID))
+	ctx = logging.WithProjectID(ctx, authCtx.ProjectID)
+	ctx = enrich.WithClient(ctx, callClient(ctx, md))
+
+	return context.WithValue(ctx, authContextKey, authCtx), nil
+}
+
+// GetAuthContext returns the caller's project and key, as set by the auth
+// interceptors.
+func GetAuthContext(ctx context.Context) (*database.AuthContext, bool) {
+	authCtx, ok := ctx.Value(authContextKey).(database.AuthContext)
+	if !ok {
+		return nil, false
+	}
+	return &authCtx, true
+}
+
+// callClient describes the caller for trace enrichment from the peer address
+// and the user-agent and SDK metadata.
+func callClient(ctx context.Context, md metadata.MD) enrich.Client {
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	client := enrich.Client{
+		UserAgent:  first("user-agent"),
+		SDKName:    first(strings.ToLower(enrich.SDKNameHeader)),
+		SDKVersion: first(strings.ToLower(enrich.SDKVersionHeader)),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		client.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(client.IP); err == nil {
+			client.IP = host
+		}
+	}
+	return client
+}
+
+func skipAuth(fullMethod string) bool {
+	for _, prefix := range unauthenticatedPrefixes {
+		if strings.HasPrefix(fullMethod, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,111 @@
+package grpcapi
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/pb/ingestionv1"
+)
+
+func traceRequest(t *ingestionv1.Trace) database.TraceRequest {
+	return database.TraceRequest{
+		ID:        t.GetId(),
+		Name:      t.GetName(),
+		Metadata:  metadataMap(t.GetMetadata()),
+		Tags:      t.GetTags(),
+		UserID:    t.GetUserId(),
+		SessionID: t.GetSessionId(),
+		StartTime: timeValue(t.GetStartTime()),
+		EndTime:   timePointer(t.GetEndTime()),
+	}
+}
+
+func spanRequest(s *ingestionv1.Span) database.SpanRequest {
+	return database.SpanRequest{
+		ID:        s.GetId(),
+		TraceID:   s.GetTraceId(),
+		ParentID:  s.GetParentId(),
+		Name:      s.GetName(),
+		Type:      s.GetType(),
+		Metadata:  metadataMap(s.GetMetadata()),
+		StartTime: timeValue(s.GetStartTime()),
+		EndTime:   timePointer(s.GetEndTime()),
+	}
+}
+
+func generationRequest(g *ingestionv1.Generation) database.GenerationRequest {
+	req := database.GenerationRequest{
+		ID:        g.GetId(),
+		TraceID:   g.GetTraceId(),
+		Name:      g.GetName(),
+		Input:     g.GetInput(),
+		Output:    g.GetOutput(),
+		Model:     g.GetModel(),
+		Metadata:  metadataMap(g.GetMetadata()),
+		StartTime: timeValue(g.GetStartTime()),
+		EndTime:   timePointer(g.GetEndTime()),
+	}
+
+	if usage := g.GetUsage(); usage != nil {
+		req.Usage = &database.UsageMetrics{
+			PromptTokens:     int(usage.GetPromptTokens()),
+			CompletionTokens: int(usage.GetCompletionTokens()),
+			TotalTokens:      int(usage.GetTotalTokens()),
+			TotalCost:        usage.GetTotalCost(),
+		}
+	}
+
+	return req
+}
+
+func eventRequest(e *ingestionv1.Event) database.EventRequest {
+	return database.EventRequest{
+		ID:        e.GetId(),
+		TraceID:   e.GetTraceId(),
+		SpanID:    e.GetSpanId(),
+		Name:      e.GetName(),
+		Level:     e.GetLevel(),
+		Message:   e.GetMessage(),
+		Metadata:  metadataMap(e.GetMetadata()),
+		Timestamp: timeValue(e.GetTimestamp()),
+	}
+}
+
+func scoreRequest(s *ingestionv1.Score) database.ScoreRequest {
+	return database.ScoreRequest{
+		ID:           s.GetId(),
+		TraceID:      s.GetTraceId(),
+		GenerationID: s.GetGenerationId(),
+		Name:         s.GetName(),
+		Value:        s.GetValue(),
+		Source:       s.GetSource(),
+		Comment:      s.GetComment(),
+		Metadata:     metadataMap(s.GetMetadata()),
+		Timestamp:    timeValue(s.GetTimestamp()),
+	}
+}
+
+func metadataMap(s *structpb.Struct) map[string]any {
+	if s == nil {
+		return nil
+	}
+	return s.AsMap()
+}
+
+func timeValue(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+func timePointer(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
@@ -0,0 +1,36 @@
+package grpcapi
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/ingest"
+	"langlite-ingestion/internal/lifecycle"
+	"langlite-ingestion/internal/pb/ingestionv1"
+)
+
+// NewServer builds the gRPC server with the ingestion service, the standard
+// health service and reflection registered. db is used to authenticate API
+// keys; items are persisted through ingestor. API key last-used updates run
+// on background.
+func NewServer(db database.Service, ingestor *ingest.Ingestor, background *lifecycle.Group) *grpc.Server {
+	auth := &authenticator{db: db, background: background}
+
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(auth.unary),
+		grpc.ChainStreamInterceptor(auth.stream),
+	)
+
+	ingestionv1.RegisterIngestionServiceServer(server, NewService(ingestor))
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(ingestionv1.IngestionService_ServiceDesc.ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+
+	reflection.Register(server)
+
+	return server
+}
@@ -0,0 +1,170 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"langlite-ingestion/internal/ingest"
+	"langlite-ingestion/internal/pb/ingestionv1"
+)
+
+// Service implements ingestionv1.IngestionServiceServer on top of the same
+// ingest.Ingestor as the HTTP handlers.
+type Service struct {
+	ingestionv1.UnimplementedIngestionServiceServer
+
+	ingestor *ingest.Ingestor
+}
+
+func NewService(ingestor *ingest.Ingestor) *Service {
+	return &Service{
+		ingestor: ingestor,
+	}
+}
+
+func (s *Service) Ingest(ctx context.Context, req *ingestionv1.IngestRequest) (*ingestionv1.IngestResponse, error) {
+	authCtx, ok := GetAuthContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "valid API key required")
+	}
+
+	result, err := ingestItem(ctx, s.ingestor.Batch(authCtx.ProjectID), req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ingestionv1.IngestResponse{Id: result.ID, Status: string(result.Status)}, nil
+}
+
+func (s *Service) IngestStream(stream ingestionv1.IngestionService_IngestStreamServer) error {
+	ctx := stream.Context()
+
+	authCtx, ok := GetAuthContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "valid API key required")
+	}
+
+	batch := s.ingestor.Batch(authCtx.ProjectID)
+	response := &ingestionv1.IngestStreamResponse{
+		Summary: &ingestionv1.IngestSummary{},
+	}
+
+	for index := int32(0); ; index++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(response)
+		}
+		if err != nil {
+			return err
+		}
+
+		result := &ingestionv1.IngestResult{Index: index, Status: "error"}
+
+		accepted, err := ingestItem(ctx, batch, req)
+		if err != nil {
+			result.Error = status.Convert(err).Message()
+			response.Summary.Failed++
+		} else {
+			result.Id = accepted.ID
+			result.Status = "success"
+			response.Summary.Succeeded++
+		}
+
+		response.Results = append(response.Results, result)
+		response.Summary.Total++
+	}
+}
+
+func ingestItem(ctx context.Context, batch *ingest.Batch, req *ingestionv1.IngestRequest) (ingest.Result, error) {
+	var result ingest.Result
+	var err error
+
+	switch item := req.GetItem().(type) {
+	case *ingestionv1.IngestRequest_Trace:
+		result, err = batch.Trace(ctx, traceRequest(item.Trace))
+	case *ingestionv1.IngestRequest_Span:
+		result, err = batch.Span(ctx, spanRequest(item.Span))
+	case *ingestionv1.IngestRequest_Generation:
+		result, err = batch.Generation(ctx, generationRequest(item.Generation))
+	case *ingestionv1.IngestRequest_Event:
+		result, err = batch.Event(ctx, eventRequest(item.Event))
+	case *ingestionv1.IngestRequest_Score:
+		result, err = batch.Score(ctx, scoreRequest(item.Score))
+	default:
+		return ingest.Result{}, status.Error(codes.InvalidArgument, "item must be one of: trace, span, generation, event, score")
+	}
+
+	if err != nil {
+		return ingest.Result{}, statusError(err)
+	}
+	return result, nil
+}
+
+// statusError maps an ingest.Error onto a gRPC status.
+func statusError(err error) error {
+	var ingestErr *ingest.Error
+	if !errors.As(err, &ingestErr) {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	switch ingestErr.Kind {
+	case ingest.KindValidation:
+		return validationError(ingestErr.Problems)
+	case ingest.KindInvalidReference:
+		return status.Error(codes.InvalidArgument, ingestErr.Error())
+	case ingest.KindNotFound:
+		return status.Error(codes.NotFound, ingestErr.Error())
+	case ingest.KindConflict:
+		return status.Error(codes.AlreadyExists, ingestErr.Error())
+	case ingest.KindUnavailable:
+		return status.Error(codes.Unavailable, ingestErr.Error())
+	case ingest.KindOverloaded:
+		return overloadedError(ingestErr)
+	default:
+		return status.Error(codes.Internal, ingestErr.Error())
+	}
+}
+
+// overloadedError reports backpressure as Unavailable with a RetryInfo
+// detail, which gRPC clients honour when retrying.
+func overloadedError(ingestErr *ingest.Error) error {
+	st := status.New(codes.Unavailable, ingestErr.Error())
+	if ingestErr.RetryAfter <= 0 {
+		return st.Err()
+	}
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(ingestErr.RetryAfter)}); err == nil {
+		return detailed.Err()
+	}
+	return st.Err()
+}
+
+// validationError reports Valid problems as InvalidArgument with a
+// BadRequest detail per field.
+func validationError(problems map[string]string) error {
+	fields := make([]string, 0, len(problems))
+	for field := range problems {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	badRequest := &errdetails.BadRequest{}
+	for _, field := range fields {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: problems[field],
+		})
+	}
+
+	st := status.New(codes.InvalidArgument, "Validation failed: the request contains invalid data")
+	if detailed, err := st.WithDetails(badRequest); err == nil {
+		return detailed.Err()
+	}
+	return st.Err()
+}
@@ -0,0 +1,176 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/ingest"
+	"langlite-ingestion/internal/pb/ingestionv1"
+)
+
+// fakeDB accepts a single API key and records the traces and scores written
+// to it.
+type fakeDB struct {
+	database.Service
+	traces []database.TraceRequest
+	scores []database.ScoreRequest
+}
+
+func (f *fakeDB) ValidateAPIKey(keyHash string) (*database.APIKey, error) {
+	if keyHash != database.HashAPIKey("test-key-123") {
+		return nil, errors.New("invalid API key")
+	}
+	return &database.APIKey{ID: "api-key-1", ProjectID: "test-project-1"}, nil
+}
+
+func (f *fakeDB) UpdateAPIKeyLastUsed(keyID string) error { return nil }
+
+func (f *fakeDB) TraceExists(projectID, traceID string) bool {
+	for _, t := range f.traces {
+		if t.ProjectID == projectID && t.ID == traceID {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeDB) CreateTrace(tr database.TraceRequest) error {
+	f.traces = append(f.traces, tr)
+	return nil
+}
+
+func (f *fakeDB) CreateScore(scr database.ScoreRequest) error {
+	f.scores = append(f.scores, scr)
+	return nil
+}
+
+func newTestClient(t *testing.T, db database.Service) ingestionv1.IngestionServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	server := NewServer(db, ingest.New(db, nil, ingest.ModeFallback), nil)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return ingestionv1.NewIngestionServiceClient(conn)
+}
+
+func withAPIKey(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+key)
+}
+
+func TestIngestRequiresAPIKey(t *testing.T) {
+	client := newTestClient(t, &fakeDB{})
+
+	req := &ingestionv1.IngestRequest{Item: &ingestionv1.IngestRequest_Trace{Trace: &ingestionv1.Trace{Name: "t"}}}
+
+	if _, err := client.Ingest(context.Background(), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated without a key; got %v", err)
+	}
+	if _, err := client.Ingest(withAPIKey("wrong"), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated with a bad key; got %v", err)
+	}
+}
+
+func TestIngestTraceStoresWithProject(t *testing.T) {
+	db := &fakeDB{}
+	client := newTestClient(t, db)
+
+	resp, err := client.Ingest(withAPIKey("test-key-123"), &ingestionv1.IngestRequest{
+		Item: &ingestionv1.IngestRequest_Trace{Trace: &ingestionv1.Trace{Id: "trace-1", Name: "chat"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.GetId() != "trace-1" || resp.GetStatus() != "created" {
+		t.Errorf("expected trace-1/created; got %s/%s", resp.GetId(), resp.GetStatus())
+	}
+	if len(db.traces) != 1 || db.traces[0].ProjectID != "test-project-1" {
+		t.Fatalf("expected trace stored for test-project-1; got %+v", db.traces)
+	}
+	if db.traces[0].StartTime.IsZero() {
+		t.Errorf("expected start_time to be defaulted")
+	}
+}
+
+func TestIngestReportsFieldViolations(t *testing.T) {
+	client := newTestClient(t, &fakeDB{})
+
+	_, err := client.Ingest(withAPIKey("test-key-123"), &ingestionv1.IngestRequest{
+		Item: &ingestionv1.IngestRequest_Trace{Trace: &ingestionv1.Trace{}},
+	})
+
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument; got %v", err)
+	}
+
+	var fields []string
+	for _, detail := range st.Details() {
+		if br, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range br.GetFieldViolations() {
+				fields = append(fields, v.GetField())
+			}
+		}
+	}
+	if len(fields) != 1 || fields[0] != "name" {
+		t.Errorf("expected a single name violation; got %v", fields)
+	}
+}
+
+func TestIngestStreamResolvesEarlierItems(t *testing.T) {
+	client := newTestClient(t, &fakeDB{})
+
+	stream, err := client.IngestStream(withAPIKey("test-key-123"))
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+
+	items := []*ingestionv1.IngestRequest{
+		{Item: &ingestionv1.IngestRequest_Trace{Trace: &ingestionv1.Trace{Id: "trace-1", Name: "chat"}}},
+		{Item: &ingestionv1.IngestRequest_Score{Score: &ingestionv1.Score{TraceId: "trace-1", Name: "helpful", Value: 0.9}}},
+		{Item: &ingestionv1.IngestRequest_Score{Score: &ingestionv1.Score{TraceId: "missing", Name: "helpful", Value: 0.9}}},
+	}
+	for _, item := range items {
+		if err := stream.Send(item); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	summary := resp.GetSummary()
+	if summary.GetTotal() != 3 || summary.GetSucceeded() != 2 || summary.GetFailed() != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+
+	if last := resp.GetResults()[2]; last.GetStatus() != "error" || last.GetError() == "" {
+		t.Errorf("expected the unknown trace_id to be rejected; got %+v", last)
+	}
+}
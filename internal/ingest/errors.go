@@ -0,0 +1,141 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"langlite-ingestion/internal/database"
+)
+
+// Kind classifies an ingestion failure so each transport can map it onto its
+// own status codes.
+type Kind int
+
+const (
+	// KindValidation means the item failed its Valid checks.
+	KindValidation Kind = iota
+	// KindInvalidReference means the item points at a trace, span or
+	// generation that doesn't exist in the caller's project.
+	KindInvalidReference
+	// KindNotFound means the item being updated doesn't exist.
+	KindNotFound
+	// KindUnavailable means the item could not be enqueued in ModeAsync.
+	KindUnavailable
+	// KindOverloaded means the queue is saturated, or the project has too
+	// many items waiting in it; the client should retry after RetryAfter.
+	KindOverloaded
+	// KindConflict means an item with the same id has already been stored.
+	KindConflict
+	// KindInternal means the database write failed.
+	KindInternal
+)
+
+// Error describes why an item was rejected. Title and Message follow the
+// wording of database.ErrorResponse.
+type Error struct {
+	Kind     Kind
+	Title    string
+	Message  string
+	Problems map[string]string
+	Err      error
+
+	// RetryAfter is how long a KindOverloaded client should back off.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if len(e.Problems) > 0 {
+		return e.Title + ": " + FormatProblems(e.Problems)
+	}
+	if e.Err != nil {
+		return e.Title + ": " + e.Err.Error()
+	}
+	return e.Title + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// FormatProblems renders Valid problems as "field: problem" pairs, sorted by
+// field so messages are stable.
+func FormatProblems(problems map[string]string) string {
+	fields := make([]string, 0, len(problems))
+	for field := range problems {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, fmt.Sprintf("%s: %s", field, problems[field]))
+	}
+	return strings.Join(messages, ", ")
+}
+
+func validationError(problems map[string]string) *Error {
+	return &Error{
+		Kind:     KindValidation,
+		Title:    "Validation failed",
+		Message:  "The request contains invalid data",
+		Problems: problems,
+	}
+}
+
+func referenceError(title, field string) *Error {
+	return &Error{
+		Kind:    KindInvalidReference,
+		Title:   title,
+		Message: fmt.Sprintf("The specified %s does not exist", field),
+	}
+}
+
+func internalError(entity string, err error) *Error {
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return &Error{
+			Kind:    KindConflict,
+			Title:   "Already exists",
+			Message: fmt.Sprintf("The %s id is already in use", entity),
+			Err:     err,
+		}
+	}
+	return &Error{
+		Kind:    KindInternal,
+		Title:   "Database error",
+		Message: fmt.Sprintf("Failed to create %s", entity),
+		Err:     err,
+	}
+}
+
+func unavailableError(entity string, err error) *Error {
+	return &Error{
+		Kind:    KindUnavailable,
+		Title:   "Queue unavailable",
+		Message: fmt.Sprintf("Failed to enqueue %s", entity),
+		Err:     err,
+	}
+}
+
+// retryAfterError is implemented by admission errors that know when the
+// queue is likely to have room again, e.g. *queue.OverloadError.
+type retryAfterError interface {
+	error
+	RetryAfter() time.Duration
+}
+
+func overloadedError(entity string, err error) *Error {
+	e := &Error{
+		Kind:    KindOverloaded,
+		Title:   "Ingestion overloaded",
+		Message: fmt.Sprintf("Too many items waiting to be processed, retry the %s later", entity),
+		Err:     err,
+	}
+	var retryErr retryAfterError
+	if errors.As(err, &retryErr) {
+		e.RetryAfter = retryErr.RetryAfter()
+	}
+	return e
+}
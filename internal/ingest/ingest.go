@@ -0,0 +1,443 @@
+// Package ingest validates, defaults and persists incoming observability data.
+// The HTTP handlers (single, batch and stream) and the gRPC service all go
+// through an Ingestor, so the rules for each entity live in one place.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"langlite-ingestion/internal/database"
+)
+
+// Mode selects how accepted items are persisted.
+type Mode int
+
+const (
+	// ModeSync writes items to the database before returning.
+	ModeSync Mode = iota
+	// ModeAsync enqueues items and fails if the queue can't take them.
+	ModeAsync
+	// ModeFallback enqueues items and writes them directly when the queue is
+	// unavailable or enqueueing fails.
+	ModeFallback
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeSync:
+		return "sync"
+	case ModeAsync:
+		return "async"
+	case ModeFallback:
+		return "fallback"
+	default:
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+}
+
+// ParseMode parses "sync", "async" or "fallback".
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "sync":
+		return ModeSync, nil
+	case "async":
+		return ModeAsync, nil
+	case "fallback":
+		return ModeFallback, nil
+	default:
+		return 0, fmt.Errorf("unknown ingestion mode %q", s)
+	}
+}
+
+// Status reports how an item was persisted.
+type Status string
+
+const (
+	StatusCreated  Status = "created"
+	StatusAccepted Status = "accepted"
+)
+
+type Result struct {
+	ID     string
+	Status Status
+}
+
+// Enqueuer hands items to the async pipeline. *queue.Client implements it.
+type Enqueuer interface {
+	EnqueueTrace(ctx context.Context, req database.TraceRequest) error
+	EnqueueSpan(ctx context.Context, req database.SpanRequest) error
+	EnqueueGeneration(ctx context.Context, req database.GenerationRequest) error
+	EnqueueEvent(ctx context.Context, req database.EventRequest) error
+	EnqueueScore(ctx context.Context, req database.ScoreRequest) error
+}
+
+// Admitter applies backpressure: it turns items away while the queue can't
+// keep up. *queue.Admission implements it.
+type Admitter interface {
+	Admit(ctx context.Context, projectID string) error
+}
+
+// Notifier is told of each item once it's accepted, so it can alert the
+// project's webhooks. *webhook.Dispatcher implements it.
+type Notifier interface {
+	Notify(ctx context.Context, projectID string, item any)
+}
+
+var errQueueDisabled = errors.New("queue is not configured")
+
+type Ingestor struct {
+	db        database.Service
+	queue     Enqueuer
+	admission Admitter
+	notifier  Notifier
+	mode      Mode
+}
+
+// New returns an Ingestor persisting items according to mode. queue may be
+// nil, in which case ModeFallback behaves like ModeSync and ModeAsync rejects
+// every item.
+func New(db database.Service, queue Enqueuer, mode Mode) *Ingestor {
+	return &Ingestor{
+		db:    db,
+		queue: queue,
+		mode:  mode,
+	}
+}
+
+// WithMode returns a copy of the Ingestor that persists items using mode.
+func (i *Ingestor) WithMode(mode Mode) *Ingestor {
+	c := *i
+	c.mode = mode
+	return &c
+}
+
+// WithAdmission returns a copy of the Ingestor that asks admission before
+// enqueueing each item.
+func (i *Ingestor) WithAdmission(admission Admitter) *Ingestor {
+	c := *i
+	c.admission = admission
+	return &c
+}
+
+// WithNotifier returns a copy of the Ingestor that tells notifier of every
+// item it accepts.
+func (i *Ingestor) WithNotifier(notifier Notifier) *Ingestor {
+	c := *i
+	c.notifier = notifier
+	return &c
+}
+
+func (i *Ingestor) Mode() Mode {
+	return i.mode
+}
+
+// Batch starts a unit of work for projectID. Items accepted by a Batch can be
+// referenced by later items in the same Batch before they reach the
+// database, which matters when they're still sitting in the queue.
+func (i *Ingestor) Batch(projectID string) *Batch {
+	return &Batch{
+		ingestor:    i,
+		projectID:   projectID,
+		traces:      make(map[string]bool),
+		spans:       make(map[string]bool),
+		generations: make(map[string]bool),
+	}
+}
+
+func (i *Ingestor) Trace(ctx context.Context, projectID string, req database.TraceRequest) (Result, error) {
+	return i.Batch(projectID).Trace(ctx, req)
+}
+
+func (i *Ingestor) Span(ctx context.Context, projectID string, req database.SpanRequest) (Result, error) {
+	return i.Batch(projectID).Span(ctx, req)
+}
+
+func (i *Ingestor) Generation(ctx context.Context, projectID string, req database.GenerationRequest) (Result, error) {
+	return i.Batch(projectID).Generation(ctx, req)
+}
+
+func (i *Ingestor) Event(ctx context.Context, projectID string, req database.EventRequest) (Result, error) {
+	return i.Batch(projectID).Event(ctx, req)
+}
+
+func (i *Ingestor) Score(ctx context.Context, projectID string, req database.ScoreRequest) (Result, error) {
+	return i.Batch(projectID).Score(ctx, req)
+}
+
+// UpdateSpan applies req to an existing span. Updates are always written
+// directly, whatever the mode.
+func (i *Ingestor) UpdateSpan(ctx context.Context, projectID, spanID string, req database.SpanUpdateRequest) (Result, error) {
+	if strings.TrimSpace(spanID) == "" {
+		return Result{}, validationError(map[string]string{"id": "span ID is required in the URL path"})
+	}
+
+	if problems := req.Valid(ctx); len(problems) > 0 {
+		return Result{}, validationError(problems)
+	}
+
+	if req.EndTime == nil && req.Metadata != nil {
+		now := time.Now().UTC()
+		req.EndTime = &now
+	}
+
+	if err := i.db.UpdateSpan(projectID, spanID, req); err != nil {
+		if errors.Is(err, database.ErrSpanNotFound) {
+			return Result{}, &Error{
+				Kind:    KindNotFound,
+				Title:   "Span not found",
+				Message: "The specified span does not exist",
+			}
+		}
+		return Result{}, &Error{
+			Kind:    KindInternal,
+			Title:   "Database error",
+			Message: "Failed to update span",
+			Err:     err,
+		}
+	}
+
+	return Result{ID: spanID, Status: "updated"}, nil
+}
+
+type Batch struct {
+	ingestor  *Ingestor
+	projectID string
+
+	traces      map[string]bool
+	spans       map[string]bool
+	generations map[string]bool
+}
+
+func (b *Batch) Trace(ctx context.Context, req database.TraceRequest) (Result, error) {
+	if problems := req.Valid(ctx); len(problems) > 0 {
+		return Result{}, validationError(problems)
+	}
+
+	req.ProjectID = b.projectID
+
+	if req.ID == "" {
+		req.ID = uuid.New().String()
+	}
+
+	if req.StartTime.IsZero() {
+		req.StartTime = time.Now().UTC()
+	}
+
+	status, err := b.persist(ctx, "trace",
+		func(q Enqueuer) error { return q.EnqueueTrace(ctx, req) },
+		func() error { return b.ingestor.db.CreateTrace(req) },
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	b.traces[req.ID] = true
+	b.notify(ctx, req)
+	return Result{ID: req.ID, Status: status}, nil
+}
+
+func (b *Batch) Span(ctx context.Context, req database.SpanRequest) (Result, error) {
+	if problems := req.Valid(ctx); len(problems) > 0 {
+		return Result{}, validationError(problems)
+	}
+
+	if req.ID == "" {
+		req.ID = uuid.New().String()
+	}
+
+	if req.StartTime.IsZero() {
+		req.StartTime = time.Now().UTC()
+	}
+
+	if !b.traceKnown(req.TraceID) {
+		return Result{}, referenceError("Invalid trace", "trace_id")
+	}
+
+	if req.ParentID != "" && !b.spanKnown(req.ParentID) {
+		return Result{}, referenceError("Invalid parent span", "parent_id")
+	}
+
+	status, err := b.persist(ctx, "span",
+		func(q Enqueuer) error { return q.EnqueueSpan(ctx, req) },
+		func() error { return b.ingestor.db.CreateSpan(req) },
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	b.spans[req.ID] = true
+	b.notify(ctx, req)
+	return Result{ID: req.ID, Status: status}, nil
+}
+
+func (b *Batch) Generation(ctx context.Context, req database.GenerationRequest) (Result, error) {
+	if problems := req.Valid(ctx); len(problems) > 0 {
+		return Result{}, validationError(problems)
+	}
+
+	if req.ID == "" {
+		req.ID = uuid.New().String()
+	}
+
+	if req.StartTime.IsZero() {
+		req.StartTime = time.Now().UTC()
+	}
+
+	if !b.traceKnown(req.TraceID) {
+		return Result{}, referenceError("Invalid trace", "trace_id")
+	}
+
+	status, err := b.persist(ctx, "generation",
+		func(q Enqueuer) error { return q.EnqueueGeneration(ctx, req) },
+		func() error { return b.ingestor.db.CreateGeneration(req) },
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	b.generations[req.ID] = true
+	b.notify(ctx, req)
+	return Result{ID: req.ID, Status: status}, nil
+}
+
+func (b *Batch) Event(ctx context.Context, req database.EventRequest) (Result, error) {
+	if problems := req.Valid(ctx); len(problems) > 0 {
+		return Result{}, validationError(problems)
+	}
+
+	if req.ID == "" {
+		req.ID = uuid.New().String()
+	}
+
+	if req.Timestamp.IsZero() {
+		req.Timestamp = time.Now().UTC()
+	}
+
+	if req.Level == "" {
+		req.Level = "info"
+	}
+
+	if !b.traceKnown(req.TraceID) {
+		return Result{}, referenceError("Invalid trace", "trace_id")
+	}
+
+	if req.SpanID != "" && !b.spanKnown(req.SpanID) {
+		return Result{}, referenceError("Invalid span", "span_id")
+	}
+
+	status, err := b.persist(ctx, "event",
+		func(q Enqueuer) error { return q.EnqueueEvent(ctx, req) },
+		func() error { return b.ingestor.db.CreateEvent(req) },
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	b.notify(ctx, req)
+	return Result{ID: req.ID, Status: status}, nil
+}
+
+func (b *Batch) Score(ctx context.Context, req database.ScoreRequest) (Result, error) {
+	if problems := req.Valid(ctx); len(problems) > 0 {
+		return Result{}, validationError(problems)
+	}
+
+	if req.ID == "" {
+		req.ID = uuid.New().String()
+	}
+
+	if req.Timestamp.IsZero() {
+		req.Timestamp = time.Now().UTC()
+	}
+
+	if req.Source == "" {
+		req.Source = "human"
+	}
+
+	if req.TraceID != "" && !b.traceKnown(req.TraceID) {
+		return Result{}, referenceError("Invalid trace", "trace_id")
+	}
+
+	if req.GenerationID != "" && !b.generationKnown(req.GenerationID) {
+		return Result{}, referenceError("Invalid generation", "generation_id")
+	}
+
+	status, err := b.persist(ctx, "score",
+		func(q Enqueuer) error { return q.EnqueueScore(ctx, req) },
+		func() error { return b.ingestor.db.CreateScore(req) },
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	b.notify(ctx, req)
+	return Result{ID: req.ID, Status: status}, nil
+}
+
+func (b *Batch) notify(ctx context.Context, item any) {
+	if b.ingestor.notifier != nil {
+		b.ingestor.notifier.Notify(ctx, b.projectID, item)
+	}
+}
+
+func (b *Batch) admit(ctx context.Context) error {
+	if b.ingestor.admission == nil {
+		return nil
+	}
+	return b.ingestor.admission.Admit(ctx, b.projectID)
+}
+
+func (b *Batch) traceKnown(traceID string) bool {
+	return b.traces[traceID] || b.ingestor.db.TraceExists(b.projectID, traceID)
+}
+
+func (b *Batch) spanKnown(spanID string) bool {
+	return b.spans[spanID] || b.ingestor.db.SpanExists(b.projectID, spanID)
+}
+
+func (b *Batch) generationKnown(generationID string) bool {
+	return b.generations[generationID] || b.ingestor.db.GenerationExists(b.projectID, generationID)
+}
+
+// persist enqueues or stores an item according to the mode. An item the
+// admission check turns away is rejected even in ModeFallback: a backed-up
+// queue usually means Postgres is slow, and writing directly would only add
+// to its load.
+func (b *Batch) persist(ctx context.Context, entity string, enqueue func(Enqueuer) error, store func() error) (Status, error) {
+	q := b.ingestor.queue
+
+	switch b.ingestor.mode {
+	case ModeAsync:
+		if q == nil {
+			return "", unavailableError(entity, errQueueDisabled)
+		}
+		if err := b.admit(ctx); err != nil {
+			return "", overloadedError(entity, err)
+		}
+		if err := enqueue(q); err != nil {
+			return "", unavailableError(entity, err)
+		}
+		return StatusAccepted, nil
+
+	case ModeFallback:
+		if q != nil {
+			if err := b.admit(ctx); err != nil {
+				return "", overloadedError(entity, err)
+			}
+			if err := enqueue(q); err == nil {
+				return StatusAccepted, nil
+			}
+		}
+	}
+
+	if err := store(); err != nil {
+		return "", internalError(entity, err)
+	}
+	return StatusCreated, nil
+}
@@ -0,0 +1,477 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"langlite-ingestion/internal/database"
+)
+
+// fakeDB knows about one trace, span and generation in project-1 and records
+// every item written to it.
+type fakeDB struct {
+	database.Service
+	fail    error
+	created []string
+	updated []string
+}
+
+func (f *fakeDB) TraceExists(projectID, traceID string) bool {
+	return projectID == "project-1" && traceID == "trace-1"
+}
+
+func (f *fakeDB) SpanExists(projectID, spanID string) bool {
+	return projectID == "project-1" && spanID == "span-1"
+}
+
+func (f *fakeDB) GenerationExists(projectID, generationID string) bool {
+	return projectID == "project-1" && generationID == "gen-1"
+}
+
+func (f *fakeDB) record(kind string) error {
+	if f.fail != nil {
+		return f.fail
+	}
+	f.created = append(f.created, kind)
+	return nil
+}
+
+func (f *fakeDB) CreateTrace(req database.TraceRequest) error { return f.record("trace") }
+func (f *fakeDB) CreateSpan(req database.SpanRequest) error   { return f.record("span") }
+func (f *fakeDB) CreateGeneration(req database.GenerationRequest) error {
+	return f.record("generation")
+}
+func (f *fakeDB) CreateEvent(req database.EventRequest) error { return f.record("event") }
+func (f *fakeDB) CreateScore(req database.ScoreRequest) error { return f.record("score") }
+
+func (f *fakeDB) UpdateSpan(projectID, spanID string, req database.SpanUpdateRequest) error {
+	if !f.SpanExists(projectID, spanID) {
+		return database.ErrSpanNotFound
+	}
+	f.updated = append(f.updated, spanID)
+	return nil
+}
+
+// fakeQueue records enqueued items, or fails every call when fail is set.
+type fakeQueue struct {
+	fail     error
+	enqueued []string
+}
+
+func (q *fakeQueue) record(kind string) error {
+	if q.fail != nil {
+		return q.fail
+	}
+	q.enqueued = append(q.enqueued, kind)
+	return nil
+}
+
+func (q *fakeQueue) EnqueueTrace(ctx context.Context, req database.TraceRequest) error {
+	return q.record("trace")
+}
+
+func (q *fakeQueue) EnqueueSpan(ctx context.Context, req database.SpanRequest) error {
+	return q.record("span")
+}
+
+func (q *fakeQueue) EnqueueGeneration(ctx context.Context, req database.GenerationRequest) error {
+	return q.record("generation")
+}
+
+func (q *fakeQueue) EnqueueEvent(ctx context.Context, req database.EventRequest) error {
+	return q.record("event")
+}
+
+func (q *fakeQueue) EnqueueScore(ctx context.Context, req database.ScoreRequest) error {
+	return q.record("score")
+}
+
+// addFunc adds one item of a particular entity type to a batch.
+type addFunc func(b *Batch, ctx context.Context) (Result, error)
+
+func addTrace(req database.TraceRequest) addFunc {
+	return func(b *Batch, ctx context.Context) (Result, error) { return b.Trace(ctx, req) }
+}
+
+func addSpan(req database.SpanRequest) addFunc {
+	return func(b *Batch, ctx context.Context) (Result, error) { return b.Span(ctx, req) }
+}
+
+func addGeneration(req database.GenerationRequest) addFunc {
+	return func(b *Batch, ctx context.Context) (Result, error) { return b.Generation(ctx, req) }
+}
+
+func addEvent(req database.EventRequest) addFunc {
+	return func(b *Batch, ctx context.Context) (Result, error) { return b.Event(ctx, req) }
+}
+
+func addScore(req database.ScoreRequest) addFunc {
+	return func(b *Batch, ctx context.Context) (Result, error) { return b.Score(ctx, req) }
+}
+
+var entities = []struct {
+	name    string
+	valid   addFunc
+	invalid addFunc
+	badRef  addFunc
+}{
+	{
+		name:    "trace",
+		valid:   addTrace(database.TraceRequest{Name: "chat"}),
+		invalid: addTrace(database.TraceRequest{}),
+	},
+	{
+		name:    "span",
+		valid:   addSpan(database.SpanRequest{TraceID: "trace-1", ParentID: "span-1", Name: "retrieve"}),
+		invalid: addSpan(database.SpanRequest{TraceID: "trace-1"}),
+		badRef:  addSpan(database.SpanRequest{TraceID: "trace-1", ParentID: "missing", Name: "retrieve"}),
+	},
+	{
+		name:    "generation",
+		valid:   addGeneration(database.GenerationRequest{TraceID: "trace-1", Name: "completion", Model: "gpt-4", Input: "hi"}),
+		invalid: addGeneration(database.GenerationRequest{TraceID: "trace-1"}),
+		badRef:  addGeneration(database.GenerationRequest{TraceID: "missing", Name: "completion", Model: "gpt-4", Input: "hi"}),
+	},
+	{
+		name:    "event",
+		valid:   addEvent(database.EventRequest{TraceID: "trace-1", SpanID: "span-1", Name: "click", Message: "clicked"}),
+		invalid: addEvent(database.EventRequest{TraceID: "trace-1"}),
+		badRef:  addEvent(database.EventRequest{TraceID: "trace-1", SpanID: "missing", Name: "click", Message: "clicked"}),
+	},
+	{
+		name:    "score",
+		valid:   addScore(database.ScoreRequest{TraceID: "trace-1", GenerationID: "gen-1", Name: "helpful", Value: 1}),
+		invalid: addScore(database.ScoreRequest{TraceID: "trace-1"}),
+		badRef:  addScore(database.ScoreRequest{GenerationID: "missing", Name: "helpful", Value: 1}),
+	},
+}
+
+func TestIngestModes(t *testing.T) {
+	queueDown := errors.New("redis: connection refused")
+
+	modes := []struct {
+		name       string
+		mode       Mode
+		queue      *fakeQueue
+		wantStatus Status
+		wantKind   Kind // checked when wantStatus is empty
+		wantStored bool
+		wantQueued bool
+	}{
+		{name: "sync", mode: ModeSync, queue: &fakeQueue{}, wantStatus: StatusCreated, wantStored: true},
+		{name: "async", mode: ModeAsync, queue: &fakeQueue{}, wantStatus: StatusAccepted, wantQueued: true},
+		{name: "async without queue", mode: ModeAsync, wantKind: KindUnavailable},
+		{name: "async with failing queue", mode: ModeAsync, queue: &fakeQueue{fail: queueDown}, wantKind: KindUnavailable},
+		{name: "fallback", mode: ModeFallback, queue: &fakeQueue{}, wantStatus: StatusAccepted, wantQueued: true},
+		{name: "fallback without queue", mode: ModeFallback, wantStatus: StatusCreated, wantStored: true},
+		{name: "fallback with failing queue", mode: ModeFallback, queue: &fakeQueue{fail: queueDown}, wantStatus: StatusCreated, wantStored: true},
+	}
+
+	for _, entity := range entities {
+		for _, tc := range modes {
+			t.Run(entity.name+"/"+tc.name, func(t *testing.T) {
+				db := &fakeDB{}
+
+				var q Enqueuer
+				var fq fakeQueue
+				if tc.queue != nil {
+					fq = *tc.queue
+					q = &fq
+				}
+
+				result, err := entity.valid(New(db, q, tc.mode).Batch("project-1"), context.Background())
+
+				if tc.wantStatus == "" {
+					var ingestErr *Error
+					if !errors.As(err, &ingestErr) || ingestErr.Kind != tc.wantKind {
+						t.Fatalf("expected error of kind %v; got %v", tc.wantKind, err)
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if result.Status != tc.wantStatus {
+					t.Errorf("expected status %q; got %q", tc.wantStatus, result.Status)
+				}
+				if result.ID == "" {
+					t.Errorf("expected an ID to be assigned")
+				}
+				if stored := len(db.created) == 1; stored != tc.wantStored {
+					t.Errorf("expected stored=%v; got %v", tc.wantStored, db.created)
+				}
+				if queued := len(fq.enqueued) == 1; queued != tc.wantQueued {
+					t.Errorf("expected queued=%v; got %v", tc.wantQueued, fq.enqueued)
+				}
+			})
+		}
+	}
+}
+
+// fakeAdmission turns away every item when full.
+type fakeAdmission struct {
+	full     bool
+	projects []string
+}
+
+type overload struct{}
+
+func (overload) Error() string             { return "queue is full" }
+func (overload) RetryAfter() time.Duration { return 10 * time.Second }
+
+func (a *fakeAdmission) Admit(ctx context.Context, projectID string) error {
+	a.projects = append(a.projects, projectID)
+	if a.full {
+		return overload{}
+	}
+	return nil
+}
+
+func TestIngestAdmission(t *testing.T) {
+	for _, mode := range []Mode{ModeAsync, ModeFallback} {
+		t.Run(mode.String(), func(t *testing.T) {
+			db := &fakeDB{}
+			q := &fakeQueue{}
+			admission := &fakeAdmission{}
+			batch := New(db, q, mode).WithAdmission(admission).Batch("project-1")
+
+			if _, err := batch.Trace(context.Background(), database.TraceRequest{Name: "chat"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			admission.full = true
+			_, err := batch.Trace(context.Background(), database.TraceRequest{Name: "chat"})
+			var ingestErr *Error
+			if !errors.As(err, &ingestErr) || ingestErr.Kind != KindOverloaded || ingestErr.RetryAfter != 10*time.Second {
+				t.Fatalf("expected an overloaded error with a retry delay; got %v", err)
+			}
+
+			if len(q.enqueued) != 1 || len(db.created) != 0 {
+				t.Errorf("expected only the admitted item to be enqueued and nothing stored; got %v, %v", q.enqueued, db.created)
+			}
+			if len(admission.projects) != 2 || admission.projects[0] != "project-1" {
+				t.Errorf("expected admission to be asked per item for the batch's project; got %v", admission.projects)
+			}
+		})
+	}
+
+	// Sync writes don't go through the queue, so they aren't turned away.
+	db := &fakeDB{}
+	ingestor := New(db, &fakeQueue{}, ModeSync).WithAdmission(&fakeAdmission{full: true})
+	if _, err := ingestor.Trace(context.Background(), "project-1", database.TraceRequest{Name: "chat"}); err != nil || len(db.created) != 1 {
+		t.Errorf("expected sync ingestion to ignore admission; got %v, %v", err, db.created)
+	}
+}
+
+// fakeNotifier records the items it's told of.
+type fakeNotifier struct {
+	projects []string
+	items    []any
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, projectID string, item any) {
+	n.projects = append(n.projects, projectID)
+	n.items = append(n.items, item)
+}
+
+func TestIngestNotifies(t *testing.T) {
+	for _, entity := range entities {
+		t.Run(entity.name, func(t *testing.T) {
+			notifier := &fakeNotifier{}
+			batch := New(&fakeDB{}, &fakeQueue{}, ModeAsync).WithNotifier(notifier).Batch("project-1")
+
+			if _, err := entity.invalid(batch, context.Background()); err == nil {
+				t.Fatal("expected the invalid item to be rejected")
+			}
+			res, err := entity.valid(batch, context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(notifier.items) != 1 || notifier.projects[0] != "project-1" {
+				t.Fatalf("expected only the accepted item notified for project-1; got %v", notifier.items)
+			}
+			// Items are notified as accepted, defaults and all.
+			if id := reflect.ValueOf(notifier.items[0]).FieldByName("ID").String(); id != res.ID {
+				t.Errorf("expected item %s notified; got %s", res.ID, id)
+			}
+		})
+	}
+
+	// Items that fail to persist aren't notified.
+	notifier := &fakeNotifier{}
+	ingestor := New(&fakeDB{fail: errors.New("connection refused")}, nil, ModeSync).WithNotifier(notifier)
+	if _, err := ingestor.Trace(context.Background(), "project-1", database.TraceRequest{Name: "chat"}); err == nil || len(notifier.items) != 0 {
+		t.Errorf("expected a failed write not to notify; got %v, %v", err, notifier.items)
+	}
+}
+
+func TestIngestRejects(t *testing.T) {
+	for _, entity := range entities {
+		cases := []struct {
+			name     string
+			add      addFunc
+			wantKind Kind
+		}{
+			{name: "invalid", add: entity.invalid, wantKind: KindValidation},
+			{name: "unknown reference", add: entity.badRef, wantKind: KindInvalidReference},
+		}
+
+		for _, tc := range cases {
+			if tc.add == nil {
+				continue
+			}
+
+			t.Run(entity.name+"/"+tc.name, func(t *testing.T) {
+				db := &fakeDB{}
+				q := &fakeQueue{}
+
+				_, err := tc.add(New(db, q, ModeFallback).Batch("project-1"), context.Background())
+
+				var ingestErr *Error
+				if !errors.As(err, &ingestErr) || ingestErr.Kind != tc.wantKind {
+					t.Fatalf("expected error of kind %v; got %v", tc.wantKind, err)
+				}
+				if tc.wantKind == KindValidation && len(ingestErr.Problems) == 0 {
+					t.Errorf("expected validation problems")
+				}
+				if len(db.created) != 0 || len(q.enqueued) != 0 {
+					t.Errorf("expected nothing persisted; got stored=%v queued=%v", db.created, q.enqueued)
+				}
+			})
+		}
+	}
+}
+
+func TestIngestScopesReferencesToProject(t *testing.T) {
+	// trace-1 exists, but not in project-2.
+	for _, entity := range entities {
+		if entity.name == "trace" || entity.name == "score" {
+			continue
+		}
+
+		t.Run(entity.name, func(t *testing.T) {
+			_, err := entity.valid(New(&fakeDB{}, nil, ModeSync).Batch("project-2"), context.Background())
+
+			var ingestErr *Error
+			if !errors.As(err, &ingestErr) || ingestErr.Kind != KindInvalidReference {
+				t.Fatalf("expected an invalid reference; got %v", err)
+			}
+		})
+	}
+}
+
+func TestIngestDatabaseError(t *testing.T) {
+	for _, entity := range entities {
+		t.Run(entity.name, func(t *testing.T) {
+			db := &fakeDB{fail: errors.New("connection reset")}
+
+			_, err := entity.valid(New(db, nil, ModeSync).Batch("project-1"), context.Background())
+
+			var ingestErr *Error
+			if !errors.As(err, &ingestErr) || ingestErr.Kind != KindInternal {
+				t.Fatalf("expected an internal error; got %v", err)
+			}
+			if !errors.Is(err, db.fail) {
+				t.Errorf("expected the database error to be wrapped; got %v", err)
+			}
+		})
+	}
+}
+
+func TestBatchResolvesEarlierItems(t *testing.T) {
+	db := &fakeDB{}
+	q := &fakeQueue{}
+	batch := New(db, q, ModeAsync).Batch("project-1")
+	ctx := context.Background()
+
+	steps := []struct {
+		name string
+		add  addFunc
+	}{
+		{"trace", addTrace(database.TraceRequest{ID: "trace-new", Name: "chat"})},
+		{"span", addSpan(database.SpanRequest{ID: "span-new", TraceID: "trace-new", Name: "retrieve"})},
+		{"child span", addSpan(database.SpanRequest{TraceID: "trace-new", ParentID: "span-new", Name: "rank"})},
+		{"generation", addGeneration(database.GenerationRequest{ID: "gen-new", TraceID: "trace-new", Name: "completion", Model: "gpt-4", Input: "hi"})},
+		{"event", addEvent(database.EventRequest{TraceID: "trace-new", SpanID: "span-new", Name: "click", Message: "clicked"})},
+		{"score", addScore(database.ScoreRequest{TraceID: "trace-new", GenerationID: "gen-new", Name: "helpful", Value: 1})},
+	}
+
+	for _, step := range steps {
+		if _, err := step.add(batch, ctx); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+	}
+
+	if len(q.enqueued) != len(steps) {
+		t.Errorf("expected %d items enqueued; got %v", len(steps), q.enqueued)
+	}
+}
+
+func TestUpdateSpan(t *testing.T) {
+	req := database.SpanUpdateRequest{Metadata: map[string]any{"k": "v"}}
+
+	cases := []struct {
+		name      string
+		projectID string
+		spanID    string
+		wantKind  Kind
+		wantErr   bool
+	}{
+		{name: "existing span", projectID: "project-1", spanID: "span-1"},
+		{name: "missing span", projectID: "project-1", spanID: "missing", wantErr: true, wantKind: KindNotFound},
+		{name: "other project", projectID: "project-2", spanID: "span-1", wantErr: true, wantKind: KindNotFound},
+		{name: "empty ID", projectID: "project-1", spanID: " ", wantErr: true, wantKind: KindValidation},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &fakeDB{}
+			// Updates ignore the mode and never touch the queue.
+			result, err := New(db, nil, ModeAsync).UpdateSpan(context.Background(), tc.projectID, tc.spanID, req)
+
+			if !tc.wantErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if result.ID != tc.spanID || len(db.updated) != 1 {
+					t.Errorf("expected %s to be updated; got %+v, %v", tc.spanID, result, db.updated)
+				}
+				return
+			}
+
+			var ingestErr *Error
+			if !errors.As(err, &ingestErr) || ingestErr.Kind != tc.wantKind {
+				t.Fatalf("expected error of kind %v; got %v", tc.wantKind, err)
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    Mode
+		wantErr bool
+	}{
+		{in: "sync", want: ModeSync},
+		{in: "ASYNC", want: ModeAsync},
+		{in: " fallback ", want: ModeFallback},
+		{in: "batch", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		got, err := ParseMode(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseMode(%q): unexpected error %v", tc.in, err)
+			continue
+		}
+		if !tc.wantErr && got != tc.want {
+			t.Errorf("ParseMode(%q) = %v; want %v", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Package lifecycle coordinates an orderly shutdown: components register a
+// stop hook as they start, and Shutdown runs the hooks in reverse, so
+// whatever started last (e.g. the HTTP listener) stops first and whatever
+// everything depends on (e.g. Postgres) closes last.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type hook struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// Manager holds the stop hooks of a running process.
+type Manager struct {
+	mu       sync.Mutex
+	hooks    []hook
+	shutdown bool
+}
+
+func New() *Manager {
+	return &Manager{}
+}
+
+// OnShutdown registers stop to run during Shutdown, before every hook
+// registered earlier.
+func (m *Manager) OnShutdown(name string, stop func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, stop: stop})
+}
+
+// Shutdown runs the hooks newest first, sharing ctx's deadline. A failing or
+// late hook doesn't stop the rest from running; their errors are joined.
+// Only the first call does anything.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if m.shutdown {
+		m.mu.Unlock()
+		return nil
+	}
+	m.shutdown = true
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		start := time.Now()
+		if err := h.stop(ctx); err != nil {
+			slog.Error("Shutdown step failed", "step", h.name, "duration", time.Since(start), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+			continue
+		}
+		slog.Info("Shutdown step complete", "step", h.name, "duration", time.Since(start))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Group tracks background goroutines so shutdown can stop and wait for them.
+// A nil *Group runs goroutines untracked, which suits tests.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewGroup() *Group {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Group{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in a goroutine. fn's context is cancelled when Stop is called;
+// loops should return then, one-off tasks may run to completion.
+func (g *Group) Go(fn func(ctx context.Context)) {
+	if g == nil {
+		go fn(context.Background())
+		return
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		fn(g.ctx)
+	}()
+}
+
+// Stop cancels the goroutines' context and waits for them to return, or for
+// ctx to be done.
+func (g *Group) Stop(ctx context.Context) error {
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background tasks still running: %w", ctx.Err())
+	}
+}
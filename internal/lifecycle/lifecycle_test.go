@@ -0,0 +1,93 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestShutdownRunsHooksInReverse(t *testing.T) {
+	m := New()
+
+	var order []string
+	for _, name := range []string{"postgres", "redis", "workers", "http"} {
+		m.OnShutdown(name, func(context.Context) error {
+			order = append(order, name)
+			if name == "workers" {
+				return errors.New("job requeue failed")
+			}
+			return nil
+		})
+	}
+
+	err := m.Shutdown(context.Background())
+	if err == nil || err.Error() != "workers: job requeue failed" {
+		t.Errorf("expected the failing step's error; got %v", err)
+	}
+
+	want := []string{"http", "workers", "redis", "postgres"}
+	if !slices.Equal(order, want) {
+		t.Errorf("expected %v; got %v", want, order)
+	}
+
+	if err := m.Shutdown(context.Background()); err != nil || len(order) != len(want) {
+		t.Errorf("expected a second shutdown to do nothing; got %v, %v", err, order)
+	}
+}
+
+func TestGroupStop(t *testing.T) {
+	g := NewGroup()
+
+	loopDone := make(chan struct{})
+	g.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		close(loopDone)
+	})
+
+	taskDone := false
+	g.Go(func(context.Context) {
+		time.Sleep(10 * time.Millisecond)
+		taskDone = true
+	})
+
+	if err := g.Stop(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	select {
+	case <-loopDone:
+	default:
+		t.Errorf("expected the loop to see its context cancelled")
+	}
+	if !taskDone {
+		t.Errorf("expected Stop to wait for the task")
+	}
+}
+
+func TestGroupStopDeadline(t *testing.T) {
+	g := NewGroup()
+
+	release := make(chan struct{})
+	defer close(release)
+	g.Go(func(context.Context) { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline error; got %v", err)
+	}
+}
+
+func TestNilGroupRunsUntracked(t *testing.T) {
+	var g *Group
+
+	done := make(chan struct{})
+	g.Go(func(context.Context) { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the task to run")
+	}
+}
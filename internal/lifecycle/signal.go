@@ -0,0 +1,51 @@
+package lifecycle
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// defaultShutdownTimeout bounds the whole shutdown, unless
+// LANGLITE_SHUTDOWN_TIMEOUT says otherwise.
+const defaultShutdownTimeout = 30 * time.Second
+
+// ShutdownOnSignal waits for SIGINT or SIGTERM, runs Shutdown within the
+// shutdown timeout and then signals done. A second signal kills the process.
+func (m *Manager) ShutdownOnSignal(done chan<- bool) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	<-ctx.Done()
+
+	timeout := shutdownTimeout()
+	slog.Info("Shutting down gracefully, press Ctrl+C again to force", "timeout", timeout)
+	stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		slog.Error("Shutdown incomplete", "error", err)
+	}
+
+	slog.Info("Server exiting")
+
+	done <- true
+}
+
+func shutdownTimeout() time.Duration {
+	v := os.Getenv("LANGLITE_SHUTDOWN_TIMEOUT")
+	if v == "" {
+		return defaultShutdownTimeout
+	}
+
+	timeout, err := time.ParseDuration(v)
+	if err != nil || timeout <= 0 {
+		slog.Warn("Invalid LANGLITE_SHUTDOWN_TIMEOUT, using the default", "value", v, "default", defaultShutdownTimeout)
+		return defaultShutdownTimeout
+	}
+	return timeout
+}
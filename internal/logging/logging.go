@@ -0,0 +1,146 @@
+// Package logging sets up structured JSON logging with log/slog and carries
+// correlation fields (request, project, job and worker IDs) in contexts so
+// every log line written with a *Context call includes them, along with the
+// current trace and span IDs.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey struct{}
+
+// fields are the correlation IDs attached to a context.
+type fields struct {
+	requestID string
+	projectID string
+	jobID     string
+	workerID  string
+}
+
+func fromContext(ctx context.Context) fields {
+	f, _ := ctx.Value(contextKey{}).(fields)
+	return f
+}
+
+func with(ctx context.Context, set func(*fields)) context.Context {
+	f := fromContext(ctx)
+	set(&f)
+	return context.WithValue(ctx, contextKey{}, f)
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return with(ctx, func(f *fields) { f.requestID = id })
+}
+
+func WithProjectID(ctx context.Context, id string) context.Context {
+	return with(ctx, func(f *fields) { f.projectID = id })
+}
+
+func WithJobID(ctx context.Context, id string) context.Context {
+	return with(ctx, func(f *fields) { f.jobID = id })
+}
+
+func WithWorkerID(ctx context.Context, id string) context.Context {
+	return with(ctx, func(f *fields) { f.workerID = id })
+}
+
+// maxRequestIDLength bounds client-supplied request IDs.
+const maxRequestIDLength = 128
+
+// NormalizeRequestID returns id if it is a usable client-supplied request ID:
+// non-empty, printable ASCII and at most 128 bytes. Otherwise it returns a new
+// random ID.
+func NormalizeRequestID(id string) string {
+	if id == "" || len(id) > maxRequestIDLength {
+		return uuid.New().String()
+	}
+	for _, r := range id {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return uuid.New().String()
+		}
+	}
+	return id
+}
+
+// RequestID returns the request ID attached to ctx, or "".
+func RequestID(ctx context.Context) string {
+	return fromContext(ctx).requestID
+}
+
+// ProjectID returns the project ID attached to ctx, or "".
+func ProjectID(ctx context.Context) string {
+	return fromContext(ctx).projectID
+}
+
+// contextHandler adds the trace and correlation IDs in a record's context to
+// it.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+
+	f := fromContext(ctx)
+	for _, attr := range []struct{ key, value string }{
+		{"request_id", f.requestID},
+		{"project_id", f.projectID},
+		{"job_id", f.jobID},
+		{"worker_id", f.workerID},
+	} {
+		if attr.value != "" {
+			r.AddAttrs(slog.String(attr.key, attr.value))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// New returns a JSON logger writing to w at level and above.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})})
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+// Setup installs a JSON logger on stdout as the slog and log default, at the
+// level in LANGLITE_LOG_LEVEL (default info).
+func Setup() {
+	level := slog.LevelInfo
+	var levelErr error
+	if v := os.Getenv("LANGLITE_LOG_LEVEL"); v != "" {
+		level, levelErr = ParseLevel(v)
+	}
+
+	slog.SetDefault(New(os.Stdout, level))
+
+	if levelErr != nil {
+		slog.Warn("Falling back to info logging", "error", levelErr)
+	}
+}
@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestContextFieldsAreLogged(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx = WithProjectID(ctx, "project-1")
+	ctx = WithJobID(WithWorkerID(ctx, "worker-1"), "job-1")
+
+	logger.DebugContext(ctx, "hidden")
+	logger.With("component", "test").InfoContext(ctx, "hello")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected one JSON line; got %q: %v", buf.String(), err)
+	}
+
+	want := map[string]string{
+		"msg":        "hello",
+		"component":  "test",
+		"request_id": "req-1",
+		"project_id": "project-1",
+		"job_id":     "job-1",
+		"worker_id":  "worker-1",
+	}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("expected %s=%q; got %v", key, value, line[key])
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	cases := map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	}
+	for in, want := range cases {
+		got, err := ParseLevel(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Errorf("expected an error for an unknown level")
+	}
+}
@@ -1,29 +1,45 @@
 package metrics
 
 import (
+	"context"
+	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+
+	"langlite-ingestion/internal/logging"
 )
 
+// Metrics owns its registry, so any number can exist side by side (one per
+// test, say) without clashing on the global one.
 type Metrics struct {
+	registry *prometheus.Registry
+
 	// HTTP Request metrics
 	HTTPRequestsTotal    *prometheus.CounterVec
 	HTTPRequestDuration  *prometheus.HistogramVec
 	HTTPRequestsInFlight *prometheus.GaugeVec
 
 	// Queue metrics
-	QueueDepth        *prometheus.GaugeVec
-	QueueJobsTotal    *prometheus.CounterVec
-	QueueJobDuration  *prometheus.HistogramVec
-	QueueJobsFailures *prometheus.CounterVec
+	QueueDepth               *prometheus.GaugeVec
+	QueueJobsTotal           *prometheus.CounterVec
+	QueueJobDuration         *prometheus.HistogramVec
+	QueueJobsFailures        *prometheus.CounterVec
+	QueueAdmissionRejections *prometheus.CounterVec
+	QueueWaitDuration        *prometheus.HistogramVec
+	QueuePromotions          *prometheus.CounterVec
+	QueueScheduledRuns       *prometheus.CounterVec
 
 	// Worker metrics
-	WorkerJobsProcessed *prometheus.CounterVec
-	WorkerJobsActive    *prometheus.GaugeVec
-	WorkerStatus        *prometheus.GaugeVec
+	WorkerJobsProcessed    *prometheus.CounterVec
+	WorkerJobsActive       *prometheus.GaugeVec
+	WorkerStatus           *prometheus.GaugeVec
+	WorkerConcurrencyLimit prometheus.Gauge
 
 	// Rate limiting metrics
 	RateLimitHits    *prometheus.CounterVec
@@ -37,11 +53,23 @@ type Metrics struct {
 	// Redis metrics
 	RedisOperations        *prometheus.CounterVec
 	RedisOperationDuration *prometheus.HistogramVec
+
+	// Retention metrics
+	RetentionPurgedRows *prometheus.CounterVec
 }
 
 func NewMetrics() *Metrics {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	factory := promauto.With(registry)
+
 	return &Metrics{
-		HTTPRequestsTotal: promauto.NewCounterVec(
+		registry: registry,
+
+		HTTPRequestsTotal: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "http_requests_total",
 				Help: "Total number of HTTP requests",
@@ -49,7 +77,7 @@ func NewMetrics() *Metrics {
 			[]string{"method", "endpoint", "status_code"},
 		),
 
-		HTTPRequestDuration: promauto.NewHistogramVec(
+		HTTPRequestDuration: factory.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "http_request_duration_seconds",
 				Help:    "Duration of HTTP requests in seconds",
@@ -58,7 +86,7 @@ func NewMetrics() *Metrics {
 			[]string{"method", "endpoint", "status_code"},
 		),
 
-		HTTPRequestsInFlight: promauto.NewGaugeVec(
+		HTTPRequestsInFlight: factory.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "http_requests_in_flight",
 				Help: "Number of HTTP requests currently being processed",
@@ -66,7 +94,7 @@ func NewMetrics() *Metrics {
 			[]string{"method", "endpoint"},
 		),
 
-		QueueDepth: promauto.NewGaugeVec(
+		QueueDepth: factory.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "queue_depth",
 				Help: "Current depth of job queues",
@@ -74,7 +102,7 @@ func NewMetrics() *Metrics {
 			[]string{"queue_name", "priority", "job_type"},
 		),
 
-		QueueJobsTotal: promauto.NewCounterVec(
+		QueueJobsTotal: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "queue_jobs_total",
 				Help: "Total number of jobs processed by queues",
@@ -82,7 +110,7 @@ func NewMetrics() *Metrics {
 			[]string{"queue_name", "priority", "job_type", "status"},
 		),
 
-		QueueJobDuration: promauto.NewHistogramVec(
+		QueueJobDuration: factory.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "queue_job_duration_seconds",
 				Help:    "Duration of queue job processing in seconds",
@@ -91,7 +119,7 @@ func NewMetrics() *Metrics {
 			[]string{"queue_name", "priority", "job_type"},
 		),
 
-		QueueJobsFailures: promauto.NewCounterVec(
+		QueueJobsFailures: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "queue_jobs_failures_total",
 				Help: "Total number of failed queue jobs",
@@ -99,7 +127,7 @@ func NewMetrics() *Metrics {
 			[]string{"queue_name", "priority", "job_type", "error_type"},
 		),
 
-		WorkerJobsProcessed: promauto.NewCounterVec(
+		WorkerJobsProcessed: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "worker_jobs_processed_total",
 				Help: "Total number of jobs processed by workers",
@@ -107,7 +135,40 @@ func NewMetrics() *Metrics {
 			[]string{"worker_id", "job_type", "status"},
 		),
 
-		WorkerJobsActive: promauto.NewGaugeVec(
+		QueueWaitDuration: factory.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "queue_job_wait_seconds",
+				Help:    "Time jobs waited in the queue before a worker took them, in seconds",
+				Buckets: []float64{0.01, 0.1, 0.5, 1, 5, 15, 30, 60, 120, 300, 600},
+			},
+			[]string{"queue_name", "priority", "job_type"},
+		),
+
+		QueuePromotions: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "queue_jobs_promoted_total",
+				Help: "Total number of jobs moved up a priority after waiting too long, by the priority they left",
+			},
+			[]string{"priority", "job_type"},
+		),
+
+		QueueScheduledRuns: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "queue_scheduled_runs_total",
+				Help: "Total number of jobs enqueued by schedules",
+			},
+			[]string{"schedule"},
+		),
+
+		QueueAdmissionRejections: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "queue_admission_rejections_total",
+				Help: "Total number of ingested items turned away because the queue was saturated",
+			},
+			[]string{"reason"},
+		),
+
+		WorkerJobsActive: factory.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "worker_jobs_active",
 				Help: "Number of jobs currently being processed by workers",
@@ -115,7 +176,7 @@ func NewMetrics() *Metrics {
 			[]string{"worker_id"},
 		),
 
-		WorkerStatus: promauto.NewGaugeVec(
+		WorkerStatus: factory.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "worker_status",
 				Help: "Status of workers (1 = running, 0 = stopped)",
@@ -123,7 +184,14 @@ func NewMetrics() *Metrics {
 			[]string{"worker_id"},
 		),
 
-		RateLimitHits: promauto.NewCounterVec(
+		WorkerConcurrencyLimit: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "worker_concurrency_limit",
+				Help: "Number of this process's workers allowed to run jobs at once, adapted to database latency",
+			},
+		),
+
+		RateLimitHits: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "rate_limit_hits_total",
 				Help: "Total number of rate limit hits",
@@ -131,7 +199,7 @@ func NewMetrics() *Metrics {
 			[]string{"api_key_id", "limit_type"},
 		),
 
-		RateLimitCurrent: promauto.NewGaugeVec(
+		RateLimitCurrent: factory.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "rate_limit_current",
 				Help: "Current rate limit usage",
@@ -139,7 +207,7 @@ func NewMetrics() *Metrics {
 			[]string{"api_key_id", "limit_type"},
 		),
 
-		DatabaseConnections: promauto.NewGaugeVec(
+		DatabaseConnections: factory.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "database_connections",
 				Help: "Number of database connections",
@@ -147,7 +215,7 @@ func NewMetrics() *Metrics {
 			[]string{"status"}, // open, idle, in_use
 		),
 
-		DatabaseQueries: promauto.NewCounterVec(
+		DatabaseQueries: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "database_queries_total",
 				Help: "Total number of database queries",
@@ -155,7 +223,7 @@ func NewMetrics() *Metrics {
 			[]string{"operation", "table", "status"},
 		),
 
-		DatabaseQueryDuration: promauto.NewHistogramVec(
+		DatabaseQueryDuration: factory.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "database_query_duration_seconds",
 				Help:    "Duration of database queries in seconds",
@@ -164,7 +232,7 @@ func NewMetrics() *Metrics {
 			[]string{"operation", "table"},
 		),
 
-		RedisOperations: promauto.NewCounterVec(
+		RedisOperations: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "redis_operations_total",
 				Help: "Total number of Redis operations",
@@ -172,7 +240,7 @@ func NewMetrics() *Metrics {
 			[]string{"operation", "status"},
 		),
 
-		RedisOperationDuration: promauto.NewHistogramVec(
+		RedisOperationDuration: factory.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "redis_operation_duration_seconds",
 				Help:    "Duration of Redis operations in seconds",
@@ -180,13 +248,63 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"operation"},
 		),
+
+		RetentionPurgedRows: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "retention_purged_rows_total",
+				Help: "Total number of rows deleted by retention purges, excluding cascaded rows",
+			},
+			[]string{"entity"},
+		),
+	}
+}
+
+// Handler serves the registry in the Prometheus text format, or OpenMetrics
+// (which carries exemplars) when the scraper asks for it.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{
+		Registry:          m.registry,
+		EnableOpenMetrics: true,
+	})
+}
+
+// maxExemplarRunes is the OpenMetrics limit on the combined length of an
+// exemplar's label names and values.
+const maxExemplarRunes = 128
+
+// exemplar links an observation to the sampled trace and the request it was
+// made for, if ctx carries them.
+func exemplar(ctx context.Context) prometheus.Labels {
+	labels := prometheus.Labels{}
+	size := 0
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+		labels["trace_id"] = sc.TraceID().String()
+		size += len("trace_id") + len(labels["trace_id"])
+	}
+
+	// Request IDs are client supplied and may be too long to fit.
+	if requestID := logging.RequestID(ctx); requestID != "" && size+len("request_id")+len(requestID) <= maxExemplarRunes {
+		labels["request_id"] = requestID
 	}
+
+	return labels
 }
 
-func (m *Metrics) RecordHTTPRequest(method, endpoint string, statusCode int, duration time.Duration) {
+// observe records v on h with an exemplar from ctx when there is one.
+func observe(ctx context.Context, h prometheus.Observer, v float64) {
+	labels := exemplar(ctx)
+	if eo, ok := h.(prometheus.ExemplarObserver); ok && len(labels) > 0 {
+		eo.ObserveWithExemplar(v, labels)
+		return
+	}
+	h.Observe(v)
+}
+
+func (m *Metrics) RecordHTTPRequest(ctx context.Context, method, endpoint string, statusCode int, duration time.Duration) {
 	status := strconv.Itoa(statusCode)
 	m.HTTPRequestsTotal.WithLabelValues(method, endpoint, status).Inc()
-	m.HTTPRequestDuration.WithLabelValues(method, endpoint, status).Observe(duration.Seconds())
+	observe(ctx, m.HTTPRequestDuration.WithLabelValues(method, endpoint, status), duration.Seconds())
 }
 
 func (m *Metrics) RecordHTTPRequestInFlight(method, endpoint string, delta float64) {
@@ -197,15 +315,29 @@ func (m *Metrics) UpdateQueueDepth(queueName, priority, jobType string, depth fl
 	m.QueueDepth.WithLabelValues(queueName, priority, jobType).Set(depth)
 }
 
-func (m *Metrics) RecordQueueJob(queueName, priority, jobType, status string, duration time.Duration) {
+func (m *Metrics) RecordQueueJob(ctx context.Context, queueName, priority, jobType, status string, duration time.Duration) {
 	m.QueueJobsTotal.WithLabelValues(queueName, priority, jobType, status).Inc()
-	m.QueueJobDuration.WithLabelValues(queueName, priority, jobType).Observe(duration.Seconds())
+	observe(ctx, m.QueueJobDuration.WithLabelValues(queueName, priority, jobType), duration.Seconds())
 }
 
 func (m *Metrics) RecordQueueJobFailure(queueName, priority, jobType, errorType string) {
 	m.QueueJobsFailures.WithLabelValues(queueName, priority, jobType, errorType).Inc()
 }
 
+// RecordQueueWait observes how long a job waited for a worker, labelled by
+// the queue it was enqueued on even if aging promoted it.
+func (m *Metrics) RecordQueueWait(ctx context.Context, queueName, priority, jobType string, wait time.Duration) {
+	observe(ctx, m.QueueWaitDuration.WithLabelValues(queueName, priority, jobType), wait.Seconds())
+}
+
+func (m *Metrics) RecordQueuePromotions(priority, jobType string, n int) {
+	m.QueuePromotions.WithLabelValues(priority, jobType).Add(float64(n))
+}
+
+func (m *Metrics) RecordScheduledRun(schedule string) {
+	m.QueueScheduledRuns.WithLabelValues(schedule).Inc()
+}
+
 func (m *Metrics) RecordWorkerJob(workerID, jobType, status string) {
 	m.WorkerJobsProcessed.WithLabelValues(workerID, jobType, status).Inc()
 }
@@ -222,6 +354,14 @@ func (m *Metrics) UpdateWorkerStatus(workerID string, running bool) {
 	m.WorkerStatus.WithLabelValues(workerID).Set(status)
 }
 
+func (m *Metrics) UpdateWorkerConcurrencyLimit(limit int) {
+	m.WorkerConcurrencyLimit.Set(float64(limit))
+}
+
+func (m *Metrics) RecordAdmissionRejected(reason string) {
+	m.QueueAdmissionRejections.WithLabelValues(reason).Inc()
+}
+
 func (m *Metrics) RecordRateLimitHit(apiKeyID, limitType string) {
 	m.RateLimitHits.WithLabelValues(apiKeyID, limitType).Inc()
 }
@@ -236,12 +376,16 @@ func (m *Metrics) UpdateDatabaseConnections(open, idle, inUse float64) {
 	m.DatabaseConnections.WithLabelValues("in_use").Set(inUse)
 }
 
-func (m *Metrics) RecordDatabaseQuery(operation, table, status string, duration time.Duration) {
+func (m *Metrics) RecordDatabaseQuery(ctx context.Context, operation, table, status string, duration time.Duration) {
 	m.DatabaseQueries.WithLabelValues(operation, table, status).Inc()
-	m.DatabaseQueryDuration.WithLabelValues(operation, table).Observe(duration.Seconds())
+	observe(ctx, m.DatabaseQueryDuration.WithLabelValues(operation, table), duration.Seconds())
 }
 
-func (m *Metrics) RecordRedisOperation(operation, status string, duration time.Duration) {
+func (m *Metrics) RecordRedisOperation(ctx context.Context, operation, status string, duration time.Duration) {
 	m.RedisOperations.WithLabelValues(operation, status).Inc()
-	m.RedisOperationDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	observe(ctx, m.RedisOperationDuration.WithLabelValues(operation), duration.Seconds())
+}
+
+func (m *Metrics) RecordRetentionPurge(entity string, rows int64) {
+	m.RetentionPurgedRows.WithLabelValues(entity).Add(float64(rows))
 }
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"langlite-ingestion/internal/logging"
+)
+
+func TestNewMetricsUsesOwnRegistry(t *testing.T) {
+	// Registering on the global registry would panic the second time.
+	a, b := NewMetrics(), NewMetrics()
+	if a.registry == b.registry {
+		t.Errorf("expected each Metrics to have its own registry")
+	}
+}
+
+func TestExemplar(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sampled := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	unsampled := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		want map[string]string
+	}{
+		{"empty", context.Background(), map[string]string{}},
+		{"request", logging.WithRequestID(context.Background(), "req-1"), map[string]string{"request_id": "req-1"}},
+		{"sampled trace", logging.WithRequestID(sampled, "req-1"), map[string]string{"trace_id": traceID.String(), "request_id": "req-1"}},
+		{"unsampled trace", unsampled, map[string]string{}},
+		{"long request id", logging.WithRequestID(sampled, strings.Repeat("r", 100)), map[string]string{"trace_id": traceID.String()}},
+	}
+
+	for _, c := range cases {
+		got := exemplar(c.ctx)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: expected %v; got %v", c.name, c.want, got)
+			continue
+		}
+		for k, v := range c.want {
+			if got[k] != v {
+				t.Errorf("%s: expected %v; got %v", c.name, c.want, got)
+			}
+		}
+	}
+
+	// An exemplar that breaks the length limit would panic here.
+	m := NewMetrics()
+	m.RecordHTTPRequest(logging.WithRequestID(sampled, strings.Repeat("r", 128)), "GET", "/", 200, 0)
+}
@@ -0,0 +1,167 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: langlite/ingestion/v1/ingestion.proto
+
+package ingestionv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	IngestionService_Ingest_FullMethodName       = "/langlite.ingestion.v1.IngestionService/Ingest"
+	IngestionService_IngestStream_FullMethodName = "/langlite.ingestion.v1.IngestionService/IngestStream"
+)
+
+// IngestionServiceClient is the client API for IngestionService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// IngestionService is the gRPC counterpart of the /api/v1 HTTP endpoints.
+// Calls must carry an API key in the "authorization" metadata as
+// "Bearer <key>", or in "x-api-key".
+type IngestionServiceClient interface {
+	// Ingest accepts a single item.
+	Ingest(ctx context.Context, in *IngestRequest, opts ...grpc.CallOption) (*IngestResponse, error)
+	// IngestStream accepts any number of items and reports a result per item
+	// once the client closes the stream.
+	IngestStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[IngestRequest, IngestStreamResponse], error)
+}
+
+type ingestionServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIngestionServiceClient(cc grpc.ClientConnInterface) IngestionServiceClient {
+	return &ingestionServiceClient{cc}
+}
+
+func (c *ingestionServiceClient) Ingest(ctx context.Context, in *IngestRequest, opts ...grpc.CallOption) (*IngestResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IngestResponse)
+	err := c.cc.Invoke(ctx, IngestionService_Ingest_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ingestionServiceClient) IngestStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[IngestRequest, IngestStreamResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &IngestionService_ServiceDesc.Streams[0], IngestionService_IngestStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[IngestRequest, IngestStreamResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type IngestionService_IngestStreamClient = grpc.ClientStreamingClient[IngestRequest, IngestStreamResponse]
+
+// IngestionServiceServer is the server API for IngestionService service.
+// All implementations must embed UnimplementedIngestionServiceServer
+// for forward compatibility.
+//
+// IngestionService is the gRPC counterpart of the /api/v1 HTTP endpoints.
+// Calls must carry an API key in the "authorization" metadata as
+// "Bearer <key>", or in "x-api-key".
+type IngestionServiceServer interface {
+	// Ingest accepts a single item.
+	Ingest(context.Context, *IngestRequest) (*IngestResponse, error)
+	// IngestStream accepts any number of items and reports a result per item
+	// once the client closes the stream.
+	IngestStream(grpc.ClientStreamingServer[IngestRequest, IngestStreamResponse]) error
+	mustEmbedUnimplementedIngestionServiceServer()
+}
+
+// UnimplementedIngestionServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedIngestionServiceServer struct{}
+
+func (UnimplementedIngestionServiceServer) Ingest(context.Context, *IngestRequest) (*IngestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ingest not implemented")
+}
+func (UnimplementedIngestionServiceServer) IngestStream(grpc.ClientStreamingServer[IngestRequest, IngestStreamResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method IngestStream not implemented")
+}
+func (UnimplementedIngestionServiceServer) mustEmbedUnimplementedIngestionServiceServer() {}
+func (UnimplementedIngestionServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeIngestionServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to IngestionServiceServer will
+// result in compilation errors.
+type UnsafeIngestionServiceServer interface {
+	mustEmbedUnimplementedIngestionServiceServer()
+}
+
+func RegisterIngestionServiceServer(s grpc.ServiceRegistrar, srv IngestionServiceServer) {
+	// If the following call pancis, it indicates UnimplementedIngestionServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&IngestionService_ServiceDesc, srv)
+}
+
+func _IngestionService_Ingest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IngestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IngestionServiceServer).Ingest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: IngestionService_Ingest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IngestionServiceServer).Ingest(ctx, req.(*IngestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IngestionService_IngestStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(IngestionServiceServer).IngestStream(&grpc.GenericServerStream[IngestRequest, IngestStreamResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type IngestionService_IngestStreamServer = grpc.ClientStreamingServer[IngestRequest, IngestStreamResponse]
+
+// IngestionService_ServiceDesc is the grpc.ServiceDesc for IngestionService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var IngestionService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "langlite.ingestion.v1.IngestionService",
+	HandlerType: (*IngestionServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Ingest",
+			Handler:    _IngestionService_Ingest_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "IngestStream",
+			Handler:       _IngestionService_IngestStream_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "langlite/ingestion/v1/ingestion.proto",
+}
@@ -0,0 +1,154 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// admissionRefreshInterval is how often Admission rereads the backlog.
+	// In between, each instance counts what it admits itself.
+	admissionRefreshInterval = time.Second
+
+	// pendingReconcileInterval is how often Admission has the backend
+	// recount each project's waiting items, correcting any drift.
+	pendingReconcileInterval = time.Minute
+
+	queueFullRetryAfter    = 10 * time.Second
+	projectQuotaRetryAfter = 5 * time.Second
+)
+
+// Reasons an item is turned away, as recorded in metrics.
+const (
+	rejectQueueFull    = "queue_full"
+	rejectProjectQuota = "project_quota"
+)
+
+// OverloadError reports that the queue can't take more work right now.
+type OverloadError struct {
+	Reason string
+	After  time.Duration
+}
+
+func (e *OverloadError) Error() string {
+	return e.Reason
+}
+
+// RetryAfter is how long producers should wait before trying again.
+func (e *OverloadError) RetryAfter() time.Duration {
+	return e.After
+}
+
+// AdmissionRecorder receives the items Admission turns away.
+// *metrics.Metrics implements it.
+type AdmissionRecorder interface {
+	RecordAdmissionRejected(reason string)
+}
+
+// Admission pushes back on producers when workers fall behind: it turns
+// items away once maxDepth items are waiting to be stored across all
+// projects, and once a project has projectQuota of them, so one noisy
+// project can't fill the queue for everyone else. Both limits count items
+// rather than jobs, matching what Admit counts, since each item fans out
+// into several jobs. Either limit is off when 0.
+//
+// Admit works from a snapshot that Run refreshes every second, so it doesn't
+// add a Redis round trip to every ingested item.
+type Admission struct {
+	client       *Client
+	metrics      AdmissionRecorder
+	maxDepth     int64
+	projectQuota int64
+
+	mu      sync.Mutex
+	backlog int64
+	pending map[string]int64
+}
+
+func NewAdmission(client *Client, m AdmissionRecorder, maxDepth, projectQuota int64) *Admission {
+	return &Admission{
+		client:       client,
+		metrics:      m,
+		maxDepth:     maxDepth,
+		projectQuota: projectQuota,
+		pending:      make(map[string]int64),
+	}
+}
+
+// Run refreshes the snapshot until ctx is done, reconciling the pending
+// counts every pendingReconcileInterval.
+func (a *Admission) Run(ctx context.Context) {
+	ticker := time.NewTicker(admissionRefreshInterval)
+	defer ticker.Stop()
+
+	var reconciled time.Time
+	for {
+		if time.Since(reconciled) >= pendingReconcileInterval {
+			if err := a.client.ReconcilePending(ctx); err != nil && ctx.Err() == nil {
+				slog.WarnContext(ctx, "Failed to reconcile pending items", "error", err)
+			}
+			reconciled = time.Now()
+		}
+		if err := a.refresh(ctx); err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "Failed to refresh queue admission", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Admission) refresh(ctx context.Context) error {
+	pending, err := a.client.PendingByProject(ctx)
+	if err != nil {
+		return err
+	}
+	var backlog int64
+	for _, n := range pending {
+		backlog += n
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.backlog, a.pending = backlog, pending
+	return nil
+}
+
+// Admit reports whether projectID may enqueue another item, returning an
+// *OverloadError if not.
+func (a *Admission) Admit(ctx context.Context, projectID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.maxDepth > 0 && a.backlog >= a.maxDepth {
+		a.reject(rejectQueueFull)
+		return &OverloadError{
+			Reason: fmt.Sprintf("queue is full: %d items waiting", a.backlog),
+			After:  queueFullRetryAfter,
+		}
+	}
+
+	if a.projectQuota > 0 && a.pending[projectID] >= a.projectQuota {
+		a.reject(rejectProjectQuota)
+		return &OverloadError{
+			Reason: fmt.Sprintf("project has %d items waiting, the most allowed", a.pending[projectID]),
+			After:  projectQuotaRetryAfter,
+		}
+	}
+
+	a.backlog++
+	a.pending[projectID]++
+	return nil
+}
+
+func (a *Admission) reject(reason string) {
+	if a.metrics != nil {
+		a.metrics.RecordAdmissionRejected(reason)
+	}
+}
@@ -0,0 +1,131 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/logging"
+)
+
+type admissionRecorder struct {
+	rejected []string
+}
+
+func (r *admissionRecorder) RecordAdmissionRejected(reason string) {
+	r.rejected = append(r.rejected, reason)
+}
+
+func TestAdmission(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	client := NewClient(NewRedisBackend(rdb))
+
+	noisy := logging.WithProjectID(ctx, "noisy")
+	for range 3 {
+		if _, err := client.Enqueue(noisy, QueueHigh, StoreRawPayload{}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	if _, err := client.Enqueue(logging.WithProjectID(ctx, "quiet"), QueueHigh, StoreRawPayload{}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	rec := &admissionRecorder{}
+	admission := NewAdmission(client, rec, 6, 3)
+	if err := admission.refresh(ctx); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	var overload *OverloadError
+	if err := admission.Admit(ctx, "noisy"); !errors.As(err, &overload) || overload.RetryAfter() != projectQuotaRetryAfter {
+		t.Errorf("expected the noisy project to hit its quota; got %v", err)
+	}
+	if err := admission.Admit(ctx, "quiet"); err != nil {
+		t.Errorf("expected the quiet project to be admitted; got %v", err)
+	}
+
+	// The backlog is now 4 items plus the one just admitted; one more fills
+	// the queue for everyone.
+	if err := admission.Admit(ctx, "other"); err != nil {
+		t.Errorf("expected another project to be admitted; got %v", err)
+	}
+	if err := admission.Admit(ctx, "quiet"); !errors.As(err, &overload) || overload.RetryAfter() != queueFullRetryAfter {
+		t.Errorf("expected a full queue to turn everyone away; got %v", err)
+	}
+
+	if len(rec.rejected) != 2 || rec.rejected[0] != rejectProjectQuota || rec.rejected[1] != rejectQueueFull {
+		t.Errorf("expected both rejections recorded; got %v", rec.rejected)
+	}
+
+	// Dequeueing frees up the project's quota.
+	if _, err := client.Dequeue(ctx, []JobType{JobTypeStoreRaw}, 0); err != nil {
+		t.Fatalf("dequeue: %v", err)
+	}
+	pending, err := client.PendingByProject(ctx)
+	if err != nil {
+		t.Fatalf("pending: %v", err)
+	}
+	if pending["noisy"] != 2 || pending["quiet"] != 1 {
+		t.Errorf("expected the oldest noisy job to be dequeued; got %v", pending)
+	}
+}
+
+// The project quota counts items, however many jobs each one enqueues.
+func TestAdmissionQuotaCountsItems(t *testing.T) {
+	ctx := logging.WithProjectID(context.Background(), "p1")
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	client := NewClient(NewRedisBackend(rdb))
+
+	// Each trace enqueues store_raw, enrich_trace and analytics_export.
+	if err := client.EnqueueTrace(ctx, database.TraceRequest{ID: "t1", ProjectID: "p1", Name: "chat"}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	admission := NewAdmission(client, nil, 0, 2)
+	if err := admission.refresh(ctx); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if err := admission.Admit(ctx, "p1"); err != nil {
+		t.Fatalf("expected a second item within a quota of 2; got %v", err)
+	}
+	if err := client.EnqueueTrace(ctx, database.TraceRequest{ID: "t2", ProjectID: "p1", Name: "chat"}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	if err := admission.refresh(ctx); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	var overload *OverloadError
+	if err := admission.Admit(ctx, "p1"); !errors.As(err, &overload) {
+		t.Errorf("expected a third item over the quota; got %v", err)
+	}
+}
+
+// The queue depth counts items too, in the same unit Admit adds.
+func TestAdmissionDepthCountsItems(t *testing.T) {
+	ctx := logging.WithProjectID(context.Background(), "p1")
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	client := NewClient(NewRedisBackend(rdb))
+
+	// One trace is three jobs but a single item.
+	if err := client.EnqueueTrace(ctx, database.TraceRequest{ID: "t1", ProjectID: "p1", Name: "chat"}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	admission := NewAdmission(client, nil, 2, 0)
+	if err := admission.refresh(ctx); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if err := admission.Admit(ctx, "p2"); err != nil {
+		t.Fatalf("expected a second item within a depth of 2; got %v", err)
+	}
+	var overload *OverloadError
+	if err := admission.Admit(ctx, "p3"); !errors.As(err, &overload) || overload.Error() != "queue is full: 2 items waiting" {
+		t.Errorf("expected a third item over the depth; got %v", err)
+	}
+}
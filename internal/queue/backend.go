@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// Backend stores the queues and the state workers and schedulers share
+// across instances. Client builds the queue's behaviour on top of it.
+// RedisBackend, MemoryBackend and PostgresBackend implement it.
+//
+// A queue is ordered: Push adds a job at the back, PushFront at the front,
+// and Pop takes from the front.
+type Backend interface {
+	// Push adds a new job to the back of its queue and tracks it until it
+	// completes or is buried.
+	Push(ctx context.Context, job *Job) error
+	// PushFront puts a job back at the front of its queue, e.g. one
+	// interrupted by shutdown.
+	PushFront(ctx context.Context, job *Job) error
+	// PushDelayed holds a job until at, then ReleaseDelayed moves it to the
+	// back of its queue. It tracks new jobs too.
+	PushDelayed(ctx context.Context, job *Job, at time.Time) error
+	// Pop takes the job at the front of the first of queueNames that has
+	// one, waiting up to timeout for one to arrive. It returns nil, nil if
+	// none did.
+	Pop(ctx context.Context, queueNames []string, timeout time.Duration) (*Job, error)
+	// Complete stops tracking a finished job and keeps it as completed for
+	// an hour.
+	Complete(ctx context.Context, job *Job) error
+	// Bury moves a job that ran out of attempts to the dead letter queue.
+	Bury(ctx context.Context, job *Job) error
+
+	// ReleaseDelayed moves delayed jobs that are due by now onto their
+	// queues and returns how many it moved. Several instances may call it
+	// at once; each job is moved once.
+	ReleaseDelayed(ctx context.Context, now time.Time) (int, error)
+	// Promote moves up to limit of the oldest jobs that have been ready
+	// since before cutoff from the front of the from queue to the front of
+	// the to queue, and returns how many it moved.
+	Promote(ctx context.Context, from, to string, cutoff time.Time, limit int) (int, error)
+
+	// Stats returns the length of each of queueNames, plus "dead_letter"
+	// and "delayed".
+	Stats(ctx context.Context, queueNames []string) (map[string]int64, error)
+	// PendingByProject returns how many items each project has waiting to
+	// be stored: its store_raw jobs in the queues. See countsAsPending.
+	PendingByProject(ctx context.Context) (map[string]int64, error)
+	// JobStatuses returns the status of each job still tracked for the
+	// project's entity entityID, in no particular order.
+	JobStatuses(ctx context.Context, projectID, entityID string) ([]JobStatus, error)
+
+	// RegisterWorkers records workers, refreshing their expiry.
+	RegisterWorkers(ctx context.Context, workers []WorkerInfo) error
+	// DeregisterWorkers removes stopped workers.
+	DeregisterWorkers(ctx context.Context, ids []string) error
+	// Workers lists the workers reported within workerRegistryTTL, in no
+	// particular order, and forgets the rest.
+	Workers(ctx context.Context) ([]WorkerInfo, error)
+
+	// TryLock takes the named lock for token, or extends it if token
+	// already holds it, for ttl. It reports whether token holds the lock.
+	TryLock(ctx context.Context, name, token string, ttl time.Duration) (bool, error)
+	// Unlock releases the named lock if token holds it.
+	Unlock(ctx context.Context, name, token string) error
+
+	// LastRun returns when the named schedule last ran, or the zero time
+	// if it never has.
+	LastRun(ctx context.Context, schedule string) (time.Time, error)
+	// AdvanceRun moves the schedule's last run from from, the zero time
+	// for a schedule that never ran, to to. It reports false if the last
+	// run wasn't from, i.e. another instance got there first.
+	AdvanceRun(ctx context.Context, schedule string, from, to time.Time) (bool, error)
+}
+
+// countsAsPending reports whether job counts towards its project's waiting
+// items. Each ingested item has exactly one store_raw job, and its other
+// jobs follow from that one, so counting store_raw jobs counts items, which
+// is what Admission admits.
+func countsAsPending(job *Job) bool {
+	return job.Type == JobTypeStoreRaw && job.ProjectID != ""
+}
@@ -0,0 +1,387 @@
+package queue
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/logging"
+)
+
+// testBackends returns a fresh instance of each backend that runs without
+// external services.
+func testBackends(t *testing.T) map[string]Backend {
+	t.Helper()
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	return map[string]Backend{
+		"redis":  NewRedisBackend(rdb),
+		"memory": NewMemoryBackend(),
+	}
+}
+
+func testJob(t *testing.T, payload Payload, priority QueuePriority, projectID string) *Job {
+	t.Helper()
+
+	job, err := newJob(context.Background(), priority, payload)
+	if err != nil {
+		t.Fatalf("new job: %v", err)
+	}
+	job.ProjectID = projectID
+	return job
+}
+
+func mustPop(t *testing.T, b Backend, queueNames ...string) *Job {
+	t.Helper()
+
+	job, err := b.Pop(context.Background(), queueNames, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("pop: %v", err)
+	}
+	if job == nil {
+		t.Fatalf("expected a job in %v", queueNames)
+	}
+	return job
+}
+
+func TestBackendOrdering(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			first := testJob(t, StoreRawPayload{}, QueueLow, "")
+			second := testJob(t, StoreRawPayload{}, QueueLow, "")
+			urgent := testJob(t, StoreRawPayload{}, QueueHigh, "")
+			for _, job := range []*Job{first, second, urgent} {
+				if err := b.Push(ctx, job); err != nil {
+					t.Fatalf("push: %v", err)
+				}
+			}
+
+			// Queues are taken from in the order they're named.
+			if job := mustPop(t, b, "high:store_raw", "low:store_raw"); job.ID != urgent.ID {
+				t.Errorf("expected the high priority job first; got %s", job.ID)
+			}
+			if job := mustPop(t, b, "high:store_raw", "low:store_raw"); job.ID != first.ID {
+				t.Errorf("expected the oldest job next; got %s", job.ID)
+			}
+
+			// A job put back at the front is taken before older ones.
+			interrupted := testJob(t, StoreRawPayload{}, QueueLow, "")
+			if err := b.PushFront(ctx, interrupted); err != nil {
+				t.Fatalf("push front: %v", err)
+			}
+			if job := mustPop(t, b, "low:store_raw"); job.ID != interrupted.ID {
+				t.Errorf("expected the requeued job first; got %s", job.ID)
+			}
+			if job := mustPop(t, b, "low:store_raw"); job.ID != second.ID {
+				t.Errorf("expected the remaining job last; got %s", job.ID)
+			}
+
+			job, err := b.Pop(ctx, []string{"low:store_raw"}, 50*time.Millisecond)
+			if err != nil || job != nil {
+				t.Errorf("expected an empty queue to time out; got %v, %v", job, err)
+			}
+		})
+	}
+}
+
+func TestBackendPopWaits(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			job := testJob(t, StoreRawPayload{}, QueueHigh, "")
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				_ = b.Push(ctx, job)
+			}()
+
+			got, err := b.Pop(ctx, []string{"high:store_raw"}, 2*time.Second)
+			if err != nil || got == nil || got.ID != job.ID {
+				t.Errorf("expected Pop to wait for the job; got %v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestBackendDelayedAndDeadJobs(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			soon := testJob(t, EnrichTracePayload{}, QueueMedium, "")
+			later := testJob(t, EnrichTracePayload{}, QueueMedium, "")
+			if err := b.PushDelayed(ctx, soon, now.Add(time.Second)); err != nil {
+				t.Fatalf("push delayed: %v", err)
+			}
+			if err := b.PushDelayed(ctx, later, now.Add(time.Hour)); err != nil {
+				t.Fatalf("push delayed: %v", err)
+			}
+
+			for range 2 {
+				if _, err := b.ReleaseDelayed(ctx, now.Add(time.Minute)); err != nil {
+					t.Fatalf("release: %v", err)
+				}
+			}
+
+			stats, err := b.Stats(ctx, []string{"medium:enrich_trace"})
+			if err != nil {
+				t.Fatalf("stats: %v", err)
+			}
+			if stats["medium:enrich_trace"] != 1 || stats["delayed"] != 1 {
+				t.Errorf("expected the due job released once; got %v", stats)
+			}
+
+			job := mustPop(t, b, "medium:enrich_trace")
+			if job.ID != soon.ID {
+				t.Errorf("expected the due job; got %s", job.ID)
+			}
+			if err := b.Bury(ctx, job); err != nil {
+				t.Fatalf("bury: %v", err)
+			}
+			if stats, _ := b.Stats(ctx, nil); stats["dead_letter"] != 1 {
+				t.Errorf("expected one dead letter; got %v", stats)
+			}
+		})
+	}
+}
+
+func TestBackendPromote(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			old := testJob(t, AnalyticsExportPayload{}, QueueLow, "")
+			old.EnqueuedAt = old.EnqueuedAt.Add(-time.Hour)
+			recent := testJob(t, AnalyticsExportPayload{}, QueueLow, "")
+			for _, job := range []*Job{old, recent} {
+				if err := b.Push(ctx, job); err != nil {
+					t.Fatalf("push: %v", err)
+				}
+			}
+
+			promoted, err := b.Promote(ctx, "low:analytics_export", "medium:analytics_export", time.Now().Add(-time.Minute), 10)
+			if err != nil {
+				t.Fatalf("promote: %v", err)
+			}
+			if promoted != 1 {
+				t.Errorf("expected only the old job promoted; got %d", promoted)
+			}
+			if job := mustPop(t, b, "medium:analytics_export"); job.ID != old.ID {
+				t.Errorf("expected the old job in the medium queue; got %s", job.ID)
+			}
+		})
+	}
+}
+
+// A worker that dies between BRPOP and decrementing the count leaves it too
+// high; reconciling recounts it from the queues.
+func TestRedisReconcilePending(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	b := NewRedisBackend(rdb)
+
+	for _, projectID := range []string{"a", "a", "b"} {
+		if err := b.Push(ctx, testJob(t, StoreRawPayload{}, QueueHigh, projectID)); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+	if err := b.Push(ctx, testJob(t, StoreRawPayload{}, QueueLow, "b")); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if err := b.Push(ctx, testJob(t, EnrichTracePayload{}, QueueMedium, "b")); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	// As if a worker popped a's jobs and died before counting them.
+	rdb.RPop(ctx, "high:store_raw")
+	rdb.RPop(ctx, "high:store_raw")
+	rdb.HSet(ctx, pendingByProjectKey, "gone", 7)
+
+	if err := b.ReconcilePending(ctx); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	pending, err := b.PendingByProject(ctx)
+	if err != nil {
+		t.Fatalf("pending: %v", err)
+	}
+	if len(pending) != 1 || pending["b"] != 2 {
+		t.Errorf("expected only b's two items counted; got %v", pending)
+	}
+}
+
+func TestBackendPendingByProject(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, projectID := range []string{"a", "a", "b", ""} {
+				if err := b.Push(ctx, testJob(t, StoreRawPayload{}, QueueHigh, projectID)); err != nil {
+					t.Fatalf("push: %v", err)
+				}
+			}
+			// An item's other jobs don't count again.
+			if err := b.Push(ctx, testJob(t, AnalyticsExportPayload{}, QueueLow, "b")); err != nil {
+				t.Fatalf("push: %v", err)
+			}
+			mustPop(t, b, "high:store_raw")
+
+			pending, err := b.PendingByProject(ctx)
+			if err != nil {
+				t.Fatalf("pending: %v", err)
+			}
+			if pending["a"] != 1 || pending["b"] != 1 {
+				t.Errorf("expected one item waiting per project; got %v", pending)
+			}
+		})
+	}
+}
+
+func TestBackendLocksAndRuns(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			if held, err := b.TryLock(ctx, "cron", "a", time.Minute); err != nil || !held {
+				t.Fatalf("expected a to take the lock; got %v, %v", held, err)
+			}
+			if held, _ := b.TryLock(ctx, "cron", "b", time.Minute); held {
+				t.Error("expected b to be refused a held lock")
+			}
+			if held, _ := b.TryLock(ctx, "cron", "a", time.Minute); !held {
+				t.Error("expected a to renew its lock")
+			}
+			if err := b.Unlock(ctx, "cron", "b"); err != nil {
+				t.Fatalf("unlock: %v", err)
+			}
+			if held, _ := b.TryLock(ctx, "cron", "b", time.Minute); held {
+				t.Error("expected only the holder to release the lock")
+			}
+			if err := b.Unlock(ctx, "cron", "a"); err != nil {
+				t.Fatalf("unlock: %v", err)
+			}
+			if held, _ := b.TryLock(ctx, "cron", "b", time.Minute); !held {
+				t.Error("expected b to take the released lock")
+			}
+
+			last, err := b.LastRun(ctx, "purge")
+			if err != nil || !last.IsZero() {
+				t.Fatalf("expected no last run; got %v, %v", last, err)
+			}
+			first := time.Date(2026, 3, 6, 9, 0, 0, 0, time.UTC)
+			if ok, err := b.AdvanceRun(ctx, "purge", time.Time{}, first); err != nil || !ok {
+				t.Fatalf("expected the first run recorded; got %v, %v", ok, err)
+			}
+			if ok, _ := b.AdvanceRun(ctx, "purge", time.Time{}, first.Add(time.Hour)); ok {
+				t.Error("expected a stale advance to fail")
+			}
+			if ok, _ := b.AdvanceRun(ctx, "purge", first, first.Add(time.Hour)); !ok {
+				t.Error("expected the advance from the last run to succeed")
+			}
+			if last, _ := b.LastRun(ctx, "purge"); !last.Equal(first.Add(time.Hour)) {
+				t.Errorf("expected the last run at 10:00; got %v", last)
+			}
+		})
+	}
+}
+
+func TestBackendWorkers(t *testing.T) {
+	ctx := context.Background()
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			workers := []WorkerInfo{
+				{ID: "w1", JobTypes: []JobType{JobTypeStoreRaw}, ReportedAt: now},
+				{ID: "w2", JobTypes: []JobType{JobTypeEnrichTrace}, ReportedAt: now},
+			}
+			if err := b.RegisterWorkers(ctx, workers); err != nil {
+				t.Fatalf("register: %v", err)
+			}
+			if err := b.DeregisterWorkers(ctx, []string{"w2"}); err != nil {
+				t.Fatalf("deregister: %v", err)
+			}
+
+			got, err := b.Workers(ctx)
+			if err != nil {
+				t.Fatalf("workers: %v", err)
+			}
+			if len(got) != 1 || got[0].ID != "w1" {
+				t.Errorf("expected only w1 registered; got %v", got)
+			}
+		})
+	}
+}
+
+func TestBackendJobStatuses(t *testing.T) {
+	for name, b := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := logging.WithProjectID(context.Background(), "p1")
+			c := NewClient(b)
+
+			expect := func(want JobState, states ...JobState) *IngestionStatus {
+				t.Helper()
+				status, err := c.IngestionStatus(ctx, "p1", "s1")
+				if err != nil {
+					t.Fatalf("ingestion status: %v", err)
+				}
+				if status == nil || len(status.Jobs) != len(states) {
+					t.Fatalf("expected %d jobs; got %+v", len(states), status)
+				}
+				got := make([]JobState, len(status.Jobs))
+				for i, job := range status.Jobs {
+					got[i] = job.State
+				}
+				if status.Status != want || !slices.Equal(got, states) {
+					t.Fatalf("expected %s with jobs %v; got %s with %v", want, states, status.Status, got)
+				}
+				return status
+			}
+
+			if err := c.EnqueueSpan(ctx, database.SpanRequest{ID: "s1", TraceID: "t1"}); err != nil {
+				t.Fatalf("enqueue: %v", err)
+			}
+			expect(JobStatePending, JobStatePending, JobStatePending)
+
+			store, err := c.Dequeue(ctx, []JobType{JobTypeStoreRaw}, 100*time.Millisecond)
+			if err != nil || store == nil {
+				t.Fatalf("dequeue: %v, %v", store, err)
+			}
+			expect(JobStatePending, JobStateProcessing, JobStatePending)
+			if err := c.CompleteJob(ctx, store, &JobResult{Success: true, ProcessedAt: time.Now().UTC()}); err != nil {
+				t.Fatalf("complete: %v", err)
+			}
+
+			export, err := c.Dequeue(ctx, []JobType{JobTypeAnalyticsExport}, 100*time.Millisecond)
+			if err != nil || export == nil {
+				t.Fatalf("dequeue: %v, %v", export, err)
+			}
+			if err := c.FailJob(ctx, export, "clickhouse is down"); err != nil {
+				t.Fatalf("fail: %v", err)
+			}
+			status := expect(JobStateDelayed, JobStateCompleted, JobStateDelayed)
+			if status.Jobs[1].Error != "clickhouse is down" || status.Jobs[1].Attempts != 1 {
+				t.Errorf("expected the failed attempt reported; got %+v", status.Jobs[1])
+			}
+
+			if _, err := b.ReleaseDelayed(ctx, time.Now().Add(time.Minute)); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+			expect(JobStatePending, JobStateCompleted, JobStatePending)
+
+			export, err = c.Dequeue(ctx, []JobType{JobTypeAnalyticsExport}, 100*time.Millisecond)
+			if err != nil || export == nil {
+				t.Fatalf("dequeue: %v, %v", export, err)
+			}
+			export.Attempts = export.MaxAttempts
+			if err := c.FailJob(ctx, export, "clickhouse is still down"); err != nil {
+				t.Fatalf("fail: %v", err)
+			}
+			expect(JobStateDead, JobStateCompleted, JobStateDead)
+
+			if status, err := c.IngestionStatus(ctx, "p2", "s1"); err != nil || status != nil {
+				t.Errorf("expected another project's lookup to find nothing; got %+v, %v", status, err)
+			}
+		})
+	}
+}
@@ -3,106 +3,155 @@ package queue
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
+
+	"langlite-ingestion/internal/logging"
 )
 
+// Client enqueues jobs and hands them to workers, storing them in a Backend.
 type Client struct {
-	redis *redis.Client
+	backend Backend
 }
 
-func NewClient(redisClient *redis.Client) *Client {
+func NewClient(backend Backend) *Client {
 	return &Client{
-		redis: redisClient,
+		backend: backend,
 	}
 }
 
-func (c *Client) Enqueue(ctx context.Context, jobType JobType, priority QueuePriority, payload map[string]interface{}) (*Job, error) {
-	job := &Job{
-		ID:          uuid.New().String(),
-		Type:        jobType,
-		Priority:    priority,
-		Payload:     payload,
-		CreatedAt:   time.Now().UTC(),
-		Attempts:    0,
-		MaxAttempts: 3, // Default max attempts
+// Enqueue adds a job carrying payload, of the job type payload belongs to,
+// to the back of its priority's queue.
+func (c *Client) Enqueue(ctx context.Context, priority QueuePriority, payload Payload) (*Job, error) {
+	job, err := newJob(ctx, priority, payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.push(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// EnqueueAt enqueues a job that becomes ready at at. Workers move delayed
+// jobs onto their queue every 30 seconds, so the job may start up to that
+// late. A time that has passed enqueues the job at once.
+func (c *Client) EnqueueAt(ctx context.Context, priority QueuePriority, payload Payload, at time.Time) (_ *Job, err error) {
+	if !at.After(time.Now()) {
+		return c.Enqueue(ctx, priority, payload)
 	}
 
-	jobJSON, err := job.ToJSON()
+	job, err := newJob(ctx, priority, payload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to serialize job: %w", err)
+		return nil, err
 	}
+	job.EnqueuedAt = at.UTC()
 
-	queueName := GetQueueName(jobType, priority)
+	ctx, span := startEnqueueSpan(ctx, job, GetQueueName(job.Type, priority))
+	defer func() { endSpan(span, err) }()
 
-	err = c.redis.LPush(ctx, queueName, jobJSON).Err()
-	if err != nil {
-		return nil, fmt.Errorf("failed to enqueue job: %w", err)
+	if err := c.backend.PushDelayed(ctx, job, at); err != nil {
+		return nil, err
 	}
+	return job, nil
+}
+
+// push adds a new job to the back of its queue.
+func (c *Client) push(ctx context.Context, job *Job) (err error) {
+	ctx, span := startEnqueueSpan(ctx, job, GetQueueName(job.Type, job.Priority))
+	defer func() { endSpan(span, err) }()
 
-	trackingKey := fmt.Sprintf("jobs:tracking:%s", job.ID)
-	err = c.redis.Set(ctx, trackingKey, jobJSON, 24*time.Hour).Err()
+	return c.backend.Push(ctx, job)
+}
+
+func newJob(ctx context.Context, priority QueuePriority, payload Payload) (*Job, error) {
+	data, version, err := encodePayload(payload)
 	if err != nil {
-		// log error brt don't fail the enqueue operation
-		fmt.Printf("Warning: failed to add job to tracking: %v\n", err)
+		return nil, err
 	}
 
+	now := time.Now().UTC()
+	job := &Job{
+		ID:             uuid.New().String(),
+		Type:           payload.JobType(),
+		Priority:       priority,
+		Payload:        data,
+		PayloadVersion: version,
+		CreatedAt:      now,
+		EnqueuedAt:     now,
+		Attempts:       0,
+		MaxAttempts:    3, // Default max attempts
+		RequestID:      logging.RequestID(ctx),
+		ProjectID:      logging.ProjectID(ctx),
+	}
+	if p, ok := payload.(projectPayload); ok && job.ProjectID == "" {
+		job.ProjectID = p.projectID()
+	}
+	if p, ok := payload.(entityPayload); ok {
+		job.EntityID = p.entityID()
+	}
+	if p, ok := payload.(attemptsPayload); ok {
+		job.MaxAttempts = p.maxAttempts()
+	}
 	return job, nil
 }
 
+// Dequeue takes the next job of jobTypes in strict priority order. Workers
+// go through a Scheduler instead, so lower priorities aren't starved.
 func (c *Client) Dequeue(ctx context.Context, jobTypes []JobType, timeout time.Duration) (*Job, error) {
 	// build queue names in priority order (high -> medium -> low)
 	var queueNames []string
-	priorities := []QueuePriority{QueueHigh, QueueMedium, QueueLow}
-
-	for _, priority := range priorities {
+	for _, priority := range queuePriorities {
 		for _, jobType := range jobTypes {
 			queueNames = append(queueNames, GetQueueName(jobType, priority))
 		}
 	}
 
-	// use BRPOP to block until a job is available
-	result, err := c.redis.BRPop(ctx, timeout, queueNames...).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return nil, nil // No job available within timeout
-		}
-		return nil, fmt.Errorf("failed to dequeue job: %w", err)
-	}
+	return c.dequeue(ctx, queueNames, timeout)
+}
 
-	// result[0] is the queue name, result[1] is the job JSON
-	jobJSON := result[1]
-	job, err := FromJSON(jobJSON)
-	if err != nil {
-		return nil, fmt.Errorf("failed to deserialize job: %w", err)
+// dequeue takes a job from the first of queueNames that has one, waiting up
+// to timeout for one to arrive.
+func (c *Client) dequeue(ctx context.Context, queueNames []string, timeout time.Duration) (*Job, error) {
+	job, err := c.backend.Pop(ctx, queueNames, timeout)
+	if err != nil || job == nil {
+		return nil, err
 	}
 
 	job.Attempts++
-
 	return job, nil
 }
 
-func (c *Client) CompleteJob(ctx context.Context, job *Job, result *JobResult) error {
-	job.ProcessedAt = &result.ProcessedAt
-
-	trackingKey := fmt.Sprintf("jobs:tracking:%s", job.ID)
-	err := c.redis.Del(ctx, trackingKey).Err()
-	if err != nil {
-		fmt.Printf("Warning: failed to remove job from tracking: %v\n", err)
+// Requeue puts a job interrupted by shutdown back at the head of its queue,
+// so it's the next one picked up. The interrupted attempt isn't counted.
+func (c *Client) Requeue(ctx context.Context, job *Job) error {
+	if job.Attempts > 0 {
+		job.Attempts--
 	}
+	return c.backend.PushFront(ctx, job)
+}
 
-	completedKey := fmt.Sprintf("jobs:completed:%s", job.ID)
-	jobJSON, _ := job.ToJSON()
-	err = c.redis.Set(ctx, completedKey, jobJSON, time.Hour).Err()
-	if err != nil {
-		fmt.Printf("Warning: failed to add job to completed: %v\n", err)
+// DeferJob holds a job back for delay without counting the attempt, for
+// jobs this worker can't run yet but a newer one can.
+func (c *Client) DeferJob(ctx context.Context, job *Job, delay time.Duration) error {
+	if job.Attempts > 0 {
+		job.Attempts--
 	}
+	job.EnqueuedAt = time.Now().UTC().Add(delay)
 
+	if err := c.backend.PushDelayed(ctx, job, job.EnqueuedAt); err != nil {
+		return fmt.Errorf("failed to defer job: %w", err)
+	}
 	return nil
 }
 
+func (c *Client) CompleteJob(ctx context.Context, job *Job, result *JobResult) error {
+	job.ProcessedAt = &result.ProcessedAt
+	return c.backend.Complete(ctx, job)
+}
+
 func (c *Client) FailJob(ctx context.Context, job *Job, errorMsg string) error {
 	job.Error = errorMsg
 
@@ -110,103 +159,110 @@ func (c *Client) FailJob(ctx context.Context, job *Job, errorMsg string) error {
 		return c.retryJob(ctx, job)
 	}
 
-	return c.moveToDeadLetter(ctx, job)
+	return c.backend.Bury(ctx, job)
 }
 
 func (c *Client) retryJob(ctx context.Context, job *Job) error {
 	delay := time.Duration(job.Attempts*job.Attempts) * time.Second
+	job.EnqueuedAt = time.Now().UTC().Add(delay)
 
-	jobJSON, err := job.ToJSON()
-	if err != nil {
-		return fmt.Errorf("failed to serialize job for retry: %w", err)
-	}
-
-	delayedKey := "jobs:delayed"
-	score := float64(time.Now().Add(delay).Unix())
-
-	err = c.redis.ZAdd(ctx, delayedKey, redis.Z{
-		Score:  score,
-		Member: jobJSON,
-	}).Err()
-
-	if err != nil {
+	if err := c.backend.PushDelayed(ctx, job, job.EnqueuedAt); err != nil {
 		return fmt.Errorf("failed to schedule job retry: %w", err)
 	}
-
 	return nil
 }
 
-func (c *Client) moveToDeadLetter(ctx context.Context, job *Job) error {
-	jobJSON, err := job.ToJSON()
-	if err != nil {
-		return fmt.Errorf("failed to serialize job for dead letter: %w", err)
-	}
+func (c *Client) ProcessDelayedJobs(ctx context.Context) error {
+	_, err := c.backend.ReleaseDelayed(ctx, time.Now())
+	return err
+}
 
-	deadLetterKey := "jobs:dead_letter"
-	err = c.redis.LPush(ctx, deadLetterKey, jobJSON).Err()
-	if err != nil {
-		return fmt.Errorf("failed to move job to dead letter queue: %w", err)
-	}
+// PromoteAged moves up to promoteBatch of the oldest jobs of jobType that
+// have waited at least after in the from queue to the front of the to
+// queue, and returns how many it moved.
+func (c *Client) PromoteAged(ctx context.Context, jobType JobType, from, to QueuePriority, after time.Duration) (int, error) {
+	return c.backend.Promote(ctx, GetQueueName(jobType, from), GetQueueName(jobType, to), time.Now().Add(-after), promoteBatch)
+}
 
-	trackingKey := fmt.Sprintf("jobs:tracking:%s", job.ID)
-	c.redis.Del(ctx, trackingKey)
+// queuedJobTypes and queuePriorities name every queue the client reports on.
+var (
+	queuedJobTypes  = []JobType{JobTypeEnrichTrace, JobTypeStoreRaw, JobTypeAnalyticsExport, JobTypePurgeRetention, JobTypeEraseUserData, JobTypeRefreshRollups, JobTypeDeliverWebhook}
+	queuePriorities = []QueuePriority{QueueHigh, QueueMedium, QueueLow}
+)
 
-	return nil
+// queueNames names every priority queue.
+func queueNames() []string {
+	var names []string
+	for _, priority := range queuePriorities {
+		for _, jobType := range queuedJobTypes {
+			names = append(names, GetQueueName(jobType, priority))
+		}
+	}
+	return names
 }
 
-func (c *Client) ProcessDelayedJobs(ctx context.Context) error {
-	delayedKey := "jobs:delayed"
-	now := float64(time.Now().Unix())
-
-	jobs, err := c.redis.ZRangeByScore(ctx, delayedKey, &redis.ZRangeBy{
-		Min: "0",
-		Max: fmt.Sprintf("%f", now),
-	}).Result()
+func (c *Client) GetQueueStats(ctx context.Context) (map[string]int64, error) {
+	return c.backend.Stats(ctx, queueNames())
+}
 
+// Backlog is the number of jobs waiting in the priority queues, excluding
+// delayed retries and dead letters.
+func (c *Client) Backlog(ctx context.Context) (int64, error) {
+	names := queueNames()
+	stats, err := c.backend.Stats(ctx, names)
 	if err != nil {
-		return fmt.Errorf("failed to get delayed jobs: %w", err)
+		return 0, err
 	}
 
-	for _, jobJSON := range jobs {
-		job, err := FromJSON(jobJSON)
-		if err != nil {
-			continue
-		}
+	var total int64
+	for _, name := range names {
+		total += stats[name]
+	}
+	return total, nil
+}
 
-		queueName := GetQueueName(job.Type, job.Priority)
-		err = c.redis.LPush(ctx, queueName, jobJSON).Err()
-		if err != nil {
-			continue
-		}
+// PendingByProject returns how many items each project has waiting to be
+// stored.
+func (c *Client) PendingByProject(ctx context.Context) (map[string]int64, error) {
+	return c.backend.PendingByProject(ctx)
+}
 
-		c.redis.ZRem(ctx, delayedKey, jobJSON)
-	}
+// pendingReconciler is implemented by backends that keep a running count of
+// each project's waiting items, which can drift from the queues.
+type pendingReconciler interface {
+	ReconcilePending(ctx context.Context) error
+}
 
+// ReconcilePending recounts each project's waiting items from the queues,
+// for backends that keep a running count.
+func (c *Client) ReconcilePending(ctx context.Context) error {
+	if r, ok := c.backend.(pendingReconciler); ok {
+		return r.ReconcilePending(ctx)
+	}
 	return nil
 }
 
-func (c *Client) GetQueueStats(ctx context.Context) (map[string]int64, error) {
-	stats := make(map[string]int64)
+// RegisterWorkers records workers in the registry, refreshing their expiry.
+func (c *Client) RegisterWorkers(ctx context.Context, workers []WorkerInfo) error {
+	return c.backend.RegisterWorkers(ctx, workers)
+}
 
-	jobTypes := []JobType{JobTypeEnrichTrace, JobTypeStoreRaw, JobTypeAnalyticsExport}
-	priorities := []QueuePriority{QueueHigh, QueueMedium, QueueLow}
+// DeregisterWorkers removes stopped workers from the registry.
+func (c *Client) DeregisterWorkers(ctx context.Context, ids []string) error {
+	return c.backend.DeregisterWorkers(ctx, ids)
+}
 
-	for _, priority := range priorities {
-		for _, jobType := range jobTypes {
-			queueName := GetQueueName(jobType, priority)
-			length, err := c.redis.LLen(ctx, queueName).Result()
-			if err != nil {
-				continue
-			}
-			stats[queueName] = length
-		}
+// Workers lists every registered worker in the cluster, sorted by ID, and
+// prunes the ones whose pool stopped reporting.
+func (c *Client) Workers(ctx context.Context) ([]WorkerInfo, error) {
+	workers, err := c.backend.Workers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if workers == nil {
+		workers = []WorkerInfo{}
 	}
 
-	deadLetterLength, _ := c.redis.LLen(ctx, "jobs:dead_letter").Result()
-	stats["dead_letter"] = deadLetterLength
-
-	delayedLength, _ := c.redis.ZCard(ctx, "jobs:delayed").Result()
-	stats["delayed"] = delayedLength
-
-	return stats, nil
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
+	return workers, nil
 }
@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// adaptInterval is how often the pool revisits its concurrency limit.
+const adaptInterval = 5 * time.Second
+
+// LatencySource reports recent database latency. *database.LatencyTracker
+// implements it.
+type LatencySource interface {
+	Latency() time.Duration
+}
+
+// limiter caps how many of a pool's workers poll for and run jobs at once.
+// The rest wait until a slot frees up or the limit rises. A nil *limiter
+// doesn't limit.
+type limiter struct {
+	mu     sync.Mutex
+	limit  int
+	active int
+	// changed is closed and replaced whenever a slot may have opened.
+	changed chan struct{}
+}
+
+func newLimiter(limit int) *limiter {
+	return &limiter{limit: limit, changed: make(chan struct{})}
+}
+
+// acquire takes a slot, or reports false if ctx is done first.
+func (l *limiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+
+	for {
+		l.mu.Lock()
+		if l.active < l.limit {
+			l.active++
+			l.mu.Unlock()
+			return true
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (l *limiter) release() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.notify()
+}
+
+func (l *limiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.notify()
+}
+
+func (l *limiter) currentLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+func (l *limiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// AdaptConcurrency makes the pool run fewer jobs at once while database
+// latency from source is above target, and more again once it recovers:
+// the limit drops by a quarter each interval latency is too high and grows
+// by one each interval it isn't, between one worker and all of them. It
+// applies across job types. Call it before Start.
+func (wp *WorkerPool) AdaptConcurrency(source LatencySource, target time.Duration) {
+	wp.limiter = newLimiter(len(wp.workers))
+	wp.latency = source
+	wp.latencyTarget = target
+	for _, worker := range wp.workers {
+		worker.limiter = wp.limiter
+	}
+	if wp.metrics != nil {
+		wp.metrics.UpdateWorkerConcurrencyLimit(len(wp.workers))
+	}
+}
+
+func (wp *WorkerPool) adaptLoop(ctx context.Context) {
+	ticker := time.NewTicker(adaptInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			wp.adapt()
+		}
+	}
+}
+
+func (wp *WorkerPool) adapt() {
+	latency := wp.latency.Latency()
+	limit := wp.limiter.currentLimit()
+
+	next := limit
+	if latency > wp.latencyTarget {
+		next = max(1, limit*3/4)
+	} else if limit < len(wp.workers) {
+		next = limit + 1
+	}
+	if next == limit {
+		return
+	}
+
+	wp.limiter.setLimit(next)
+	if wp.metrics != nil {
+		wp.metrics.UpdateWorkerConcurrencyLimit(next)
+	}
+	slog.Info("Adjusted worker concurrency", "instance", wp.instance, "limit", next, "workers", len(wp.workers), "db_latency", latency, "target", wp.latencyTarget)
+}
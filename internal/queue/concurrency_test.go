@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1)
+	if !l.acquire(context.Background()) {
+		t.Fatal("expected a free slot")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if l.acquire(ctx) {
+		t.Fatal("expected the second acquire to wait for the context")
+	}
+
+	acquired := make(chan bool)
+	go func() { acquired <- l.acquire(context.Background()) }()
+	l.setLimit(2)
+	if !<-acquired {
+		t.Error("expected raising the limit to free a slot")
+	}
+
+	var unlimited *limiter
+	if !unlimited.acquire(ctx) {
+		t.Error("expected a nil limiter not to limit")
+	}
+	unlimited.release()
+}
+
+type fixedLatency time.Duration
+
+func (l fixedLatency) Latency() time.Duration { return time.Duration(l) }
+
+func TestAdaptConcurrency(t *testing.T) {
+	latency := fixedLatency(time.Second)
+	pool := NewWorkerPool(nil, nil, nil, Concurrency{AnyJobType: 8})
+	pool.AdaptConcurrency(&latency, 100*time.Millisecond)
+
+	var limits []int
+	for range 5 {
+		pool.adapt()
+		limits = append(limits, pool.limiter.currentLimit())
+	}
+	latency = fixedLatency(10 * time.Millisecond)
+	for range 2 {
+		pool.adapt()
+		limits = append(limits, pool.limiter.currentLimit())
+	}
+
+	want := []int{6, 4, 3, 2, 1, 2, 3}
+	for i := range want {
+		if limits[i] != want[i] {
+			t.Fatalf("expected limits %v; got %v", want, limits)
+		}
+	}
+
+	for range 10 {
+		pool.adapt()
+	}
+	if limit := pool.limiter.currentLimit(); limit != 8 {
+		t.Errorf("expected the limit to recover to every worker; got %d", limit)
+	}
+}
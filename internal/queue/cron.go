@@ -0,0 +1,193 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spec says when a schedule runs next.
+type Spec interface {
+	// Next returns the first run strictly after t.
+	Next(t time.Time) time.Time
+}
+
+// ParseSpec parses a standard five-field cron expression (minute, hour, day
+// of month, month, day of week) evaluated in UTC, e.g. "*/15 * * * *" or
+// "30 3 * * 1-5". Fields take *, lists, ranges and steps. It also accepts
+// @hourly, @daily (or @midnight), @weekly, @monthly, @yearly (or
+// @annually) and "@every <duration>", e.g. "@every 90s".
+func ParseSpec(s string) (Spec, error) {
+	s = strings.TrimSpace(s)
+
+	if rest, ok := strings.CutPrefix(s, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("invalid schedule %q: @every needs a duration of at least 1s", s)
+		}
+		return everySpec(every), nil
+	}
+
+	switch s {
+	case "@yearly", "@annually":
+		s = "0 0 1 1 *"
+	case "@monthly":
+		s = "0 0 1 * *"
+	case "@weekly":
+		s = "0 0 * * 0"
+	case "@daily", "@midnight":
+		s = "0 0 * * *"
+	case "@hourly":
+		s = "0 * * * *"
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", s, len(fields))
+	}
+
+	var spec cronSpec
+	bounds := []struct {
+		set      *uint64
+		min, max int
+	}{
+		{&spec.minute, 0, 59},
+		{&spec.hour, 0, 23},
+		{&spec.dom, 1, 31},
+		{&spec.month, 1, 12},
+		{&spec.dow, 0, 7},
+	}
+	for i, field := range fields {
+		set, err := parseField(field, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: field %q: %w", s, field, err)
+		}
+		*bounds[i].set = set
+	}
+
+	// Sunday is both 0 and 7.
+	if spec.dow&(1<<7) != 0 {
+		spec.dow |= 1
+	}
+	// As in cron, a restricted day of month or day of week matches either.
+	spec.anyDOM = fields[2] == "*"
+	spec.anyDOW = fields[4] == "*"
+
+	return spec, nil
+}
+
+// parseField parses one cron field into a bit set of the values it matches.
+func parseField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, errors.New("step must be a positive integer")
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			from, to, _ := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = parseValue(from, min, max); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(to, min, max); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("range %s is backwards", rangePart)
+			}
+		default:
+			n, err := parseValue(rangePart, min, max)
+			if err != nil {
+				return 0, err
+			}
+			lo = n
+			if !hasStep {
+				hi = n
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func parseValue(s string, min, max int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%q is not a number between %d and %d", s, min, max)
+	}
+	return n, nil
+}
+
+// cronSpec holds a bit per value each field matches.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	anyDOM, anyDOW                bool
+}
+
+// cronSearchLimit bounds how far ahead Next looks for expressions that
+// never match, such as "0 0 31 2 *".
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+func (s cronSpec) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+
+	for t.Before(limit) {
+		if s.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hour&(1<<t.Hour()) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s cronSpec) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.anyDOM && s.anyDOW:
+		return true
+	case s.anyDOM:
+		return dow
+	case s.anyDOW:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// everySpec runs at a fixed interval after the previous run.
+type everySpec time.Duration
+
+func (s everySpec) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSpec(t *testing.T) {
+	from := time.Date(2026, 3, 6, 10, 7, 30, 0, time.UTC) // a Friday
+
+	for _, tc := range []struct {
+		spec string
+		want []time.Time
+	}{
+		{"*/15 * * * *", []time.Time{
+			time.Date(2026, 3, 6, 10, 15, 0, 0, time.UTC),
+			time.Date(2026, 3, 6, 10, 30, 0, 0, time.UTC),
+		}},
+		{"@hourly", []time.Time{
+			time.Date(2026, 3, 6, 11, 0, 0, 0, time.UTC),
+			time.Date(2026, 3, 6, 12, 0, 0, 0, time.UTC),
+		}},
+		{"30 3 * * 1-5", []time.Time{
+			time.Date(2026, 3, 9, 3, 30, 0, 0, time.UTC),
+			time.Date(2026, 3, 10, 3, 30, 0, 0, time.UTC),
+		}},
+		{"0 0 1,15 * 7", []time.Time{
+			time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 3, 22, 0, 0, 0, 0, time.UTC),
+		}},
+		{"0 12 29 2 *", []time.Time{
+			time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC),
+		}},
+		{"@every 90s", []time.Time{
+			from.Add(90 * time.Second),
+			from.Add(180 * time.Second),
+		}},
+	} {
+		spec, err := ParseSpec(tc.spec)
+		if err != nil {
+			t.Errorf("parse %q: %v", tc.spec, err)
+			continue
+		}
+
+		next := from
+		for _, want := range tc.want {
+			next = spec.Next(next)
+			if !next.Equal(want) {
+				t.Errorf("%q: expected %s; got %s", tc.spec, want, next)
+				break
+			}
+		}
+	}
+
+	never, err := ParseSpec("0 0 31 2 *")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if next := never.Next(from); !next.IsZero() {
+		t.Errorf("expected February 31st never to come; got %s", next)
+	}
+
+	for _, invalid := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@every 10ms", "@fortnightly"} {
+		if _, err := ParseSpec(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryBackend keeps the queues in process, for tests and single-node
+// development. Jobs are lost when the process exits, and only workers in
+// the same process see them.
+type MemoryBackend struct {
+	mu sync.Mutex
+	// queues hold job JSON with the front of each queue first, so jobs
+	// are copied in and out like they would be over the wire.
+	queues      map[string][]string
+	delayed     []delayedJob
+	deadLetters []string
+	// tracked holds the status of jobs that haven't completed and
+	// completed the completed ones. entityJobs indexes both by
+	// "project:entity".
+	tracked    map[string]JobStatus
+	completed  map[string]memoryCompleted
+	entityJobs map[string][]string
+	pending    map[string]int64
+	workers    map[string]WorkerInfo
+	locks      map[string]memoryLock
+	lastRuns   map[string]time.Time
+	// changed is closed and replaced whenever a job is pushed.
+	changed chan struct{}
+}
+
+type delayedJob struct {
+	at      time.Time
+	jobJSON string
+}
+
+type memoryCompleted struct {
+	jobJSON   string
+	expiresAt time.Time
+}
+
+type memoryLock struct {
+	token     string
+	expiresAt time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		queues:     make(map[string][]string),
+		tracked:    make(map[string]JobStatus),
+		completed:  make(map[string]memoryCompleted),
+		entityJobs: make(map[string][]string),
+		pending:    make(map[string]int64),
+		workers:    make(map[string]WorkerInfo),
+		locks:      make(map[string]memoryLock),
+		lastRuns:   make(map[string]time.Time),
+		changed:    make(chan struct{}),
+	}
+}
+
+// notify wakes every Pop waiting for a job. Callers hold mu.
+func (b *MemoryBackend) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *MemoryBackend) Push(ctx context.Context, job *Job) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queueName := GetQueueName(job.Type, job.Priority)
+	b.queues[queueName] = append(b.queues[queueName], jobJSON)
+	b.track(job, JobStatePending)
+	b.addPending(job, 1)
+	b.notify()
+	return nil
+}
+
+func (b *MemoryBackend) PushFront(ctx context.Context, job *Job) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queueName := GetQueueName(job.Type, job.Priority)
+	b.queues[queueName] = append([]string{jobJSON}, b.queues[queueName]...)
+	b.tracked[job.ID] = newJobStatus(job, JobStatePending)
+	b.addPending(job, 1)
+	b.notify()
+	return nil
+}
+
+func (b *MemoryBackend) PushDelayed(ctx context.Context, job *Job, at time.Time) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.delayed = append(b.delayed, delayedJob{at: at, jobJSON: jobJSON})
+	if job.Attempts == 0 {
+		b.track(job, JobStateDelayed)
+	} else {
+		b.tracked[job.ID] = newJobStatus(job, JobStateDelayed)
+	}
+	return nil
+}
+
+// track starts tracking a new job and indexes it under its entity. Callers
+// hold mu.
+func (b *MemoryBackend) track(job *Job, state JobState) {
+	b.tracked[job.ID] = newJobStatus(job, state)
+	if job.EntityID != "" {
+		key := job.ProjectID + ":" + job.EntityID
+		b.entityJobs[key] = append(b.entityJobs[key], job.ID)
+	}
+}
+
+// addPending adjusts the job's project's count of waiting items. Callers
+// hold mu.
+func (b *MemoryBackend) addPending(job *Job, delta int64) {
+	if !countsAsPending(job) {
+		return
+	}
+	b.pending[job.ProjectID] += delta
+	if b.pending[job.ProjectID] <= 0 {
+		delete(b.pending, job.ProjectID)
+	}
+}
+
+func (b *MemoryBackend) Pop(ctx context.Context, queueNames []string, timeout time.Duration) (*Job, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		job, changed, err := b.pop(queueNames)
+		if job != nil || err != nil {
+			return job, err
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// pop takes the first job waiting in queueNames, or returns a channel that
+// is closed when one may have arrived.
+func (b *MemoryBackend) pop(queueNames []string) (*Job, <-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, queueName := range queueNames {
+		queue := b.queues[queueName]
+		if len(queue) == 0 {
+			continue
+		}
+
+		b.queues[queueName] = queue[1:]
+		job, err := FromJSON(queue[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to deserialize job: %w", err)
+		}
+		b.addPending(job, -1)
+		b.tracked[job.ID] = newJobStatus(job, JobStateProcessing)
+		return job, nil, nil
+	}
+	return nil, b.changed, nil
+}
+
+func (b *MemoryBackend) Complete(ctx context.Context, job *Job) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.tracked, job.ID)
+	b.completed[job.ID] = memoryCompleted{jobJSON: jobJSON, expiresAt: time.Now().Add(time.Hour)}
+	return nil
+}
+
+func (b *MemoryBackend) Bury(ctx context.Context, job *Job) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job for dead letter: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.deadLetters = append(b.deadLetters, jobJSON)
+	b.tracked[job.ID] = newJobStatus(job, JobStateDead)
+	return nil
+}
+
+// ReleaseDelayed also forgets completed jobs that have expired.
+func (b *MemoryBackend) ReleaseDelayed(ctx context.Context, now time.Time) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for id, completed := range b.completed {
+		if now.After(completed.expiresAt) {
+			delete(b.completed, id)
+		}
+	}
+
+	var waiting []delayedJob
+	released := 0
+	for _, delayed := range b.delayed {
+		if delayed.at.After(now) {
+			waiting = append(waiting, delayed)
+			continue
+		}
+
+		job, err := FromJSON(delayed.jobJSON)
+		if err != nil {
+			continue
+		}
+		queueName := GetQueueName(job.Type, job.Priority)
+		b.queues[queueName] = append(b.queues[queueName], delayed.jobJSON)
+		b.addPending(job, 1)
+		b.tracked[job.ID] = newJobStatus(job, JobStatePending)
+		released++
+	}
+	b.delayed = waiting
+
+	if released > 0 {
+		b.notify()
+	}
+	return released, nil
+}
+
+func (b *MemoryBackend) Promote(ctx context.Context, from, to string, cutoff time.Time, limit int) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queue := b.queues[from]
+	promoted := 0
+	for promoted < len(queue) && promoted < limit {
+		job, err := FromJSON(queue[promoted])
+		if err != nil || !job.ReadyAt().Before(cutoff) {
+			break
+		}
+		promoted++
+	}
+	if promoted == 0 {
+		return 0, nil
+	}
+
+	// Like RedisBackend, which moves jobs one at a time to the front,
+	// leave the last one moved first.
+	moved := make([]string, promoted)
+	for i := range moved {
+		moved[i] = queue[promoted-1-i]
+	}
+	b.queues[from] = queue[promoted:]
+	b.queues[to] = append(moved, b.queues[to]...)
+	b.notify()
+	return promoted, nil
+}
+
+func (b *MemoryBackend) Stats(ctx context.Context, queueNames []string) (map[string]int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := make(map[string]int64, len(queueNames)+2)
+	for _, queueName := range queueNames {
+		stats[queueName] = int64(len(b.queues[queueName]))
+	}
+	stats["dead_letter"] = int64(len(b.deadLetters))
+	stats["delayed"] = int64(len(b.delayed))
+	return stats, nil
+}
+
+func (b *MemoryBackend) PendingByProject(ctx context.Context) (map[string]int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pending := make(map[string]int64, len(b.pending))
+	for projectID, n := range b.pending {
+		pending[projectID] = n
+	}
+	return pending, nil
+}
+
+func (b *MemoryBackend) JobStatuses(ctx context.Context, projectID, entityID string) ([]JobStatus, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var statuses []JobStatus
+	for _, id := range b.entityJobs[projectID+":"+entityID] {
+		if completed, ok := b.completed[id]; ok {
+			job, err := FromJSON(completed.jobJSON)
+			if err != nil {
+				continue
+			}
+			statuses = append(statuses, completedJobStatus(job))
+		} else if status, ok := b.tracked[id]; ok {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+func (b *MemoryBackend) RegisterWorkers(ctx context.Context, workers []WorkerInfo) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, worker := range workers {
+		b.workers[worker.ID] = worker
+	}
+	return nil
+}
+
+func (b *MemoryBackend) DeregisterWorkers(ctx context.Context, ids []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, id := range ids {
+		delete(b.workers, id)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Workers(ctx context.Context) ([]WorkerInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cutoff := time.Now().Add(-workerRegistryTTL)
+	workers := make([]WorkerInfo, 0, len(b.workers))
+	for id, worker := range b.workers {
+		if worker.ReportedAt.Before(cutoff) {
+			delete(b.workers, id)
+			continue
+		}
+		workers = append(workers, worker)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
+	return workers, nil
+}
+
+func (b *MemoryBackend) TryLock(ctx context.Context, name, token string, ttl time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	lock, held := b.locks[name]
+	if held && lock.token != token && now.Before(lock.expiresAt) {
+		return false, nil
+	}
+	b.locks[name] = memoryLock{token: token, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (b *MemoryBackend) Unlock(ctx context.Context, name, token string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.locks[name].token == token {
+		delete(b.locks, name)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) LastRun(ctx context.Context, schedule string) (time.Time, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastRuns[schedule], nil
+}
+
+func (b *MemoryBackend) AdvanceRun(ctx context.Context, schedule string, from, to time.Time) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.lastRuns[schedule].Equal(from) {
+		return false, nil
+	}
+	b.lastRuns[schedule] = to
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Recorder receives the queue's job, worker and Redis measurements.
+// *metrics.Metrics implements it.
+type Recorder interface {
+	RecordQueueJob(ctx context.Context, queueName, priority, jobType, status string, duration time.Duration)
+	RecordQueueJobFailure(queueName, priority, jobType, errorType string)
+	RecordQueueWait(ctx context.Context, queueName, priority, jobType string, wait time.Duration)
+	RecordQueuePromotions(priority, jobType string, n int)
+	RecordScheduledRun(schedule string)
+	RecordWorkerJob(workerID, jobType, status string)
+	UpdateWorkerJobsActive(workerID string, delta float64)
+	UpdateWorkerStatus(workerID string, running bool)
+	UpdateWorkerConcurrencyLimit(limit int)
+	RecordRedisOperation(ctx context.Context, operation, status string, duration time.Duration)
+	RecordRetentionPurge(entity string, rows int64)
+}
+
+// Failure types recorded for jobs that don't complete.
+const (
+	failureUnknownJobType = "unknown_job_type"
+	failureProcessorError = "processor_error"
+	failureJobFailed      = "job_failed"
+	failureRequeued       = "requeued"
+	failureDeferred       = "deferred"
+)
+
+// recordJob reports a processed job. An empty failureType means it completed.
+func (w *Worker) recordJob(ctx context.Context, job *Job, start time.Time, failureType string) {
+	if w.metrics == nil {
+		return
+	}
+
+	queueName := GetQueueName(job.Type, job.Priority)
+	priority, jobType := string(job.Priority), string(job.Type)
+
+	status := "completed"
+	if failureType != "" {
+		status = "failed"
+		w.metrics.RecordQueueJobFailure(queueName, priority, jobType, failureType)
+	}
+
+	w.metrics.RecordQueueJob(ctx, queueName, priority, jobType, status, time.Since(start))
+	w.metrics.RecordWorkerJob(w.id, jobType, status)
+}
+
+// RedisHook measures every command sent through a Redis client. Pipelines
+// are recorded as a single "pipeline" operation.
+func RedisHook(recorder Recorder) redis.Hook {
+	return redisHook{recorder: recorder}
+}
+
+type redisHook struct {
+	recorder Recorder
+}
+
+func (h redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.recorder.RecordRedisOperation(ctx, cmd.Name(), redisStatus(err), time.Since(start))
+		return err
+	}
+}
+
+func (h redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.recorder.RecordRedisOperation(ctx, "pipeline", redisStatus(err), time.Since(start))
+		return err
+	}
+}
+
+// redisStatus treats a missing key or an empty BRPOP as a successful call.
+func redisStatus(err error) string {
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "error"
+	}
+	return "success"
+}
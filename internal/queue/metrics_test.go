@@ -0,0 +1,116 @@
+package queue
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"langlite-ingestion/internal/metrics"
+)
+
+type stubProcessor struct {
+	result *JobResult
+}
+
+func (p stubProcessor) Process(ctx context.Context, job *Job) (*JobResult, error) {
+	return p.result, nil
+}
+
+func (p stubProcessor) CanProcess(jobType JobType) bool {
+	return true
+}
+
+func scrapeMetrics(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("scrape /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read /metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestWorkerRecordsMetrics(t *testing.T) {
+	m := metrics.NewMetrics()
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	rdb.AddHook(RedisHook(m))
+
+	client := NewClient(NewRedisBackend(rdb))
+	worker := NewWorker("worker-test", client, nil, m)
+	worker.processors[JobTypeStoreRaw] = stubProcessor{result: &JobResult{Success: true}}
+	worker.processors[JobTypeAnalyticsExport] = stubProcessor{result: &JobResult{Success: false, Error: "boom"}}
+
+	ctx := context.Background()
+	if _, err := client.Enqueue(ctx, QueueHigh, StoreRawPayload{}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if _, err := client.Enqueue(ctx, QueueLow, AnalyticsExportPayload{}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	worker.processNextJob(ctx)
+	worker.processNextJob(ctx)
+
+	body := scrapeMetrics(t, m)
+	for _, want := range []string{
+		`queue_jobs_total{job_type="store_raw",priority="high",queue_name="high:store_raw",status="completed"} 1`,
+		`queue_jobs_total{job_type="analytics_export",priority="low",queue_name="low:analytics_export",status="failed"} 1`,
+		`queue_jobs_failures_total{error_type="job_failed",job_type="analytics_export",priority="low",queue_name="low:analytics_export"} 1`,
+		`queue_job_duration_seconds_count{job_type="store_raw",priority="high",queue_name="high:store_raw"} 1`,
+		`queue_job_wait_seconds_count{job_type="analytics_export",priority="low",queue_name="low:analytics_export"} 1`,
+		`worker_jobs_processed_total{job_type="store_raw",status="completed",worker_id="worker-test"} 1`,
+		`worker_jobs_active{worker_id="worker-test"} 0`,
+		// Pushes run a script: the first loads it, the second reuses it.
+		`redis_operations_total{operation="eval",status="success"} 1`,
+		`redis_operations_total{operation="evalsha",status="success"} 1`,
+		`redis_operations_total{operation="brpop",status="success"} 2`,
+		`redis_operation_duration_seconds_count{operation="brpop"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected /metrics to contain %s", want)
+		}
+	}
+}
+
+func TestRedisHookCountsErrors(t *testing.T) {
+	rec := &redisRecorder{}
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	rdb.AddHook(RedisHook(rec))
+
+	ctx := context.Background()
+	rdb.Get(ctx, "missing")
+	rdb.Set(ctx, "k", "v", time.Minute)
+	rdb.Incr(ctx, "k")
+
+	// The connection handshake is measured too, so only look at the
+	// commands sent above.
+	want := []string{"get success", "set success", "incr error"}
+	if len(rec.ops) < len(want) || !slices.Equal(rec.ops[len(rec.ops)-len(want):], want) {
+		t.Errorf("expected %v last; got %v", want, rec.ops)
+	}
+}
+
+type redisRecorder struct {
+	Recorder
+	ops []string
+}
+
+func (r *redisRecorder) RecordRedisOperation(ctx context.Context, operation, status string, duration time.Duration) {
+	r.ops = append(r.ops, operation+" "+status)
+}
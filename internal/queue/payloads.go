@@ -0,0 +1,226 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/enrich"
+)
+
+// Payload is the typed body of a job. Each job type has one payload type,
+// registered in payloadCodecs, which says what the job's type is.
+//
+// Payloads are stored as JSON alongside the version of their schema, so
+// workers can still read jobs enqueued by an older or newer deploy. A change
+// that older workers can't read, such as renaming or retyping a field, bumps
+// the type's version; its decode then reads the old versions too, for the
+// jobs still queued when the change ships.
+type Payload interface {
+	JobType() JobType
+}
+
+// StoreRawPayload asks a worker to store one ingested item.
+type StoreRawPayload struct {
+	ProjectID string `json:"project_id,omitempty"`
+	// EntityID is the item's ID, and TraceID its trace's.
+	EntityID string `json:"entity_id,omitempty"`
+	TraceID  string `json:"trace_id,omitempty"`
+	// DataType is "trace", "span", "generation", "event" or "score", and says
+	// which database request RawData holds.
+	DataType string          `json:"data_type"`
+	RawData  json.RawMessage `json:"raw_data"`
+}
+
+func (StoreRawPayload) JobType() JobType { return JobTypeStoreRaw }
+
+// EnrichTracePayload asks a worker to enrich a stored trace.
+type EnrichTracePayload struct {
+	ProjectID string                 `json:"project_id,omitempty"`
+	TraceID   string                 `json:"trace_id,omitempty"`
+	TraceData *database.TraceRequest `json:"trace_data"`
+	// Client and ReceivedAt describe how the trace arrived. Jobs enqueued
+	// before they were added have neither; the job's creation time stands
+	// in for ReceivedAt.
+	Client     enrich.Client `json:"client,omitempty"`
+	ReceivedAt time.Time     `json:"received_at,omitempty"`
+}
+
+func (EnrichTracePayload) JobType() JobType { return JobTypeEnrichTrace }
+
+// AnalyticsExportPayload asks a worker to export an item to analytics.
+type AnalyticsExportPayload struct {
+	ProjectID  string          `json:"project_id,omitempty"`
+	EntityID   string          `json:"entity_id,omitempty"`
+	TraceID    string          `json:"trace_id,omitempty"`
+	ExportData json.RawMessage `json:"export_data"`
+	// ExportType names the destination; empty means "clickhouse".
+	ExportType string `json:"export_type,omitempty"`
+}
+
+func (AnalyticsExportPayload) JobType() JobType { return JobTypeAnalyticsExport }
+
+// PurgeRetentionPayload asks a worker to purge rows past every project's
+// retention policy.
+type PurgeRetentionPayload struct{}
+
+func (PurgeRetentionPayload) JobType() JobType { return JobTypePurgeRetention }
+
+// EraseUserDataPayload asks a worker to run an end user's erasure request.
+type EraseUserDataPayload struct {
+	RequestID string `json:"request_id"`
+}
+
+func (EraseUserDataPayload) JobType() JobType { return JobTypeEraseUserData }
+
+// RefreshRollupsPayload asks a worker to bring the usage rollups up to date.
+type RefreshRollupsPayload struct{}
+
+func (RefreshRollupsPayload) JobType() JobType { return JobTypeRefreshRollups }
+
+// DeliverWebhookPayload asks a worker to deliver an event to one webhook.
+type DeliverWebhookPayload struct {
+	ProjectID string `json:"project_id"`
+	WebhookID string `json:"webhook_id"`
+	EventID   string `json:"event_id"`
+	EventType string `json:"event_type"`
+	// Body is the event as delivered, signed afresh on every attempt.
+	Body json.RawMessage `json:"body"`
+}
+
+func (DeliverWebhookPayload) JobType() JobType { return JobTypeDeliverWebhook }
+
+// projectPayload is implemented by payloads that belong to a project, so
+// jobs enqueued outside a request still count towards its quota.
+type projectPayload interface {
+	projectID() string
+}
+
+func (p StoreRawPayload) projectID() string        { return p.ProjectID }
+func (p EnrichTracePayload) projectID() string     { return p.ProjectID }
+func (p AnalyticsExportPayload) projectID() string { return p.ProjectID }
+func (p DeliverWebhookPayload) projectID() string  { return p.ProjectID }
+
+// entityPayload is implemented by payloads that process an ingested item,
+// so the item's jobs can be looked up by its ID.
+type entityPayload interface {
+	entityID() string
+}
+
+func (p StoreRawPayload) entityID() string        { return p.EntityID }
+func (p EnrichTracePayload) entityID() string     { return p.TraceID }
+func (p AnalyticsExportPayload) entityID() string { return p.EntityID }
+
+// attemptsPayload is implemented by payloads whose jobs get more or fewer
+// attempts than the default.
+type attemptsPayload interface {
+	maxAttempts() int
+}
+
+// Webhook endpoints are often down for longer than a store is, so their
+// deliveries get a couple more attempts.
+func (DeliverWebhookPayload) maxAttempts() int { return 5 }
+
+// payloadCodec is a job type's entry in the payload registry.
+type payloadCodec struct {
+	// version is the schema version new payloads are written with.
+	version int
+	// decode reads a payload written with any version up to version.
+	decode func(version int, data []byte) (Payload, error)
+}
+
+// payloadCodecs maps each job type to its payload. Jobs enqueued before
+// payloads were versioned count as version 1.
+var payloadCodecs = map[JobType]payloadCodec{
+	JobTypeStoreRaw:        {version: 1, decode: decodeJSON[StoreRawPayload]},
+	JobTypeEnrichTrace:     {version: 1, decode: decodeJSON[EnrichTracePayload]},
+	JobTypeAnalyticsExport: {version: 1, decode: decodeJSON[AnalyticsExportPayload]},
+	JobTypePurgeRetention:  {version: 1, decode: decodeJSON[PurgeRetentionPayload]},
+	JobTypeEraseUserData:   {version: 1, decode: decodeJSON[EraseUserDataPayload]},
+	JobTypeRefreshRollups:  {version: 1, decode: decodeJSON[RefreshRollupsPayload]},
+	JobTypeDeliverWebhook:  {version: 1, decode: decodeJSON[DeliverWebhookPayload]},
+}
+
+// decodeJSON decodes payloads whose every version so far shares one JSON
+// layout.
+func decodeJSON[P Payload](_ int, data []byte) (Payload, error) {
+	var payload P
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// encodePayload serializes payload with its type's current version.
+func encodePayload(payload Payload) (json.RawMessage, int, error) {
+	if payload == nil {
+		return nil, 0, fmt.Errorf("job needs a payload")
+	}
+	codec, ok := payloadCodecs[payload.JobType()]
+	if !ok {
+		return nil, 0, fmt.Errorf("no payload registered for job type %s", payload.JobType())
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to serialize payload: %w", err)
+	}
+	return data, codec.version, nil
+}
+
+// payloadTooNew reports whether the job's payload was written by a newer
+// deploy than this one, so this worker can't read it. The worker defers such
+// jobs rather than failing them, so they wait for an upgraded worker instead
+// of using up their attempts during a rolling deploy.
+func (j *Job) payloadTooNew() bool {
+	codec, ok := payloadCodecs[j.Type]
+	return ok && j.payloadVersion() > codec.version
+}
+
+func (j *Job) payloadVersion() int {
+	if j.PayloadVersion == 0 {
+		return 1
+	}
+	return j.PayloadVersion
+}
+
+// DecodePayload returns the job's typed payload. It fails for payloads
+// written by a newer deploy than this one; the worker defers those before
+// they reach a processor.
+func (j *Job) DecodePayload() (Payload, error) {
+	codec, ok := payloadCodecs[j.Type]
+	if !ok {
+		return nil, fmt.Errorf("no payload registered for job type %s", j.Type)
+	}
+
+	version := j.payloadVersion()
+	if version > codec.version {
+		return nil, fmt.Errorf("%s payload version %d is newer than the %d this worker reads", j.Type, version, codec.version)
+	}
+
+	data := []byte(j.Payload)
+	if len(data) == 0 || string(data) == "null" {
+		data = []byte("{}")
+	}
+	payload, err := codec.decode(version, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s payload: %w", j.Type, err)
+	}
+	return payload, nil
+}
+
+// payloadOf decodes job's payload as a P.
+func payloadOf[P Payload](job *Job) (P, error) {
+	var zero P
+	payload, err := job.DecodePayload()
+	if err != nil {
+		return zero, err
+	}
+
+	typed, ok := payload.(P)
+	if !ok {
+		return zero, fmt.Errorf("%s job has a %T payload, not %T", job.Type, payload, zero)
+	}
+	return typed, nil
+}
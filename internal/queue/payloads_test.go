@@ -0,0 +1,159 @@
+package queue
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/enrich"
+)
+
+func TestPayloadRoundTrip(t *testing.T) {
+	payloads := []Payload{
+		StoreRawPayload{ProjectID: "p1", TraceID: "t1", DataType: "span", RawData: []byte(`{"name":"retrieve"}`)},
+		EnrichTracePayload{ProjectID: "p1", TraceID: "t1", TraceData: &database.TraceRequest{ID: "t1", Name: "chat"}},
+		AnalyticsExportPayload{TraceID: "t1", ExportData: []byte(`{"id":"t1"}`), ExportType: "clickhouse"},
+		PurgeRetentionPayload{},
+		EraseUserDataPayload{RequestID: "dr1"},
+		RefreshRollupsPayload{},
+		DeliverWebhookPayload{ProjectID: "p1", WebhookID: "w1", EventID: "e1", EventType: "event.ingested", Body: []byte(`{"id":"e1"}`)},
+	}
+	if len(payloads) != len(queuedJobTypes) {
+		t.Fatalf("expected a payload per job type; %d job types, %d payloads", len(queuedJobTypes), len(payloads))
+	}
+
+	for _, payload := range payloads {
+		job, err := newJob(context.Background(), QueueHigh, payload)
+		if err != nil {
+			t.Fatalf("new %s job: %v", payload.JobType(), err)
+		}
+		if job.Type != payload.JobType() || job.PayloadVersion != payloadCodecs[job.Type].version {
+			t.Errorf("expected a %s job at version %d; got %s at %d", payload.JobType(), payloadCodecs[job.Type].version, job.Type, job.PayloadVersion)
+		}
+
+		jobJSON, err := job.ToJSON()
+		if err != nil {
+			t.Fatalf("serialize: %v", err)
+		}
+		decoded, err := FromJSON(jobJSON)
+		if err != nil {
+			t.Fatalf("deserialize: %v", err)
+		}
+		got, err := decoded.DecodePayload()
+		if err != nil {
+			t.Fatalf("decode %s payload: %v", job.Type, err)
+		}
+		if !reflect.DeepEqual(got, payload) {
+			t.Errorf("expected %s payload %+v; got %+v", job.Type, payload, got)
+		}
+	}
+}
+
+func TestProjectIDFromPayload(t *testing.T) {
+	job, err := newJob(context.Background(), QueueHigh, StoreRawPayload{ProjectID: "p1", DataType: "trace"})
+	if err != nil {
+		t.Fatalf("new job: %v", err)
+	}
+	if job.ProjectID != "p1" {
+		t.Errorf("expected the job to belong to p1; got %q", job.ProjectID)
+	}
+}
+
+func TestMaxAttemptsFromPayload(t *testing.T) {
+	job, err := newJob(context.Background(), QueueMedium, DeliverWebhookPayload{ProjectID: "p1", WebhookID: "w1"})
+	if err != nil {
+		t.Fatalf("new job: %v", err)
+	}
+	if job.MaxAttempts != 5 {
+		t.Errorf("expected webhook deliveries to get 5 attempts; got %d", job.MaxAttempts)
+	}
+}
+
+// spanDB records the spans a StoreRawProcessor stores.
+type spanDB struct {
+	database.Service
+	spans []database.SpanRequest
+}
+
+func (f *spanDB) CreateSpan(span database.SpanRequest) error {
+	f.spans = append(f.spans, span)
+	return nil
+}
+
+// Jobs enqueued before payloads were typed and versioned must still run
+// after a deploy. These are jobs as the map-based payloads serialized them.
+func TestLegacyPayloads(t *testing.T) {
+	t.Run("store_raw", func(t *testing.T) {
+		job := mustParseJob(t, `{"id":"j1","type":"store_raw","priority":"high","payload":{"data_type":"span","raw_data":{"id":"s1","trace_id":"t1","name":"retrieve","start_time":"2026-03-01T10:00:00Z"},"trace_id":"t1"},"created_at":"2026-03-01T10:00:00Z","attempts":1,"max_attempts":3}`)
+
+		db := &spanDB{}
+		result, err := NewStoreRawProcessor(db).Process(context.Background(), job)
+		if err != nil || !result.Success {
+			t.Fatalf("expected the job to succeed; got %+v, %v", result, err)
+		}
+		if len(db.spans) != 1 || db.spans[0].ID != "s1" || db.spans[0].Name != "retrieve" {
+			t.Errorf("expected span s1 stored; got %+v", db.spans)
+		}
+	})
+
+	t.Run("enrich_trace", func(t *testing.T) {
+		job := mustParseJob(t, `{"id":"j2","type":"enrich_trace","priority":"medium","payload":{"project_id":"p1","trace_id":"t1","trace_data":{"id":"t1","project_id":"p1","name":"chat","user_id":"u1","start_time":"2026-03-01T10:00:00Z","end_time":"2026-03-01T10:00:02Z"}},"created_at":"2026-03-01T10:00:00Z","attempts":1,"max_attempts":3}`)
+
+		result, err := NewEnrichTraceProcessor(&enrichDB{}, enrich.DefaultRegistry(nil)).Process(context.Background(), job)
+		if err != nil || !result.Success {
+			t.Fatalf("expected the job to succeed; got %+v, %v", result, err)
+		}
+		if enriched := result.Data.(map[string]interface{}); enriched["duration_ms"] != int64(2000) {
+			t.Errorf("expected a 2s trace; got %v", enriched["duration_ms"])
+		}
+	})
+
+	t.Run("analytics_export", func(t *testing.T) {
+		payload, err := payloadOf[AnalyticsExportPayload](mustParseJob(t, `{"id":"j3","type":"analytics_export","priority":"low","payload":{"export_data":{"id":"g1"},"export_type":"clickhouse","trace_id":"t1"},"created_at":"2026-03-01T10:00:00Z","attempts":0,"max_attempts":3}`))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if payload.ExportType != "clickhouse" || string(payload.ExportData) != `{"id":"g1"}` {
+			t.Errorf("unexpected payload %+v", payload)
+		}
+	})
+
+	t.Run("erase_user_data", func(t *testing.T) {
+		payload, err := payloadOf[EraseUserDataPayload](mustParseJob(t, `{"id":"j4","type":"erase_user_data","priority":"medium","payload":{"request_id":"dr1"},"created_at":"2026-03-01T10:00:00Z","attempts":0,"max_attempts":3}`))
+		if err != nil || payload.RequestID != "dr1" {
+			t.Errorf("expected request dr1; got %+v, %v", payload, err)
+		}
+	})
+
+	t.Run("purge_retention", func(t *testing.T) {
+		// Scheduled jobs used to carry their run in the payload.
+		job := mustParseJob(t, `{"id":"j5","type":"purge_retention","priority":"low","payload":{"scheduled_for":"2026-03-01T10:00:00Z"},"created_at":"2026-03-01T10:00:00Z","attempts":0,"max_attempts":3}`)
+		if _, err := payloadOf[PurgeRetentionPayload](job); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+	})
+}
+
+func TestNewerPayloadVersion(t *testing.T) {
+	job := mustParseJob(t, `{"id":"j6","type":"erase_user_data","priority":"medium","payload":{"request":{"id":"dr1"}},"payload_version":2,"created_at":"2026-03-01T10:00:00Z","attempts":1,"max_attempts":3}`)
+
+	result, err := NewUserErasureProcessor(nil).Process(context.Background(), job)
+	if err != nil {
+		t.Fatalf("process: %v", err)
+	}
+	if result.Success || !strings.Contains(result.Error, "version 2") {
+		t.Errorf("expected a payload from a newer deploy not to be processed; got %+v", result)
+	}
+}
+
+func mustParseJob(t *testing.T, jobJSON string) *Job {
+	t.Helper()
+
+	job, err := FromJSON(jobJSON)
+	if err != nil {
+		t.Fatalf("parse job: %v", err)
+	}
+	return job
+}
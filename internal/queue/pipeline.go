@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/enrich"
+)
+
+// EnqueueTrace queues the async pipeline for a trace: store the raw data,
+// enrich it, then export it to analytics.
+func (c *Client) EnqueueTrace(ctx context.Context, req database.TraceRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to serialize trace: %w", err)
+	}
+
+	// Job 1: Store raw data (high priority)
+	_, err = c.Enqueue(ctx, QueueHigh, StoreRawPayload{
+		ProjectID: req.ProjectID,
+		EntityID:  req.ID,
+		TraceID:   req.ID,
+		DataType:  "trace",
+		RawData:   data,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Job 2: Enrich trace data (medium priority)
+	_, err = c.Enqueue(ctx, QueueMedium, EnrichTracePayload{
+		ProjectID:  req.ProjectID,
+		TraceID:    req.ID,
+		TraceData:  &req,
+		Client:     enrich.ClientFromContext(ctx),
+		ReceivedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Job 3: Export to analytics (low priority)
+	_, err = c.Enqueue(ctx, QueueLow, AnalyticsExportPayload{
+		ProjectID:  req.ProjectID,
+		EntityID:   req.ID,
+		TraceID:    req.ID,
+		ExportData: data,
+		ExportType: "clickhouse",
+	})
+	return err
+}
+
+func (c *Client) EnqueueGeneration(ctx context.Context, req database.GenerationRequest) error {
+	return c.enqueueItem(ctx, "generation", req.ID, req.TraceID, req, true)
+}
+
+func (c *Client) EnqueueSpan(ctx context.Context, req database.SpanRequest) error {
+	return c.enqueueItem(ctx, "span", req.ID, req.TraceID, req, true)
+}
+
+func (c *Client) EnqueueEvent(ctx context.Context, req database.EventRequest) error {
+	return c.enqueueItem(ctx, "event", req.ID, req.TraceID, req, false)
+}
+
+func (c *Client) EnqueueScore(ctx context.Context, req database.ScoreRequest) error {
+	return c.enqueueItem(ctx, "score", req.ID, req.TraceID, req, false)
+}
+
+// enqueueItem queues a store_raw job for req, the item id of dataType,
+// followed by an analytics export if export is set.
+func (c *Client) enqueueItem(ctx context.Context, dataType, id, traceID string, req interface{}, export bool) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to serialize %s: %w", dataType, err)
+	}
+
+	_, err = c.Enqueue(ctx, QueueHigh, StoreRawPayload{
+		EntityID: id,
+		TraceID:  traceID,
+		DataType: dataType,
+		RawData:  data,
+	})
+	if err != nil || !export {
+		return err
+	}
+
+	_, err = c.Enqueue(ctx, QueueLow, AnalyticsExportPayload{
+		EntityID:   id,
+		TraceID:    traceID,
+		ExportData: data,
+		ExportType: "clickhouse",
+	})
+	return err
+}
+
+// EnqueueWebhook queues the delivery of an event to one of a project's
+// webhooks.
+func (c *Client) EnqueueWebhook(ctx context.Context, projectID, webhookID, eventID, eventType string, body []byte) error {
+	_, err := c.Enqueue(ctx, QueueMedium, DeliverWebhookPayload{
+		ProjectID: projectID,
+		WebhookID: webhookID,
+		EventID:   eventID,
+		EventType: eventType,
+		Body:      body,
+	})
+	return err
+}
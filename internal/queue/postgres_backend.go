@@ -0,0 +1,435 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// pgPollInterval is how often Pop looks for a job while its queues are
+	// empty. Postgres has no blocking pop, so idle workers poll.
+	pgPollInterval = 500 * time.Millisecond
+
+	// pgCompletedTTL is how long completed jobs are kept, like the expiry
+	// RedisBackend puts on them.
+	pgCompletedTTL = time.Hour
+)
+
+// PostgresBackend keeps the queues in the queue_jobs table (migration 011),
+// so a deployment can run without Redis. Workers claim jobs with
+// FOR UPDATE SKIP LOCKED, so any number of them can pop from the same
+// queues without blocking each other or taking the same job.
+type PostgresBackend struct {
+	db *sql.DB
+}
+
+func NewPostgresBackend(db *sql.DB) *PostgresBackend {
+	return &PostgresBackend{
+		db: db,
+	}
+}
+
+func (b *PostgresBackend) Push(ctx context.Context, job *Job) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job: %w", err)
+	}
+
+	_, err = b.db.ExecContext(ctx, `
+		INSERT INTO queue_jobs (id, queue_name, state, position, ready_at, project_id, job)
+		VALUES ($1, $2, 'ready', nextval('queue_job_positions'), $3, $4, $5)`,
+		job.ID, GetQueueName(job.Type, job.Priority), job.ReadyAt(), nullableProjectID(job), jobJSON)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return nil
+}
+
+func (b *PostgresBackend) PushFront(ctx context.Context, job *Job) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job: %w", err)
+	}
+
+	_, err = b.db.ExecContext(ctx, `
+		INSERT INTO queue_jobs (id, queue_name, state, position, ready_at, project_id, job)
+		VALUES ($1, $2, 'ready', -nextval('queue_job_positions'), $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET
+			queue_name = EXCLUDED.queue_name,
+			state = 'ready',
+			position = EXCLUDED.position,
+			job = EXCLUDED.job,
+			updated_at = NOW()`,
+		job.ID, GetQueueName(job.Type, job.Priority), job.ReadyAt(), nullableProjectID(job), jobJSON)
+	if err != nil {
+		return fmt.Errorf("failed to requeue job: %w", err)
+	}
+	return nil
+}
+
+func (b *PostgresBackend) PushDelayed(ctx context.Context, job *Job, at time.Time) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job: %w", err)
+	}
+
+	_, err = b.db.ExecContext(ctx, `
+		INSERT INTO queue_jobs (id, queue_name, state, position, ready_at, project_id, job)
+		VALUES ($1, $2, 'delayed', 0, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET
+			state = 'delayed',
+			ready_at = EXCLUDED.ready_at,
+			job = EXCLUDED.job,
+			updated_at = NOW()`,
+		job.ID, GetQueueName(job.Type, job.Priority), at, nullableProjectID(job), jobJSON)
+	if err != nil {
+		return fmt.Errorf("failed to schedule job: %w", err)
+	}
+	return nil
+}
+
+func nullableProjectID(job *Job) sql.NullString {
+	return sql.NullString{String: job.ProjectID, Valid: job.ProjectID != ""}
+}
+
+func (b *PostgresBackend) Pop(ctx context.Context, queueNames []string, timeout time.Duration) (*Job, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		job, err := b.pop(ctx, queueNames)
+		if job != nil || err != nil {
+			return job, err
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, nil
+		}
+		select {
+		case <-time.After(min(wait, pgPollInterval)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// pop claims the job at the front of the first of queueNames that has one,
+// skipping jobs other workers are claiming, or returns nil.
+func (b *PostgresBackend) pop(ctx context.Context, queueNames []string) (*Job, error) {
+	var jobJSON string
+	err := b.db.QueryRowContext(ctx, `
+		UPDATE queue_jobs SET state = 'running', updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM queue_jobs
+			WHERE state = 'ready' AND queue_name = ANY($1)
+			ORDER BY array_position($1, queue_name::text), position
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING job`,
+		queueNames).Scan(&jobJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to dequeue job: %w", err)
+	}
+
+	job, err := FromJSON(jobJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize job: %w", err)
+	}
+	return job, nil
+}
+
+func (b *PostgresBackend) Complete(ctx context.Context, job *Job) error {
+	return b.finish(ctx, job, "completed")
+}
+
+func (b *PostgresBackend) Bury(ctx context.Context, job *Job) error {
+	return b.finish(ctx, job, "dead")
+}
+
+func (b *PostgresBackend) finish(ctx context.Context, job *Job, state string) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job: %w", err)
+	}
+
+	_, err = b.db.ExecContext(ctx, `
+		UPDATE queue_jobs SET state = $2, job = $3, updated_at = NOW()
+		WHERE id = $1`,
+		job.ID, state, jobJSON)
+	if err != nil {
+		return fmt.Errorf("failed to mark job %s: %w", state, err)
+	}
+	return nil
+}
+
+// ReleaseDelayed also deletes completed jobs older than pgCompletedTTL.
+func (b *PostgresBackend) ReleaseDelayed(ctx context.Context, now time.Time) (int, error) {
+	if _, err := b.db.ExecContext(ctx, `
+		DELETE FROM queue_jobs WHERE state = 'completed' AND updated_at < $1`,
+		now.Add(-pgCompletedTTL)); err != nil {
+		return 0, fmt.Errorf("failed to delete completed jobs: %w", err)
+	}
+
+	result, err := b.db.ExecContext(ctx, `
+		UPDATE queue_jobs SET state = 'ready', position = nextval('queue_job_positions'), updated_at = NOW()
+		WHERE state = 'delayed' AND ready_at <= $1`,
+		now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release delayed jobs: %w", err)
+	}
+
+	released, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(released), nil
+}
+
+func (b *PostgresBackend) Promote(ctx context.Context, from, to string, cutoff time.Time, limit int) (int, error) {
+	result, err := b.db.ExecContext(ctx, `
+		UPDATE queue_jobs SET queue_name = $2, position = -nextval('queue_job_positions'), updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM queue_jobs
+			WHERE state = 'ready' AND queue_name = $1 AND ready_at < $3
+			ORDER BY position
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)`,
+		from, to, cutoff, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to promote jobs: %w", err)
+	}
+
+	promoted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(promoted), nil
+}
+
+func (b *PostgresBackend) Stats(ctx context.Context, queueNames []string) (map[string]int64, error) {
+	stats := make(map[string]int64, len(queueNames)+2)
+	for _, queueName := range queueNames {
+		stats[queueName] = 0
+	}
+	stats["dead_letter"] = 0
+	stats["delayed"] = 0
+
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT queue_name, state, COUNT(*) FROM queue_jobs
+		WHERE state IN ('ready', 'delayed', 'dead')
+		GROUP BY queue_name, state`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count jobs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var queueName, state string
+		var count int64
+		if err := rows.Scan(&queueName, &state, &count); err != nil {
+			return nil, err
+		}
+
+		switch state {
+		case "ready":
+			if _, ok := stats[queueName]; ok {
+				stats[queueName] = count
+			}
+		case "delayed":
+			stats["delayed"] += count
+		case "dead":
+			stats["dead_letter"] += count
+		}
+	}
+	return stats, rows.Err()
+}
+
+func (b *PostgresBackend) PendingByProject(ctx context.Context) (map[string]int64, error) {
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT project_id, COUNT(*) FROM queue_jobs
+		WHERE state = 'ready' AND project_id IS NOT NULL AND job->>'type' = 'store_raw'
+		GROUP BY project_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count pending jobs: %w", err)
+	}
+	defer rows.Close()
+
+	pending := make(map[string]int64)
+	for rows.Next() {
+		var projectID string
+		var count int64
+		if err := rows.Scan(&projectID, &count); err != nil {
+			return nil, err
+		}
+		pending[projectID] = count
+	}
+	return pending, rows.Err()
+}
+
+// pgJobStates maps the states in queue_jobs to the ones JobStatuses reports.
+var pgJobStates = map[string]JobState{
+	"ready":     JobStatePending,
+	"delayed":   JobStateDelayed,
+	"running":   JobStateProcessing,
+	"completed": JobStateCompleted,
+	"dead":      JobStateDead,
+}
+
+// JobStatuses looks jobs up by the entity_id in their JSON, indexed by
+// migration 013. Completed jobs are kept for pgCompletedTTL and dead ones
+// until they're deleted.
+func (b *PostgresBackend) JobStatuses(ctx context.Context, projectID, entityID string) ([]JobStatus, error) {
+	rows, err := b.db.QueryContext(ctx, `
+		SELECT state, job, updated_at FROM queue_jobs
+		WHERE project_id = $1 AND job->>'entity_id' = $2`,
+		projectID, entityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job statuses: %w", err)
+	}
+	defer rows.Close()
+
+	var statuses []JobStatus
+	for rows.Next() {
+		var state, jobJSON string
+		var updatedAt time.Time
+		if err := rows.Scan(&state, &jobJSON, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan job status: %w", err)
+		}
+
+		job, err := FromJSON(jobJSON)
+		if err != nil {
+			continue
+		}
+		status := newJobStatus(job, pgJobStates[state])
+		status.UpdatedAt = updatedAt
+		statuses = append(statuses, status)
+	}
+	return statuses, rows.Err()
+}
+
+func (b *PostgresBackend) RegisterWorkers(ctx context.Context, workers []WorkerInfo) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, worker := range workers {
+		data, err := json.Marshal(worker)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO queue_workers (id, info, reported_at) VALUES ($1, $2, $3)
+			ON CONFLICT (id) DO UPDATE SET info = EXCLUDED.info, reported_at = EXCLUDED.reported_at`,
+			worker.ID, data, worker.ReportedAt); err != nil {
+			return fmt.Errorf("failed to register worker: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (b *PostgresBackend) DeregisterWorkers(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := b.db.ExecContext(ctx, `DELETE FROM queue_workers WHERE id = ANY($1)`, ids)
+	return err
+}
+
+func (b *PostgresBackend) Workers(ctx context.Context) ([]WorkerInfo, error) {
+	cutoff := time.Now().Add(-workerRegistryTTL)
+	if _, err := b.db.ExecContext(ctx, `DELETE FROM queue_workers WHERE reported_at < $1`, cutoff); err != nil {
+		return nil, fmt.Errorf("failed to prune workers: %w", err)
+	}
+
+	rows, err := b.db.QueryContext(ctx, `SELECT info FROM queue_workers`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workers: %w", err)
+	}
+	defer rows.Close()
+
+	var workers []WorkerInfo
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var worker WorkerInfo
+		if err := json.Unmarshal(data, &worker); err != nil {
+			continue
+		}
+		workers = append(workers, worker)
+	}
+	return workers, rows.Err()
+}
+
+// TryLock times leases by the database's clock, so instances with skewed
+// clocks agree on when a lock expires.
+func (b *PostgresBackend) TryLock(ctx context.Context, name, token string, ttl time.Duration) (bool, error) {
+	result, err := b.db.ExecContext(ctx, `
+		INSERT INTO queue_locks (name, token, expires_at)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3))
+		ON CONFLICT (name) DO UPDATE SET token = EXCLUDED.token, expires_at = EXCLUDED.expires_at
+		WHERE queue_locks.token = EXCLUDED.token OR queue_locks.expires_at < NOW()`,
+		name, token, ttl.Seconds())
+	if err != nil {
+		return false, err
+	}
+
+	taken, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return taken == 1, nil
+}
+
+func (b *PostgresBackend) Unlock(ctx context.Context, name, token string) error {
+	_, err := b.db.ExecContext(ctx, `DELETE FROM queue_locks WHERE name = $1 AND token = $2`, name, token)
+	return err
+}
+
+func (b *PostgresBackend) LastRun(ctx context.Context, schedule string) (time.Time, error) {
+	var lastRun time.Time
+	err := b.db.QueryRowContext(ctx, `SELECT last_run FROM queue_schedules WHERE name = $1`, schedule).Scan(&lastRun)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return lastRun.UTC(), nil
+}
+
+func (b *PostgresBackend) AdvanceRun(ctx context.Context, schedule string, from, to time.Time) (bool, error) {
+	var result sql.Result
+	var err error
+	if from.IsZero() {
+		result, err = b.db.ExecContext(ctx, `
+			INSERT INTO queue_schedules (name, last_run) VALUES ($1, $2)
+			ON CONFLICT (name) DO NOTHING`,
+			schedule, to)
+	} else {
+		result, err = b.db.ExecContext(ctx, `
+			UPDATE queue_schedules SET last_run = $3 WHERE name = $1 AND last_run = $2`,
+			schedule, from, to)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	advanced, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return advanced == 1, nil
+}
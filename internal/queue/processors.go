@@ -1,12 +1,20 @@
 package queue
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
+
 	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/enrich"
+	"langlite-ingestion/internal/webhook"
 )
 
 type JobProcessor interface {
@@ -14,12 +22,15 @@ type JobProcessor interface {
 	CanProcess(jobType JobType) bool
 }
 
+// EnrichTraceProcessor runs the trace's project's enrichers on it and stores
+// the fields they derive on the trace.
 type EnrichTraceProcessor struct {
-	db database.Service
+	db        database.Service
+	enrichers *enrich.Registry
 }
 
-func NewEnrichTraceProcessor(db database.Service) *EnrichTraceProcessor {
-	return &EnrichTraceProcessor{db: db}
+func NewEnrichTraceProcessor(db database.Service, enrichers *enrich.Registry) *EnrichTraceProcessor {
+	return &EnrichTraceProcessor{db: db, enrichers: enrichers}
 }
 
 func (p *EnrichTraceProcessor) CanProcess(jobType JobType) bool {
@@ -29,7 +40,7 @@ func (p *EnrichTraceProcessor) CanProcess(jobType JobType) bool {
 func (p *EnrichTraceProcessor) Process(ctx context.Context, job *Job) (*JobResult, error) {
 	start := time.Now()
 
-	traceData, err := p.extractTraceData(job.Payload)
+	in, projectID, err := p.extractInput(job)
 	if err != nil {
 		return &JobResult{
 			Success:     false,
@@ -39,11 +50,12 @@ func (p *EnrichTraceProcessor) Process(ctx context.Context, job *Job) (*JobResul
 		}, nil
 	}
 
-	enrichedData, err := p.enrichTrace(ctx, traceData)
+	enrichedData, err := p.enrichTrace(ctx, projectID, in)
 	if err != nil {
 		return &JobResult{
 			Success:     false,
 			Error:       fmt.Sprintf("failed to enrich trace: %v", err),
+			Data:        enrichedData,
 			Duration:    time.Since(start),
 			ProcessedAt: time.Now().UTC(),
 		}, nil
@@ -57,57 +69,59 @@ func (p *EnrichTraceProcessor) Process(ctx context.Context, job *Job) (*JobResul
 	}, nil
 }
 
-func (p *EnrichTraceProcessor) extractTraceData(payload map[string]interface{}) (*database.TraceRequest, error) {
-	traceDataRaw, exists := payload["trace_data"]
-	if !exists {
-		return nil, fmt.Errorf("trace_data not found in payload")
-	}
-
-	jsonData, err := json.Marshal(traceDataRaw)
+// extractInput returns what the enrichers see of the job's trace, and the
+// project it belongs to.
+func (p *EnrichTraceProcessor) extractInput(job *Job) (enrich.Input, string, error) {
+	payload, err := payloadOf[EnrichTracePayload](job)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal trace data: %w", err)
+		return enrich.Input{}, "", err
+	}
+	if payload.TraceData == nil {
+		return enrich.Input{}, "", fmt.Errorf("trace_data not found in payload")
 	}
 
-	var traceData database.TraceRequest
-	err = json.Unmarshal(jsonData, &traceData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal trace data: %w", err)
+	in := enrich.Input{
+		Trace:      *payload.TraceData,
+		Client:     payload.Client,
+		ReceivedAt: payload.ReceivedAt,
+	}
+	if in.ReceivedAt.IsZero() {
+		in.ReceivedAt = job.CreatedAt
 	}
 
-	return &traceData, nil
+	projectID := cmp.Or(in.Trace.ProjectID, payload.ProjectID, job.ProjectID)
+	if projectID == "" {
+		return enrich.Input{}, "", fmt.Errorf("project_id not found in payload")
+	}
+	return in, projectID, nil
 }
 
-func (p *EnrichTraceProcessor) enrichTrace(ctx context.Context, trace *database.TraceRequest) (map[string]interface{}, error) {
-	enriched := make(map[string]interface{})
-
-	enriched["server_received_at"] = time.Now().UTC()
-	enriched["enrichment_version"] = "1.0"
-
-	if trace.EndTime != nil && !trace.StartTime.IsZero() {
-		duration := trace.EndTime.Sub(trace.StartTime)
-		enriched["duration_ms"] = duration.Milliseconds()
+// enrichTrace runs the project's enrichers and stores what they derive. The
+// trace may not be stored yet, since store_raw jobs run in parallel; the
+// job then fails and its retry finds it. Enrichers that fail fail the job
+// too, after the others' fields are stored.
+func (p *EnrichTraceProcessor) enrichTrace(ctx context.Context, projectID string, in enrich.Input) (map[string]any, error) {
+	settings, err := p.db.GetEnrichmentSettings(projectID)
+	if err != nil {
+		return nil, err
 	}
 
-	enriched["trace_id"] = trace.ID
-	enriched["project_id"] = trace.ProjectID
-
-	if trace.UserID != "" {
-		enriched["user_context"] = map[string]interface{}{
-			"user_id": trace.UserID,
+	var names []string
+	if settings != nil {
+		names = settings.Enrichers
+		if len(names) == 0 {
+			return nil, nil
 		}
 	}
 
-	if trace.SessionID != "" {
-		enriched["session_context"] = map[string]interface{}{
-			"session_id": trace.SessionID,
+	enriched, enrichErr := p.enrichers.Enrich(ctx, names, in)
+	if err := p.db.SetTraceEnrichment(projectID, in.Trace.ID, enriched); err != nil {
+		if errors.Is(err, database.ErrTraceNotFound) {
+			return enriched, fmt.Errorf("trace %s isn't stored yet", in.Trace.ID)
 		}
+		return enriched, err
 	}
-
-	if trace.Metadata != nil {
-		enriched["original_metadata"] = trace.Metadata
-	}
-
-	return enriched, nil
+	return enriched, enrichErr
 }
 
 type StoreRawProcessor struct {
@@ -125,7 +139,7 @@ func (p *StoreRawProcessor) CanProcess(jobType JobType) bool {
 func (p *StoreRawProcessor) Process(ctx context.Context, job *Job) (*JobResult, error) {
 	start := time.Now()
 
-	rawData, dataType, err := p.extractRawData(job.Payload)
+	rawData, dataType, err := p.extractRawData(job)
 	if err != nil {
 		return &JobResult{
 			Success:     false,
@@ -136,6 +150,16 @@ func (p *StoreRawProcessor) Process(ctx context.Context, job *Job) (*JobResult,
 	}
 
 	err = p.storeByType(ctx, dataType, rawData)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		// Jobs can run twice, e.g. when Shutdown requeues one whose first
+		// run had already committed, so the item being there is success.
+		return &JobResult{
+			Success:     true,
+			Data:        map[string]interface{}{"stored_type": dataType, "already_stored": true},
+			Duration:    time.Since(start),
+			ProcessedAt: time.Now().UTC(),
+		}, nil
+	}
 	if err != nil {
 		return &JobResult{
 			Success:     false,
@@ -153,26 +177,21 @@ func (p *StoreRawProcessor) Process(ctx context.Context, job *Job) (*JobResult,
 	}, nil
 }
 
-func (p *StoreRawProcessor) extractRawData(payload map[string]interface{}) (interface{}, string, error) {
-	rawData, exists := payload["raw_data"]
-	if !exists {
+func (p *StoreRawProcessor) extractRawData(job *Job) (json.RawMessage, string, error) {
+	payload, err := payloadOf[StoreRawPayload](job)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(payload.RawData) == 0 {
 		return nil, "", fmt.Errorf("raw_data not found in payload")
 	}
-
-	dataType, exists := payload["data_type"]
-	if !exists {
+	if payload.DataType == "" {
 		return nil, "", fmt.Errorf("data_type not found in payload")
 	}
-
-	dataTypeStr, ok := dataType.(string)
-	if !ok {
-		return nil, "", fmt.Errorf("data_type must be a string")
-	}
-
-	return rawData, dataTypeStr, nil
+	return payload.RawData, payload.DataType, nil
 }
 
-func (p *StoreRawProcessor) storeByType(ctx context.Context, dataType string, rawData interface{}) error {
+func (p *StoreRawProcessor) storeByType(ctx context.Context, dataType string, rawData json.RawMessage) error {
 	switch dataType {
 	case "trace":
 		return p.storeTrace(ctx, rawData)
@@ -189,75 +208,45 @@ func (p *StoreRawProcessor) storeByType(ctx context.Context, dataType string, ra
 	}
 }
 
-func (p *StoreRawProcessor) storeTrace(ctx context.Context, rawData interface{}) error {
-	jsonData, err := json.Marshal(rawData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal trace data: %w", err)
-	}
-
+func (p *StoreRawProcessor) storeTrace(ctx context.Context, rawData json.RawMessage) error {
 	var trace database.TraceRequest
-	err = json.Unmarshal(jsonData, &trace)
-	if err != nil {
+	if err := json.Unmarshal(rawData, &trace); err != nil {
 		return fmt.Errorf("failed to unmarshal trace data: %w", err)
 	}
 
 	return p.db.CreateTrace(trace)
 }
 
-func (p *StoreRawProcessor) storeSpan(ctx context.Context, rawData interface{}) error {
-	jsonData, err := json.Marshal(rawData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal span data: %w", err)
-	}
-
+func (p *StoreRawProcessor) storeSpan(ctx context.Context, rawData json.RawMessage) error {
 	var span database.SpanRequest
-	err = json.Unmarshal(jsonData, &span)
-	if err != nil {
+	if err := json.Unmarshal(rawData, &span); err != nil {
 		return fmt.Errorf("failed to unmarshal span data: %w", err)
 	}
 
 	return p.db.CreateSpan(span)
 }
 
-func (p *StoreRawProcessor) storeGeneration(ctx context.Context, rawData interface{}) error {
-	jsonData, err := json.Marshal(rawData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal generation data: %w", err)
-	}
-
+func (p *StoreRawProcessor) storeGeneration(ctx context.Context, rawData json.RawMessage) error {
 	var generation database.GenerationRequest
-	err = json.Unmarshal(jsonData, &generation)
-	if err != nil {
+	if err := json.Unmarshal(rawData, &generation); err != nil {
 		return fmt.Errorf("failed to unmarshal generation data: %w", err)
 	}
 
 	return p.db.CreateGeneration(generation)
 }
 
-func (p *StoreRawProcessor) storeEvent(ctx context.Context, rawData interface{}) error {
-	jsonData, err := json.Marshal(rawData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal event data: %w", err)
-	}
-
+func (p *StoreRawProcessor) storeEvent(ctx context.Context, rawData json.RawMessage) error {
 	var event database.EventRequest
-	err = json.Unmarshal(jsonData, &event)
-	if err != nil {
+	if err := json.Unmarshal(rawData, &event); err != nil {
 		return fmt.Errorf("failed to unmarshal event data: %w", err)
 	}
 
 	return p.db.CreateEvent(event)
 }
 
-func (p *StoreRawProcessor) storeScore(ctx context.Context, rawData interface{}) error {
-	jsonData, err := json.Marshal(rawData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal score data: %w", err)
-	}
-
+func (p *StoreRawProcessor) storeScore(ctx context.Context, rawData json.RawMessage) error {
 	var score database.ScoreRequest
-	err = json.Unmarshal(jsonData, &score)
-	if err != nil {
+	if err := json.Unmarshal(rawData, &score); err != nil {
 		return fmt.Errorf("failed to unmarshal score data: %w", err)
 	}
 
@@ -284,7 +273,7 @@ func (p *AnalyticsExportProcessor) Process(ctx context.Context, job *Job) (*JobR
 	// For now, this is a placeholder implementation:
 	// In production, this would export to ClickHouse or other analytics systems
 
-	exportData, exportType, err := p.extractExportData(job.Payload)
+	exportData, exportType, err := p.extractExportData(job)
 	if err != nil {
 		return &JobResult{
 			Success:     false,
@@ -313,26 +302,23 @@ func (p *AnalyticsExportProcessor) Process(ctx context.Context, job *Job) (*JobR
 	}, nil
 }
 
-func (p *AnalyticsExportProcessor) extractExportData(payload map[string]interface{}) (interface{}, string, error) {
-	exportData, exists := payload["export_data"]
-	if !exists {
+func (p *AnalyticsExportProcessor) extractExportData(job *Job) (json.RawMessage, string, error) {
+	payload, err := payloadOf[AnalyticsExportPayload](job)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(payload.ExportData) == 0 {
 		return nil, "", fmt.Errorf("export_data not found in payload")
 	}
 
-	exportType, exists := payload["export_type"]
-	if !exists {
+	exportType := payload.ExportType
+	if exportType == "" {
 		exportType = "clickhouse" // Default export type
 	}
-
-	exportTypeStr, ok := exportType.(string)
-	if !ok {
-		return nil, "", fmt.Errorf("export_type must be a string")
-	}
-
-	return exportData, exportTypeStr, nil
+	return payload.ExportData, exportType, nil
 }
 
-func (p *AnalyticsExportProcessor) simulateExport(ctx context.Context, exportType string, data interface{}) error {
+func (p *AnalyticsExportProcessor) simulateExport(ctx context.Context, exportType string, data json.RawMessage) error {
 	time.Sleep(100 * time.Millisecond)
 
 	// In production, this would:
@@ -341,6 +327,310 @@ func (p *AnalyticsExportProcessor) simulateExport(ctx context.Context, exportTyp
 	// 3. Batch insert the data
 	// 4. Handle export errors and retries
 
-	fmt.Printf("Simulated export to %s completed\n", exportType)
+	slog.InfoContext(ctx, "Simulated export completed", "export_type", exportType)
 	return nil
 }
+
+const (
+	// retentionBatchSize bounds how many rows one purge statement deletes,
+	// keeping each transaction and its locks short.
+	retentionBatchSize = 1000
+
+	// retentionBatchPause leaves room for ingestion between batches.
+	retentionBatchPause = 50 * time.Millisecond
+
+	// webhookDeliveryRetention is how long webhook delivery attempts are
+	// logged for.
+	webhookDeliveryRetention = 30 * 24 * time.Hour
+)
+
+// RetentionPurgeProcessor deletes rows older than their project's retention
+// policy in bounded batches.
+type RetentionPurgeProcessor struct {
+	db      database.Service
+	metrics Recorder
+}
+
+func NewRetentionPurgeProcessor(db database.Service, m Recorder) *RetentionPurgeProcessor {
+	return &RetentionPurgeProcessor{db: db, metrics: m}
+}
+
+func (p *RetentionPurgeProcessor) CanProcess(jobType JobType) bool {
+	return jobType == JobTypePurgeRetention
+}
+
+func (p *RetentionPurgeProcessor) Process(ctx context.Context, job *Job) (*JobResult, error) {
+	start := time.Now()
+
+	policies, err := p.db.ListRetentionPolicies()
+	if err != nil {
+		return &JobResult{
+			Success:     false,
+			Error:       fmt.Sprintf("failed to list retention policies: %v", err),
+			Duration:    time.Since(start),
+			ProcessedAt: time.Now().UTC(),
+		}, nil
+	}
+
+	purged := make(map[string]int64)
+	var failures []string
+
+	for _, policy := range policies {
+		before := start.UTC().AddDate(0, 0, -policy.Days)
+
+		entities := []string{policy.Entity}
+		if policy.Entity == "traces" {
+			entities = append(entities, "sessions")
+		}
+
+		for _, entity := range entities {
+			n, err := p.purge(ctx, policy.ProjectID, entity, before)
+			purged[entity] += n
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s/%s: %v", policy.ProjectID, entity, err))
+				break
+			}
+		}
+	}
+
+	n, err := p.db.PurgeWebhookDeliveries(start.UTC().Add(-webhookDeliveryRetention))
+	purged["webhook_deliveries"] += n
+	if err != nil {
+		failures = append(failures, fmt.Sprintf("webhook_deliveries: %v", err))
+	}
+
+	if len(failures) > 0 {
+		return &JobResult{
+			Success:     false,
+			Error:       fmt.Sprintf("failed to purge %d policies: %s", len(failures), strings.Join(failures, "; ")),
+			Data:        map[string]interface{}{"purged": purged},
+			Duration:    time.Since(start),
+			ProcessedAt: time.Now().UTC(),
+		}, nil
+	}
+
+	return &JobResult{
+		Success:     true,
+		Data:        map[string]interface{}{"policies": len(policies), "purged": purged},
+		Duration:    time.Since(start),
+		ProcessedAt: time.Now().UTC(),
+	}, nil
+}
+
+// purge deletes batches of one entity until none are left or ctx is done.
+func (p *RetentionPurgeProcessor) purge(ctx context.Context, projectID, entity string, before time.Time) (int64, error) {
+	var total int64
+
+	for {
+		n, err := p.db.PurgeExpired(projectID, entity, before, retentionBatchSize)
+		total += n
+		if n > 0 && p.metrics != nil {
+			p.metrics.RecordRetentionPurge(entity, n)
+		}
+		if err != nil {
+			return total, err
+		}
+		if n < retentionBatchSize {
+			return total, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(retentionBatchPause):
+		}
+	}
+}
+
+// UserErasureProcessor runs an end user's erasure request. Progress and the
+// receipt are recorded on the request itself.
+type UserErasureProcessor struct {
+	db database.Service
+}
+
+func NewUserErasureProcessor(db database.Service) *UserErasureProcessor {
+	return &UserErasureProcessor{db: db}
+}
+
+func (p *UserErasureProcessor) CanProcess(jobType JobType) bool {
+	return jobType == JobTypeEraseUserData
+}
+
+func (p *UserErasureProcessor) Process(ctx context.Context, job *Job) (*JobResult, error) {
+	start := time.Now()
+
+	payload, err := payloadOf[EraseUserDataPayload](job)
+	if err == nil && payload.RequestID == "" {
+		err = fmt.Errorf("request_id not found in payload")
+	}
+	if err != nil {
+		return &JobResult{
+			Success:     false,
+			Error:       err.Error(),
+			Duration:    time.Since(start),
+			ProcessedAt: time.Now().UTC(),
+		}, nil
+	}
+
+	requestID := payload.RequestID
+	if err := p.db.EraseUserData(requestID); err != nil {
+		return &JobResult{
+			Success:     false,
+			Error:       fmt.Sprintf("failed to erase user data: %v", err),
+			Data:        map[string]interface{}{"request_id": requestID},
+			Duration:    time.Since(start),
+			ProcessedAt: time.Now().UTC(),
+		}, nil
+	}
+
+	return &JobResult{
+		Success:     true,
+		Data:        map[string]interface{}{"request_id": requestID},
+		Duration:    time.Since(start),
+		ProcessedAt: time.Now().UTC(),
+	}, nil
+}
+
+// RollupRefreshProcessor brings the usage rollups up to date.
+type RollupRefreshProcessor struct {
+	db database.Service
+}
+
+func NewRollupRefreshProcessor(db database.Service) *RollupRefreshProcessor {
+	return &RollupRefreshProcessor{db: db}
+}
+
+func (p *RollupRefreshProcessor) CanProcess(jobType JobType) bool {
+	return jobType == JobTypeRefreshRollups
+}
+
+func (p *RollupRefreshProcessor) Process(ctx context.Context, job *Job) (*JobResult, error) {
+	start := time.Now()
+
+	hours, err := p.db.RefreshUsageRollups()
+	if err != nil {
+		return &JobResult{
+			Success:     false,
+			Error:       fmt.Sprintf("failed to refresh usage rollups: %v", err),
+			Duration:    time.Since(start),
+			ProcessedAt: time.Now().UTC(),
+		}, nil
+	}
+
+	return &JobResult{
+		Success:     true,
+		Data:        map[string]interface{}{"project_hours": hours},
+		Duration:    time.Since(start),
+		ProcessedAt: time.Now().UTC(),
+	}, nil
+}
+
+const (
+	// DefaultWebhookTimeout is how long a webhook endpoint has to respond.
+	DefaultWebhookTimeout = 10 * time.Second
+
+	// DefaultWebhookDisableAfter is how many failed deliveries in a row
+	// disable a webhook.
+	DefaultWebhookDisableAfter = 20
+)
+
+// DeliverWebhookProcessor delivers an event to a webhook and logs the
+// attempt. A webhook that keeps failing is disabled after disableAfter
+// attempts in a row, unless disableAfter is 0.
+type DeliverWebhookProcessor struct {
+	db           database.Service
+	deliverer    *webhook.Deliverer
+	disableAfter int
+}
+
+func NewDeliverWebhookProcessor(db database.Service, deliverer *webhook.Deliverer, disableAfter int) *DeliverWebhookProcessor {
+	return &DeliverWebhookProcessor{db: db, deliverer: deliverer, disableAfter: disableAfter}
+}
+
+func (p *DeliverWebhookProcessor) CanProcess(jobType JobType) bool {
+	return jobType == JobTypeDeliverWebhook
+}
+
+func (p *DeliverWebhookProcessor) Process(ctx context.Context, job *Job) (*JobResult, error) {
+	start := time.Now()
+
+	payload, err := payloadOf[DeliverWebhookPayload](job)
+	if err != nil {
+		return &JobResult{
+			Success:     false,
+			Error:       err.Error(),
+			Duration:    time.Since(start),
+			ProcessedAt: time.Now().UTC(),
+		}, nil
+	}
+
+	hook, err := p.db.GetWebhook(payload.ProjectID, payload.WebhookID)
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		return skippedDelivery(start, "webhook deleted"), nil
+	}
+	if err != nil {
+		return &JobResult{
+			Success:     false,
+			Error:       fmt.Sprintf("failed to get webhook: %v", err),
+			Duration:    time.Since(start),
+			ProcessedAt: time.Now().UTC(),
+		}, nil
+	}
+	// Pings are how a disabled endpoint is checked before enabling it again.
+	if !hook.Enabled && payload.EventType != webhook.EventPing {
+		return skippedDelivery(start, "webhook disabled"), nil
+	}
+
+	result, deliverErr := p.deliverer.Deliver(ctx, *hook, payload.EventID, payload.EventType, payload.Body)
+
+	delivery := database.WebhookDelivery{
+		ID:         uuid.New().String(),
+		WebhookID:  hook.ID,
+		ProjectID:  hook.ProjectID,
+		EventID:    payload.EventID,
+		EventType:  payload.EventType,
+		Attempt:    job.Attempts,
+		StatusCode: result.StatusCode,
+		Success:    deliverErr == nil,
+		Response:   result.Response,
+		DurationMS: result.Duration.Milliseconds(),
+	}
+	if deliverErr != nil {
+		delivery.Error = deliverErr.Error()
+	}
+
+	disabled, err := p.db.RecordWebhookDelivery(delivery, p.disableAfter)
+	if err != nil && !errors.Is(err, database.ErrWebhookNotFound) {
+		slog.ErrorContext(ctx, "Failed to record webhook delivery", "webhook_id", hook.ID, "event_id", payload.EventID, "error", err)
+	}
+	if disabled {
+		slog.WarnContext(ctx, "Webhook disabled after consecutive failed deliveries", "webhook_id", hook.ID, "project_id", hook.ProjectID, "failures", p.disableAfter)
+	}
+
+	if deliverErr != nil {
+		return &JobResult{
+			Success:     false,
+			Error:       deliverErr.Error(),
+			Duration:    time.Since(start),
+			ProcessedAt: time.Now().UTC(),
+		}, nil
+	}
+
+	return &JobResult{
+		Success:     true,
+		Data:        map[string]interface{}{"webhook_id": hook.ID, "status_code": result.StatusCode},
+		Duration:    time.Since(start),
+		ProcessedAt: time.Now().UTC(),
+	}, nil
+}
+
+// skippedDelivery succeeds a delivery that's no longer wanted, so it isn't
+// retried.
+func skippedDelivery(start time.Time, reason string) *JobResult {
+	return &JobResult{
+		Success:     true,
+		Data:        map[string]interface{}{"skipped": reason},
+		Duration:    time.Since(start),
+		ProcessedAt: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,289 @@
+package queue
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/enrich"
+	"langlite-ingestion/internal/webhook"
+)
+
+// purgeDB has a number of expired rows per "project/entity" and hands them out
+// in batches.
+type purgeDB struct {
+	database.Service
+	policies []database.RetentionPolicy
+	expired  map[string]int64
+	calls    []string
+	before   map[string]time.Time
+}
+
+func (f *purgeDB) PurgeWebhookDeliveries(before time.Time) (int64, error) {
+	f.before["webhook_deliveries"] = before
+	return 3, nil
+}
+
+func (f *purgeDB) ListRetentionPolicies() ([]database.RetentionPolicy, error) {
+	return f.policies, nil
+}
+
+func (f *purgeDB) PurgeExpired(projectID, entity string, before time.Time, limit int) (int64, error) {
+	key := projectID + "/" + entity
+	f.calls = append(f.calls, key)
+	f.before[key] = before
+
+	n := min(f.expired[key], int64(limit))
+	f.expired[key] -= n
+	return n, nil
+}
+
+func TestRetentionPurgeProcessor(t *testing.T) {
+	db := &purgeDB{
+		policies: []database.RetentionPolicy{
+			{ProjectID: "p1", Entity: "traces", Days: 30},
+			{ProjectID: "p2", Entity: "events", Days: 7},
+		},
+		expired: map[string]int64{
+			"p1/traces":   retentionBatchSize + 5,
+			"p1/sessions": 2,
+			"p2/events":   0,
+		},
+		before: make(map[string]time.Time),
+	}
+
+	result, err := NewRetentionPurgeProcessor(db, nil).Process(context.Background(), &Job{Type: JobTypePurgeRetention})
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if !result.Success {
+		t.Fatalf("expected success; got %s", result.Error)
+	}
+
+	purged := result.Data.(map[string]interface{})["purged"].(map[string]int64)
+	if purged["traces"] != retentionBatchSize+5 || purged["sessions"] != 2 || purged["events"] != 0 || purged["webhook_deliveries"] != 3 {
+		t.Errorf("unexpected purged counts %v", purged)
+	}
+
+	// Two batches of traces, then sessions once the traces are gone.
+	want := []string{"p1/traces", "p1/traces", "p1/sessions", "p2/events"}
+	if len(db.calls) != len(want) {
+		t.Fatalf("expected calls %v; got %v", want, db.calls)
+	}
+	for i := range want {
+		if db.calls[i] != want[i] {
+			t.Errorf("expected calls %v; got %v", want, db.calls)
+			break
+		}
+	}
+
+	if age := time.Since(db.before["p2/events"]); age < 7*24*time.Hour || age > 7*24*time.Hour+time.Minute {
+		t.Errorf("expected a 7 day cutoff; got %v", age)
+	}
+	if age := time.Since(db.before["webhook_deliveries"]); age < webhookDeliveryRetention || age > webhookDeliveryRetention+time.Minute {
+		t.Errorf("expected a %v cutoff for webhook deliveries; got %v", webhookDeliveryRetention, age)
+	}
+}
+
+// enrichDB holds one project's enrichment settings and the enrichment stored
+// per trace. Traces not in stored aren't in the database yet.
+type enrichDB struct {
+	database.Service
+	settings *database.EnrichmentSettings
+	stored   map[string]map[string]any
+	missing  bool
+}
+
+func (f *enrichDB) GetEnrichmentSettings(projectID string) (*database.EnrichmentSettings, error) {
+	return f.settings, nil
+}
+
+func (f *enrichDB) SetTraceEnrichment(projectID, traceID string, enrichment map[string]any) error {
+	if f.missing {
+		return database.ErrTraceNotFound
+	}
+	if f.stored == nil {
+		f.stored = make(map[string]map[string]any)
+	}
+	f.stored[projectID+"/"+traceID] = enrichment
+	return nil
+}
+
+func enrichJob(t *testing.T) *Job {
+	t.Helper()
+
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(1500 * time.Millisecond)
+	job, err := newJob(context.Background(), QueueMedium, EnrichTracePayload{
+		ProjectID: "p1",
+		TraceID:   "t1",
+		TraceData: &database.TraceRequest{ID: "t1", ProjectID: "p1", StartTime: start, EndTime: &end},
+		Client:    enrich.Client{IP: "81.2.69.160", UserAgent: "langlite-python/1.2.0"},
+	})
+	if err != nil {
+		t.Fatalf("newJob: %v", err)
+	}
+	return job
+}
+
+func TestEnrichTraceProcessor(t *testing.T) {
+	geo, err := enrich.LoadGeoIP(strings.NewReader("81.2.69.0,81.2.69.255,GB\n"))
+	if err != nil {
+		t.Fatalf("LoadGeoIP: %v", err)
+	}
+	enrichers := enrich.DefaultRegistry(geo)
+
+	t.Run("every enricher by default", func(t *testing.T) {
+		db := &enrichDB{}
+		result, err := NewEnrichTraceProcessor(db, enrichers).Process(context.Background(), enrichJob(t))
+		if err != nil || !result.Success {
+			t.Fatalf("expected the job to succeed; got %+v, %v", result, err)
+		}
+
+		stored := db.stored["p1/t1"]
+		if stored["duration_ms"] != int64(1500) {
+			t.Errorf("expected duration_ms 1500; got %v", stored["duration_ms"])
+		}
+		if stored["server_received_at"] == nil {
+			t.Error("expected server_received_at to fall back to the job's creation")
+		}
+		if client, _ := stored["client"].(enrich.Client); client.UserAgent != "langlite-python/1.2.0" {
+			t.Errorf("expected the client stored; got %v", stored["client"])
+		}
+		if geo, _ := stored["geo"].(map[string]any); geo["country"] != "GB" {
+			t.Errorf("expected country GB; got %v", stored["geo"])
+		}
+	})
+
+	t.Run("project's enrichers only", func(t *testing.T) {
+		db := &enrichDB{settings: &database.EnrichmentSettings{Enrichers: []string{"geo"}}}
+		result, err := NewEnrichTraceProcessor(db, enrichers).Process(context.Background(), enrichJob(t))
+		if err != nil || !result.Success {
+			t.Fatalf("expected the job to succeed; got %+v, %v", result, err)
+		}
+		if stored := db.stored["p1/t1"]; len(stored) != 1 || stored["geo"] == nil {
+			t.Errorf("expected only geo stored; got %v", stored)
+		}
+	})
+
+	t.Run("enrichment off", func(t *testing.T) {
+		db := &enrichDB{settings: &database.EnrichmentSettings{Enrichers: []string{}}}
+		result, err := NewEnrichTraceProcessor(db, enrichers).Process(context.Background(), enrichJob(t))
+		if err != nil || !result.Success {
+			t.Fatalf("expected the job to succeed; got %+v, %v", result, err)
+		}
+		if len(db.stored) != 0 {
+			t.Errorf("expected nothing stored; got %v", db.stored)
+		}
+	})
+
+	t.Run("trace not stored yet", func(t *testing.T) {
+		db := &enrichDB{missing: true}
+		result, err := NewEnrichTraceProcessor(db, enrichers).Process(context.Background(), enrichJob(t))
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		if result.Success {
+			t.Error("expected the job to fail so it's retried")
+		}
+	})
+}
+
+// hookDB holds one webhook and logs its deliveries, disabling it the way
+// the database does.
+type hookDB struct {
+	database.Service
+	hook       *database.Webhook
+	deliveries []database.WebhookDelivery
+}
+
+func (f *hookDB) GetWebhook(projectID, webhookID string) (*database.Webhook, error) {
+	if f.hook == nil || f.hook.ProjectID != projectID || f.hook.ID != webhookID {
+		return nil, database.ErrWebhookNotFound
+	}
+	hook := *f.hook
+	return &hook, nil
+}
+
+func (f *hookDB) RecordWebhookDelivery(d database.WebhookDelivery, disableAfter int) (bool, error) {
+	f.deliveries = append(f.deliveries, d)
+	if d.Success {
+		f.hook.ConsecutiveFailures = 0
+		return false, nil
+	}
+
+	f.hook.ConsecutiveFailures++
+	if f.hook.Enabled && disableAfter > 0 && f.hook.ConsecutiveFailures >= disableAfter {
+		f.hook.Enabled = false
+		return true, nil
+	}
+	return false, nil
+}
+
+func TestDeliverWebhookProcessor(t *testing.T) {
+	status := http.StatusOK
+	var deliveries int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deliveries++
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	db := &hookDB{hook: &database.Webhook{ID: "w1", ProjectID: "p1", URL: srv.URL, Secret: "whsec_test", Enabled: true}}
+	p := NewDeliverWebhookProcessor(db, webhook.NewDeliverer(time.Second, true), 2)
+
+	deliver := func(eventType string) *JobResult {
+		t.Helper()
+		job, err := newJob(context.Background(), QueueMedium, DeliverWebhookPayload{
+			ProjectID: "p1", WebhookID: "w1", EventID: "e1", EventType: eventType, Body: []byte(`{"id":"e1"}`),
+		})
+		if err != nil {
+			t.Fatalf("newJob: %v", err)
+		}
+		job.Attempts = 1
+		result, err := p.Process(context.Background(), job)
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		return result
+	}
+
+	if result := deliver("event.ingested"); !result.Success {
+		t.Fatalf("expected the delivery to succeed; got %s", result.Error)
+	}
+	if len(db.deliveries) != 1 || !db.deliveries[0].Success || db.deliveries[0].StatusCode != http.StatusOK || db.deliveries[0].Attempt != 1 {
+		t.Errorf("expected the success logged; got %+v", db.deliveries)
+	}
+
+	status = http.StatusServiceUnavailable
+	for i := 0; i < 2; i++ {
+		if result := deliver("event.ingested"); result.Success {
+			t.Fatal("expected a 503 to fail so it's retried")
+		}
+	}
+	if db.hook.Enabled {
+		t.Error("expected two failures in a row to disable the webhook")
+	}
+	if last := db.deliveries[len(db.deliveries)-1]; last.Success || last.StatusCode != http.StatusServiceUnavailable || last.Error == "" {
+		t.Errorf("expected the failure logged; got %+v", last)
+	}
+
+	before := deliveries
+	if result := deliver("event.ingested"); !result.Success || deliveries != before {
+		t.Errorf("expected deliveries to a disabled webhook skipped; got %+v", result)
+	}
+
+	status = http.StatusOK
+	if result := deliver(webhook.EventPing); !result.Success || deliveries != before+1 {
+		t.Errorf("expected a ping delivered to the disabled webhook; got %+v", result)
+	}
+
+	db.hook = nil
+	if result := deliver("event.ingested"); !result.Success {
+		t.Errorf("expected deliveries to a deleted webhook skipped; got %s", result.Error)
+	}
+}
@@ -0,0 +1,610 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// delayedKey is a sorted set of jobs waiting to be enqueued, scored by
+	// when they become ready in Unix seconds: retries and EnqueueAt jobs.
+	delayedKey = "jobs:delayed"
+
+	deadLetterKey = "jobs:dead_letter"
+
+	// trackingKeyPrefix prefixes the JobStatus of each job that hasn't
+	// completed, dead ones included, and completedKeyPrefix each completed
+	// job's JSON.
+	trackingKeyPrefix  = "jobs:tracking:"
+	completedKeyPrefix = "jobs:completed:"
+
+	// entityJobsKeyPrefix prefixes a set of the IDs of the jobs for each
+	// "project:entity".
+	entityJobsKeyPrefix = "jobs:entity:"
+
+	// trackingTTL is how long a job's status is kept after it last
+	// changed, and completedTTL how long a completed job is kept.
+	trackingTTL  = 24 * time.Hour
+	completedTTL = time.Hour
+
+	// pendingByProjectKey is a hash of how many items each project has
+	// waiting in the store_raw queues. Pushes update it in the same script
+	// as the queue; see reconcilePendingScript for pops.
+	pendingByProjectKey = "jobs:pending_by_project"
+
+	// workerRegistryKey is a sorted set of worker IDs scored by when their
+	// pool last reported them, in Unix seconds.
+	workerRegistryKey = "workers:registry"
+
+	// workerInfoKeyPrefix prefixes each worker's WorkerInfo JSON.
+	workerInfoKeyPrefix = "workers:info:"
+
+	lockKeyPrefix = "locks:"
+
+	// scheduleRunsKey is a hash of each schedule's last run, as RFC 3339.
+	scheduleRunsKey = "schedules:last_run"
+)
+
+// pushScript pushes the job ARGV[1] onto the queue KEYS[1], at the head
+// when ARGV[3] is "front", and counts it as waiting for project ARGV[2] in
+// the hash KEYS[2] unless ARGV[2] is empty.
+var pushScript = redis.NewScript(`
+if ARGV[3] == "front" then
+	redis.call("RPUSH", KEYS[1], ARGV[1])
+else
+	redis.call("LPUSH", KEYS[1], ARGV[1])
+end
+if ARGV[2] ~= "" then
+	redis.call("HINCRBY", KEYS[2], ARGV[2], 1)
+end
+return 1
+`)
+
+// releaseScript moves the job ARGV[1] from the delayed set KEYS[1] onto the
+// queue KEYS[2], counting it as pushScript does in KEYS[3]. It returns 0 if
+// another worker moved the job first.
+var releaseScript = redis.NewScript(`
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("LPUSH", KEYS[2], ARGV[1])
+if ARGV[2] ~= "" then
+	redis.call("HINCRBY", KEYS[3], ARGV[2], 1)
+end
+return 1
+`)
+
+// reconcilePendingScript rebuilds the hash KEYS[#KEYS] from the jobs in the
+// queues KEYS[1..#KEYS-1]. Pop can't decrement the count in the same step as
+// BRPOP, so a worker that dies in between would leave it too high for good;
+// rebuilding it now and then puts it right.
+var reconcilePendingScript = redis.NewScript(`
+local counts = {}
+for i = 1, #KEYS - 1 do
+	for _, raw in ipairs(redis.call("LRANGE", KEYS[i], 0, -1)) do
+		local ok, job = pcall(cjson.decode, raw)
+		if ok and type(job.project_id) == "string" and job.project_id ~= "" then
+			counts[job.project_id] = (counts[job.project_id] or 0) + 1
+		end
+	end
+end
+local hash = KEYS[#KEYS]
+redis.call("DEL", hash)
+for project, n in pairs(counts) do
+	redis.call("HSET", hash, project, n)
+end
+return 1
+`)
+
+// RedisBackend keeps each queue in a Redis list, pushing on the left and
+// popping from the right.
+type RedisBackend struct {
+	redis *redis.Client
+}
+
+func NewRedisBackend(redisClient *redis.Client) *RedisBackend {
+	return &RedisBackend{redis: redisClient}
+}
+
+func (b *RedisBackend) Push(ctx context.Context, job *Job) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job: %w", err)
+	}
+
+	if err := b.push(ctx, job, jobJSON, false); err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	b.track(ctx, job, JobStatePending)
+	return nil
+}
+
+func (b *RedisBackend) PushFront(ctx context.Context, job *Job) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job: %w", err)
+	}
+
+	// Workers BRPOP from the right, so RPUSH puts the job at the head.
+	if err := b.push(ctx, job, jobJSON, true); err != nil {
+		return fmt.Errorf("failed to requeue job %s: %w", job.ID, err)
+	}
+	b.setState(ctx, job, JobStatePending)
+	return nil
+}
+
+func (b *RedisBackend) PushDelayed(ctx context.Context, job *Job, at time.Time) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job: %w", err)
+	}
+
+	err = b.redis.ZAdd(ctx, delayedKey, redis.Z{Score: float64(at.Unix()), Member: jobJSON}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to schedule job: %w", err)
+	}
+	if job.Attempts == 0 {
+		b.track(ctx, job, JobStateDelayed)
+	} else {
+		b.setState(ctx, job, JobStateDelayed)
+	}
+	return nil
+}
+
+// track starts tracking a new job's status under jobs:tracking, and indexes
+// it under its entity. A failure doesn't fail the enqueue.
+func (b *RedisBackend) track(ctx context.Context, job *Job, state JobState) {
+	b.setState(ctx, job, state)
+	if job.EntityID == "" {
+		return
+	}
+
+	key := entityJobsKey(job.ProjectID, job.EntityID)
+	pipe := b.redis.Pipeline()
+	pipe.SAdd(ctx, key, job.ID)
+	pipe.Expire(ctx, key, trackingTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to index job by entity", "job_id", job.ID, "error", err)
+	}
+}
+
+// setState records the job's status under jobs:tracking. Like tracking, a
+// failure doesn't fail the queue operation.
+func (b *RedisBackend) setState(ctx context.Context, job *Job, state JobState) {
+	statusJSON, err := json.Marshal(newJobStatus(job, state))
+	if err == nil {
+		err = b.redis.Set(ctx, trackingKeyPrefix+job.ID, statusJSON, trackingTTL).Err()
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to track job", "job_id", job.ID, "state", state, "error", err)
+	}
+}
+
+func entityJobsKey(projectID, entityID string) string {
+	return entityJobsKeyPrefix + projectID + ":" + entityID
+}
+
+// push adds jobJSON to its queue, at the head if front is set, and counts it
+// towards its project's waiting items in the same step.
+func (b *RedisBackend) push(ctx context.Context, job *Job, jobJSON string, front bool) error {
+	direction := "back"
+	if front {
+		direction = "front"
+	}
+	keys := []string{GetQueueName(job.Type, job.Priority), pendingByProjectKey}
+	return pushScript.Run(ctx, b.redis, keys, jobJSON, pendingProject(job), direction).Err()
+}
+
+// pendingProject is the project whose waiting items job counts towards, or
+// "" if it doesn't count.
+func pendingProject(job *Job) string {
+	if !countsAsPending(job) {
+		return ""
+	}
+	return job.ProjectID
+}
+
+func (b *RedisBackend) Pop(ctx context.Context, queueNames []string, timeout time.Duration) (*Job, error) {
+	// use BRPOP to block until a job is available
+	result, err := b.redis.BRPop(ctx, timeout, queueNames...).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil // No job available within timeout
+		}
+		return nil, fmt.Errorf("failed to dequeue job: %w", err)
+	}
+
+	// result[0] is the queue name, result[1] is the job JSON
+	job, err := FromJSON(result[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize job: %w", err)
+	}
+	if project := pendingProject(job); project != "" {
+		// The job is already off the queue, so the count must follow even if
+		// ctx is done. Should this fail anyway, ReconcilePending corrects it.
+		err := b.redis.HIncrBy(context.WithoutCancel(ctx), pendingByProjectKey, project, -1).Err()
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to track pending items", "job_id", job.ID, "error", err)
+		}
+	}
+	b.setState(ctx, job, JobStateProcessing)
+
+	return job, nil
+}
+
+func (b *RedisBackend) Complete(ctx context.Context, job *Job) error {
+	err := b.redis.Del(ctx, trackingKeyPrefix+job.ID).Err()
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to remove job from tracking", "error", err)
+	}
+
+	jobJSON, _ := job.ToJSON()
+	err = b.redis.Set(ctx, completedKeyPrefix+job.ID, jobJSON, completedTTL).Err()
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to add job to completed", "error", err)
+	}
+
+	return nil
+}
+
+func (b *RedisBackend) Bury(ctx context.Context, job *Job) error {
+	jobJSON, err := job.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize job for dead letter: %w", err)
+	}
+
+	err = b.redis.LPush(ctx, deadLetterKey, jobJSON).Err()
+	if err != nil {
+		return fmt.Errorf("failed to move job to dead letter queue: %w", err)
+	}
+
+	// Dead jobs stay tracked, so their error can be looked up.
+	b.setState(ctx, job, JobStateDead)
+
+	return nil
+}
+
+func (b *RedisBackend) ReleaseDelayed(ctx context.Context, now time.Time) (int, error) {
+	jobs, err := b.redis.ZRangeByScore(ctx, delayedKey, &redis.ZRangeBy{
+		Min: "0",
+		Max: strconv.FormatInt(now.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get delayed jobs: %w", err)
+	}
+
+	released := 0
+	for _, jobJSON := range jobs {
+		job, err := FromJSON(jobJSON)
+		if err != nil {
+			continue
+		}
+
+		// Every worker runs this loop; whoever removes the job moves it.
+		keys := []string{delayedKey, GetQueueName(job.Type, job.Priority), pendingByProjectKey}
+		moved, err := releaseScript.Run(ctx, b.redis, keys, jobJSON, pendingProject(job)).Int()
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to enqueue delayed job", "job_id", job.ID, "error", err)
+			continue
+		}
+		if moved == 0 {
+			continue
+		}
+		b.setState(ctx, job, JobStatePending)
+		released++
+	}
+
+	return released, nil
+}
+
+// Promote resolves workers racing for the same job with WATCH, so a job is
+// never moved twice or lost.
+func (b *RedisBackend) Promote(ctx context.Context, from, to string, cutoff time.Time, limit int) (int, error) {
+	// Enqueues touch the queue too and fail the transaction, so give up
+	// after a while and let the next round carry on.
+	promoted := 0
+	for attempts := 0; promoted < limit && attempts < 2*limit; attempts++ {
+		moved := false
+		err := b.redis.Watch(ctx, func(tx *redis.Tx) error {
+			// Workers BRPOP from the right, so the oldest job is last.
+			jobJSON, err := tx.LIndex(ctx, from, -1).Result()
+			if err == redis.Nil {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			job, err := FromJSON(jobJSON)
+			if err != nil || !job.ReadyAt().Before(cutoff) {
+				return nil
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.RPop(ctx, from)
+				pipe.RPush(ctx, to, jobJSON)
+				return nil
+			})
+			moved = err == nil
+			return err
+		}, from)
+
+		if errors.Is(err, redis.TxFailedErr) {
+			// A worker took the job first; try the new oldest one.
+			continue
+		}
+		if err != nil {
+			return promoted, fmt.Errorf("failed to promote jobs from %s: %w", from, err)
+		}
+		if !moved {
+			return promoted, nil
+		}
+		promoted++
+	}
+	return promoted, nil
+}
+
+func (b *RedisBackend) Stats(ctx context.Context, queueNames []string) (map[string]int64, error) {
+	pipe := b.redis.Pipeline()
+
+	lengths := make([]*redis.IntCmd, len(queueNames))
+	for i, queueName := range queueNames {
+		lengths[i] = pipe.LLen(ctx, queueName)
+	}
+	deadLetters := pipe.LLen(ctx, deadLetterKey)
+	delayed := pipe.ZCard(ctx, delayedKey)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get queue lengths: %w", err)
+	}
+
+	stats := make(map[string]int64, len(queueNames)+2)
+	for i, queueName := range queueNames {
+		stats[queueName] = lengths[i].Val()
+	}
+	stats["dead_letter"] = deadLetters.Val()
+	stats["delayed"] = delayed.Val()
+	return stats, nil
+}
+
+func (b *RedisBackend) PendingByProject(ctx context.Context) (map[string]int64, error) {
+	values, err := b.redis.HGetAll(ctx, pendingByProjectKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending jobs by project: %w", err)
+	}
+
+	pending := make(map[string]int64, len(values))
+	for projectID, value := range values {
+		n, err := strconv.ParseInt(value, 10, 64)
+		// Counts can dip below zero for jobs enqueued before they were
+		// tracked, until the next ReconcilePending.
+		if err != nil || n <= 0 {
+			continue
+		}
+		pending[projectID] = n
+	}
+	return pending, nil
+}
+
+// ReconcilePending rebuilds the count of each project's waiting items from
+// the store_raw queues, in one step.
+func (b *RedisBackend) ReconcilePending(ctx context.Context) error {
+	var keys []string
+	for _, priority := range queuePriorities {
+		keys = append(keys, GetQueueName(JobTypeStoreRaw, priority))
+	}
+	keys = append(keys, pendingByProjectKey)
+
+	if err := reconcilePendingScript.Run(ctx, b.redis, keys).Err(); err != nil {
+		return fmt.Errorf("failed to reconcile pending items: %w", err)
+	}
+	return nil
+}
+
+// JobStatuses leaves out jobs whose tracking expired: completed ones after an
+// hour, others a day after they last changed.
+func (b *RedisBackend) JobStatuses(ctx context.Context, projectID, entityID string) ([]JobStatus, error) {
+	ids, err := b.redis.SMembers(ctx, entityJobsKey(projectID, entityID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the entity's jobs: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	pipe := b.redis.Pipeline()
+	completed := make([]*redis.StringCmd, len(ids))
+	tracked := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		completed[i] = pipe.Get(ctx, completedKeyPrefix+id)
+		tracked[i] = pipe.Get(ctx, trackingKeyPrefix+id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to get job statuses: %w", err)
+	}
+
+	var statuses []JobStatus
+	for i := range ids {
+		if jobJSON, err := completed[i].Result(); err == nil {
+			job, err := FromJSON(jobJSON)
+			if err != nil {
+				continue
+			}
+			statuses = append(statuses, completedJobStatus(job))
+			continue
+		}
+
+		statusJSON, err := tracked[i].Result()
+		if err != nil {
+			continue
+		}
+		var status JobStatus
+		if err := json.Unmarshal([]byte(statusJSON), &status); err != nil {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (b *RedisBackend) RegisterWorkers(ctx context.Context, workers []WorkerInfo) error {
+	pipe := b.redis.Pipeline()
+	for _, worker := range workers {
+		data, err := json.Marshal(worker)
+		if err != nil {
+			return fmt.Errorf("failed to serialize worker %s: %w", worker.ID, err)
+		}
+		pipe.Set(ctx, workerInfoKeyPrefix+worker.ID, data, workerRegistryTTL)
+		pipe.ZAdd(ctx, workerRegistryKey, redis.Z{Score: float64(worker.ReportedAt.Unix()), Member: worker.ID})
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to register workers: %w", err)
+	}
+	return nil
+}
+
+func (b *RedisBackend) DeregisterWorkers(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(ids))
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		keys[i] = workerInfoKeyPrefix + id
+		members[i] = id
+	}
+
+	pipe := b.redis.Pipeline()
+	pipe.Del(ctx, keys...)
+	pipe.ZRem(ctx, workerRegistryKey, members...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to deregister workers: %w", err)
+	}
+	return nil
+}
+
+func (b *RedisBackend) Workers(ctx context.Context) ([]WorkerInfo, error) {
+	cutoff := time.Now().Add(-workerRegistryTTL).Unix()
+	if err := b.redis.ZRemRangeByScore(ctx, workerRegistryKey, "-inf", fmt.Sprintf("(%d", cutoff)).Err(); err != nil {
+		return nil, fmt.Errorf("failed to prune worker registry: %w", err)
+	}
+
+	ids, err := b.redis.ZRange(ctx, workerRegistryKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workers: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = workerInfoKeyPrefix + id
+	}
+	values, err := b.redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workers: %w", err)
+	}
+
+	workers := make([]WorkerInfo, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// Expired between ZRANGE and MGET.
+			continue
+		}
+		var worker WorkerInfo
+		if err := json.Unmarshal([]byte(data), &worker); err != nil {
+			continue
+		}
+		workers = append(workers, worker)
+	}
+	return workers, nil
+}
+
+// renewScript extends a lock only if the token still holds it.
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// unlockScript deletes a lock only if the token still holds it.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (b *RedisBackend) TryLock(ctx context.Context, name, token string, ttl time.Duration) (bool, error) {
+	key := lockKeyPrefix + name
+
+	acquired, err := b.redis.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to take lock %s: %w", name, err)
+	}
+	if acquired {
+		return true, nil
+	}
+
+	renewed, err := renewScript.Run(ctx, b.redis, []string{key}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew lock %s: %w", name, err)
+	}
+	return renewed == 1, nil
+}
+
+func (b *RedisBackend) Unlock(ctx context.Context, name, token string) error {
+	if err := unlockScript.Run(ctx, b.redis, []string{lockKeyPrefix + name}, token).Err(); err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", name, err)
+	}
+	return nil
+}
+
+func (b *RedisBackend) LastRun(ctx context.Context, schedule string) (time.Time, error) {
+	value, err := b.redis.HGet(ctx, scheduleRunsKey, schedule).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get last run of %s: %w", schedule, err)
+	}
+
+	lastRun, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid last run of %s: %w", schedule, err)
+	}
+	return lastRun, nil
+}
+
+// advanceScript sets a schedule's last run if it's still ARGV[1], where ""
+// means it has none.
+var advanceScript = redis.NewScript(`
+local last = redis.call("HGET", KEYS[1], ARGV[1])
+if (last == false and ARGV[2] == "") or last == ARGV[2] then
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+	return 1
+end
+return 0`)
+
+func (b *RedisBackend) AdvanceRun(ctx context.Context, schedule string, from, to time.Time) (bool, error) {
+	var fromValue string
+	if !from.IsZero() {
+		fromValue = from.UTC().Format(time.RFC3339)
+	}
+
+	advanced, err := advanceScript.Run(ctx, b.redis, []string{scheduleRunsKey}, schedule, fromValue, to.UTC().Format(time.RFC3339)).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to record run of %s: %w", schedule, err)
+	}
+	return advanced == 1, nil
+}
@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AnyJobType keys the workers in a Concurrency that take every job type.
+const AnyJobType JobType = "*"
+
+// Concurrency is how many workers poll the queues of each job type. Workers
+// under AnyJobType take every type; giving a type workers of its own keeps a
+// flood of other jobs from starving it.
+type Concurrency map[JobType]int
+
+// DefaultConcurrency runs three workers that take every job type.
+var DefaultConcurrency = Concurrency{AnyJobType: 3}
+
+// ParseConcurrency parses a comma-separated list of type=count pairs, e.g.
+// "*=2,store_raw=8,enrich_trace=4".
+func ParseConcurrency(s string) (Concurrency, error) {
+	concurrency := make(Concurrency)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, count, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid concurrency %q: expected type=count", pair)
+		}
+
+		jobType := JobType(strings.TrimSpace(name))
+		if jobType != AnyJobType && !isQueuedJobType(jobType) {
+			return nil, fmt.Errorf("invalid concurrency %q: unknown job type %q", pair, jobType)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid concurrency %q: count must be a non-negative integer", pair)
+		}
+		concurrency[jobType] = n
+	}
+
+	if concurrency.Total() == 0 {
+		return nil, fmt.Errorf("invalid concurrency %q: no workers", s)
+	}
+	return concurrency, nil
+}
+
+// Total is the number of workers across all job types.
+func (c Concurrency) Total() int {
+	var total int
+	for _, n := range c {
+		total += n
+	}
+	return total
+}
+
+func isQueuedJobType(jobType JobType) bool {
+	for _, t := range queuedJobTypes {
+		if t == jobType {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// workerHeartbeatInterval is how often a pool reports its workers.
+	workerHeartbeatInterval = 15 * time.Second
+
+	// workerRegistryTTL is how long a worker stays listed after its pool's
+	// last report, so workers of a crashed process drop out on their own.
+	workerRegistryTTL = time.Minute
+)
+
+// WorkerInfo is what a worker pool publishes about each of its workers, so
+// any instance can list the workers of the whole cluster.
+type WorkerInfo struct {
+	ID       string    `json:"id"`
+	Instance string    `json:"instance"`
+	JobTypes []JobType `json:"job_types"`
+	Running  bool      `json:"running"`
+
+	StartedAt  time.Time `json:"started_at"`
+	ReportedAt time.Time `json:"reported_at"`
+
+	// LastHeartbeat is when the worker last polled the queue or finished a
+	// job; it lags ReportedAt while the worker runs a long job.
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+
+	CurrentJobID   string  `json:"current_job_id,omitempty"`
+	CurrentJobType JobType `json:"current_job_type,omitempty"`
+}
+
+// instanceID names this process in worker IDs: the hostname, which is the
+// pod name under Kubernetes, plus a random suffix to tell restarts apart.
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "worker"
+	}
+	return host + "-" + uuid.NewString()[:8]
+}
+
+// GetWorkerStats reports the queue lengths and every worker in the cluster.
+func (c *Client) GetWorkerStats(ctx context.Context) (map[string]interface{}, error) {
+	queueStats, err := c.GetQueueStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	workers, err := c.Workers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make(map[string]bool)
+	for _, worker := range workers {
+		instances[worker.Instance] = true
+	}
+
+	return map[string]interface{}{
+		"worker_count":   len(workers),
+		"instance_count": len(instances),
+		"queue_stats":    queueStats,
+		"workers":        workers,
+	}, nil
+}
@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseConcurrency(t *testing.T) {
+	got, err := ParseConcurrency(" *=2, store_raw=8,enrich_trace=0")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got[AnyJobType] != 2 || got[JobTypeStoreRaw] != 8 || got.Total() != 10 {
+		t.Errorf("unexpected concurrency %v", got)
+	}
+
+	for _, invalid := range []string{"", "store_raw", "store_raw=-1", "resize_images=2", "*=0"} {
+		if _, err := ParseConcurrency(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestWorkerPoolConcurrency(t *testing.T) {
+	pool := NewWorkerPool(nil, nil, nil, Concurrency{AnyJobType: 1, JobTypeStoreRaw: 2})
+	if len(pool.workers) != 3 {
+		t.Fatalf("expected 3 workers; got %d", len(pool.workers))
+	}
+
+	if !slices.Equal(pool.workers[0].jobTypes, queuedJobTypes) {
+		t.Errorf("expected the first worker to take every type; got %v", pool.workers[0].jobTypes)
+	}
+	for _, worker := range pool.workers[1:] {
+		if !slices.Equal(worker.jobTypes, []JobType{JobTypeStoreRaw}) {
+			t.Errorf("expected %s to take store_raw only; got %v", worker.id, worker.jobTypes)
+		}
+	}
+}
+
+func TestWorkerRegistry(t *testing.T) {
+	ctx := context.Background()
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	client := NewClient(NewRedisBackend(rdb))
+
+	// A cancelled context makes the workers exit at once, while the pools
+	// keep reporting them.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	api := NewWorkerPool(client, nil, nil, Concurrency{AnyJobType: 1})
+	workers := NewWorkerPool(client, nil, nil, Concurrency{JobTypeEnrichTrace: 2})
+	api.Start(cancelled)
+	defer api.Stop()
+	workers.Start(cancelled)
+
+	// A worker from a process that died without deregistering.
+	stale := WorkerInfo{ID: "gone-any-1", Instance: "gone", ReportedAt: time.Now().Add(-2 * workerRegistryTTL)}
+	if err := client.RegisterWorkers(ctx, []WorkerInfo{stale}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	var listed []WorkerInfo
+	deadline := time.Now().Add(5 * time.Second)
+	for len(listed) < 3 && time.Now().Before(deadline) {
+		var err error
+		if listed, err = client.Workers(ctx); err != nil {
+			t.Fatalf("list workers: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(listed) != 3 {
+		t.Fatalf("expected the workers of both pools; got %+v", listed)
+	}
+	for _, info := range listed {
+		if info.Instance == "gone" {
+			t.Errorf("expected the stale worker to be pruned")
+		}
+		if info.Instance == workers.instance && !slices.Equal(info.JobTypes, []JobType{JobTypeEnrichTrace}) {
+			t.Errorf("expected %s to report its job type; got %v", info.ID, info.JobTypes)
+		}
+	}
+
+	if err := workers.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	stats, err := client.GetWorkerStats(ctx)
+	if err != nil {
+		t.Fatalf("worker stats: %v", err)
+	}
+	if stats["worker_count"] != 1 || stats["instance_count"] != 1 {
+		t.Errorf("expected only the remaining pool's worker; got %v", stats)
+	}
+}
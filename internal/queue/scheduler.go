@@ -0,0 +1,212 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// agingInterval is how often a pool promotes jobs that waited too long.
+	agingInterval = 10 * time.Second
+
+	// promoteBatch bounds how many jobs one queue promotes per round.
+	promoteBatch = 100
+)
+
+// Weights sets how often workers take from each queue while several have
+// jobs waiting. A queue's weight is its priority's weight times its job
+// type's, which defaults to 1.
+type Weights struct {
+	Priority map[QueuePriority]float64
+	JobType  map[JobType]float64
+}
+
+// DefaultWeights takes high, medium and low priority jobs 70/20/10.
+var DefaultWeights = Weights{
+	Priority: map[QueuePriority]float64{QueueHigh: 70, QueueMedium: 20, QueueLow: 10},
+}
+
+// Aging promotes a job one priority up once it has waited this long in a
+// medium or low priority queue.
+type Aging map[QueuePriority]time.Duration
+
+// DefaultAging promotes medium jobs after two minutes and low jobs after
+// five.
+var DefaultAging = Aging{QueueMedium: 2 * time.Minute, QueueLow: 5 * time.Minute}
+
+// ParseWeights parses a comma-separated list of name=weight pairs, where a
+// name is a priority or a job type, e.g. "high=70,medium=20,low=10" or
+// "high=6,medium=3,low=1,analytics_export=2". Unnamed priorities keep their
+// default weight.
+func ParseWeights(s string) (Weights, error) {
+	weights := Weights{
+		Priority: make(map[QueuePriority]float64, len(DefaultWeights.Priority)),
+		JobType:  make(map[JobType]float64),
+	}
+	for priority, weight := range DefaultWeights.Priority {
+		weights.Priority[priority] = weight
+	}
+
+	err := parsePairs(s, func(name, value string) error {
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight < 0 || math.IsInf(weight, 0) || math.IsNaN(weight) {
+			return errors.New("weight must be a non-negative number")
+		}
+
+		switch {
+		case isQueuePriority(QueuePriority(name)):
+			weights.Priority[QueuePriority(name)] = weight
+		case isQueuedJobType(JobType(name)):
+			weights.JobType[JobType(name)] = weight
+		default:
+			return fmt.Errorf("unknown priority or job type %q", name)
+		}
+		return nil
+	})
+	if err != nil {
+		return Weights{}, err
+	}
+	return weights, nil
+}
+
+// ParseAging parses a comma-separated list of priority=duration pairs, e.g.
+// "medium=1m,low=10m". A duration of 0 stops that priority being promoted.
+// Unnamed priorities keep their default.
+func ParseAging(s string) (Aging, error) {
+	aging := make(Aging, len(DefaultAging))
+	for priority, after := range DefaultAging {
+		aging[priority] = after
+	}
+	err := parsePairs(s, func(name, value string) error {
+		priority := QueuePriority(name)
+		if priority != QueueMedium && priority != QueueLow {
+			return fmt.Errorf("only medium and low priority jobs can be promoted, not %q", name)
+		}
+
+		after, err := time.ParseDuration(value)
+		if err != nil || after < 0 {
+			return errors.New("must be a non-negative duration")
+		}
+		aging[priority] = after
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return aging, nil
+}
+
+// parsePairs calls fn for each name=value pair in a comma-separated list.
+func parsePairs(s string, fn func(name, value string) error) error {
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid pair %q: expected name=value", pair)
+		}
+		if err := fn(strings.TrimSpace(name), strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("invalid pair %q: %w", pair, err)
+		}
+	}
+	return nil
+}
+
+func isQueuePriority(priority QueuePriority) bool {
+	for _, p := range queuePriorities {
+		if p == priority {
+			return true
+		}
+	}
+	return false
+}
+
+// Scheduler decides which queue each dequeue takes from, so lower priorities
+// get a share of the workers instead of waiting until every higher priority
+// queue is empty.
+type Scheduler struct {
+	weights Weights
+	aging   Aging
+}
+
+func NewScheduler(weights Weights, aging Aging) *Scheduler {
+	return &Scheduler{weights: weights, aging: aging}
+}
+
+func (s *Scheduler) weight(priority QueuePriority, jobType JobType) float64 {
+	weight := s.weights.Priority[priority]
+	if typeWeight, ok := s.weights.JobType[jobType]; ok {
+		weight *= typeWeight
+	}
+	return weight
+}
+
+// order returns the queues for jobTypes in a weighted random order. BRPOP
+// takes from the first one that has a job, so while every queue has work
+// each is picked in proportion to its weight, and an empty queue passes
+// its turn on to the rest. Queues weighted 0 come last, in priority order.
+func (s *Scheduler) order(jobTypes []JobType) []string {
+	type candidate struct {
+		name string
+		key  float64
+	}
+
+	var weighted []candidate
+	var unweighted []string
+	for _, priority := range queuePriorities {
+		for _, jobType := range jobTypes {
+			name := GetQueueName(jobType, priority)
+			weight := s.weight(priority, jobType)
+			if weight <= 0 {
+				unweighted = append(unweighted, name)
+				continue
+			}
+			// Efraimidis-Spirakis: sorting by u^(1/w) samples without
+			// replacement in proportion to the weights.
+			weighted = append(weighted, candidate{name: name, key: math.Pow(rand.Float64(), 1/weight)})
+		}
+	}
+
+	sort.Slice(weighted, func(i, j int) bool { return weighted[i].key > weighted[j].key })
+
+	names := make([]string, 0, len(weighted)+len(unweighted))
+	for _, c := range weighted {
+		names = append(names, c.name)
+	}
+	return append(names, unweighted...)
+}
+
+// promote moves jobs that waited longer than their priority's aging limit
+// one priority up.
+func (s *Scheduler) promote(ctx context.Context, client *Client, m Recorder) {
+	for i, priority := range queuePriorities {
+		after := s.aging[priority]
+		if i == 0 || after <= 0 {
+			continue
+		}
+
+		for _, jobType := range queuedJobTypes {
+			promoted, err := client.PromoteAged(ctx, jobType, priority, queuePriorities[i-1], after)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.ErrorContext(ctx, "Failed to promote aged jobs", "job_type", jobType, "priority", priority, "error", err)
+				}
+				continue
+			}
+			if promoted > 0 && m != nil {
+				m.RecordQueuePromotions(string(priority), string(jobType), promoted)
+			}
+		}
+	}
+}
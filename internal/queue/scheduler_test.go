@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseWeights(t *testing.T) {
+	weights, err := ParseWeights("high=6, low=1,analytics_export=2")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if weights.Priority[QueueHigh] != 6 || weights.Priority[QueueMedium] != 20 || weights.Priority[QueueLow] != 1 {
+		t.Errorf("unexpected priority weights %v", weights.Priority)
+	}
+	if weights.JobType[JobTypeAnalyticsExport] != 2 {
+		t.Errorf("unexpected job type weights %v", weights.JobType)
+	}
+
+	for _, invalid := range []string{"high", "urgent=1", "high=-1", "high=abc", "*=2"} {
+		if _, err := ParseWeights(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestParseAging(t *testing.T) {
+	aging, err := ParseAging("medium=1m")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if aging[QueueMedium] != time.Minute || aging[QueueLow] != DefaultAging[QueueLow] {
+		t.Errorf("unexpected aging %v", aging)
+	}
+
+	for _, invalid := range []string{"high=1m", "low=-1s", "low=soon"} {
+		if _, err := ParseAging(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	s := NewScheduler(Weights{
+		Priority: map[QueuePriority]float64{QueueHigh: 70, QueueMedium: 20, QueueLow: 10},
+		JobType:  map[JobType]float64{JobTypeStoreRaw: 0},
+	}, nil)
+	jobTypes := []JobType{JobTypeAnalyticsExport, JobTypeStoreRaw}
+
+	const rounds = 10000
+	first := make(map[string]int)
+	for range rounds {
+		order := s.order(jobTypes)
+		if len(order) != 6 {
+			t.Fatalf("expected every queue in the order; got %v", order)
+		}
+		// Queues weighted 0 only run once the others are empty.
+		if order[3] != "high:store_raw" || order[4] != "medium:store_raw" || order[5] != "low:store_raw" {
+			t.Fatalf("expected unweighted queues last in priority order; got %v", order)
+		}
+		first[order[0]]++
+	}
+
+	for queueName, want := range map[string]float64{
+		"high:analytics_export":   0.7,
+		"medium:analytics_export": 0.2,
+		"low:analytics_export":    0.1,
+	} {
+		if got := float64(first[queueName]) / rounds; math.Abs(got-want) > 0.03 {
+			t.Errorf("expected %s first %.0f%% of the time; got %.1f%%", queueName, want*100, got*100)
+		}
+	}
+}
+
+func TestPromoteAged(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	client := NewClient(NewRedisBackend(rdb))
+	ctx := context.Background()
+
+	old, err := client.Enqueue(ctx, QueueLow, AnalyticsExportPayload{})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	// Backdate the job so it's due for promotion.
+	old.EnqueuedAt = old.EnqueuedAt.Add(-10 * time.Minute)
+	oldJSON, _ := old.ToJSON()
+	rdb.LSet(ctx, "low:analytics_export", 0, oldJSON)
+
+	if _, err := client.Enqueue(ctx, QueueLow, AnalyticsExportPayload{}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	NewScheduler(DefaultWeights, DefaultAging).promote(ctx, client, nil)
+
+	if n := rdb.LLen(ctx, "low:analytics_export").Val(); n != 1 {
+		t.Errorf("expected the recent job to stay low priority; %d left", n)
+	}
+	job, err := client.Dequeue(ctx, []JobType{JobTypeAnalyticsExport}, time.Second)
+	if err != nil || job == nil {
+		t.Fatalf("dequeue: %v", err)
+	}
+	if job.ID != old.ID {
+		t.Errorf("expected the old job to be promoted to medium first; got %s", job.ID)
+	}
+}
@@ -0,0 +1,249 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// cronLockName is the lock the instance that fires schedules holds.
+	cronLockName = "cron"
+
+	// cronInterval is how often schedules are checked and the leader lock
+	// renewed.
+	cronInterval = 5 * time.Second
+
+	// cronLeaderTTL is how long the lock outlives its holder's last renewal,
+	// so another instance takes over soon after the leader dies.
+	cronLeaderTTL = 30 * time.Second
+
+	// cronMissedAfter is how late a run can be before it counts as missed.
+	cronMissedAfter = time.Minute
+
+	// cronMaxCatchUp bounds how many missed runs MissedRunAll enqueues.
+	cronMaxCatchUp = 24
+)
+
+// MissedRuns says what a schedule does about runs that came due while no
+// instance was leading, e.g. during a deploy or an outage.
+type MissedRuns int
+
+const (
+	// MissedRunOnce enqueues a single job for all the missed runs.
+	MissedRunOnce MissedRuns = iota
+	// MissedSkip drops missed runs and waits for the next one.
+	MissedSkip
+	// MissedRunAll enqueues a job for each missed run, up to the last
+	// cronMaxCatchUp of them.
+	MissedRunAll
+)
+
+// Schedule enqueues a job carrying Payload whenever Spec comes due. The
+// job's ScheduledFor is the run it stands for.
+type Schedule struct {
+	// Name identifies the schedule across instances and restarts.
+	Name     string
+	Spec     string
+	Priority QueuePriority
+	Payload  Payload
+	Missed   MissedRuns
+}
+
+type schedule struct {
+	Schedule
+	spec Spec
+}
+
+// Cron fires schedules from whichever instance holds the leader lock in the
+// queue's backend, so each run is enqueued once however many instances run
+// a Cron. Runs are tracked in the backend too, so a new leader carries on
+// where the last one stopped and applies each schedule's MissedRuns to what
+// it finds due.
+type Cron struct {
+	client    *Client
+	metrics   Recorder
+	token     string
+	schedules []schedule
+	leading   bool
+}
+
+func NewCron(client *Client, m Recorder) *Cron {
+	return &Cron{client: client, metrics: m, token: instanceID()}
+}
+
+// Add registers a schedule. Call it before Run.
+func (c *Cron) Add(s Schedule) error {
+	if s.Name == "" {
+		return errors.New("schedule needs a name")
+	}
+	if s.Payload == nil {
+		return fmt.Errorf("schedule %q needs a payload", s.Name)
+	}
+	for _, existing := range c.schedules {
+		if existing.Name == s.Name {
+			return fmt.Errorf("schedule %q already exists", s.Name)
+		}
+	}
+
+	spec, err := ParseSpec(s.Spec)
+	if err != nil {
+		return err
+	}
+	if spec.Next(time.Now()).IsZero() {
+		return fmt.Errorf("schedule %q never runs", s.Spec)
+	}
+
+	c.schedules = append(c.schedules, schedule{Schedule: s, spec: spec})
+	return nil
+}
+
+// Run checks for due schedules every cronInterval while this instance leads,
+// and campaigns for the lock while it doesn't, until ctx is done.
+func (c *Cron) Run(ctx context.Context) {
+	if len(c.schedules) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(cronInterval)
+	defer ticker.Stop()
+
+	for {
+		c.tick(ctx, time.Now().UTC())
+
+		select {
+		case <-ctx.Done():
+			c.resign()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Cron) tick(ctx context.Context, now time.Time) {
+	leading, err := c.campaign(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Failed to take the cron leader lock", "error", err)
+		}
+		return
+	}
+	if leading != c.leading {
+		c.leading = leading
+		slog.InfoContext(ctx, "Cron leadership changed", "instance", c.token, "leading", leading)
+	}
+	if !leading {
+		return
+	}
+
+	for _, s := range c.schedules {
+		if err := c.fire(ctx, s, now); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Failed to run schedule", "schedule", s.Name, "error", err)
+		}
+	}
+}
+
+// campaign takes the leader lock if it's free, or renews it if this
+// instance already holds it, and reports whether it does.
+func (c *Cron) campaign(ctx context.Context) (bool, error) {
+	return c.client.backend.TryLock(ctx, cronLockName, c.token, cronLeaderTTL)
+}
+
+// resign releases the lock so another instance takes over without waiting
+// for it to expire.
+func (c *Cron) resign() {
+	if !c.leading {
+		return
+	}
+	c.leading = false
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.client.backend.Unlock(ctx, cronLockName, c.token); err != nil {
+		slog.Warn("Failed to release the cron leader lock", "error", err)
+	}
+}
+
+// fire enqueues the runs of s that came due since its last run, as its
+// MissedRuns allows. A schedule seen for the first time starts from now.
+//
+// The last run is moved on before the jobs are enqueued, so if two
+// instances both think they lead, only one enqueues. A failed enqueue drops
+// the run rather than risk running it twice.
+func (c *Cron) fire(ctx context.Context, s schedule, now time.Time) error {
+	backend := c.client.backend
+
+	last, err := backend.LastRun(ctx, s.Name)
+	if err != nil {
+		return err
+	}
+	if last.IsZero() {
+		_, err := backend.AdvanceRun(ctx, s.Name, last, now)
+		return err
+	}
+
+	due := dueRuns(s.spec, last, now)
+	if len(due) == 0 {
+		return nil
+	}
+
+	advanced, err := backend.AdvanceRun(ctx, s.Name, last, due[len(due)-1])
+	if err != nil || !advanced {
+		return err
+	}
+
+	for _, run := range runsToFire(s.Missed, due, now) {
+		if err := c.enqueue(ctx, s, run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dueRuns returns the runs of spec after last up to now, keeping only the
+// latest cronMaxCatchUp.
+func dueRuns(spec Spec, last, now time.Time) []time.Time {
+	var due []time.Time
+	for run := spec.Next(last); !run.IsZero() && !run.After(now); run = spec.Next(run) {
+		due = append(due, run)
+		if len(due) > cronMaxCatchUp {
+			due = due[1:]
+		}
+	}
+	return due
+}
+
+// runsToFire picks which of the due runs to enqueue. The latest run is
+// never missed unless it's more than cronMissedAfter late.
+func runsToFire(missed MissedRuns, due []time.Time, now time.Time) []time.Time {
+	latest := due[len(due)-1]
+	onTime := now.Sub(latest) <= cronMissedAfter
+
+	switch {
+	case missed == MissedRunAll:
+		return due
+	case missed == MissedSkip && !onTime:
+		return nil
+	default:
+		return due[len(due)-1:]
+	}
+}
+
+func (c *Cron) enqueue(ctx context.Context, s schedule, run time.Time) error {
+	job, err := newJob(ctx, s.Priority, s.Payload)
+	if err != nil {
+		return err
+	}
+	job.ScheduledFor = &run
+
+	if err := c.client.push(ctx, job); err != nil {
+		return err
+	}
+	if c.metrics != nil {
+		c.metrics.RecordScheduledRun(s.Name)
+	}
+	slog.InfoContext(ctx, "Enqueued scheduled job", "schedule", s.Name, "job_id", job.ID, "job_type", job.Type, "scheduled_for", run)
+	return nil
+}
@@ -0,0 +1,155 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestCron(t *testing.T, client *Client, schedules ...Schedule) *Cron {
+	t.Helper()
+
+	cron := NewCron(client, nil)
+	for _, s := range schedules {
+		if err := cron.Add(s); err != nil {
+			t.Fatalf("add %s: %v", s.Name, err)
+		}
+	}
+	return cron
+}
+
+func TestCronLeaderElection(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	client := NewClient(NewRedisBackend(rdb))
+	ctx := context.Background()
+
+	purge := Schedule{Name: "purge", Spec: "@hourly", Priority: QueueLow, Payload: PurgeRetentionPayload{}}
+	a, b := newTestCron(t, client, purge), newTestCron(t, client, purge)
+
+	start := time.Date(2026, 3, 6, 9, 59, 0, 0, time.UTC)
+	a.tick(ctx, start)
+	b.tick(ctx, start)
+	if !a.leading || b.leading {
+		t.Fatalf("expected only the first instance to lead; got %v and %v", a.leading, b.leading)
+	}
+
+	at := start.Add(2 * time.Minute)
+	a.tick(ctx, at)
+	b.tick(ctx, at)
+	if n := rdb.LLen(ctx, "low:purge_retention").Val(); n != 1 {
+		t.Fatalf("expected the leader alone to enqueue the 10:00 run; got %d jobs", n)
+	}
+
+	// Once the leader resigns, the other instance takes over.
+	a.resign()
+	b.tick(ctx, at)
+	if !b.leading {
+		t.Error("expected the second instance to take over")
+	}
+	a.tick(ctx, at)
+	if a.leading {
+		t.Error("expected the first instance to follow")
+	}
+
+	// The lock expires if the leader stops renewing it.
+	mr.FastForward(cronLeaderTTL + time.Second)
+	a.tick(ctx, at)
+	if !a.leading {
+		t.Error("expected the first instance to take over an expired lock")
+	}
+}
+
+func TestCronMissedRuns(t *testing.T) {
+	ctx := context.Background()
+	last := time.Date(2026, 3, 6, 0, 0, 0, 0, time.UTC)
+	now := last.Add(5*time.Hour + 30*time.Minute)
+
+	for _, tc := range []struct {
+		missed MissedRuns
+		now    time.Time
+		want   []string
+	}{
+		{MissedRunOnce, now, []string{"2026-03-06T05:00:00Z"}},
+		{MissedSkip, now, nil},
+		{MissedSkip, last.Add(5*time.Hour + 30*time.Second), []string{"2026-03-06T05:00:00Z"}},
+		{MissedRunAll, now, []string{
+			"2026-03-06T01:00:00Z", "2026-03-06T02:00:00Z", "2026-03-06T03:00:00Z",
+			"2026-03-06T04:00:00Z", "2026-03-06T05:00:00Z",
+		}},
+	} {
+		rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		client := NewClient(NewRedisBackend(rdb))
+		rdb.HSet(ctx, scheduleRunsKey, "export", last.Format(time.RFC3339))
+
+		cron := newTestCron(t, client, Schedule{
+			Name: "export", Spec: "@hourly", Priority: QueueLow, Payload: AnalyticsExportPayload{}, Missed: tc.missed,
+		})
+		cron.tick(ctx, tc.now)
+
+		var got []string
+		for _, jobJSON := range rdb.LRange(ctx, "low:analytics_export", 0, -1).Val() {
+			job, err := FromJSON(jobJSON)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			// Jobs are pushed on the left, so the oldest is last.
+			got = append([]string{job.ScheduledFor.Format(time.RFC3339)}, got...)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("missed=%d at %s: expected runs %v; got %v", tc.missed, tc.now, tc.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("missed=%d at %s: expected runs %v; got %v", tc.missed, tc.now, tc.want, got)
+				break
+			}
+		}
+
+		if lastRun := rdb.HGet(ctx, scheduleRunsKey, "export").Val(); lastRun != "2026-03-06T05:00:00Z" {
+			t.Errorf("missed=%d: expected the last run to move to 05:00; got %s", tc.missed, lastRun)
+		}
+	}
+}
+
+func TestEnqueueAt(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	client := NewClient(NewRedisBackend(rdb))
+	ctx := context.Background()
+
+	later, err := client.EnqueueAt(ctx, QueueHigh, StoreRawPayload{}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("enqueue later: %v", err)
+	}
+	if _, err := client.EnqueueAt(ctx, QueueHigh, StoreRawPayload{}, time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("enqueue now: %v", err)
+	}
+
+	stats, err := client.GetQueueStats(ctx)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats["delayed"] != 1 || stats["high:store_raw"] != 1 {
+		t.Errorf("expected one delayed and one queued job; got %v", stats)
+	}
+
+	// Pretend the hour has passed.
+	rdb.ZAdd(ctx, delayedKey, redis.Z{Score: 0, Member: rdb.ZRange(ctx, delayedKey, 0, 0).Val()[0]})
+	if err := client.ProcessDelayedJobs(ctx); err != nil {
+		t.Fatalf("process delayed: %v", err)
+	}
+	if err := client.ProcessDelayedJobs(ctx); err != nil {
+		t.Fatalf("process delayed: %v", err)
+	}
+
+	if n := rdb.LLen(ctx, "high:store_raw").Val(); n != 2 {
+		t.Errorf("expected the delayed job to be queued once; %d queued", n)
+	}
+	if rdb.Exists(ctx, "jobs:tracking:"+later.ID).Val() != 1 {
+		t.Error("expected the delayed job to be tracked")
+	}
+}
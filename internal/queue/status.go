@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"context"
+	"slices"
+	"sort"
+)
+
+// IngestionStatus is what became of the jobs of an item ingested through
+// the queue.
+type IngestionStatus struct {
+	ID string `json:"id"`
+	// Status is dead if any job is dead, and completed once every job
+	// completed. Otherwise it's the state of the job furthest from done:
+	// pending, then delayed (waiting to retry), then processing.
+	Status JobState    `json:"status"`
+	Jobs   []JobStatus `json:"jobs"`
+}
+
+// ingestionStatePrecedence orders the states an item's status can take
+// from its jobs', highest first.
+var ingestionStatePrecedence = []JobState{JobStateDead, JobStatePending, JobStateDelayed, JobStateProcessing}
+
+// IngestionStatus looks up the jobs of the project's item id, oldest first.
+// It returns nil if none are tracked: the item was written directly, its
+// ID is unknown, or its jobs' tracking expired.
+func (c *Client) IngestionStatus(ctx context.Context, projectID, id string) (*IngestionStatus, error) {
+	jobs, err := c.backend.JobStatuses(ctx, projectID, id)
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+
+	status := &IngestionStatus{ID: id, Status: JobStateCompleted, Jobs: jobs}
+	for _, state := range ingestionStatePrecedence {
+		if slices.ContainsFunc(jobs, func(job JobStatus) bool { return job.State == state }) {
+			status.Status = state
+			break
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"langlite-ingestion/internal/telemetry"
+)
+
+func jobAttributes(job *Job, queueName string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("redis"),
+		semconv.MessagingDestinationName(queueName),
+		semconv.MessagingMessageID(job.ID),
+		attribute.String("langlite.job.type", string(job.Type)),
+		attribute.Int("langlite.job.attempt", job.Attempts),
+	}
+}
+
+// startEnqueueSpan starts the producer span for job and stores its context in
+// the job, so the worker's spans can link back to it.
+func startEnqueueSpan(ctx context.Context, job *Job, queueName string) (context.Context, trace.Span) {
+	ctx, span := telemetry.Tracer().Start(ctx, "enqueue "+string(job.Type),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(jobAttributes(job, queueName)...),
+		trace.WithAttributes(semconv.MessagingOperationTypePublish),
+	)
+	job.TraceContext = telemetry.Inject(ctx)
+	return ctx, span
+}
+
+// startProcessSpans records the dequeue of job, which began waiting at
+// polledAt, and starts the span processing it. Both start a new trace linked
+// to the span that enqueued the job; retries link to the same producer.
+func startProcessSpans(ctx context.Context, job *Job, polledAt time.Time) (context.Context, trace.Span) {
+	queueName := GetQueueName(job.Type, job.Priority)
+	opts := []trace.SpanStartOption{
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(jobAttributes(job, queueName)...),
+	}
+	if producer := telemetry.Extract(job.TraceContext); producer.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: producer}))
+	}
+
+	ctx, receive := telemetry.Tracer().Start(ctx, "dequeue "+string(job.Type),
+		append(opts, trace.WithTimestamp(polledAt), trace.WithAttributes(semconv.MessagingOperationTypeReceive))...)
+	receive.End()
+
+	return telemetry.Tracer().Start(ctx, "process "+string(job.Type),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(jobAttributes(job, queueName)...),
+		trace.WithAttributes(semconv.MessagingOperationTypeDeliver),
+	)
+}
+
+// endSpan marks span failed with err, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
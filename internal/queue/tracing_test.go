@@ -0,0 +1,55 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestJobSpansLinkAcrossQueue(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	job := &Job{ID: "job-1", Type: JobTypeStoreRaw, Priority: QueueHigh}
+	_, enqueue := startEnqueueSpan(context.Background(), job, GetQueueName(job.Type, job.Priority))
+	enqueue.End()
+
+	// The trace context has to survive the trip through Redis.
+	data, err := job.ToJSON()
+	if err != nil {
+		t.Fatalf("serialize job: %v", err)
+	}
+	var dequeued Job
+	if err := json.Unmarshal([]byte(data), &dequeued); err != nil {
+		t.Fatalf("deserialize job: %v", err)
+	}
+
+	_, process := startProcessSpans(context.Background(), &dequeued, time.Now().Add(-time.Second))
+	endSpan(process, nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected enqueue, dequeue and process spans; got %d", len(spans))
+	}
+	producer, receive, consumer := spans[0], spans[1], spans[2]
+
+	if receive.SpanContext().TraceID() == producer.SpanContext().TraceID() {
+		t.Errorf("expected the worker to start a new trace")
+	}
+	if len(receive.Links()) != 1 || receive.Links()[0].SpanContext.SpanID() != producer.SpanContext().SpanID() {
+		t.Errorf("expected dequeue span to link to the enqueue span; got %+v", receive.Links())
+	}
+	if consumer.Parent().SpanID() != receive.SpanContext().SpanID() {
+		t.Errorf("expected process span to be a child of the dequeue span")
+	}
+	if consumer.Name() != "process store_raw" {
+		t.Errorf("unexpected span name %q", consumer.Name())
+	}
+}
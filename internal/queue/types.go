@@ -3,8 +3,6 @@ package queue
 import (
 	"encoding/json"
 	"time"
-
-	"langlite-ingestion/internal/database"
 )
 
 type JobType string
@@ -13,6 +11,10 @@ const (
 	JobTypeEnrichTrace     JobType = "enrich_trace"
 	JobTypeStoreRaw        JobType = "store_raw"
 	JobTypeAnalyticsExport JobType = "analytics_export"
+	JobTypePurgeRetention  JobType = "purge_retention"
+	JobTypeEraseUserData   JobType = "erase_user_data"
+	JobTypeRefreshRollups  JobType = "refresh_rollups"
+	JobTypeDeliverWebhook  JobType = "deliver_webhook"
 )
 
 type QueuePriority string
@@ -24,32 +26,37 @@ const (
 )
 
 type Job struct {
-	ID          string                 `json:"id"`
-	Type        JobType                `json:"type"`
-	Priority    QueuePriority          `json:"priority"`
-	Payload     map[string]interface{} `json:"payload"`
-	CreatedAt   time.Time              `json:"created_at"`
-	ProcessedAt *time.Time             `json:"processed_at,omitempty"`
-	Attempts    int                    `json:"attempts"`
-	MaxAttempts int                    `json:"max_attempts"`
-	Error       string                 `json:"error,omitempty"`
-}
+	ID       string        `json:"id"`
+	Type     JobType       `json:"type"`
+	Priority QueuePriority `json:"priority"`
+	// Payload is the job's Payload as JSON, written with PayloadVersion of
+	// its type's schema. Read it with DecodePayload.
+	Payload        json.RawMessage `json:"payload"`
+	PayloadVersion int             `json:"payload_version,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	// EnqueuedAt is when the job last became ready to run: when it was
+	// created, or when its retry delay ran out.
+	EnqueuedAt  time.Time  `json:"enqueued_at"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	Error       string     `json:"error,omitempty"`
 
-type JobPayload struct {
-	// Common fields
-	ProjectID string `json:"project_id"`
-	TraceID   string `json:"trace_id,omitempty"`
+	// RequestID and ProjectID identify the HTTP request and project that
+	// enqueued the job, for correlating logs.
+	RequestID string `json:"request_id,omitempty"`
+	ProjectID string `json:"project_id,omitempty"`
 
-	// For enrich_trace jobs
-	TraceData *database.TraceRequest `json:"trace_data,omitempty"`
+	// EntityID is the ingested item the job stores or processes, so the
+	// item's jobs can be looked up by the ID the API answered with.
+	EntityID string `json:"entity_id,omitempty"`
 
-	// For store_raw jobs
-	RawData  interface{} `json:"raw_data,omitempty"`
-	DataType string      `json:"data_type,omitempty"` // "trace", "span", "generation", etc.
+	// TraceContext is the W3C trace context of the span that enqueued the
+	// job.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
 
-	// For analytics_export jobs
-	ExportData interface{} `json:"export_data,omitempty"`
-	ExportType string      `json:"export_type,omitempty"` // "clickhouse", "warehouse", etc.
+	// ScheduledFor is the run of a Schedule the job stands for.
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
 }
 
 func (j *Job) ToJSON() (string, error) {
@@ -63,10 +70,72 @@ func FromJSON(data string) (*Job, error) {
 	return &job, err
 }
 
+// ReadyAt is when the job started waiting for a worker. Jobs enqueued before
+// EnqueuedAt existed fall back to CreatedAt.
+func (j *Job) ReadyAt() time.Time {
+	if j.EnqueuedAt.IsZero() {
+		return j.CreatedAt
+	}
+	return j.EnqueuedAt
+}
+
 func GetQueueName(jobType JobType, priority QueuePriority) string {
 	return string(priority) + ":" + string(jobType)
 }
 
+// JobState is where a job is in its lifecycle.
+type JobState string
+
+const (
+	JobStatePending    JobState = "pending"
+	JobStateDelayed    JobState = "delayed"
+	JobStateProcessing JobState = "processing"
+	JobStateCompleted  JobState = "completed"
+	JobStateDead       JobState = "dead"
+)
+
+// JobStatus is a tracked job's state and the error of its last failed
+// attempt. Its JSON shares Job's field names, so a job's JSON reads as a
+// status, if without a state.
+type JobStatus struct {
+	ID       string   `json:"id"`
+	Type     JobType  `json:"type"`
+	State    JobState `json:"state"`
+	Attempts int      `json:"attempts"`
+	// MaxAttempts is how many attempts the job gets before it's dead.
+	MaxAttempts int       `json:"max_attempts"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func newJobStatus(job *Job, state JobState) JobStatus {
+	status := JobStatus{
+		ID:          job.ID,
+		Type:        job.Type,
+		State:       state,
+		Attempts:    job.Attempts,
+		MaxAttempts: job.MaxAttempts,
+		Error:       job.Error,
+		CreatedAt:   job.CreatedAt,
+		UpdatedAt:   time.Now().UTC(),
+	}
+	// Client.dequeue counts the attempt once the job is popped.
+	if state == JobStateProcessing {
+		status.Attempts++
+	}
+	return status
+}
+
+// completedJobStatus is the status of a job kept by Complete.
+func completedJobStatus(job *Job) JobStatus {
+	status := newJobStatus(job, JobStateCompleted)
+	if job.ProcessedAt != nil {
+		status.UpdatedAt = *job.ProcessedAt
+	}
+	return status
+}
+
 type JobResult struct {
 	Success     bool          `json:"success"`
 	Error       string        `json:"error,omitempty"`
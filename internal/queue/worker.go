@@ -2,12 +2,17 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/enrich"
+	"langlite-ingestion/internal/logging"
+	"langlite-ingestion/internal/webhook"
 )
 
 type Worker struct {
@@ -15,26 +20,55 @@ type Worker struct {
 	client     *Client
 	processors map[JobType]JobProcessor
 	jobTypes   []JobType
+	metrics    Recorder
+	limiter    *limiter
+	// scheduler orders the queues for each dequeue; without one, the
+	// worker takes jobs in strict priority order.
+	scheduler *Scheduler
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+
+	// lastBeat is when the worker last polled the queue or finished a job,
+	// in Unix nanoseconds.
+	lastBeat atomic.Int64
+
+	mu         sync.Mutex
+	startedAt  time.Time
 	running    bool
-	stopCh     chan struct{}
-	wg         sync.WaitGroup
+	stopping   bool
+	cancelPoll context.CancelFunc
+	// current is the job in flight and cancelJob cancels its context.
+	current   *Job
+	cancelJob context.CancelFunc
 }
 
-func NewWorker(id string, client *Client, db database.Service) *Worker {
+func NewWorker(id string, client *Client, db database.Service, m Recorder) *Worker {
 	processors := make(map[JobType]JobProcessor)
 
-	enrichProcessor := NewEnrichTraceProcessor(db)
+	enrichProcessor := NewEnrichTraceProcessor(db, enrich.DefaultRegistry(nil))
 	storeProcessor := NewStoreRawProcessor(db)
 	analyticsProcessor := NewAnalyticsExportProcessor()
+	retentionProcessor := NewRetentionPurgeProcessor(db, m)
+	erasureProcessor := NewUserErasureProcessor(db)
+	rollupProcessor := NewRollupRefreshProcessor(db)
+	webhookProcessor := NewDeliverWebhookProcessor(db, webhook.NewDeliverer(DefaultWebhookTimeout, false), DefaultWebhookDisableAfter)
 
 	processors[JobTypeEnrichTrace] = enrichProcessor
 	processors[JobTypeStoreRaw] = storeProcessor
 	processors[JobTypeAnalyticsExport] = analyticsProcessor
+	processors[JobTypePurgeRetention] = retentionProcessor
+	processors[JobTypeEraseUserData] = erasureProcessor
+	processors[JobTypeRefreshRollups] = rollupProcessor
+	processors[JobTypeDeliverWebhook] = webhookProcessor
 
 	jobTypes := []JobType{
 		JobTypeEnrichTrace,
 		JobTypeStoreRaw,
 		JobTypeAnalyticsExport,
+		JobTypePurgeRetention,
+		JobTypeEraseUserData,
+		JobTypeRefreshRollups,
+		JobTypeDeliverWebhook,
 	}
 
 	return &Worker{
@@ -42,17 +76,28 @@ func NewWorker(id string, client *Client, db database.Service) *Worker {
 		client:     client,
 		processors: processors,
 		jobTypes:   jobTypes,
+		metrics:    m,
 		stopCh:     make(chan struct{}),
 	}
 }
 
 func (w *Worker) Start(ctx context.Context) {
-	if w.running {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running || w.stopping {
 		return
 	}
 
 	w.running = true
-	log.Printf("Worker %s starting...", w.id)
+	w.startedAt = time.Now().UTC()
+	w.beat()
+	slog.Info("Worker starting", "worker_id", w.id)
+	if w.metrics != nil {
+		w.metrics.UpdateWorkerStatus(w.id, true)
+	}
+
+	// Cancelling the poll interrupts waiting for a job, not the job itself.
+	ctx, w.cancelPoll = context.WithCancel(ctx)
 
 	w.wg.Add(1)
 	go w.processLoop(ctx)
@@ -61,18 +106,139 @@ func (w *Worker) Start(ctx context.Context) {
 	go w.delayedJobLoop(ctx)
 }
 
+// Stop stops the worker and waits for its in-flight job however long it takes.
 func (w *Worker) Stop() {
-	if !w.running {
-		return
+	_ = w.Shutdown(context.Background())
+}
+
+// Shutdown stops the worker taking new jobs and waits for the one in flight
+// to finish. If ctx is done first, the job's context is cancelled and the job
+// goes back on its queue for another worker without counting the attempt.
+// Should the abandoned processor still finish, its result is dropped, so the
+// job may run twice but is never lost.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.running || w.stopping {
+		w.mu.Unlock()
+		return nil
+	}
+	w.stopping = true
+	close(w.stopCh)
+	w.cancelPoll()
+	w.mu.Unlock()
+
+	slog.Info("Worker stopping", "worker_id", w.id)
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if job := w.abandon(); job != nil {
+			slog.Warn("Job still running at shutdown deadline, requeueing it", "worker_id", w.id, "job_id", job.ID, "job_type", job.Type)
+			err = w.requeue(job)
+		}
 	}
 
-	log.Printf("Worker %s stopping...", w.id)
+	w.mu.Lock()
 	w.running = false
-	close(w.stopCh)
-	w.wg.Wait()
-	log.Printf("Worker %s stopped", w.id)
+	w.mu.Unlock()
+	if w.metrics != nil {
+		w.metrics.UpdateWorkerStatus(w.id, false)
+	}
+	slog.Info("Worker stopped", "worker_id", w.id)
+
+	return err
+}
+
+// info describes the worker for the registry.
+func (w *Worker) info(instance string, reportedAt time.Time) WorkerInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info := WorkerInfo{
+		ID:         w.id,
+		Instance:   instance,
+		JobTypes:   w.jobTypes,
+		Running:    w.running,
+		StartedAt:  w.startedAt,
+		ReportedAt: reportedAt,
+	}
+	if beat := w.LastHeartbeat(); !beat.IsZero() {
+		beat = beat.UTC()
+		info.LastHeartbeat = &beat
+	}
+	if w.current != nil {
+		info.CurrentJobID = w.current.ID
+		info.CurrentJobType = w.current.Type
+	}
+	return info
+}
+
+// claim makes job the worker's in-flight job and returns the context to run
+// it in, which outlives the poll. It fails once the worker is stopping.
+func (w *Worker) claim(ctx context.Context, job *Job) (context.Context, context.CancelFunc, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopping {
+		return ctx, func() {}, false
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	w.current, w.cancelJob = job, cancel
+	return ctx, cancel, true
+}
+
+// release clears the in-flight job. It reports false if Shutdown has
+// abandoned the job, in which case the caller must drop its outcome.
+func (w *Worker) release(job *Job) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.current != job {
+		return false
+	}
+	w.current, w.cancelJob = nil, nil
+	return true
+}
+
+// abandon takes the in-flight job away from the worker and cancels it.
+func (w *Worker) abandon() *Job {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	job := w.current
+	if job != nil {
+		w.cancelJob()
+	}
+	w.current, w.cancelJob = nil, nil
+	return job
+}
+
+func (w *Worker) requeue(job *Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := w.client.Requeue(ctx, job); err != nil {
+		return fmt.Errorf("worker %s: %w", w.id, err)
+	}
+	return nil
 }
 
+// errJobRequeued marks a job abandoned by Shutdown and put back on its queue.
+var errJobRequeued = errors.New("job requeued at shutdown")
+
+// errPayloadTooNew marks a job deferred because a newer deploy wrote its
+// payload.
+var errPayloadTooNew = errors.New("payload version is newer than this worker reads")
+
+// newerPayloadDelay is how long a job with a newer payload version waits
+// before a worker tries it again, by which time an upgraded one may take it.
+const newerPayloadDelay = time.Minute
+
 func (w *Worker) processLoop(ctx context.Context) {
 	defer w.wg.Done()
 
@@ -83,50 +249,139 @@ func (w *Worker) processLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		default:
+			if !w.limiter.acquire(ctx) {
+				continue
+			}
+			w.beat()
 			w.processNextJob(ctx)
+			w.limiter.release()
 		}
 	}
 }
 
+func (w *Worker) beat() {
+	w.lastBeat.Store(time.Now().UnixNano())
+}
+
+// LastHeartbeat is when the worker last polled the queue or finished a job,
+// or the zero time if it never started. A worker running a long job doesn't
+// beat until the job ends.
+func (w *Worker) LastHeartbeat() time.Time {
+	nanos := w.lastBeat.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
 func (w *Worker) processNextJob(ctx context.Context) {
-	job, err := w.client.Dequeue(ctx, w.jobTypes, 5*time.Second)
+	ctx = logging.WithWorkerID(ctx, w.id)
+
+	polledAt := time.Now()
+	job, err := w.dequeue(ctx)
 	if err != nil {
-		log.Printf("Worker %s: error dequeuing job: %v", w.id, err)
+		if ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Error dequeuing job", "error", err)
+		}
 		return
 	}
 
 	if job == nil {
 		return
 	}
+	if w.metrics != nil {
+		w.metrics.RecordQueueWait(ctx, GetQueueName(job.Type, job.Priority), string(job.Priority), string(job.Type), polledAt.Sub(job.ReadyAt()))
+	}
+
+	ctx = logging.WithJobID(ctx, job.ID)
+	if job.RequestID != "" {
+		ctx = logging.WithRequestID(ctx, job.RequestID)
+	}
+	if job.ProjectID != "" {
+		ctx = logging.WithProjectID(ctx, job.ProjectID)
+	}
+
+	ctx, cancel, ok := w.claim(ctx, job)
+	defer cancel()
+	if !ok {
+		// The worker began stopping while the job was being dequeued.
+		if err := w.requeue(job); err != nil {
+			slog.ErrorContext(ctx, "Failed to requeue job", "error", err)
+		}
+		return
+	}
 
-	log.Printf("Worker %s: processing job %s (type: %s, attempt: %d)",
-		w.id, job.ID, job.Type, job.Attempts)
+	var failure error
+	ctx, span := startProcessSpans(ctx, job, polledAt)
+	defer func() { endSpan(span, failure) }()
+
+	start := time.Now()
+	var failureType string
+	if w.metrics != nil {
+		w.metrics.UpdateWorkerJobsActive(w.id, 1)
+		defer w.metrics.UpdateWorkerJobsActive(w.id, -1)
+	}
+	defer func() { w.recordJob(ctx, job, start, failureType) }()
+
+	slog.InfoContext(ctx, "Processing job", "job_type", job.Type, "attempt", job.Attempts)
 
 	processor, exists := w.processors[job.Type]
 	if !exists {
-		log.Printf("Worker %s: no processor found for job type %s", w.id, job.Type)
-		w.client.FailJob(ctx, job, fmt.Sprintf("no processor for job type %s", job.Type))
+		failure = fmt.Errorf("no processor for job type %s", job.Type)
+		failureType = failureUnknownJobType
+		slog.ErrorContext(ctx, "No processor found for job type", "job_type", job.Type)
+		w.release(job)
+		w.client.FailJob(ctx, job, failure.Error())
+		return
+	}
+
+	if job.payloadTooNew() {
+		failure = errPayloadTooNew
+		failureType = failureDeferred
+		slog.WarnContext(ctx, "Deferring job with a newer payload version", "job_type", job.Type, "payload_version", job.PayloadVersion, "delay", newerPayloadDelay)
+		if !w.release(job) {
+			// Shutdown has already put the job back.
+			return
+		}
+		if err := w.client.DeferJob(ctx, job, newerPayloadDelay); err != nil {
+			slog.ErrorContext(ctx, "Failed to defer job", "error", err)
+		}
 		return
 	}
 
 	result, err := processor.Process(ctx, job)
+	if !w.release(job) {
+		failure = errJobRequeued
+		failureType = failureRequeued
+		slog.WarnContext(ctx, "Dropping result of job requeued at shutdown", "job_type", job.Type)
+		return
+	}
 	if err != nil {
-		log.Printf("Worker %s: processor error for job %s: %v", w.id, job.ID, err)
+		failure = err
+		failureType = failureProcessorError
+		slog.ErrorContext(ctx, "Processor error", "job_type", job.Type, "error", err)
 		w.client.FailJob(ctx, job, err.Error())
 		return
 	}
 
 	if result.Success {
-		log.Printf("Worker %s: job %s completed successfully (duration: %v)",
-			w.id, job.ID, result.Duration)
+		slog.InfoContext(ctx, "Job completed", "job_type", job.Type, "duration", result.Duration)
 		w.client.CompleteJob(ctx, job, result)
 	} else {
-		log.Printf("Worker %s: job %s failed: %s (duration: %v)",
-			w.id, job.ID, result.Error, result.Duration)
+		failure = errors.New(result.Error)
+		failureType = failureJobFailed
+		slog.WarnContext(ctx, "Job failed", "job_type", job.Type, "error", result.Error, "duration", result.Duration)
 		w.client.FailJob(ctx, job, result.Error)
 	}
 }
 
+func (w *Worker) dequeue(ctx context.Context) (*Job, error) {
+	if w.scheduler == nil {
+		return w.client.Dequeue(ctx, w.jobTypes, 5*time.Second)
+	}
+	return w.client.dequeue(ctx, w.scheduler.order(w.jobTypes), 5*time.Second)
+}
+
 func (w *Worker) delayedJobLoop(ctx context.Context) {
 	defer w.wg.Done()
 
@@ -142,7 +397,7 @@ func (w *Worker) delayedJobLoop(ctx context.Context) {
 		case <-ticker.C:
 			err := w.client.ProcessDelayedJobs(ctx)
 			if err != nil {
-				log.Printf("Worker %s: error processing delayed jobs: %v", w.id, err)
+				slog.ErrorContext(ctx, "Error processing delayed jobs", "worker_id", w.id, "error", err)
 			}
 		}
 	}
@@ -152,58 +407,211 @@ type WorkerPool struct {
 	workers []*Worker
 	client  *Client
 	db      database.Service
+
+	metrics Recorder
+
+	// instance names this process in the worker registry.
+	instance string
+
+	scheduler *Scheduler
+
+	// limiter, when AdaptConcurrency set it, caps how many workers run at
+	// once based on database latency.
+	limiter       *limiter
+	latency       LatencySource
+	latencyTarget time.Duration
+
+	stopLoops context.CancelFunc
+	loops     sync.WaitGroup
 }
 
-func NewWorkerPool(client *Client, db database.Service, workerCount int) *WorkerPool {
-	workers := make([]*Worker, workerCount)
+// NewWorkerPool creates the workers concurrency asks for. Worker IDs start
+// with a per-process instance name so they're unique across the cluster.
+func NewWorkerPool(client *Client, db database.Service, m Recorder, concurrency Concurrency) *WorkerPool {
+	instance := instanceID()
+	scheduler := NewScheduler(DefaultWeights, DefaultAging)
 
-	for i := 0; i < workerCount; i++ {
-		workerID := fmt.Sprintf("worker-%d", i+1)
-		workers[i] = NewWorker(workerID, client, db)
+	// Workers for every type first, then the dedicated ones in a stable order.
+	jobTypes := append([]JobType{AnyJobType}, queuedJobTypes...)
+
+	var workers []*Worker
+	for _, jobType := range jobTypes {
+		name := string(jobType)
+		if jobType == AnyJobType {
+			name = "any"
+		}
+
+		for i := 0; i < concurrency[jobType]; i++ {
+			workerID := fmt.Sprintf("%s-%s-%d", instance, name, i+1)
+			worker := NewWorker(workerID, client, db, m)
+			worker.scheduler = scheduler
+			if jobType != AnyJobType {
+				worker.jobTypes = []JobType{jobType}
+			}
+			workers = append(workers, worker)
+		}
 	}
 
 	return &WorkerPool{
-		workers: workers,
-		client:  client,
-		db:      db,
+		workers:   workers,
+		client:    client,
+		db:        db,
+		metrics:   m,
+		instance:  instance,
+		scheduler: scheduler,
 	}
 }
 
+// Start starts the workers and registers them in Redis, reporting them
+// every workerHeartbeatInterval until Shutdown.
 func (wp *WorkerPool) Start(ctx context.Context) {
-	log.Printf("Starting worker pool with %d workers", len(wp.workers))
+	slog.Info("Starting worker pool", "instance", wp.instance, "workers", len(wp.workers))
 
 	for _, worker := range wp.workers {
 		worker.Start(ctx)
 	}
+
+	loopCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	wp.stopLoops = cancel
+
+	wp.loops.Add(1)
+	go func() {
+		defer wp.loops.Done()
+		wp.heartbeatLoop(loopCtx)
+	}()
+
+	wp.loops.Add(1)
+	go func() {
+		defer wp.loops.Done()
+		wp.agingLoop(loopCtx)
+	}()
+
+	if wp.limiter != nil {
+		wp.loops.Add(1)
+		go func() {
+			defer wp.loops.Done()
+			wp.adaptLoop(loopCtx)
+		}()
+	}
 }
 
-func (wp *WorkerPool) Stop() {
-	log.Printf("Stopping worker pool...")
+// UseScheduler replaces the pool's default scheduling weights and aging.
+// Call it before Start.
+func (wp *WorkerPool) UseScheduler(scheduler *Scheduler) {
+	wp.scheduler = scheduler
+	for _, worker := range wp.workers {
+		worker.scheduler = scheduler
+	}
+}
 
+// UseEnrichers replaces the enrichers the pool's trace enrichment jobs can
+// run, by default the built-ins without geo. Call it before Start.
+func (wp *WorkerPool) UseEnrichers(enrichers *enrich.Registry) {
 	for _, worker := range wp.workers {
-		worker.Stop()
+		worker.processors[JobTypeEnrichTrace] = NewEnrichTraceProcessor(wp.db, enrichers)
 	}
 }
 
-func (wp *WorkerPool) GetStats(ctx context.Context) (map[string]interface{}, error) {
-	queueStats, err := wp.client.GetQueueStats(ctx)
-	if err != nil {
-		return nil, err
+// UseWebhooks replaces how the pool's workers deliver webhook events and
+// how many failures in a row disable a webhook, 0 meaning never. Call it
+// before Start.
+func (wp *WorkerPool) UseWebhooks(deliverer *webhook.Deliverer, disableAfter int) {
+	for _, worker := range wp.workers {
+		worker.processors[JobTypeDeliverWebhook] = NewDeliverWebhookProcessor(wp.db, deliverer, disableAfter)
 	}
+}
 
-	stats := map[string]interface{}{
-		"worker_count": len(wp.workers),
-		"queue_stats":  queueStats,
-		"workers":      make([]map[string]interface{}, len(wp.workers)),
+func (wp *WorkerPool) agingLoop(ctx context.Context) {
+	ticker := time.NewTicker(agingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			wp.scheduler.promote(ctx, wp.client, wp.metrics)
+		}
 	}
+}
+
+func (wp *WorkerPool) heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(workerHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		wp.report(ctx)
 
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// report publishes the state of every worker to the registry.
+func (wp *WorkerPool) report(ctx context.Context) {
+	now := time.Now().UTC()
+	infos := make([]WorkerInfo, len(wp.workers))
 	for i, worker := range wp.workers {
-		workerStats := map[string]interface{}{
-			"id":      worker.id,
-			"running": worker.running,
+		infos[i] = worker.info(wp.instance, now)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := wp.client.RegisterWorkers(ctx, infos); err != nil && ctx.Err() == nil {
+		slog.Error("Failed to report workers", "instance", wp.instance, "error", err)
+	}
+}
+
+func (wp *WorkerPool) Stop() {
+	_ = wp.Shutdown(context.Background())
+}
+
+// Shutdown stops every worker taking new jobs and waits for their in-flight
+// jobs, requeueing any still running when ctx is done. The workers are then
+// removed from the registry.
+func (wp *WorkerPool) Shutdown(ctx context.Context) error {
+	slog.Info("Stopping worker pool", "instance", wp.instance)
+
+	errs := make([]error, len(wp.workers)+1)
+	var wg sync.WaitGroup
+	for i, worker := range wp.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = worker.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+
+	if wp.stopLoops != nil {
+		wp.stopLoops()
+		wp.loops.Wait()
+		wp.stopLoops = nil
+
+		// Deregister even past the deadline, or the workers stay listed
+		// until their entries expire.
+		deregisterCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		ids := make([]string, len(wp.workers))
+		for i, worker := range wp.workers {
+			ids[i] = worker.id
 		}
-		stats["workers"].([]map[string]interface{})[i] = workerStats
+		errs[len(wp.workers)] = wp.client.DeregisterWorkers(deregisterCtx, ids)
 	}
 
-	return stats, nil
+	return errors.Join(errs...)
+}
+
+// LiveWorkers counts the workers that have beaten within maxAge.
+func (wp *WorkerPool) LiveWorkers(maxAge time.Duration) (live, total int) {
+	cutoff := time.Now().Add(-maxAge)
+	for _, worker := range wp.workers {
+		if worker.LastHeartbeat().After(cutoff) {
+			live++
+		}
+	}
+	return live, len(wp.workers)
 }
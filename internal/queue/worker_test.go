@@ -0,0 +1,210 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"langlite-ingestion/internal/database"
+)
+
+// blockingProcessor signals when a job starts and holds it until release is
+// closed, ignoring cancellation like a processor stuck in a database call.
+type blockingProcessor struct {
+	started chan *Job
+	release chan struct{}
+	ctxErr  chan error
+}
+
+func (p blockingProcessor) Process(ctx context.Context, job *Job) (*JobResult, error) {
+	p.started <- job
+	<-p.release
+	p.ctxErr <- ctx.Err()
+	return &JobResult{Success: true, ProcessedAt: time.Now().UTC()}, nil
+}
+
+func (p blockingProcessor) CanProcess(jobType JobType) bool {
+	return true
+}
+
+func startBlockingWorker(t *testing.T) (*Worker, blockingProcessor, *redis.Client, *Job) {
+	t.Helper()
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	client := NewClient(NewRedisBackend(rdb))
+
+	processor := blockingProcessor{
+		started: make(chan *Job, 1),
+		release: make(chan struct{}),
+		ctxErr:  make(chan error, 1),
+	}
+	worker := NewWorker("worker-test", client, nil, nil)
+	worker.processors[JobTypeStoreRaw] = processor
+
+	job, err := client.Enqueue(context.Background(), QueueHigh, StoreRawPayload{})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	worker.Start(context.Background())
+	select {
+	case <-processor.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the worker to pick up the job")
+	}
+
+	return worker, processor, rdb, job
+}
+
+func TestShutdownWaitsForInFlightJob(t *testing.T) {
+	worker, processor, rdb, job := startBlockingWorker(t)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- worker.Shutdown(context.Background()) }()
+
+	select {
+	case <-stopped:
+		t.Fatal("expected Shutdown to wait for the job")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(processor.release)
+	if err := <-stopped; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := <-processor.ctxErr; err != nil {
+		t.Errorf("expected the job to run uncancelled; got %v", err)
+	}
+
+	ctx := context.Background()
+	if n := rdb.Exists(ctx, "jobs:completed:"+job.ID).Val(); n != 1 {
+		t.Errorf("expected the job to complete")
+	}
+	if n := rdb.LLen(ctx, GetQueueName(JobTypeStoreRaw, QueueHigh)).Val(); n != 0 {
+		t.Errorf("expected no requeued job; got %d", n)
+	}
+}
+
+func TestShutdownRequeuesJobAtDeadline(t *testing.T) {
+	worker, processor, rdb, job := startBlockingWorker(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := worker.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	queued, err := rdb.LRange(context.Background(), GetQueueName(JobTypeStoreRaw, QueueHigh), 0, -1).Result()
+	if err != nil || len(queued) != 1 {
+		t.Fatalf("expected the job back on its queue; got %v, %v", queued, err)
+	}
+	requeued, err := FromJSON(queued[0])
+	if err != nil {
+		t.Fatalf("deserialize: %v", err)
+	}
+	if requeued.ID != job.ID || requeued.Attempts != 0 {
+		t.Errorf("expected job %s with no attempts counted; got %+v", job.ID, requeued)
+	}
+
+	// The abandoned processor's result must not complete the job too.
+	close(processor.release)
+	if err := <-processor.ctxErr; err != context.Canceled {
+		t.Errorf("expected the job's context to be cancelled; got %v", err)
+	}
+	worker.wg.Wait()
+
+	if n := rdb.Exists(context.Background(), "jobs:completed:"+job.ID).Val(); n != 0 {
+		t.Errorf("expected the requeued job not to be completed")
+	}
+}
+
+// storedDB keeps trace ids and refuses duplicates like the database does.
+type storedDB struct {
+	database.Service
+	traces map[string]bool
+}
+
+func (d *storedDB) CreateTrace(tr database.TraceRequest) error {
+	if d.traces[tr.ID] {
+		return fmt.Errorf("trace %s: %w", tr.ID, database.ErrAlreadyExists)
+	}
+	d.traces[tr.ID] = true
+	return nil
+}
+
+func TestStoreRawJobRunsTwice(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	client := NewClient(NewRedisBackend(rdb))
+	db := &storedDB{traces: map[string]bool{}}
+	worker := NewWorker("worker-test", client, db, nil)
+
+	ctx := context.Background()
+	job, err := client.Enqueue(ctx, QueueHigh, StoreRawPayload{
+		DataType: "trace",
+		RawData:  json.RawMessage(`{"id": "trace-1", "project_id": "project-1", "name": "chat"}`),
+	})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	worker.processNextJob(ctx)
+
+	// Shutdown put the job back after its first run had committed.
+	if err := client.Requeue(ctx, job); err != nil {
+		t.Fatalf("requeue: %v", err)
+	}
+	worker.processNextJob(ctx)
+
+	if n := rdb.LLen(ctx, GetQueueName(JobTypeStoreRaw, QueueHigh)).Val(); n != 0 {
+		t.Errorf("expected no job left to retry; got %d", n)
+	}
+	if n := rdb.LLen(ctx, "jobs:dead_letter").Val(); n != 0 {
+		t.Errorf("expected the replayed job not to be buried; got %d", n)
+	}
+	if n := rdb.ZCard(ctx, "jobs:delayed").Val(); n != 0 {
+		t.Errorf("expected the replayed job not to be retried; got %d", n)
+	}
+	if n := rdb.Exists(ctx, "jobs:completed:"+job.ID).Val(); n != 1 {
+		t.Errorf("expected the replayed job to complete")
+	}
+}
+
+// A job written by a newer deploy waits for an upgraded worker rather than
+// using up its attempts on this one.
+func TestNewerPayloadJobIsDeferred(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	client := NewClient(NewRedisBackend(rdb))
+	worker := NewWorker("worker-test", client, &storedDB{traces: map[string]bool{}}, nil)
+
+	ctx := context.Background()
+	// Its last attempt: failing it would bury it.
+	job := mustParseJob(t, `{"id":"j7","type":"store_raw","priority":"high","payload":{"data_type":"trace","raw":{"id":"trace-1"}},"payload_version":2,"created_at":"2026-03-01T10:00:00Z","attempts":2,"max_attempts":3}`)
+	if err := client.backend.Push(ctx, job); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	worker.processNextJob(ctx)
+
+	if n := rdb.LLen(ctx, "jobs:dead_letter").Val(); n != 0 {
+		t.Errorf("expected the newer job not to be buried; got %d", n)
+	}
+	if n := rdb.ZCard(ctx, "jobs:delayed").Val(); n != 1 {
+		t.Fatalf("expected the newer job to be deferred; got %d", n)
+	}
+	if n := rdb.Exists(ctx, "jobs:completed:"+job.ID).Val(); n != 0 {
+		t.Errorf("expected the newer job not to run")
+	}
+
+	deferred, err := FromJSON(rdb.ZRange(ctx, "jobs:delayed", 0, 0).Val()[0])
+	if err != nil {
+		t.Fatalf("parse deferred job: %v", err)
+	}
+	if deferred.Attempts != 2 || deferred.PayloadVersion != 2 {
+		t.Errorf("expected the deferred job to keep its attempts and version; got attempts %d version %d", deferred.Attempts, deferred.PayloadVersion)
+	}
+}
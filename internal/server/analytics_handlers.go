@@ -0,0 +1,144 @@
+package server
+
+import (
+	"cmp"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"langlite-ingestion/internal/database"
+)
+
+// defaultTimeseriesWindow is the time range the timeseries covers when from
+// isn't given.
+const defaultTimeseriesWindow = 7 * 24 * time.Hour
+
+var timeseriesDimensions = []string{"model", "span_type", "trace_name", "tag"}
+
+func (s *Server) TimeseriesHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	limit, offset, problems := pagination(r)
+	from, to, rangeProblems := timeRange(r, defaultTimeseriesWindow)
+	maps.Copy(problems, rangeProblems)
+	interval, intervalProblems := bucketInterval(r, from, to)
+	maps.Copy(problems, intervalProblems)
+
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = "model"
+	}
+	if !slices.Contains(timeseriesDimensions, groupBy) {
+		problems["group_by"] = "group_by must be one of: model, span_type, trace_name, tag"
+	}
+
+	if len(problems) > 0 {
+		errorResp := database.ErrorResponse{
+			Error:    "Validation failed",
+			Message:  "The request contains invalid data",
+			Code:     http.StatusBadRequest,
+			Problems: problems,
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+
+	q := database.TimeseriesQuery{
+		GroupBy:  groupBy,
+		From:     from,
+		To:       to,
+		Interval: interval,
+		Values:   r.URL.Query()["value"],
+	}
+
+	rows, err := s.db.QueryTimeseries(authCtx.ProjectID, q)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to query timeseries", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to query timeseries",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	series := buildSeries(rows, from, to, interval)
+	series = series[min(offset, len(series)):min(offset+limit, len(series))]
+
+	response := database.TimeseriesResponse{
+		GroupBy:  groupBy,
+		Interval: interval,
+		From:     from,
+		To:       to,
+		Limit:    limit,
+		Offset:   offset,
+		Series:   series,
+	}
+
+	encode(w, r, http.StatusOK, response)
+}
+
+// buildSeries turns rollup rows into one series per group with a point for
+// every interval bucket in [from, to), busiest groups first.
+func buildSeries(rows []database.TimeseriesRow, from, to time.Time, interval string) []database.TimeseriesSeries {
+	byGroup := make(map[string]map[int64]database.TimeseriesRow)
+	totals := make(map[string]int64)
+	for _, row := range rows {
+		if byGroup[row.Group] == nil {
+			byGroup[row.Group] = make(map[int64]database.TimeseriesRow)
+		}
+		byGroup[row.Group][row.Start.Unix()] = row
+		totals[row.Group] += row.Count
+	}
+
+	groups := slices.Collect(maps.Keys(byGroup))
+	slices.SortFunc(groups, func(a, b string) int {
+		return cmp.Or(cmp.Compare(totals[b], totals[a]), cmp.Compare(a, b))
+	})
+
+	step := intervalDurations[interval]
+	series := make([]database.TimeseriesSeries, 0, len(groups))
+	for _, group := range groups {
+		points := []database.TimeseriesPoint{}
+		for start := truncateInterval(from, interval); start.Before(to); start = start.Add(step) {
+			points = append(points, timeseriesPoint(start, byGroup[group][start.Unix()]))
+		}
+		series = append(series, database.TimeseriesSeries{Group: group, Points: points})
+	}
+
+	return series
+}
+
+func timeseriesPoint(start time.Time, row database.TimeseriesRow) database.TimeseriesPoint {
+	point := database.TimeseriesPoint{
+		Start:            start,
+		Count:            row.Count,
+		PromptTokens:     row.PromptTokens,
+		CompletionTokens: row.CompletionTokens,
+		TotalTokens:      row.TotalTokens,
+		TotalCost:        row.TotalCost,
+		ErrorCount:       row.ErrorCount,
+	}
+
+	if row.LatencyCount > 0 {
+		avg := row.LatencySumMs / float64(row.LatencyCount)
+		point.LatencyAvgMs = &avg
+		point.LatencyP50Ms = database.LatencyPercentile(row.Histogram, row.LatencyMaxMs, 0.50)
+		point.LatencyP95Ms = database.LatencyPercentile(row.Histogram, row.LatencyMaxMs, 0.95)
+		point.LatencyP99Ms = database.LatencyPercentile(row.Histogram, row.LatencyMaxMs, 0.99)
+	}
+
+	return point
+}
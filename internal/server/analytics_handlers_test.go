@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"langlite-ingestion/internal/database"
+)
+
+// timeseriesDB has rollups for two models on 2025-01-01.
+type timeseriesDB struct {
+	database.Service
+	query database.TimeseriesQuery
+}
+
+func (f *timeseriesDB) QueryTimeseries(projectID string, q database.TimeseriesQuery) ([]database.TimeseriesRow, error) {
+	f.query = q
+
+	// 10 generations: 9 between 100ms and 250ms, 1 between 1s and 2.5s.
+	histogram := make([]int64, len(database.LatencyBucketBoundsMs)+1)
+	histogram[5] = 9
+	histogram[8] = 1
+
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []database.TimeseriesRow{
+		{Start: day, Group: "gpt-4o-mini", Count: 2, TotalTokens: 40},
+		{Start: day.Add(time.Hour), Group: "gpt-4o", Count: 10, LatencyCount: 10, LatencySumMs: 3000,
+			LatencyMaxMs: 2000, Histogram: histogram, TotalTokens: 500, ErrorCount: 1},
+	}, nil
+}
+
+func timeseriesRequest(t *testing.T, db *timeseriesDB, target string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	s := &Server{db: db}
+	r := chi.NewRouter()
+	r.Get("/api/v1/analytics/timeseries", s.TimeseriesHandler)
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	authCtx := database.AuthContext{ProjectID: "project-1", APIKeyID: "key-1"}
+	req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTimeseries(t *testing.T) {
+	db := &timeseriesDB{}
+	rec := timeseriesRequest(t, db, "/api/v1/analytics/timeseries?interval=hour&from=2025-01-01T00:00:00Z&to=2025-01-01T03:00:00Z&value=gpt-4o&value=gpt-4o-mini")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status OK; got %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp database.TimeseriesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	if db.query.GroupBy != "model" || db.query.Interval != "hour" || !slices.Equal(db.query.Values, []string{"gpt-4o", "gpt-4o-mini"}) {
+		t.Errorf("unexpected query %+v", db.query)
+	}
+
+	if len(resp.Series) != 2 || resp.Series[0].Group != "gpt-4o" {
+		t.Fatalf("expected gpt-4o first of 2 series; got %+v", resp.Series)
+	}
+	for _, series := range resp.Series {
+		if len(series.Points) != 3 {
+			t.Errorf("%s: expected 3 zero-filled points; got %d", series.Group, len(series.Points))
+		}
+	}
+
+	point := resp.Series[0].Points[1]
+	if point.Count != 10 || point.TotalTokens != 500 || point.ErrorCount != 1 {
+		t.Errorf("unexpected point %+v", point)
+	}
+	if point.LatencyAvgMs == nil || *point.LatencyAvgMs != 300 {
+		t.Errorf("expected avg latency 300ms; got %v", point.LatencyAvgMs)
+	}
+	// The 5th of 9 values in the 100-250ms bucket.
+	if point.LatencyP50Ms == nil || math.Abs(*point.LatencyP50Ms-(100+150*5.0/9)) > 1e-9 {
+		t.Errorf("unexpected p50 %v", point.LatencyP50Ms)
+	}
+	// Half way through the 1000-2000ms bucket, capped by the max.
+	if point.LatencyP95Ms == nil || math.Abs(*point.LatencyP95Ms-1500) > 1e-9 {
+		t.Errorf("unexpected p95 %v", point.LatencyP95Ms)
+	}
+
+	empty := resp.Series[0].Points[0]
+	if empty.Count != 0 || empty.LatencyP50Ms != nil {
+		t.Errorf("expected an empty point; got %+v", empty)
+	}
+}
+
+func TestTimeseriesPagesSeries(t *testing.T) {
+	rec := timeseriesRequest(t, &timeseriesDB{}, "/api/v1/analytics/timeseries?from=2025-01-01T00:00:00Z&to=2025-01-02T00:00:00Z&limit=1&offset=1")
+
+	var resp database.TimeseriesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Series) != 1 || resp.Series[0].Group != "gpt-4o-mini" {
+		t.Errorf("expected only gpt-4o-mini; got %+v", resp.Series)
+	}
+}
+
+func TestTimeseriesRejects(t *testing.T) {
+	cases := []struct {
+		name      string
+		target    string
+		wantField string
+	}{
+		{name: "bad dimension", target: "/api/v1/analytics/timeseries?group_by=user", wantField: "group_by"},
+		{name: "bad interval", target: "/api/v1/analytics/timeseries?interval=minute", wantField: "interval"},
+		{name: "inverted range", target: "/api/v1/analytics/timeseries?from=2025-01-02T00:00:00Z&to=2025-01-01T00:00:00Z", wantField: "from"},
+		{name: "bad limit", target: "/api/v1/analytics/timeseries?limit=0", wantField: "limit"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := timeseriesRequest(t, &timeseriesDB{}, tc.target)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400; got %d: %s", rec.Code, rec.Body)
+			}
+
+			var resp database.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if _, ok := resp.Problems[tc.wantField]; !ok {
+				t.Errorf("expected a %s problem; got %v", tc.wantField, resp.Problems)
+			}
+		})
+	}
+}
+
+func TestTruncateInterval(t *testing.T) {
+	// 2025-01-01 was a Wednesday.
+	ts := time.Date(2025, 1, 1, 13, 45, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		"hour": time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC),
+		"day":  time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		"week": time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC),
+	}
+	for interval, want := range cases {
+		if got := truncateInterval(ts, interval); !got.Equal(want) {
+			t.Errorf("%s: expected %v; got %v", interval, want, got)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/logging"
+)
+
+const (
+	auditContextKey contextKey = "audit"
+	adminContextKey contextKey = "admin"
+)
+
+// defaultAuditWindow is the time range the audit log covers when from isn't
+// given.
+const defaultAuditWindow = 30 * 24 * time.Hour
+
+// auditRecord carries what a handler knows about the operation back to the
+// Audited middleware.
+type auditRecord struct {
+	target string
+}
+
+// Audited records action in the audit log once the wrapped handler has
+// responded, with the caller, the target set by setAuditTarget and an outcome
+// derived from the response status.
+func (s *Server) Audited(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &auditRecord{}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), auditContextKey, rec)))
+
+			s.recordAudit(r, action, rec.target, ww.Status())
+		})
+	}
+}
+
+// setAuditTarget names what an audited request acted on, e.g.
+// "data_request:<id>". It does nothing outside Audited.
+func setAuditTarget(r *http.Request, target string) {
+	if rec, ok := r.Context().Value(auditContextKey).(*auditRecord); ok {
+		rec.target = target
+	}
+}
+
+func (s *Server) recordAudit(r *http.Request, action, target string, status int) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	entry := database.AuditEntry{
+		ID:         uuid.New().String(),
+		CreatedAt:  time.Now().UTC(),
+		ActorType:  database.AuditActorAnonymous,
+		Action:     action,
+		Target:     target,
+		RequestID:  logging.RequestID(r.Context()),
+		IP:         s.clientIP(r),
+		Outcome:    auditOutcome(status),
+		StatusCode: status,
+	}
+
+	if authCtx, ok := GetAuthContext(r); ok {
+		entry.ActorType = database.AuditActorAPIKey
+		entry.ActorID = authCtx.APIKeyID
+		entry.ProjectID = authCtx.ProjectID
+	} else if isAdmin(r) {
+		entry.ActorType = database.AuditActorAdmin
+		entry.ActorID = database.AuditActorAdmin
+	}
+
+	if err := s.db.RecordAudit(entry); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to record audit entry", "action", action, "error", err)
+	}
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return database.AuditDenied
+	case status >= 400:
+		return database.AuditFailure
+	default:
+		return database.AuditSuccess
+	}
+}
+
+// clientIP is the peer address, unless the peer is one of the trusted
+// proxies: then it's the rightmost X-Forwarded-For hop that isn't, since any
+// hop left of that could have been set by the client.
+func (s *Server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !s.trustedProxy(host) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	client := host
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		client = hop
+		if !s.trustedProxy(hop) {
+			break
+		}
+	}
+	return client
+}
+
+func (s *Server) trustedProxy(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminAuthMiddleware guards the admin API with the LANGLITE_ADMIN_TOKEN
+// bearer token. Rejected attempts are audited.
+func (s *Server) AdminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			errorResp := database.ErrorResponse{
+				Error:   "Admin API disabled",
+				Message: "Set LANGLITE_ADMIN_TOKEN to enable the admin API",
+				Code:    http.StatusForbidden,
+			}
+			encode(w, r, http.StatusForbidden, errorResp)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			s.recordAudit(r, "admin.auth", r.URL.Path, http.StatusUnauthorized)
+			errorResp := database.ErrorResponse{
+				Error:   "Invalid admin token",
+				Message: "Authorization header must be 'Bearer <admin token>'",
+				Code:    http.StatusUnauthorized,
+			}
+			encode(w, r, http.StatusUnauthorized, errorResp)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), adminContextKey, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func isAdmin(r *http.Request) bool {
+	admin, _ := r.Context().Value(adminContextKey).(bool)
+	return admin
+}
+
+// ListAuditHandler serves the audit log, filtered by project_id, actor_id,
+// action, target, outcome and a from/to window.
+func (s *Server) ListAuditHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, problems := pagination(r)
+	from, to, rangeProblems := timeRange(r, defaultAuditWindow)
+	maps.Copy(problems, rangeProblems)
+
+	params := r.URL.Query()
+	outcome := params.Get("outcome")
+	if outcome != "" && !slices.Contains(database.AuditOutcomes, outcome) {
+		problems["outcome"] = "outcome must be one of: " + strings.Join(database.AuditOutcomes, ", ")
+	}
+
+	if len(problems) > 0 {
+		errorResp := database.ErrorResponse{
+			Error:    "Validation failed",
+			Message:  "The request contains invalid data",
+			Code:     http.StatusBadRequest,
+			Problems: problems,
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+
+	q := database.AuditQuery{
+		ProjectID: params.Get("project_id"),
+		ActorID:   params.Get("actor_id"),
+		Action:    params.Get("action"),
+		Target:    params.Get("target"),
+		Outcome:   outcome,
+		From:      from,
+		To:        to,
+		Limit:     limit,
+		Offset:    offset,
+	}
+
+	entries, err := s.db.ListAuditLog(q)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list audit log", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to list audit log",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	response := database.AuditListResponse{
+		Entries: entries,
+		From:    from,
+		To:      to,
+		Limit:   limit,
+		Offset:  offset,
+	}
+
+	encode(w, r, http.StatusOK, response)
+}
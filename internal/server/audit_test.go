@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"langlite-ingestion/internal/database"
+)
+
+type auditDB struct {
+	database.Service
+	entries []database.AuditEntry
+	query   database.AuditQuery
+}
+
+func (f *auditDB) RecordAudit(entry database.AuditEntry) error {
+	f.entries = append(f.entries, entry)
+	return nil
+}
+
+func (f *auditDB) ListAuditLog(q database.AuditQuery) ([]database.AuditEntry, error) {
+	f.query = q
+	return f.entries, nil
+}
+
+func TestAuditedRecordsOperation(t *testing.T) {
+	cases := map[int]string{
+		http.StatusOK:                  database.AuditSuccess,
+		http.StatusForbidden:           database.AuditDenied,
+		http.StatusInternalServerError: database.AuditFailure,
+	}
+
+	for status, outcome := range cases {
+		db := &auditDB{}
+		s := &Server{db: db}
+
+		r := chi.NewRouter()
+		r.Use(s.RequestIDMiddleware)
+		r.With(s.Audited("thing.delete")).Post("/things/{id}", func(w http.ResponseWriter, r *http.Request) {
+			setAuditTarget(r, "thing:"+r.PathValue("id"))
+			w.WriteHeader(status)
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/things/t1", nil)
+		req.RemoteAddr = "10.0.0.1:5000"
+		authCtx := database.AuthContext{ProjectID: "project-1", APIKeyID: "key-1"}
+		req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+		r.ServeHTTP(httptest.NewRecorder(), req)
+
+		if len(db.entries) != 1 {
+			t.Fatalf("%d: expected one audit entry; got %d", status, len(db.entries))
+		}
+		e := db.entries[0]
+		if e.Action != "thing.delete" || e.Target != "thing:t1" || e.Outcome != outcome || e.StatusCode != status {
+			t.Errorf("%d: unexpected entry %+v", status, e)
+		}
+		if e.ActorType != database.AuditActorAPIKey || e.ActorID != "key-1" || e.ProjectID != "project-1" {
+			t.Errorf("%d: unexpected actor in %+v", status, e)
+		}
+		if e.RequestID == "" || e.IP != "10.0.0.1" {
+			t.Errorf("%d: expected request id and ip in %+v", status, e)
+		}
+	}
+}
+
+func adminRequest(t *testing.T, s *Server, target, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := chi.NewRouter()
+	r.Route("/admin/v1", func(r chi.Router) {
+		r.Use(s.AdminAuthMiddleware)
+		r.With(s.Audited("audit.list")).Get("/audit", s.ListAuditHandler)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminAuth(t *testing.T) {
+	db := &auditDB{}
+
+	rec := adminRequest(t, &Server{db: db}, "/admin/v1/audit", "secret")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected admin API to be disabled without a token; got %d", rec.Code)
+	}
+
+	s := &Server{db: db, adminToken: "secret"}
+	rec = adminRequest(t, s, "/admin/v1/audit", "wrong")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status Unauthorized; got %d: %s", rec.Code, rec.Body)
+	}
+	if len(db.entries) != 1 || db.entries[0].Action != "admin.auth" || db.entries[0].Outcome != database.AuditDenied {
+		t.Fatalf("expected rejected attempt to be audited; got %+v", db.entries)
+	}
+
+	rec = adminRequest(t, s, "/admin/v1/audit?action=rate_limit.reset&project_id=project-1&outcome=denied", "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status OK; got %d: %s", rec.Code, rec.Body)
+	}
+	if db.query.Action != "rate_limit.reset" || db.query.ProjectID != "project-1" || db.query.Outcome != database.AuditDenied {
+		t.Errorf("unexpected query %+v", db.query)
+	}
+
+	var resp database.AuditListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Entries) != 1 {
+		t.Errorf("expected one entry; got %+v", resp.Entries)
+	}
+
+	last := db.entries[len(db.entries)-1]
+	if last.Action != "audit.list" || last.ActorType != database.AuditActorAdmin {
+		t.Errorf("expected audit read to be audited as admin; got %+v", last)
+	}
+}
+
+func TestListAuditRejectsUnknownOutcome(t *testing.T) {
+	s := &Server{db: &auditDB{}, adminToken: "secret"}
+
+	rec := adminRequest(t, s, "/admin/v1/audit?outcome=maybe", "secret")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status Bad Request; got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	s := &Server{trustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+
+	cases := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		want      string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"spoofed by a direct client", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"via trusted proxy", "10.0.0.2:5000", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"spoofed through a trusted proxy", "10.0.0.2:5000", []string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"chain of trusted proxies", "10.0.0.2:5000", []string{"203.0.113.7, 10.0.0.3", "10.0.0.4"}, "203.0.113.7"},
+		{"garbage hop", "10.0.0.2:5000", []string{"not-an-ip"}, "10.0.0.2"},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.peer
+		for _, v := range tc.forwarded {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		if got := s.clientIP(req); got != tc.want {
+			t.Errorf("%s: expected %s; got %s", tc.name, tc.want, got)
+		}
+	}
+}
+
+// unknownKeyDB rejects every API key.
+type unknownKeyDB struct {
+	auditDB
+}
+
+func (f *unknownKeyDB) ValidateAPIKey(keyHash string) (*database.APIKey, error) {
+	return nil, errors.New("api key not found")
+}
+
+func TestAuthMiddlewareAuditsDenials(t *testing.T) {
+	db := &unknownKeyDB{}
+	s := &Server{db: db}
+
+	handler := s.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler reached without a valid key")
+	}))
+
+	for _, header := range []string{"", "Basic abc", "Bearer wrong"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users/u1/erasure", nil)
+		req.RemoteAddr = "203.0.113.7:5000"
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%q: expected status Unauthorized; got %d", header, rec.Code)
+		}
+	}
+
+	if len(db.entries) != 3 {
+		t.Fatalf("expected every rejected attempt to be audited; got %+v", db.entries)
+	}
+	for _, e := range db.entries {
+		if e.Action != "api_key.auth" || e.Target != "/api/v1/users/u1/erasure" || e.Outcome != database.AuditDenied ||
+			e.ActorType != database.AuditActorAnonymous || e.IP != "203.0.113.7" {
+			t.Errorf("unexpected entry %+v", e)
+		}
+	}
+}
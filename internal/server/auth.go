@@ -2,21 +2,29 @@ package server
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"net/http"
 	"strings"
 
 	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/enrich"
+	"langlite-ingestion/internal/logging"
 )
 
 type contextKey string
 
 const AuthContextKey contextKey = "auth"
 
+// AuthMiddleware resolves the request's API key to its project. Rejected
+// attempts are audited, since they never reach an Audited route.
 func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/health" || r.URL.Path == "/" || r.URL.Path == "/metrics" {
+		if probePaths[r.URL.Path] || r.URL.Path == "/" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The admin API has its own token, see AdminAuthMiddleware.
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -28,6 +36,7 @@ func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 				Message: "Authorization header is required",
 				Code:    http.StatusUnauthorized,
 			}
+			s.recordAudit(r, "api_key.auth", r.URL.Path, http.StatusUnauthorized)
 			encode(w, r, http.StatusUnauthorized, errorResp)
 			return
 		}
@@ -39,6 +48,7 @@ func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 				Message: "Authorization header must be 'Bearer <token>'",
 				Code:    http.StatusUnauthorized,
 			}
+			s.recordAudit(r, "api_key.auth", r.URL.Path, http.StatusUnauthorized)
 			encode(w, r, http.StatusUnauthorized, errorResp)
 			return
 		}
@@ -50,36 +60,43 @@ func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 				Message: "API key cannot be empty",
 				Code:    http.StatusUnauthorized,
 			}
+			s.recordAudit(r, "api_key.auth", r.URL.Path, http.StatusUnauthorized)
 			encode(w, r, http.StatusUnauthorized, errorResp)
 			return
 		}
 
-		hasher := sha256.New()
-		hasher.Write([]byte(apiKey))
-		keyHash := hex.EncodeToString(hasher.Sum(nil))
-
-		validatedKey, err := s.db.ValidateAPIKey(keyHash)
+		validatedKey, err := s.db.ValidateAPIKey(database.HashAPIKey(apiKey))
 		if err != nil {
 			errorResp := database.ErrorResponse{
 				Error:   "Invalid API key",
 				Message: "The provided API key is invalid or expired",
 				Code:    http.StatusUnauthorized,
 			}
+			s.recordAudit(r, "api_key.auth", r.URL.Path, http.StatusUnauthorized)
 			encode(w, r, http.StatusUnauthorized, errorResp)
 			return
 		}
 
 		// Update last used timestamp (async to not slow down request)
-		go func() {
+		s.background.Go(func(context.Context) {
 			_ = s.db.UpdateAPIKeyLastUsed(validatedKey.ID)
-		}()
+		})
 
 		authCtx := database.AuthContext{
 			ProjectID: validatedKey.ProjectID,
 			APIKeyID:  validatedKey.ID,
 		}
 
+		setLogProjectID(r, authCtx.ProjectID)
+
 		ctx := context.WithValue(r.Context(), AuthContextKey, authCtx)
+		ctx = logging.WithProjectID(ctx, authCtx.ProjectID)
+		ctx = enrich.WithClient(ctx, enrich.Client{
+			IP:         s.clientIP(r),
+			UserAgent:  r.UserAgent(),
+			SDKName:    r.Header.Get(enrich.SDKNameHeader),
+			SDKVersion: r.Header.Get(enrich.SDKVersionHeader),
+		})
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
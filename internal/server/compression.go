@@ -0,0 +1,123 @@
+package server
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// maxDecodedBodyBytes caps the decompressed size of a regular JSON body.
+	maxDecodedBodyBytes = 10 << 20
+
+	// maxCompressionRatio rejects bodies that expand more than this many times
+	// once decompressed. Small bodies are exempt up to compressionRatioSlack so
+	// that highly repetitive but tiny payloads still go through.
+	maxCompressionRatio   = 100
+	compressionRatioSlack = 1 << 20
+
+	// zstd frames may request large windows; keep the decoder's memory bounded.
+	maxZstdWindowBytes = 8 << 20
+)
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+	errBodyTooLarge        = errors.New("request body too large")
+	errCompressionRatio    = errors.New("request body compression ratio too high")
+)
+
+// requestBody returns the decoded request body, transparently decompressing
+// gzip and zstd content. A limit of 0 disables the absolute size check; the
+// compression ratio check always applies to compressed bodies.
+func requestBody(r *http.Request, limit int64) (io.ReadCloser, error) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+
+	raw := &countingReader{r: r.Body}
+
+	var decoded io.ReadCloser
+	switch encoding {
+	case "", "identity":
+		decoded = io.NopCloser(raw)
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(raw)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip stream: %w", err)
+		}
+		decoded = gz
+	case "zstd":
+		zr, err := zstd.NewReader(raw,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(maxZstdWindowBytes),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("open zstd stream: %w", err)
+		}
+		decoded = zr.IOReadCloser()
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnsupportedEncoding, encoding)
+	}
+
+	return &guardedReader{
+		r:          decoded,
+		raw:        raw,
+		limit:      limit,
+		compressed: encoding != "" && encoding != "identity",
+	}, nil
+}
+
+// bodyProblems turns body decoding failures caused by the client's encoding
+// into validation problems, so handlers report them alongside field errors.
+func bodyProblems(err error) map[string]string {
+	switch {
+	case errors.Is(err, errUnsupportedEncoding):
+		return map[string]string{"content_encoding": "content encoding must be one of: gzip, zstd, identity"}
+	case errors.Is(err, errBodyTooLarge):
+		return map[string]string{"body": fmt.Sprintf("decoded request body cannot exceed %d bytes", maxDecodedBodyBytes)}
+	case errors.Is(err, errCompressionRatio):
+		return map[string]string{"body": fmt.Sprintf("request body cannot expand more than %dx when decompressed", maxCompressionRatio)}
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type guardedReader struct {
+	r          io.ReadCloser
+	raw        *countingReader
+	limit      int64
+	compressed bool
+	n          int64
+}
+
+func (g *guardedReader) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	g.n += int64(n)
+
+	if g.limit > 0 && g.n > g.limit {
+		return n, errBodyTooLarge
+	}
+
+	if g.compressed && g.n > compressionRatioSlack && g.n > g.raw.n*maxCompressionRatio {
+		return n, errCompressionRatio
+	}
+
+	return n, err
+}
+
+func (g *guardedReader) Close() error {
+	return g.r.Close()
+}
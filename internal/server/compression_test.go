@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("zstd writer: %v", err)
+	}
+	defer zw.Close()
+	return zw.EncodeAll(data, nil)
+}
+
+func TestRequestBodyDecodesContentEncodings(t *testing.T) {
+	payload := []byte(`{"name":"compressed trace"}`)
+
+	tests := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", payload},
+		{"identity", payload},
+		{"gzip", gzipBytes(t, payload)},
+		{"zstd", zstdBytes(t, payload)},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/v1/traces", bytes.NewReader(tt.body))
+		if tt.encoding != "" {
+			req.Header.Set("Content-Encoding", tt.encoding)
+		}
+
+		body, err := requestBody(req, maxDecodedBodyBytes)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.encoding, err)
+		}
+		got, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatalf("%q: read: %v", tt.encoding, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("%q: expected %s; got %s", tt.encoding, payload, got)
+		}
+	}
+}
+
+func TestRequestBodyRejectsUnsupportedEncoding(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/v1/traces", strings.NewReader("{}"))
+	req.Header.Set("Content-Encoding", "br")
+
+	_, err := requestBody(req, maxDecodedBodyBytes)
+	if !errors.Is(err, errUnsupportedEncoding) {
+		t.Fatalf("expected errUnsupportedEncoding; got %v", err)
+	}
+	if bodyProblems(err)["content_encoding"] == "" {
+		t.Errorf("expected a content_encoding problem for %v", err)
+	}
+}
+
+func TestRequestBodyStopsDecompressionBombs(t *testing.T) {
+	bomb := gzipBytes(t, bytes.Repeat([]byte{'a'}, 4*compressionRatioSlack))
+
+	req := httptest.NewRequest("POST", "/api/v1/batch/stream", bytes.NewReader(bomb))
+	req.Header.Set("Content-Encoding", "gzip")
+
+	body, err := requestBody(req, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer body.Close()
+
+	if _, err := io.ReadAll(body); !errors.Is(err, errCompressionRatio) {
+		t.Fatalf("expected errCompressionRatio; got %v", err)
+	}
+}
+
+func TestRequestBodyEnforcesDecodedLimit(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/v1/traces", strings.NewReader(strings.Repeat("a", 2048)))
+
+	body, err := requestBody(req, 1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer body.Close()
+
+	if _, err := io.ReadAll(body); !errors.Is(err, errBodyTooLarge) {
+		t.Fatalf("expected errBodyTooLarge; got %v", err)
+	}
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/queue"
+)
+
+// ExportUserDataHandler streams everything stored for an end user as a JSON
+// archive download and records the export as a data request.
+func (s *Server) ExportUserDataHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	userID := r.PathValue("id")
+	startedAt := time.Now().UTC()
+
+	request := database.DataRequest{
+		ID:          uuid.New().String(),
+		ProjectID:   authCtx.ProjectID,
+		Kind:        database.DataRequestExport,
+		SubjectHash: database.HashSubject(authCtx.ProjectID, userID),
+		Status:      database.DataRequestRunning,
+		RequestedBy: authCtx.APIKeyID,
+		CreatedAt:   startedAt,
+		StartedAt:   &startedAt,
+	}
+	setAuditTarget(r, "data_request:"+request.ID)
+
+	if err := s.db.CreateDataRequest(request); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create data request", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to create data request",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	archive := &userArchive{
+		w:        w,
+		filename: fmt.Sprintf("langlite-user-export-%s.json", request.ID),
+		header: map[string]any{
+			"request_id":  request.ID,
+			"project_id":  authCtx.ProjectID,
+			"user_id":     userID,
+			"exported_at": startedAt,
+		},
+	}
+
+	receipt, err := s.db.ExportUserData(authCtx.ProjectID, userID, archive.emit)
+	if err == nil {
+		err = archive.close(receipt)
+	}
+
+	if finishErr := s.db.FinishDataRequest(request.ID, receipt, err); finishErr != nil {
+		slog.ErrorContext(r.Context(), "Failed to finish data request", "data_request_id", request.ID, "error", finishErr)
+	}
+
+	if err == nil {
+		return
+	}
+
+	// Once the archive has started there is no way to report the failure
+	// other than cutting it short.
+	if archive.started {
+		slog.ErrorContext(r.Context(), "Failed to export user data", "data_request_id", request.ID, "error", err)
+		return
+	}
+
+	if errors.Is(err, database.ErrUserNotFound) {
+		errorResp := database.ErrorResponse{
+			Error:   "User not found",
+			Message: "The specified user has no data in this project",
+			Code:    http.StatusNotFound,
+		}
+		encode(w, r, http.StatusNotFound, errorResp)
+		return
+	}
+
+	slog.ErrorContext(r.Context(), "Failed to export user data", "error", err)
+	errorResp := database.ErrorResponse{
+		Error:   "Database error",
+		Message: "Failed to export user data",
+		Code:    http.StatusInternalServerError,
+	}
+	encode(w, r, http.StatusInternalServerError, errorResp)
+}
+
+// userArchive writes an export as
+// {...header, "traces": [...], "sessions": [...], "receipt": {...}},
+// sending the response headers only once the first row arrives.
+type userArchive struct {
+	w        http.ResponseWriter
+	filename string
+	header   map[string]any
+	started  bool
+	section  string
+	count    int
+}
+
+func (a *userArchive) emit(section string, row json.RawMessage) error {
+	if err := a.open(section); err != nil {
+		return err
+	}
+
+	if a.count > 0 {
+		if _, err := io.WriteString(a.w, ","); err != nil {
+			return err
+		}
+	}
+	a.count++
+
+	_, err := a.w.Write(row)
+	return err
+}
+
+// open starts the archive and moves it on to section, closing the traces
+// array when sessions follow.
+func (a *userArchive) open(section string) error {
+	if !a.started {
+		a.started = true
+		a.w.Header().Set("Content-Type", "application/json")
+		a.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", a.filename))
+		a.w.WriteHeader(http.StatusOK)
+
+		header, err := json.Marshal(a.header)
+		if err != nil {
+			return err
+		}
+		// Leave the header object open so the sections follow its fields.
+		if _, err := a.w.Write(header[:len(header)-1]); err != nil {
+			return err
+		}
+	}
+
+	for a.section != section {
+		var next string
+		switch a.section {
+		case "":
+			next = "trace"
+			if _, err := io.WriteString(a.w, `,"traces":[`); err != nil {
+				return err
+			}
+		case "trace":
+			next = "session"
+			if _, err := io.WriteString(a.w, `],"sessions":[`); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected archive section %q after %q", section, a.section)
+		}
+
+		a.section = next
+		a.count = 0
+	}
+
+	return nil
+}
+
+func (a *userArchive) close(receipt database.DataRequestReceipt) error {
+	if err := a.open("session"); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(a.w, `],"receipt":%s}`, body)
+	return err
+}
+
+// EraseUserDataHandler records an erasure request for an end user and runs
+// it in the background, deleting or anonymising their data.
+func (s *Server) EraseUserDataHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	// The body is optional; without one the data is deleted.
+	var body database.ErasureRequest
+	if r.ContentLength != 0 {
+		var problems map[string]string
+		var err error
+		body, problems, err = decodeValid[database.ErasureRequest](r)
+		if err != nil {
+			if len(problems) > 0 {
+				errorResp := database.ErrorResponse{
+					Error:    "Validation failed",
+					Message:  "The request contains invalid data",
+					Code:     http.StatusBadRequest,
+					Problems: problems,
+				}
+				encode(w, r, http.StatusBadRequest, errorResp)
+				return
+			}
+
+			errorResp := database.ErrorResponse{
+				Error:   "Invalid request",
+				Message: "Could not parse request body",
+				Code:    http.StatusBadRequest,
+			}
+			encode(w, r, http.StatusBadRequest, errorResp)
+			return
+		}
+	}
+
+	mode := body.Mode
+	if mode == "" {
+		mode = database.ErasureDelete
+	}
+
+	userID := r.PathValue("id")
+	request := database.DataRequest{
+		ID:          uuid.New().String(),
+		ProjectID:   authCtx.ProjectID,
+		Kind:        database.DataRequestErasure,
+		Mode:        mode,
+		UserID:      userID,
+		SubjectHash: database.HashSubject(authCtx.ProjectID, userID),
+		Status:      database.DataRequestPending,
+		RequestedBy: authCtx.APIKeyID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	setAuditTarget(r, "data_request:"+request.ID)
+
+	if err := s.db.CreateDataRequest(request); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create data request", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to create data request",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	s.startErasure(r.Context(), request.ID)
+
+	encode(w, r, http.StatusAccepted, request)
+}
+
+// startErasure enqueues an erasure job, or runs the erasure in this process
+// when the queue is unavailable. Either outlives the request ctx belongs to.
+func (s *Server) startErasure(ctx context.Context, requestID string) {
+	ctx = context.WithoutCancel(ctx)
+
+	if s.queueClient != nil {
+		enqueueCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		_, err := s.queueClient.Enqueue(enqueueCtx, queue.QueueMedium, queue.EraseUserDataPayload{RequestID: requestID})
+		if err == nil {
+			return
+		}
+		slog.WarnContext(ctx, "Failed to enqueue erasure, running it directly", "data_request_id", requestID, "error", err)
+	}
+
+	s.background.Go(func(context.Context) {
+		if err := s.db.EraseUserData(requestID); err != nil {
+			slog.ErrorContext(ctx, "Failed to erase user data", "data_request_id", requestID, "error", err)
+		}
+	})
+}
+
+func (s *Server) ListDataRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	limit, offset, problems := pagination(r)
+	if len(problems) > 0 {
+		errorResp := database.ErrorResponse{
+			Error:    "Validation failed",
+			Message:  "The request contains invalid data",
+			Code:     http.StatusBadRequest,
+			Problems: problems,
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+
+	requests, err := s.db.ListDataRequests(authCtx.ProjectID, limit, offset)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list data requests", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to list data requests",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	response := database.DataRequestListResponse{
+		DataRequests: requests,
+		Limit:        limit,
+		Offset:       offset,
+	}
+
+	encode(w, r, http.StatusOK, response)
+}
+
+func (s *Server) GetDataRequestHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	requestID := r.PathValue("id")
+
+	request, err := s.db.GetDataRequest(authCtx.ProjectID, requestID)
+	if err != nil {
+		if errors.Is(err, database.ErrDataRequestNotFound) {
+			errorResp := database.ErrorResponse{
+				Error:   "Data request not found",
+				Message: "The specified data request does not exist",
+				Code:    http.StatusNotFound,
+			}
+			encode(w, r, http.StatusNotFound, errorResp)
+			return
+		}
+
+		slog.ErrorContext(r.Context(), "Failed to get data request", "data_request_id", requestID, "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to get data request",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	encode(w, r, http.StatusOK, request)
+}
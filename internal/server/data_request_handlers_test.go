@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"langlite-ingestion/internal/database"
+)
+
+// dataRequestDB has one end user, u1, in project-1 with a trace and a session.
+type dataRequestDB struct {
+	database.Service
+
+	mu       sync.Mutex
+	requests map[string]*database.DataRequest
+	erased   chan string
+}
+
+func (f *dataRequestDB) CreateDataRequest(req database.DataRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests[req.ID] = &req
+	return nil
+}
+
+func (f *dataRequestDB) GetDataRequest(projectID, requestID string) (*database.DataRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	req, ok := f.requests[requestID]
+	if !ok || req.ProjectID != projectID {
+		return nil, database.ErrDataRequestNotFound
+	}
+	return req, nil
+}
+
+func (f *dataRequestDB) FinishDataRequest(requestID string, receipt database.DataRequestReceipt, failure error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	req := f.requests[requestID]
+	req.Status = database.DataRequestCompleted
+	req.Receipt = &receipt
+	if failure != nil {
+		req.Status = database.DataRequestFailed
+		req.Error = failure.Error()
+	}
+	return nil
+}
+
+func (f *dataRequestDB) ExportUserData(projectID, userID string, emit func(string, json.RawMessage) error) (database.DataRequestReceipt, error) {
+	if projectID != "project-1" || userID != "u1" {
+		return database.DataRequestReceipt{}, database.ErrUserNotFound
+	}
+	if err := emit("trace", json.RawMessage(`{"trace":{"id":"t1"},"spans":[],"generations":[],"events":[],"scores":[]}`)); err != nil {
+		return database.DataRequestReceipt{}, err
+	}
+	if err := emit("session", json.RawMessage(`{"id":"s1"}`)); err != nil {
+		return database.DataRequestReceipt{}, err
+	}
+	return database.DataRequestReceipt{Traces: 1, Sessions: 1}, nil
+}
+
+func (f *dataRequestDB) EraseUserData(requestID string) error {
+	f.erased <- requestID
+	return nil
+}
+
+func newDataRequestDB() *dataRequestDB {
+	return &dataRequestDB{
+		requests: make(map[string]*database.DataRequest),
+		erased:   make(chan string, 1),
+	}
+}
+
+func dataRequest(t *testing.T, db *dataRequestDB, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	s := &Server{db: db}
+	r := chi.NewRouter()
+	r.Get("/api/v1/users/{id}/export", s.ExportUserDataHandler)
+	r.Post("/api/v1/users/{id}/erasure", s.EraseUserDataHandler)
+	r.Get("/api/v1/data-requests/{id}", s.GetDataRequestHandler)
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	authCtx := database.AuthContext{ProjectID: "project-1", APIKeyID: "key-1"}
+	req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestExportUserData(t *testing.T) {
+	db := newDataRequestDB()
+
+	rec := dataRequest(t, db, http.MethodGet, "/api/v1/users/u1/export", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status OK; got %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment;") {
+		t.Errorf("expected an attachment; got %q", rec.Header().Get("Content-Disposition"))
+	}
+
+	var archive struct {
+		RequestID string                      `json:"request_id"`
+		UserID    string                      `json:"user_id"`
+		Traces    []json.RawMessage           `json:"traces"`
+		Sessions  []json.RawMessage           `json:"sessions"`
+		Receipt   database.DataRequestReceipt `json:"receipt"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &archive); err != nil {
+		t.Fatalf("archive is not valid JSON: %v\n%s", err, rec.Body)
+	}
+	if archive.UserID != "u1" || len(archive.Traces) != 1 || len(archive.Sessions) != 1 || archive.Receipt.Traces != 1 {
+		t.Errorf("unexpected archive %+v", archive)
+	}
+
+	req := db.requests[archive.RequestID]
+	if req == nil {
+		t.Fatalf("expected export %s to be recorded", archive.RequestID)
+	}
+	if req.Kind != database.DataRequestExport || req.Status != database.DataRequestCompleted || req.UserID != "" {
+		t.Errorf("unexpected data request %+v", req)
+	}
+	if req.SubjectHash != database.HashSubject("project-1", "u1") || req.RequestedBy != "key-1" {
+		t.Errorf("unexpected data request %+v", req)
+	}
+}
+
+func TestExportUnknownUser(t *testing.T) {
+	db := newDataRequestDB()
+
+	rec := dataRequest(t, db, http.MethodGet, "/api/v1/users/nobody/export", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status Not Found; got %d: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Content-Disposition") != "" {
+		t.Errorf("expected no attachment for an unknown user")
+	}
+
+	for _, req := range db.requests {
+		if req.Status != database.DataRequestFailed {
+			t.Errorf("expected failed export to be recorded; got %+v", req)
+		}
+	}
+}
+
+func TestEraseUserData(t *testing.T) {
+	cases := map[string]string{
+		"":                      database.ErasureDelete,
+		`{}`:                    database.ErasureDelete,
+		`{"mode": "anonymize"}`: database.ErasureAnonymize,
+	}
+
+	for body, mode := range cases {
+		db := newDataRequestDB()
+
+		rec := dataRequest(t, db, http.MethodPost, "/api/v1/users/u1/erasure", body)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("%q: expected status Accepted; got %d: %s", body, rec.Code, rec.Body)
+		}
+
+		var resp database.DataRequest
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%q: decode response: %v", body, err)
+		}
+		if resp.Kind != database.DataRequestErasure || resp.Mode != mode || resp.Status != database.DataRequestPending {
+			t.Errorf("%q: unexpected data request %+v", body, resp)
+		}
+
+		select {
+		case id := <-db.erased:
+			if id != resp.ID {
+				t.Errorf("%q: expected erasure of %s; got %s", body, resp.ID, id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q: expected erasure to run without a queue", body)
+		}
+
+		rec = dataRequest(t, db, http.MethodGet, "/api/v1/data-requests/"+resp.ID, "")
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q: expected status OK; got %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestEraseUserDataRejectsUnknownMode(t *testing.T) {
+	db := newDataRequestDB()
+
+	rec := dataRequest(t, db, http.MethodPost, "/api/v1/users/u1/erasure", `{"mode": "shred"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status Bad Request; got %d: %s", rec.Code, rec.Body)
+	}
+	if len(db.requests) != 0 {
+		t.Errorf("expected no data request; got %d", len(db.requests))
+	}
+}
+
+func TestGetDataRequestNotFound(t *testing.T) {
+	db := newDataRequestDB()
+	db.requests["r1"] = &database.DataRequest{ID: "r1", ProjectID: "project-2"}
+
+	rec := dataRequest(t, db, http.MethodGet, "/api/v1/data-requests/r1", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status Not Found; got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"langlite-ingestion/internal/database"
+)
+
+// GetEnrichmentHandler lists the enrichers that run on the project's traces.
+func (s *Server) GetEnrichmentHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	settings, err := s.db.GetEnrichmentSettings(authCtx.ProjectID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get enrichment settings", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to get enrichment settings",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	encode(w, r, http.StatusOK, s.enrichmentResponse(authCtx.ProjectID, settings))
+}
+
+// PutEnrichmentHandler replaces the enrichers that run on the project's
+// traces. A null list goes back to every enricher offered; an empty one turns
+// enrichment off.
+func (s *Server) PutEnrichmentHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	setAuditTarget(r, "project:"+authCtx.ProjectID)
+
+	settings, problems, err := decodeValid[database.EnrichmentSettings](r)
+	if err != nil {
+		if len(problems) > 0 {
+			errorResp := database.ErrorResponse{
+				Error:    "Validation failed",
+				Message:  "The request contains invalid data",
+				Code:     http.StatusBadRequest,
+				Problems: problems,
+			}
+			encode(w, r, http.StatusBadRequest, errorResp)
+			return
+		}
+
+		errorResp := database.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Could not parse request body",
+			Code:    http.StatusBadRequest,
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+
+	for _, name := range settings.Enrichers {
+		if !s.enrichers.Has(name) {
+			errorResp := database.ErrorResponse{
+				Error:    "Validation failed",
+				Message:  "The request contains invalid data",
+				Code:     http.StatusBadRequest,
+				Problems: map[string]string{"enrichers": fmt.Sprintf("%s isn't an enricher this deployment offers", name)},
+			}
+			encode(w, r, http.StatusBadRequest, errorResp)
+			return
+		}
+	}
+
+	if err := s.db.SetEnrichmentSettings(authCtx.ProjectID, settings); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to set enrichment settings", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to set enrichment settings",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	var stored *database.EnrichmentSettings
+	if settings.Enrichers != nil {
+		stored = &settings
+	}
+	encode(w, r, http.StatusOK, s.enrichmentResponse(authCtx.ProjectID, stored))
+}
+
+// enrichmentResponse lists the enrichers settings run, every one offered if
+// settings is nil.
+func (s *Server) enrichmentResponse(projectID string, settings *database.EnrichmentSettings) database.EnrichmentResponse {
+	if settings == nil {
+		return database.EnrichmentResponse{
+			ProjectID: projectID,
+			Enrichers: s.enrichers.Names(),
+			Default:   true,
+		}
+	}
+	return database.EnrichmentResponse{
+		ProjectID: projectID,
+		Enrichers: settings.Enrichers,
+	}
+}
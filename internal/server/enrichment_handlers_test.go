@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/enrich"
+)
+
+type enrichmentDB struct {
+	database.Service
+	settings map[string]database.EnrichmentSettings
+}
+
+func (f *enrichmentDB) GetEnrichmentSettings(projectID string) (*database.EnrichmentSettings, error) {
+	if settings, ok := f.settings[projectID]; ok {
+		return &settings, nil
+	}
+	return nil, nil
+}
+
+func (f *enrichmentDB) SetEnrichmentSettings(projectID string, settings database.EnrichmentSettings) error {
+	if settings.Enrichers == nil {
+		delete(f.settings, projectID)
+		return nil
+	}
+	f.settings[projectID] = settings
+	return nil
+}
+
+func enrichmentRequest(t *testing.T, db *enrichmentDB, method, body string) (int, database.EnrichmentResponse) {
+	t.Helper()
+
+	s := &Server{db: db, enrichers: enrich.DefaultRegistry(nil)}
+	r := chi.NewRouter()
+	r.Get("/api/v1/enrichment", s.GetEnrichmentHandler)
+	r.Put("/api/v1/enrichment", s.PutEnrichmentHandler)
+
+	req := httptest.NewRequest(method, "/api/v1/enrichment", strings.NewReader(body))
+	authCtx := database.AuthContext{ProjectID: "project-1", APIKeyID: "key-1"}
+	req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	var resp database.EnrichmentResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestEnrichmentRoundTrip(t *testing.T) {
+	db := &enrichmentDB{settings: make(map[string]database.EnrichmentSettings)}
+
+	code, resp := enrichmentRequest(t, db, http.MethodGet, "")
+	if code != http.StatusOK || !resp.Default || len(resp.Enrichers) != 3 {
+		t.Fatalf("expected every built-in enricher by default; got %d %+v", code, resp)
+	}
+
+	code, resp = enrichmentRequest(t, db, http.MethodPut, `{"enrichers": ["duration"]}`)
+	if code != http.StatusOK || resp.Default || len(resp.Enrichers) != 1 {
+		t.Fatalf("expected only duration; got %d %+v", code, resp)
+	}
+
+	code, resp = enrichmentRequest(t, db, http.MethodPut, `{"enrichers": []}`)
+	if code != http.StatusOK || resp.Default || resp.Enrichers == nil || len(resp.Enrichers) != 0 {
+		t.Fatalf("expected enrichment off; got %d %+v", code, resp)
+	}
+
+	code, resp = enrichmentRequest(t, db, http.MethodPut, `{"enrichers": null}`)
+	if code != http.StatusOK || !resp.Default {
+		t.Fatalf("expected the default back; got %d %+v", code, resp)
+	}
+	if _, ok := db.settings["project-1"]; ok {
+		t.Error("expected the project's settings removed")
+	}
+}
+
+func TestEnrichmentRejects(t *testing.T) {
+	for _, body := range []string{
+		`{"enrichers": ["geo"]}`,
+		`{"enrichers": ["duration", "duration"]}`,
+		`{"enrichers": [""]}`,
+	} {
+		db := &enrichmentDB{settings: make(map[string]database.EnrichmentSettings)}
+		if code, _ := enrichmentRequest(t, db, http.MethodPut, body); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400; got %d", body, code)
+		}
+	}
+}
@@ -1,16 +1,22 @@
 package server
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 
-	"github.com/google/uuid"
 	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/ingest"
 )
 
-func (s *Server) CreateTrace(w http.ResponseWriter, r *http.Request) {
+// ingestFunc adds a single decoded item to an ingest.Batch.
+type ingestFunc[T any] func(b *ingest.Batch, ctx context.Context, req T) (ingest.Result, error)
+
+// handleIngest decodes a single item of type T and hands it to ingestor. It
+// answers 201 when the item was written and 202 when it was enqueued.
+func handleIngest[T any](w http.ResponseWriter, r *http.Request, ingestor *ingest.Ingestor, add ingestFunc[T]) {
 	authCtx, ok := GetAuthContext(r)
 	if !ok {
 		errorResp := database.ErrorResponse{
@@ -22,175 +28,140 @@ func (s *Server) CreateTrace(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req, problems, err := decodeValid[database.TraceRequest](r)
+	req, err := decode[T](r)
 	if err != nil {
-		if len(problems) > 0 {
-			errorResp := database.ErrorResponse{
-				Error:    "Validation failed",
-				Message:  "The request contains invalid data",
-				Code:     http.StatusBadRequest,
-				Problems: problems,
-			}
-			encode(w, r, http.StatusBadRequest, errorResp)
-			return
-		}
-
-		errorResp := database.ErrorResponse{
-			Error:   "Invalid request",
-			Message: "Could not parse request body",
-			Code:    http.StatusBadRequest,
-		}
-		encode(w, r, http.StatusBadRequest, errorResp)
+		encodeDecodeError(w, r, err)
 		return
 	}
 
-	req.ProjectID = authCtx.ProjectID
-
-	if req.ID == "" {
-		req.ID = uuid.New().String()
-	}
-
-	if req.StartTime.IsZero() {
-		req.StartTime = time.Now().UTC()
+	result, err := add(ingestor.Batch(authCtx.ProjectID), r.Context(), req)
+	if err != nil {
+		encodeIngestError(w, r, err)
+		return
 	}
 
-	if err := s.db.CreateTrace(req); err != nil {
-		errorResp := database.ErrorResponse{
-			Error:   "Database error",
-			Message: "Failed to create trace",
-			Code:    http.StatusInternalServerError,
-		}
-		encode(w, r, http.StatusInternalServerError, errorResp)
-		return
+	statusCode := http.StatusCreated
+	if result.Status == ingest.StatusAccepted {
+		statusCode = http.StatusAccepted
 	}
 
 	response := database.SuccessResponse{
-		ID:     req.ID,
-		Status: "created",
+		ID:     result.ID,
+		Status: string(result.Status),
 	}
 
-	encode(w, r, http.StatusCreated, response)
+	encode(w, r, statusCode, response)
 }
 
-func (s *Server) CreateGeneration(w http.ResponseWriter, r *http.Request) {
-	req, problems, err := decodeValid[database.GenerationRequest](r)
-	if err != nil {
-		if len(problems) > 0 {
-			errorResp := database.ErrorResponse{
-				Error:    "Validation failed",
-				Message:  "The request contains invalid data",
-				Code:     http.StatusBadRequest,
-				Problems: problems,
-			}
-			encode(w, r, http.StatusBadRequest, errorResp)
-			return
-		}
-
+func encodeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	if problems := bodyProblems(err); len(problems) > 0 {
 		errorResp := database.ErrorResponse{
-			Error:   "Invalid request",
-			Message: "Could not parse request body",
-			Code:    http.StatusBadRequest,
+			Error:    "Validation failed",
+			Message:  "The request contains invalid data",
+			Code:     http.StatusBadRequest,
+			Problems: problems,
 		}
 		encode(w, r, http.StatusBadRequest, errorResp)
 		return
 	}
 
-	if req.ID == "" {
-		req.ID = uuid.New().String()
-	}
-
-	if req.StartTime.IsZero() {
-		req.StartTime = time.Now().UTC()
+	errorResp := database.ErrorResponse{
+		Error:   "Invalid request",
+		Message: "Could not parse request body",
+		Code:    http.StatusBadRequest,
 	}
+	encode(w, r, http.StatusBadRequest, errorResp)
+}
 
-	if err := s.db.CreateGeneration(req); err != nil {
+// encodeIngestError maps an ingest.Error onto an HTTP status and
+// ErrorResponse.
+func encodeIngestError(w http.ResponseWriter, r *http.Request, err error) {
+	var ingestErr *ingest.Error
+	if !errors.As(err, &ingestErr) {
 		errorResp := database.ErrorResponse{
-			Error:   "Database error",
-			Message: "Failed to create generation",
+			Error:   "Internal error",
+			Message: "Failed to process request",
 			Code:    http.StatusInternalServerError,
 		}
 		encode(w, r, http.StatusInternalServerError, errorResp)
 		return
 	}
 
-	response := database.SuccessResponse{
-		ID:     req.ID,
-		Status: "created",
-	}
+	statusCode := http.StatusInternalServerError
+	switch ingestErr.Kind {
+	case ingest.KindValidation, ingest.KindInvalidReference:
+		statusCode = http.StatusBadRequest
+	case ingest.KindNotFound:
+		statusCode = http.StatusNotFound
+	case ingest.KindConflict:
+		statusCode = http.StatusConflict
+	case ingest.KindUnavailable:
+		statusCode = http.StatusServiceUnavailable
+	case ingest.KindOverloaded:
+		statusCode = http.StatusServiceUnavailable
+		setRetryAfter(w, ingestErr.RetryAfter)
+	}
+
+	errorResp := database.ErrorResponse{
+		Error:    ingestErr.Title,
+		Message:  ingestErr.Message,
+		Code:     statusCode,
+		Problems: ingestErr.Problems,
+	}
+	encode(w, r, statusCode, errorResp)
+}
 
-	encode(w, r, http.StatusCreated, response)
+// setRetryAfter sets the Retry-After header in whole seconds, rounding up.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	seconds := int((d + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
 }
 
-func (s *Server) CreateSpan(w http.ResponseWriter, r *http.Request) {
-	req, problems, err := decodeValid[database.SpanRequest](r)
+// batchResult converts the outcome of adding one item to a batch into a
+// database.BatchResult.
+func batchResult(index int, result ingest.Result, err error) database.BatchResult {
 	if err != nil {
-		if len(problems) > 0 {
-			errorResp := database.ErrorResponse{
-				Error:    "Validation failed",
-				Message:  "The request contains invalid data",
-				Code:     http.StatusBadRequest,
-				Problems: problems,
-			}
-			encode(w, r, http.StatusBadRequest, errorResp)
-			return
-		}
-
-		errorResp := database.ErrorResponse{
-			Error:   "Invalid request",
-			Message: "Could not parse request body",
-			Code:    http.StatusBadRequest,
+		return database.BatchResult{
+			Index:  index,
+			Status: "error",
+			Error:  err.Error(),
 		}
-		encode(w, r, http.StatusBadRequest, errorResp)
-		return
 	}
 
-	if req.ID == "" {
-		req.ID = uuid.New().String()
+	return database.BatchResult{
+		Index:  index,
+		ID:     result.ID,
+		Status: "success",
 	}
+}
 
-	if req.StartTime.IsZero() {
-		req.StartTime = time.Now().UTC()
-	}
+func (s *Server) CreateTrace(w http.ResponseWriter, r *http.Request) {
+	handleIngest(w, r, s.ingestor.WithMode(ingest.ModeSync), (*ingest.Batch).Trace)
+}
 
-	if !s.db.TraceExists(req.TraceID) {
-		errorResp := database.ErrorResponse{
-			Error:   "Invalid trace",
-			Message: "The specified trace_id does not exist",
-			Code:    http.StatusBadRequest,
-		}
-		encode(w, r, http.StatusBadRequest, errorResp)
-		return
-	}
+func (s *Server) CreateGeneration(w http.ResponseWriter, r *http.Request) {
+	handleIngest(w, r, s.ingestor.WithMode(ingest.ModeSync), (*ingest.Batch).Generation)
+}
 
-	if req.ParentID != "" && !s.db.SpanExists(req.ParentID) {
-		errorResp := database.ErrorResponse{
-			Error:   "Invalid parent span",
-			Message: "The specified parent_id does not exist",
-			Code:    http.StatusBadRequest,
-		}
-		encode(w, r, http.StatusBadRequest, errorResp)
-		return
-	}
+func (s *Server) CreateSpan(w http.ResponseWriter, r *http.Request) {
+	handleIngest(w, r, s.ingestor.WithMode(ingest.ModeSync), (*ingest.Batch).Span)
+}
 
-	if err := s.db.CreateSpan(req); err != nil {
+func (s *Server) UpdateSpan(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
 		errorResp := database.ErrorResponse{
-			Error:   "Database error",
-			Message: "Failed to create span",
-			Code:    http.StatusInternalServerError,
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
 		}
-		encode(w, r, http.StatusInternalServerError, errorResp)
+		encode(w, r, http.StatusUnauthorized, errorResp)
 		return
 	}
 
-	response := database.SuccessResponse{
-		ID:     req.ID,
-		Status: "created",
-	}
-
-	encode(w, r, http.StatusCreated, response)
-}
-
-func (s *Server) UpdateSpan(w http.ResponseWriter, r *http.Request) {
 	spanID := r.PathValue("id")
 	if spanID == "" {
 		errorResp := database.ErrorResponse{
@@ -202,62 +173,38 @@ func (s *Server) UpdateSpan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req, problems, err := decodeValid[database.SpanUpdateRequest](r)
+	req, err := decode[database.SpanUpdateRequest](r)
 	if err != nil {
-		if len(problems) > 0 {
-			errorResp := database.ErrorResponse{
-				Error:    "Validation failed",
-				Message:  "The request contains invalid data",
-				Code:     http.StatusBadRequest,
-				Problems: problems,
-			}
-			encode(w, r, http.StatusBadRequest, errorResp)
-			return
-		}
-
-		errorResp := database.ErrorResponse{
-			Error:   "Invalid request",
-			Message: "Could not parse request body",
-			Code:    http.StatusBadRequest,
-		}
-		encode(w, r, http.StatusBadRequest, errorResp)
+		encodeDecodeError(w, r, err)
 		return
 	}
 
-	if req.EndTime == nil && req.Metadata != nil {
-		now := time.Now().UTC()
-		req.EndTime = &now
-	}
-
-	if err := s.db.UpdateSpan(spanID, req); err != nil {
-		if err.Error() == "span not found" {
-			errorResp := database.ErrorResponse{
-				Error:   "Span not found",
-				Message: "The specified span does not exist",
-				Code:    http.StatusNotFound,
-			}
-			encode(w, r, http.StatusNotFound, errorResp)
-			return
-		}
-
-		errorResp := database.ErrorResponse{
-			Error:   "Database error",
-			Message: "Failed to update span",
-			Code:    http.StatusInternalServerError,
-		}
-		encode(w, r, http.StatusInternalServerError, errorResp)
+	result, err := s.ingestor.UpdateSpan(r.Context(), authCtx.ProjectID, spanID, req)
+	if err != nil {
+		encodeIngestError(w, r, err)
 		return
 	}
 
 	response := database.SuccessResponse{
-		ID:     spanID,
-		Status: "updated",
+		ID:     result.ID,
+		Status: string(result.Status),
 	}
 
 	encode(w, r, http.StatusOK, response)
 }
 
 func (s *Server) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
 	batchReq, problems, err := decodeValid[database.BatchRequest](r)
 	if err != nil {
 		if len(problems) > 0 {
@@ -285,61 +232,38 @@ func (s *Server) BatchHandler(w http.ResponseWriter, r *http.Request) {
 		Results: make([]database.BatchResult, 0, totalItems),
 	}
 
-	index := 0
+	ctx := r.Context()
+	// Items are written in order, so children can reference traces and spans
+	// earlier in the same batch.
+	batch := s.ingestor.WithMode(ingest.ModeSync).Batch(authCtx.ProjectID)
 
-	for _, traceReq := range batchReq.Traces {
-		result := s.processBatchTrace(traceReq, index, r)
-		response.Results = append(response.Results, result)
-		if result.Status == "success" {
+	add := func(result ingest.Result, err error) {
+		response.Results = append(response.Results, batchResult(len(response.Results), result, err))
+		if err == nil {
 			response.Summary.Succeeded++
 		} else {
 			response.Summary.Failed++
 		}
-		index++
+	}
+
+	for _, traceReq := range batchReq.Traces {
+		add(batch.Trace(ctx, traceReq))
 	}
 
 	for _, spanReq := range batchReq.Spans {
-		result := s.processBatchSpan(spanReq, index, r)
-		response.Results = append(response.Results, result)
-		if result.Status == "success" {
-			response.Summary.Succeeded++
-		} else {
-			response.Summary.Failed++
-		}
-		index++
+		add(batch.Span(ctx, spanReq))
 	}
 
 	for _, genReq := range batchReq.Generations {
-		result := s.processBatchGeneration(genReq, index, r)
-		response.Results = append(response.Results, result)
-		if result.Status == "success" {
-			response.Summary.Succeeded++
-		} else {
-			response.Summary.Failed++
-		}
-		index++
+		add(batch.Generation(ctx, genReq))
 	}
 
 	for _, eventReq := range batchReq.Events {
-		result := s.processBatchEvent(eventReq, index, r)
-		response.Results = append(response.Results, result)
-		if result.Status == "success" {
-			response.Summary.Succeeded++
-		} else {
-			response.Summary.Failed++
-		}
-		index++
+		add(batch.Event(ctx, eventReq))
 	}
 
 	for _, scoreReq := range batchReq.Scores {
-		result := s.processBatchScore(scoreReq, index, r)
-		response.Results = append(response.Results, result)
-		if result.Status == "success" {
-			response.Summary.Succeeded++
-		} else {
-			response.Summary.Failed++
-		}
-		index++
+		add(batch.Score(ctx, scoreReq))
 	}
 
 	response.Summary.Total = totalItems
@@ -352,215 +276,18 @@ func (s *Server) BatchHandler(w http.ResponseWriter, r *http.Request) {
 	encode(w, r, statusCode, response)
 }
 
-func (s *Server) processBatchTrace(traceReq database.TraceRequest, index int, r *http.Request) database.BatchResult {
-	result := database.BatchResult{
-		Index:  index,
-		Status: "error",
-	}
-
-	if problems := traceReq.Valid(r.Context()); len(problems) > 0 {
-		var errorMessages []string
-		for field, problem := range problems {
-			errorMessages = append(errorMessages, fmt.Sprintf("%s: %s", field, problem))
-		}
-		result.Error = "Validation failed: " + strings.Join(errorMessages, ", ")
-		return result
-	}
-
-	if traceReq.ID == "" {
-		traceReq.ID = uuid.New().String()
-	}
-
-	if traceReq.StartTime.IsZero() {
-		traceReq.StartTime = time.Now().UTC()
-	}
-
-	if err := s.db.CreateTrace(traceReq); err != nil {
-		result.Error = "Database error: " + err.Error()
-		return result
-	}
-
-	result.ID = traceReq.ID
-	result.Status = "success"
-	result.Error = ""
-	return result
-}
-
-func (s *Server) processBatchSpan(spanReq database.SpanRequest, index int, r *http.Request) database.BatchResult {
-	result := database.BatchResult{
-		Index:  index,
-		Status: "error",
-	}
-
-	if problems := spanReq.Valid(r.Context()); len(problems) > 0 {
-		var errorMessages []string
-		for field, problem := range problems {
-			errorMessages = append(errorMessages, fmt.Sprintf("%s: %s", field, problem))
-		}
-		result.Error = "Validation failed: " + strings.Join(errorMessages, ", ")
-		return result
-	}
-
-	if spanReq.ID == "" {
-		spanReq.ID = uuid.New().String()
-	}
-
-	if spanReq.StartTime.IsZero() {
-		spanReq.StartTime = time.Now().UTC()
-	}
-
-	if !s.db.TraceExists(spanReq.TraceID) {
-		result.Error = "Invalid trace: The specified trace_id does not exist"
-		return result
-	}
-
-	if spanReq.ParentID != "" && !s.db.SpanExists(spanReq.ParentID) {
-		result.Error = "Invalid parent span: The specified parent_id does not exist"
-		return result
-	}
-
-	if err := s.db.CreateSpan(spanReq); err != nil {
-		result.Error = "Database error: " + err.Error()
-		return result
-	}
-
-	result.ID = spanReq.ID
-	result.Status = "success"
-	result.Error = ""
-	return result
-}
-
-func (s *Server) processBatchGeneration(genReq database.GenerationRequest, index int, r *http.Request) database.BatchResult {
-	result := database.BatchResult{
-		Index:  index,
-		Status: "error",
-	}
-
-	if problems := genReq.Valid(r.Context()); len(problems) > 0 {
-		var errorMessages []string
-		for field, problem := range problems {
-			errorMessages = append(errorMessages, fmt.Sprintf("%s: %s", field, problem))
-		}
-		result.Error = "Validation failed: " + strings.Join(errorMessages, ", ")
-		return result
-	}
-
-	if genReq.ID == "" {
-		genReq.ID = uuid.New().String()
-	}
-
-	if genReq.StartTime.IsZero() {
-		genReq.StartTime = time.Now().UTC()
-	}
-
-	if err := s.db.CreateGeneration(genReq); err != nil {
-		result.Error = "Database error: " + err.Error()
-		return result
-	}
-
-	result.ID = genReq.ID
-	result.Status = "success"
-	result.Error = ""
-	return result
-}
-
-func (s *Server) processBatchEvent(eventReq database.EventRequest, index int, r *http.Request) database.BatchResult {
-	result := database.BatchResult{
-		Index:  index,
-		Status: "error",
-	}
-
-	if problems := eventReq.Valid(r.Context()); len(problems) > 0 {
-		var errorMessages []string
-		for field, problem := range problems {
-			errorMessages = append(errorMessages, fmt.Sprintf("%s: %s", field, problem))
-		}
-		result.Error = "Validation failed: " + strings.Join(errorMessages, ", ")
-		return result
-	}
-
-	if eventReq.ID == "" {
-		eventReq.ID = uuid.New().String()
-	}
-
-	if eventReq.Timestamp.IsZero() {
-		eventReq.Timestamp = time.Now().UTC()
-	}
-
-	if eventReq.Level == "" {
-		eventReq.Level = "info"
-	}
-
-	if !s.db.TraceExists(eventReq.TraceID) {
-		result.Error = "Invalid trace: The specified trace_id does not exist"
-		return result
-	}
-
-	if eventReq.SpanID != "" && !s.db.SpanExists(eventReq.SpanID) {
-		result.Error = "Invalid span: The specified span_id does not exist"
-		return result
-	}
-
-	if err := s.db.CreateEvent(eventReq); err != nil {
-		result.Error = "Database error: " + err.Error()
-		return result
-	}
-
-	result.ID = eventReq.ID
-	result.Status = "success"
-	result.Error = ""
-	return result
-}
-
-func (s *Server) processBatchScore(scoreReq database.ScoreRequest, index int, r *http.Request) database.BatchResult {
-	result := database.BatchResult{
-		Index:  index,
-		Status: "error",
-	}
-
-	if problems := scoreReq.Valid(r.Context()); len(problems) > 0 {
-		var errorMessages []string
-		for field, problem := range problems {
-			errorMessages = append(errorMessages, fmt.Sprintf("%s: %s", field, problem))
+func (s *Server) TraceBatchHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
 		}
-		result.Error = "Validation failed: " + strings.Join(errorMessages, ", ")
-		return result
-	}
-
-	if scoreReq.ID == "" {
-		scoreReq.ID = uuid.New().String()
-	}
-
-	if scoreReq.Timestamp.IsZero() {
-		scoreReq.Timestamp = time.Now().UTC()
-	}
-
-	if scoreReq.Source == "" {
-		scoreReq.Source = "human"
-	}
-
-	if scoreReq.TraceID != "" && !s.db.TraceExists(scoreReq.TraceID) {
-		result.Error = "Invalid trace: The specified trace_id does not exist"
-		return result
-	}
-
-	if scoreReq.GenerationID != "" && !s.db.GenerationExists(scoreReq.GenerationID) {
-		result.Error = "Invalid generation: The specified generation_id does not exist"
-		return result
-	}
-
-	if err := s.db.CreateScore(scoreReq); err != nil {
-		result.Error = "Database error: " + err.Error()
-		return result
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
 	}
 
-	result.ID = scoreReq.ID
-	result.Status = "success"
-	result.Error = ""
-	return result
-}
-
-func (s *Server) TraceBatchHandler(w http.ResponseWriter, r *http.Request) {
 	batchReq, problems, err := decodeValid[database.BatchTraceRequest](r)
 	if err != nil {
 		if len(problems) > 0 {
@@ -587,43 +314,16 @@ func (s *Server) TraceBatchHandler(w http.ResponseWriter, r *http.Request) {
 		Results: make([]database.BatchResult, len(batchReq.Traces)),
 	}
 
-	for i, traceReq := range batchReq.Traces {
-		result := database.BatchResult{
-			Index:  i,
-			Status: "error",
-		}
-
-		if problems := traceReq.Valid(r.Context()); len(problems) > 0 {
-			var errorMessages []string
-			for field, problem := range problems {
-				errorMessages = append(errorMessages, fmt.Sprintf("%s: %s", field, problem))
-			}
-			result.Error = "Validation failed: " + strings.Join(errorMessages, ", ")
-			response.Results[i] = result
-			response.Summary.Failed++
-			continue
-		}
-
-		if traceReq.ID == "" {
-			traceReq.ID = uuid.New().String()
-		}
-
-		if traceReq.StartTime.IsZero() {
-			traceReq.StartTime = time.Now().UTC()
-		}
+	batch := s.ingestor.WithMode(ingest.ModeSync).Batch(authCtx.ProjectID)
 
-		if err := s.db.CreateTrace(traceReq); err != nil {
-			result.Error = "Database error: " + err.Error()
-			response.Results[i] = result
+	for i, traceReq := range batchReq.Traces {
+		result, err := batch.Trace(r.Context(), traceReq)
+		response.Results[i] = batchResult(i, result, err)
+		if err != nil {
 			response.Summary.Failed++
-			continue
+		} else {
+			response.Summary.Succeeded++
 		}
-
-		result.ID = traceReq.ID
-		result.Status = "success"
-		result.Error = ""
-		response.Results[i] = result
-		response.Summary.Succeeded++
 	}
 
 	response.Summary.Total = len(batchReq.Traces)
@@ -637,147 +337,9 @@ func (s *Server) TraceBatchHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) EventHandler(w http.ResponseWriter, r *http.Request) {
-	req, problems, err := decodeValid[database.EventRequest](r)
-	if err != nil {
-		if len(problems) > 0 {
-			errorResp := database.ErrorResponse{
-				Error:    "Validation failed",
-				Message:  "The request contains invalid data",
-				Code:     http.StatusBadRequest,
-				Problems: problems,
-			}
-			encode(w, r, http.StatusBadRequest, errorResp)
-			return
-		}
-
-		errorResp := database.ErrorResponse{
-			Error:   "Invalid request",
-			Message: "Could not parse request body",
-			Code:    http.StatusBadRequest,
-		}
-		encode(w, r, http.StatusBadRequest, errorResp)
-		return
-	}
-
-	if req.ID == "" {
-		req.ID = uuid.New().String()
-	}
-
-	if req.Timestamp.IsZero() {
-		req.Timestamp = time.Now().UTC()
-	}
-
-	if req.Level == "" {
-		req.Level = "info"
-	}
-
-	if !s.db.TraceExists(req.TraceID) {
-		errorResp := database.ErrorResponse{
-			Error:   "Invalid trace",
-			Message: "The specified trace_id does not exist",
-			Code:    http.StatusBadRequest,
-		}
-		encode(w, r, http.StatusBadRequest, errorResp)
-		return
-	}
-
-	if req.SpanID != "" && !s.db.SpanExists(req.SpanID) {
-		errorResp := database.ErrorResponse{
-			Error:   "Invalid span",
-			Message: "The specified span_id does not exist",
-			Code:    http.StatusBadRequest,
-		}
-		encode(w, r, http.StatusBadRequest, errorResp)
-		return
-	}
-
-	if err := s.db.CreateEvent(req); err != nil {
-		errorResp := database.ErrorResponse{
-			Error:   "Database error",
-			Message: "Failed to create event",
-			Code:    http.StatusInternalServerError,
-		}
-		encode(w, r, http.StatusInternalServerError, errorResp)
-		return
-	}
-
-	response := database.SuccessResponse{
-		ID:     req.ID,
-		Status: "created",
-	}
-
-	encode(w, r, http.StatusCreated, response)
+	handleIngest(w, r, s.ingestor.WithMode(ingest.ModeSync), (*ingest.Batch).Event)
 }
 
 func (s *Server) ScoreHandler(w http.ResponseWriter, r *http.Request) {
-	req, problems, err := decodeValid[database.ScoreRequest](r)
-	if err != nil {
-		if len(problems) > 0 {
-			errorResp := database.ErrorResponse{
-				Error:    "Validation failed",
-				Message:  "The request contains invalid data",
-				Code:     http.StatusBadRequest,
-				Problems: problems,
-			}
-			encode(w, r, http.StatusBadRequest, errorResp)
-			return
-		}
-
-		errorResp := database.ErrorResponse{
-			Error:   "Invalid request",
-			Message: "Could not parse request body",
-			Code:    http.StatusBadRequest,
-		}
-		encode(w, r, http.StatusBadRequest, errorResp)
-		return
-	}
-
-	if req.ID == "" {
-		req.ID = uuid.New().String()
-	}
-
-	if req.Timestamp.IsZero() {
-		req.Timestamp = time.Now().UTC()
-	}
-
-	if req.Source == "" {
-		req.Source = "human"
-	}
-
-	if req.TraceID != "" && !s.db.TraceExists(req.TraceID) {
-		errorResp := database.ErrorResponse{
-			Error:   "Invalid trace",
-			Message: "The specified trace_id does not exist",
-			Code:    http.StatusBadRequest,
-		}
-		encode(w, r, http.StatusBadRequest, errorResp)
-		return
-	}
-
-	if req.GenerationID != "" && !s.db.GenerationExists(req.GenerationID) {
-		errorResp := database.ErrorResponse{
-			Error:   "Invalid generation",
-			Message: "The specified generation_id does not exist",
-			Code:    http.StatusBadRequest,
-		}
-		encode(w, r, http.StatusBadRequest, errorResp)
-		return
-	}
-
-	if err := s.db.CreateScore(req); err != nil {
-		errorResp := database.ErrorResponse{
-			Error:   "Database error",
-			Message: "Failed to create score",
-			Code:    http.StatusInternalServerError,
-		}
-		encode(w, r, http.StatusInternalServerError, errorResp)
-		return
-	}
-
-	response := database.SuccessResponse{
-		ID:     req.ID,
-		Status: "created",
-	}
-
-	encode(w, r, http.StatusCreated, response)
+	handleIngest(w, r, s.ingestor.WithMode(ingest.ModeSync), (*ingest.Batch).Score)
 }
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/ingest"
+)
+
+type nopEnqueuer struct {
+	ingest.Enqueuer
+}
+
+func (nopEnqueuer) EnqueueTrace(ctx context.Context, req database.TraceRequest) error {
+	return nil
+}
+
+type overloaded struct{}
+
+func (overloaded) Error() string             { return "queue is full" }
+func (overloaded) RetryAfter() time.Duration { return 1500 * time.Millisecond }
+
+type fullAdmission struct{}
+
+func (fullAdmission) Admit(ctx context.Context, projectID string) error {
+	return overloaded{}
+}
+
+func TestIngestBackpressure(t *testing.T) {
+	s := &Server{
+		ingestor: ingest.New(nil, nopEnqueuer{}, ingest.ModeAsync).WithAdmission(fullAdmission{}),
+	}
+
+	post := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		authCtx := database.AuthContext{ProjectID: "project-1", APIKeyID: "key-1"}
+		req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := post(s.CreateTraceAsync, `{"name": "chat"}`)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("expected 503 with Retry-After 2; got %d %q %s", rec.Code, rec.Header().Get("Retry-After"), rec.Body)
+	}
+}
+
+// syncDB records the items written directly to the database.
+type syncDB struct {
+	database.Service
+	written []string
+}
+
+func (d *syncDB) CreateTrace(req database.TraceRequest) error {
+	if slices.Contains(d.written, "trace "+req.ID) {
+		return fmt.Errorf("trace %s: %w", req.ID, database.ErrAlreadyExists)
+	}
+	d.written = append(d.written, "trace "+req.ID)
+	return nil
+}
+
+func (d *syncDB) CreateSpan(req database.SpanRequest) error {
+	d.written = append(d.written, "span "+req.ID)
+	return nil
+}
+
+func (d *syncDB) CreateEvent(req database.EventRequest) error {
+	d.written = append(d.written, "event "+req.ID)
+	return nil
+}
+
+func (d *syncDB) CreateScore(req database.ScoreRequest) error {
+	d.written = append(d.written, "score "+req.ID)
+	return nil
+}
+
+func (d *syncDB) TraceExists(projectID, traceID string) bool {
+	return slices.Contains(d.written, "trace "+traceID)
+}
+
+func (d *syncDB) SpanExists(projectID, spanID string) bool {
+	return slices.Contains(d.written, "span "+spanID)
+}
+
+func TestSyncHandlersWriteDirectly(t *testing.T) {
+	db := &syncDB{}
+	s := &Server{
+		ingestor: ingest.New(db, nopEnqueuer{}, ingest.ModeAsync).WithAdmission(fullAdmission{}),
+	}
+
+	post := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		authCtx := database.AuthContext{ProjectID: "project-1", APIKeyID: "key-1"}
+		req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := post(s.BatchHandler, `{
+		"traces": [{"id": "trace-1", "name": "a"}],
+		"spans": [{"id": "span-1", "trace_id": "trace-1", "name": "b"}],
+		"events": [{"id": "event-1", "trace_id": "trace-1", "span_id": "span-1", "name": "c", "message": "m"}]
+	}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for a batch written directly; got %d %s", rec.Code, rec.Body)
+	}
+	want := []string{"trace trace-1", "span span-1", "event event-1"}
+	if strings.Join(db.written, ",") != strings.Join(want, ",") {
+		t.Errorf("expected %v written in order, got %v", want, db.written)
+	}
+
+	rec = post(s.EventHandler, `{"id": "event-2", "trace_id": "trace-1", "name": "c", "message": "m"}`)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("expected 201 for an event; got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = post(s.ScoreHandler, `{"id": "score-1", "trace_id": "trace-1", "name": "quality", "value": 1}`)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("expected 201 for a score; got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCreateTraceConflict(t *testing.T) {
+	s := &Server{ingestor: ingest.New(&syncDB{}, nil, ingest.ModeSync)}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		authCtx := database.AuthContext{ProjectID: "project-1", APIKeyID: "key-1"}
+		req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+
+		rec := httptest.NewRecorder()
+		s.CreateTrace(rec, req)
+		return rec
+	}
+
+	if rec := post(`{"id": "trace-1", "name": "chat"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for a new trace; got %d %s", rec.Code, rec.Body)
+	}
+	rec := post(`{"id": "trace-1", "name": "chat"}`)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a resent trace id; got %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// probePaths are polled by orchestrators and load balancers, so they skip
+// authentication and rate limiting.
+var probePaths = map[string]bool{
+	"/health": true,
+	"/livez":  true,
+	"/readyz": true,
+}
+
+const (
+	// readinessTimeout bounds all dependency checks together.
+	readinessTimeout = 2 * time.Second
+
+	// workerHeartbeatTimeout is how long a worker can go without polling the
+	// queue before readiness counts it as stuck.
+	workerHeartbeatTimeout = 2 * time.Minute
+
+	// defaultMaxQueueDepth is the backlog above which the queue counts as
+	// saturated, unless LANGLITE_READY_MAX_QUEUE_DEPTH says otherwise.
+	defaultMaxQueueDepth = 10000
+)
+
+// Dependency check states. A disabled dependency isn't configured, e.g.
+// Redis when the server runs without the queue, and doesn't fail readiness.
+const (
+	checkUp       = "up"
+	checkDown     = "down"
+	checkDisabled = "disabled"
+)
+
+type dependencyCheck struct {
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type readinessResponse struct {
+	Status string                     `json:"status"`
+	Checks map[string]dependencyCheck `json:"checks"`
+}
+
+// LivezHandler reports that the process is up and serving. It doesn't look at
+// dependencies, so an outage elsewhere doesn't get the server restarted.
+func (s *Server) LivezHandler(w http.ResponseWriter, r *http.Request) {
+	encode(w, r, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyzHandler checks Postgres, Redis, the worker pool and the queue backlog
+// and answers 503 if any of them is down, so load balancers stop sending
+// traffic until the server can keep up again.
+func (s *Server) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) dependencyCheck{
+		"postgres": s.checkPostgres,
+		"redis":    s.checkRedis,
+		"workers":  s.checkWorkers,
+		"queue":    s.checkQueue,
+	}
+
+	response := readinessResponse{
+		Status: "ready",
+		Checks: make(map[string]dependencyCheck, len(checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := check(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			response.Checks[name] = result
+			if result.Status == checkDown {
+				response.Status = "not_ready"
+			}
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if response.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	encode(w, r, status, response)
+}
+
+func (s *Server) checkPostgres(ctx context.Context) dependencyCheck {
+	stats := s.db.Health()
+	if stats["status"] != "up" {
+		return dependencyCheck{Status: checkDown, Error: stats["error"]}
+	}
+	return dependencyCheck{Status: checkUp}
+}
+
+func (s *Server) checkRedis(ctx context.Context) dependencyCheck {
+	if s.redis == nil {
+		return dependencyCheck{Status: checkDisabled}
+	}
+	if err := s.redis.Ping(ctx).Err(); err != nil {
+		return dependencyCheck{Status: checkDown, Error: err.Error()}
+	}
+	return dependencyCheck{Status: checkUp}
+}
+
+// checkWorkers fails only when no worker has polled recently; one worker busy
+// with a long job shouldn't take the server out of rotation.
+func (s *Server) checkWorkers(ctx context.Context) dependencyCheck {
+	if s.workerPool == nil {
+		return dependencyCheck{Status: checkDisabled}
+	}
+
+	live, total := s.workerPool.LiveWorkers(workerHeartbeatTimeout)
+	check := dependencyCheck{
+		Status:  checkUp,
+		Details: map[string]any{"live": live, "total": total},
+	}
+	if live == 0 {
+		check.Status = checkDown
+		check.Error = fmt.Sprintf("no worker has polled the queue in %s", workerHeartbeatTimeout)
+	}
+	return check
+}
+
+func (s *Server) checkQueue(ctx context.Context) dependencyCheck {
+	if s.queueClient == nil {
+		return dependencyCheck{Status: checkDisabled}
+	}
+
+	backlog, err := s.queueClient.Backlog(ctx)
+	if err != nil {
+		return dependencyCheck{Status: checkDown, Error: err.Error()}
+	}
+
+	check := dependencyCheck{
+		Status:  checkUp,
+		Details: map[string]any{"backlog": backlog, "max_depth": s.maxQueueDepth},
+	}
+	if s.maxQueueDepth > 0 && backlog >= s.maxQueueDepth {
+		check.Status = checkDown
+		check.Error = fmt.Sprintf("queue saturated: %d jobs waiting", backlog)
+	}
+	return check
+}
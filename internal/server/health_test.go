@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/queue"
+)
+
+type healthDB struct {
+	database.Service
+	status string
+}
+
+func (f *healthDB) Health() map[string]string {
+	if f.status != "up" {
+		return map[string]string{"status": "down", "error": "db down: connection refused"}
+	}
+	return map[string]string{"status": "up"}
+}
+
+func readyz(t *testing.T, s *Server) (int, readinessResponse) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.ReadyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var resp readinessResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return rec.Code, resp
+}
+
+func TestLivez(t *testing.T) {
+	s := &Server{db: &healthDB{status: "down"}}
+
+	rec := httptest.NewRecorder()
+	s.LivezHandler(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected liveness to ignore dependencies; got %d", rec.Code)
+	}
+}
+
+func TestReadyzWithoutQueue(t *testing.T) {
+	code, resp := readyz(t, &Server{db: &healthDB{status: "up"}})
+	if code != http.StatusOK || resp.Status != "ready" {
+		t.Fatalf("expected ready; got %d %+v", code, resp)
+	}
+	for _, name := range []string{"redis", "workers", "queue"} {
+		if resp.Checks[name].Status != checkDisabled {
+			t.Errorf("expected %s to be disabled; got %+v", name, resp.Checks[name])
+		}
+	}
+
+	code, resp = readyz(t, &Server{db: &healthDB{status: "down"}})
+	if code != http.StatusServiceUnavailable || resp.Checks["postgres"].Status != checkDown || resp.Checks["postgres"].Error == "" {
+		t.Errorf("expected Postgres outage to fail readiness; got %d %+v", code, resp)
+	}
+}
+
+func TestReadyzWorkersAndBacklog(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	client := queue.NewClient(queue.NewRedisBackend(rdb))
+	pool := queue.NewWorkerPool(client, nil, nil, queue.Concurrency{queue.AnyJobType: 2})
+
+	s := &Server{
+		db:            &healthDB{status: "up"},
+		redis:         rdb,
+		queueClient:   client,
+		workerPool:    pool,
+		maxQueueDepth: 2,
+	}
+
+	code, resp := readyz(t, s)
+	if code != http.StatusServiceUnavailable || resp.Checks["workers"].Status != checkDown {
+		t.Fatalf("expected workers that never started to fail readiness; got %d %+v", code, resp)
+	}
+
+	// A cancelled context makes the workers beat once and exit.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pool.Start(ctx)
+	defer pool.Stop()
+
+	code, resp = readyz(t, s)
+	if code != http.StatusOK || resp.Checks["workers"].Status != checkUp || resp.Checks["redis"].Status != checkUp {
+		t.Fatalf("expected ready; got %d %+v", code, resp)
+	}
+
+	for range 2 {
+		if _, err := client.Enqueue(context.Background(), queue.QueueHigh, queue.StoreRawPayload{}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+
+	code, resp = readyz(t, s)
+	if code != http.StatusServiceUnavailable || resp.Checks["queue"].Status != checkDown {
+		t.Errorf("expected a saturated queue to fail readiness; got %d %+v", code, resp)
+	}
+}
@@ -5,16 +5,43 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/logging"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+
+	// maxBuckets caps how many interval buckets a time range may span.
+	maxBuckets = 1000
 )
 
+var intervalDurations = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
 type Validator interface {
 	Valid(ctx context.Context) (problems map[string]string)
 }
 
 func encode[T any](w http.ResponseWriter, r *http.Request, status int, v T) error {
+	var body any = v
+
+	// Error responses carry the request ID so clients can quote it.
+	if errResp, ok := body.(database.ErrorResponse); ok && errResp.RequestID == "" {
+		errResp.RequestID = logging.RequestID(r.Context())
+		body = errResp
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(v); err != nil {
+	if err := json.NewEncoder(w).Encode(body); err != nil {
 		return fmt.Errorf("encode json: %w", err)
 	}
 	return nil
@@ -22,8 +49,14 @@ func encode[T any](w http.ResponseWriter, r *http.Request, status int, v T) erro
 
 func decodeValid[T Validator](r *http.Request) (T, map[string]string, error) {
 	var v T
-	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
-		return v, nil, fmt.Errorf("decode json: %w", err)
+	body, err := requestBody(r, maxDecodedBodyBytes)
+	if err != nil {
+		return v, bodyProblems(err), fmt.Errorf("read body: %w", err)
+	}
+	defer body.Close()
+
+	if err := json.NewDecoder(body).Decode(&v); err != nil {
+		return v, bodyProblems(err), fmt.Errorf("decode json: %w", err)
 	}
 	if problems := v.Valid(r.Context()); len(problems) > 0 {
 		return v, problems, fmt.Errorf("invalid %T: %d problems", v, len(problems))
@@ -33,8 +66,108 @@ func decodeValid[T Validator](r *http.Request) (T, map[string]string, error) {
 
 func decode[T any](r *http.Request) (T, error) {
 	var v T
-	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+	body, err := requestBody(r, maxDecodedBodyBytes)
+	if err != nil {
+		return v, fmt.Errorf("read body: %w", err)
+	}
+	defer body.Close()
+
+	if err := json.NewDecoder(body).Decode(&v); err != nil {
 		return v, fmt.Errorf("decode json: %w", err)
 	}
 	return v, nil
 }
+
+// pagination reads the limit and offset query parameters, applying
+// defaultPageLimit when limit is absent.
+func pagination(r *http.Request) (limit, offset int, problems map[string]string) {
+	problems = make(map[string]string)
+	limit = defaultPageLimit
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			problems["limit"] = fmt.Sprintf("limit must be an integer between 1 and %d", maxPageLimit)
+		}
+		limit = n
+	}
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			problems["offset"] = "offset must be a non-negative integer"
+		}
+		offset = n
+	}
+
+	return limit, offset, problems
+}
+
+// timeRange reads the from and to query parameters as RFC 3339 timestamps.
+// to defaults to now and from to window before to.
+func timeRange(r *http.Request, window time.Duration) (from, to time.Time, problems map[string]string) {
+	problems = make(map[string]string)
+	to = time.Now().UTC()
+
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			problems["to"] = "to must be an RFC 3339 timestamp"
+		}
+		to = t.UTC()
+	}
+
+	from = to.Add(-window)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			problems["from"] = "from must be an RFC 3339 timestamp"
+		}
+		from = t.UTC()
+	}
+
+	if len(problems) == 0 && !from.Before(to) {
+		problems["from"] = "from must be before to"
+	}
+
+	return from, to, problems
+}
+
+// bucketInterval reads the interval query parameter (hour, day or week,
+// defaulting to day) and checks that [from, to) doesn't span more than
+// maxBuckets of it.
+func bucketInterval(r *http.Request, from, to time.Time) (string, map[string]string) {
+	problems := make(map[string]string)
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "day"
+	}
+
+	step, ok := intervalDurations[interval]
+	if !ok {
+		problems["interval"] = "interval must be one of: hour, day, week"
+		return interval, problems
+	}
+
+	if to.Sub(from)/step > maxBuckets {
+		problems["interval"] = fmt.Sprintf("time range cannot span more than %d %s buckets", maxBuckets, interval)
+	}
+
+	return interval, problems
+}
+
+// truncateInterval returns the start of the UTC hour, day or ISO week (starting
+// Monday) containing t, matching Postgres's date_trunc.
+func truncateInterval(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	switch interval {
+	case "hour":
+		return t.Truncate(time.Hour)
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
@@ -6,8 +6,13 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
+// MetricsMiddleware records each request under the chi route pattern it
+// matches, e.g. "/api/v1/spans/{id}", so IDs in the path don't each start a
+// new series. Requests that match no route share one "unmatched" endpoint.
 func (s *Server) MetricsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if s.metrics == nil {
@@ -16,7 +21,7 @@ func (s *Server) MetricsMiddleware(next http.Handler) http.Handler {
 		}
 
 		start := time.Now()
-		endpoint := r.URL.Path
+		endpoint := routePattern(r)
 		method := r.Method
 
 		s.metrics.RecordHTTPRequestInFlight(method, endpoint, 1)
@@ -27,10 +32,32 @@ func (s *Server) MetricsMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(wrapped, r)
 
 		duration := time.Since(start)
-		s.metrics.RecordHTTPRequest(method, endpoint, wrapped.statusCode, duration)
+		s.metrics.RecordHTTPRequest(r.Context(), method, endpoint, wrapped.statusCode, duration)
 	})
 }
 
+// unmatchedEndpoint labels requests for paths no route handles.
+const unmatchedEndpoint = "unmatched"
+
+// routePattern looks up the pattern the router will match for r. It has to be
+// known before the request is served, for the in-flight gauge.
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return unmatchedEndpoint
+	}
+
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+
+	if pattern := rctx.Routes.Find(chi.NewRouteContext(), r.Method, path); pattern != "" {
+		return pattern
+	}
+	return unmatchedEndpoint
+}
+
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
@@ -41,7 +68,13 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-func (s *Server) DatabaseMetricsCollector() {
+// Unwrap lets http.ResponseController reach the underlying writer, which the
+// streaming handlers need for flushing and per-line deadlines.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (s *Server) DatabaseMetricsCollector(ctx context.Context) {
 	if s.metrics == nil {
 		return
 	}
@@ -49,18 +82,25 @@ func (s *Server) DatabaseMetricsCollector() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
 		stats := s.db.Health()
 
-		if openStr, ok := stats["open_connections"]; ok {
-			if open, err := strconv.ParseFloat(openStr, 64); err == nil {
-				s.metrics.UpdateDatabaseConnections(open, 0, 0) // Simplified for now
-			}
+		open, openErr := strconv.ParseFloat(stats["open_connections"], 64)
+		idle, idleErr := strconv.ParseFloat(stats["idle"], 64)
+		inUse, inUseErr := strconv.ParseFloat(stats["in_use"], 64)
+		if openErr == nil && idleErr == nil && inUseErr == nil {
+			s.metrics.UpdateDatabaseConnections(open, idle, inUse)
 		}
 	}
 }
 
-func (s *Server) QueueMetricsCollector() {
+func (s *Server) QueueMetricsCollector(ctx context.Context) {
 	if s.metrics == nil || s.queueClient == nil {
 		return
 	}
@@ -68,8 +108,13 @@ func (s *Server) QueueMetricsCollector() {
 	ticker := time.NewTicker(15 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		ctx := context.Background()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
 		stats, err := s.queueClient.GetQueueStats(ctx)
 		if err != nil {
 			continue
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"langlite-ingestion/internal/metrics"
+)
+
+func TestMetricsMiddlewareLabelsByRoutePattern(t *testing.T) {
+	s := &Server{metrics: metrics.NewMetrics()}
+
+	r := chi.NewRouter()
+	r.Use(s.RequestIDMiddleware)
+	r.Use(s.MetricsMiddleware)
+	r.Get("/metrics", s.metrics.Handler().ServeHTTP)
+	r.Post("/api/v1/spans/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Route("/admin/v1", func(r chi.Router) {
+		r.Get("/audit", func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	for _, target := range []string{"/api/v1/spans/s1", "/api/v1/spans/s2"} {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		req.Header.Set("X-Request-ID", "req-1")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/v1/audit", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/no/such/path", nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	body, _ := io.ReadAll(rec.Body)
+
+	for _, want := range []string{
+		`http_requests_total{endpoint="/api/v1/spans/{id}",method="POST",status_code="200"} 2`,
+		`http_requests_total{endpoint="/admin/v1/audit",method="GET",status_code="200"} 1`,
+		`http_requests_total{endpoint="unmatched",method="GET",status_code="404"} 1`,
+		`http_requests_in_flight{endpoint="/api/v1/spans/{id}",method="POST"} 0`,
+		`# {request_id="req-1"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected /metrics to contain %s", want)
+		}
+	}
+	if strings.Contains(string(body), "/api/v1/spans/s1") {
+		t.Errorf("expected no series for a raw path")
+	}
+}
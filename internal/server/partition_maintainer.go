@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	// partitionMaintenanceInterval is how often partitions are checked.
+	partitionMaintenanceInterval = 6 * time.Hour
+
+	// partitionMonthsAhead is how many months of empty partitions are kept
+	// ready beyond the current one.
+	partitionMonthsAhead = 3
+)
+
+// PartitionMaintainer creates the monthly partitions of generations, spans and
+// events ahead of time and, when partitionRetentionMonths is set, drops the
+// ones that have fallen out of it. It runs once at startup and then every
+// partitionMaintenanceInterval.
+func (s *Server) PartitionMaintainer(ctx context.Context) {
+	s.maintainPartitions(time.Now().UTC())
+
+	ticker := time.NewTicker(partitionMaintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.maintainPartitions(now.UTC())
+		}
+	}
+}
+
+func (s *Server) maintainPartitions(now time.Time) {
+	created, err := s.db.EnsurePartitions(now, partitionMonthsAhead)
+	if err != nil {
+		slog.Error("Failed to create partitions", "error", err)
+	}
+	if len(created) > 0 {
+		slog.Info("Created partitions", "partitions", created)
+	}
+
+	if s.partitionRetentionMonths == 0 {
+		return
+	}
+
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	dropped, err := s.db.DropPartitionsBefore(thisMonth.AddDate(0, -s.partitionRetentionMonths, 0))
+	if err != nil {
+		slog.Error("Failed to drop expired partitions", "error", err)
+	}
+	if len(dropped) > 0 {
+		slog.Info("Dropped expired partitions", "partitions", dropped)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"langlite-ingestion/internal/database"
+)
+
+type partitionDB struct {
+	database.Service
+	ensuredFrom time.Time
+	monthsAhead int
+	dropCutoff  *time.Time
+}
+
+func (f *partitionDB) EnsurePartitions(from time.Time, monthsAhead int) ([]string, error) {
+	f.ensuredFrom, f.monthsAhead = from, monthsAhead
+	return nil, nil
+}
+
+func (f *partitionDB) DropPartitionsBefore(cutoff time.Time) ([]string, error) {
+	f.dropCutoff = &cutoff
+	return nil, nil
+}
+
+func TestMaintainPartitions(t *testing.T) {
+	now := time.Date(2025, 3, 17, 8, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name       string
+		retention  int
+		wantCutoff *time.Time
+	}{
+		{name: "keep everything", retention: 0},
+		{name: "keep six months", retention: 6, wantCutoff: ptr(time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC))},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &partitionDB{}
+			s := &Server{db: db, partitionRetentionMonths: tc.retention}
+			s.maintainPartitions(now)
+
+			if !db.ensuredFrom.Equal(now) || db.monthsAhead != partitionMonthsAhead {
+				t.Errorf("expected partitions from %v for %d months; got %v for %d", now, partitionMonthsAhead, db.ensuredFrom, db.monthsAhead)
+			}
+
+			switch {
+			case tc.wantCutoff == nil && db.dropCutoff != nil:
+				t.Errorf("expected no partitions dropped; got cutoff %v", *db.dropCutoff)
+			case tc.wantCutoff != nil && (db.dropCutoff == nil || !db.dropCutoff.Equal(*tc.wantCutoff)):
+				t.Errorf("expected cutoff %v; got %v", *tc.wantCutoff, db.dropCutoff)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
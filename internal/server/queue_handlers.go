@@ -1,9 +1,11 @@
 package server
 
 import (
+	"log/slog"
 	"net/http"
 
 	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/ingest"
 )
 
 func (s *Server) QueueStatusHandler(w http.ResponseWriter, r *http.Request) {
@@ -41,8 +43,10 @@ func (s *Server) QueueStatusHandler(w http.ResponseWriter, r *http.Request) {
 	encode(w, r, http.StatusOK, response)
 }
 
+// WorkerStatusHandler lists the workers of every process sharing the queue,
+// as registered in Redis, not only this process's pool.
 func (s *Server) WorkerStatusHandler(w http.ResponseWriter, r *http.Request) {
-	if s.workerPool == nil {
+	if s.queueClient == nil {
 		response := map[string]interface{}{
 			"workers_enabled": false,
 			"message":         "Worker pool is disabled (Redis not available)",
@@ -51,7 +55,7 @@ func (s *Server) WorkerStatusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stats, err := s.workerPool.GetStats(r.Context())
+	stats, err := s.queueClient.GetWorkerStats(r.Context())
 	if err != nil {
 		errorResp := database.ErrorResponse{
 			Error:   "Worker status error",
@@ -83,14 +87,16 @@ func (s *Server) ProcessingStatusHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	if s.workerPool != nil {
-		workerStats, err := s.workerPool.GetStats(r.Context())
+	if s.queueClient != nil {
+		workerStats, err := s.queueClient.GetWorkerStats(r.Context())
 		if err == nil {
 			response["worker_stats"] = workerStats
 		}
 	}
 
-	if s.queueClient != nil {
+	response["ingest_mode"] = s.ingestor.Mode().String()
+
+	if s.queueClient != nil && s.ingestor.Mode() != ingest.ModeSync {
 		response["processing_mode"] = "async"
 		response["response_status"] = "202 Accepted (async processing)"
 	} else {
@@ -101,6 +107,54 @@ func (s *Server) ProcessingStatusHandler(w http.ResponseWriter, r *http.Request)
 	encode(w, r, http.StatusOK, response)
 }
 
+// IngestionStatusHandler reports what became of the jobs of an item the
+// async endpoints accepted, by the ID they answered with.
+func (s *Server) IngestionStatusHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	if s.queueClient == nil {
+		errorResp := database.ErrorResponse{
+			Error:   "Queue unavailable",
+			Message: "Items are written directly while the queue is unavailable",
+			Code:    http.StatusServiceUnavailable,
+		}
+		encode(w, r, http.StatusServiceUnavailable, errorResp)
+		return
+	}
+
+	status, err := s.queueClient.IngestionStatus(r.Context(), authCtx.ProjectID, r.PathValue("id"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get ingestion status", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Queue status error",
+			Message: "Could not retrieve the item's jobs",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+	if status == nil {
+		errorResp := database.ErrorResponse{
+			Error:   "Not found",
+			Message: "No jobs are tracked for this ID; it may have been written directly, or its jobs expired",
+			Code:    http.StatusNotFound,
+		}
+		encode(w, r, http.StatusNotFound, errorResp)
+		return
+	}
+
+	encode(w, r, http.StatusOK, status)
+}
+
 func calculateTotalPending(stats map[string]int64) int64 {
 	var total int64
 	for queueName, count := range stats {
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/logging"
+	"langlite-ingestion/internal/queue"
+)
+
+func TestIngestionStatusHandler(t *testing.T) {
+	client := queue.NewClient(queue.NewMemoryBackend())
+	ctx := logging.WithProjectID(context.Background(), "project-1")
+	if err := client.EnqueueTrace(ctx, database.TraceRequest{ID: "trace-1", ProjectID: "project-1"}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	s := &Server{queueClient: client}
+	r := chi.NewRouter()
+	r.Get("/api/v1/ingestion/{id}/status", s.IngestionStatusHandler)
+
+	request := func(projectID, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/ingestion/"+id+"/status", nil)
+		authCtx := database.AuthContext{ProjectID: projectID, APIKeyID: "key-1"}
+		req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request("project-1", "trace-1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status OK; got %d: %s", rec.Code, rec.Body)
+	}
+	var status queue.IngestionStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	// store_raw, enrich_trace and analytics_export.
+	if status.ID != "trace-1" || status.Status != queue.JobStatePending || len(status.Jobs) != 3 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	if rec := request("project-2", "trace-1"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected another project's trace not found; got %d", rec.Code)
+	}
+	if rec := request("project-1", "unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown ID not found; got %d", rec.Code)
+	}
+}
@@ -32,6 +32,8 @@ func (s *Server) ResetRateLimitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setAuditTarget(r, "api_key:"+authCtx.APIKeyID)
+
 	if s.rateLimiter == nil {
 		errorResp := database.ErrorResponse{
 			Error:   "Rate limiting disabled",
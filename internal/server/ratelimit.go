@@ -23,7 +23,7 @@ func NewRateLimiter(redisClient *redis.Client) *RateLimiter {
 
 func (rl *RateLimiter) RateLimitMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/health" || r.URL.Path == "/" {
+		if probePaths[r.URL.Path] || r.URL.Path == "/" {
 			next.ServeHTTP(w, r)
 			return
 		}
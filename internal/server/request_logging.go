@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"langlite-ingestion/internal/logging"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+
+	requestLogContextKey contextKey = "request_log"
+)
+
+// RequestIDMiddleware takes the request ID from X-Request-ID, or generates
+// one, echoes it in the response and attaches it to the request context for
+// logs, queued jobs and error responses.
+func (s *Server) RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := logging.NormalizeRequestID(r.Header.Get(requestIDHeader))
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// requestLog carries what inner middleware learns about a request, such as
+// its project, back to RequestLoggerMiddleware.
+type requestLog struct {
+	projectID string
+}
+
+// RequestLoggerMiddleware logs one line per request once it has been served.
+func (s *Server) RequestLoggerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &requestLog{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		ctx := r.Context()
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(ctx, requestLogContextKey, entry)))
+
+		if entry.projectID != "" {
+			ctx = logging.WithProjectID(ctx, entry.projectID)
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+
+		slog.Log(ctx, level, "HTTP request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote_ip", s.clientIP(r),
+		)
+	})
+}
+
+// setLogProjectID records the authenticated project on the request's log line.
+func setLogProjectID(r *http.Request, projectID string) {
+	if entry, ok := r.Context().Value(requestLogContextKey).(*requestLog); ok {
+		entry.projectID = projectID
+	}
+}
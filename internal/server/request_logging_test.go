@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"langlite-ingestion/internal/database"
+)
+
+func TestRequestIDIsEchoed(t *testing.T) {
+	s := &Server{}
+	r := chi.NewRouter()
+	r.Use(s.RequestIDMiddleware)
+	r.Get("/fail", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, r, http.StatusBadRequest, database.ErrorResponse{Error: "Invalid request", Code: http.StatusBadRequest})
+	})
+
+	cases := map[string]bool{
+		"sdk-req-42":               true,
+		"":                         false,
+		"bad\nid":                  false,
+		strings.Repeat("x", 129):   false,
+		"0af7651916cd43dd8448eb21": true,
+	}
+
+	for sent, kept := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/fail", nil)
+		req.Header.Set("X-Request-ID", sent)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		got := rec.Header().Get("X-Request-ID")
+		if kept && got != sent {
+			t.Errorf("expected %q to be kept; got %q", sent, got)
+		}
+		if !kept && (got == "" || got == sent) {
+			t.Errorf("expected %q to be replaced; got %q", sent, got)
+		}
+
+		var resp database.ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if resp.RequestID != got {
+			t.Errorf("expected error response to carry %q; got %q", got, resp.RequestID)
+		}
+	}
+}
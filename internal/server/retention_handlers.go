@@ -0,0 +1,101 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"langlite-ingestion/internal/database"
+)
+
+func (s *Server) GetRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	settings, err := s.db.GetRetentionSettings(authCtx.ProjectID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to get retention settings", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to get retention settings",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	response := database.RetentionResponse{
+		ProjectID: authCtx.ProjectID,
+		Retention: settings,
+	}
+
+	encode(w, r, http.StatusOK, response)
+}
+
+// PutRetentionHandler replaces the project's retention settings. Entities left
+// out of the body are kept forever.
+func (s *Server) PutRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	setAuditTarget(r, "project:"+authCtx.ProjectID)
+
+	settings, problems, err := decodeValid[database.RetentionSettings](r)
+	if err != nil {
+		if len(problems) > 0 {
+			errorResp := database.ErrorResponse{
+				Error:    "Validation failed",
+				Message:  "The request contains invalid data",
+				Code:     http.StatusBadRequest,
+				Problems: problems,
+			}
+			encode(w, r, http.StatusBadRequest, errorResp)
+			return
+		}
+
+		errorResp := database.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Could not parse request body",
+			Code:    http.StatusBadRequest,
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+
+	if settings == nil {
+		settings = database.RetentionSettings{}
+	}
+
+	if err := s.db.SetRetentionSettings(authCtx.ProjectID, settings); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to set retention settings", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to set retention settings",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	response := database.RetentionResponse{
+		ProjectID: authCtx.ProjectID,
+		Retention: settings,
+	}
+
+	encode(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"langlite-ingestion/internal/database"
+)
+
+type retentionDB struct {
+	database.Service
+	settings map[string]database.RetentionSettings
+}
+
+func (f *retentionDB) GetRetentionSettings(projectID string) (database.RetentionSettings, error) {
+	if settings, ok := f.settings[projectID]; ok {
+		return settings, nil
+	}
+	return database.RetentionSettings{}, nil
+}
+
+func (f *retentionDB) SetRetentionSettings(projectID string, settings database.RetentionSettings) error {
+	f.settings[projectID] = settings
+	return nil
+}
+
+func retentionRequest(t *testing.T, db *retentionDB, method, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	s := &Server{db: db}
+	r := chi.NewRouter()
+	r.Get("/api/v1/retention", s.GetRetentionHandler)
+	r.Put("/api/v1/retention", s.PutRetentionHandler)
+
+	req := httptest.NewRequest(method, "/api/v1/retention", strings.NewReader(body))
+	authCtx := database.AuthContext{ProjectID: "project-1", APIKeyID: "key-1"}
+	req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRetentionRoundTrip(t *testing.T) {
+	db := &retentionDB{settings: make(map[string]database.RetentionSettings)}
+
+	rec := retentionRequest(t, db, http.MethodPut, `{"traces": 30, "events": 7}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status OK; got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = retentionRequest(t, db, http.MethodGet, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status OK; got %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp database.RetentionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.ProjectID != "project-1" || len(resp.Retention) != 2 || resp.Retention["traces"] != 30 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if _, ok := resp.Retention["scores"]; ok {
+		t.Errorf("expected scores to be kept forever; got %+v", resp.Retention)
+	}
+}
+
+func TestRetentionRejects(t *testing.T) {
+	cases := map[string]string{
+		`{"sessions": 30}`: "sessions",
+		`{"traces": 0}`:    "traces",
+		`{"scores": 5000}`: "scores",
+	}
+
+	for body, field := range cases {
+		rec := retentionRequest(t, &retentionDB{settings: make(map[string]database.RetentionSettings)}, http.MethodPut, body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected status 400; got %d: %s", body, rec.Code, rec.Body)
+		}
+
+		var resp database.ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if _, ok := resp.Problems[field]; !ok {
+			t.Errorf("%s: expected a %s problem; got %v", body, field, resp.Problems)
+		}
+	}
+}
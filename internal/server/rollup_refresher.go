@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// rollupRefreshInterval is how often usage rollups catch up with new data.
+const rollupRefreshInterval = time.Minute
+
+// RollupRefresher keeps the usage rollups behind /api/v1/analytics/timeseries
+// up to date when there's no queue to run the refresh_rollups schedule. Every
+// instance runs it; the database skips refreshes that another instance
+// already has in progress.
+func (s *Server) RollupRefresher(ctx context.Context) {
+	ticker := time.NewTicker(rollupRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		hours, err := s.db.RefreshUsageRollups()
+		if err != nil {
+			slog.Error("Failed to refresh usage rollups", "error", err)
+			continue
+		}
+		if hours > 0 {
+			slog.Info("Refreshed usage rollups", "project_hours", hours, "duration", time.Since(start))
+		}
+	}
+}
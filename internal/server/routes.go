@@ -8,14 +8,16 @@ import (
 	"strings"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"langlite-ingestion/internal/enrich"
 )
 
 func (s *Server) RegisterRoutes() http.Handler {
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(s.RequestIDMiddleware)
+	r.Use(s.TraceRouteMiddleware)
+	r.Use(s.RequestLoggerMiddleware)
 
 	if s.metrics != nil {
 		r.Use(s.MetricsMiddleware)
@@ -39,7 +41,7 @@ func (s *Server) RegisterRoutes() http.Handler {
 		// AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedOrigins:   allowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Requested-With"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Content-Encoding", "X-Requested-With", enrich.SDKNameHeader, enrich.SDKVersionHeader},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -47,9 +49,13 @@ func (s *Server) RegisterRoutes() http.Handler {
 	r.Get("/", s.HelloWorldHandler)
 
 	r.Get("/health", s.healthHandler)
-	r.Get("/metrics", promhttp.Handler().ServeHTTP)
+	r.Get("/livez", s.LivezHandler)
+	r.Get("/readyz", s.ReadyzHandler)
+	if s.metrics != nil {
+		r.Get("/metrics", s.metrics.Handler().ServeHTTP)
+	}
 	r.Get("/rate-limit-status", s.RateLimitStatusHandler)
-	r.Post("/reset-rate-limit", s.ResetRateLimitHandler)
+	r.With(s.Audited("rate_limit.reset")).Post("/reset-rate-limit", s.ResetRateLimitHandler)
 
 	// queue monitoring endpoints
 	r.Get("/queue-status", s.QueueStatusHandler)
@@ -64,6 +70,48 @@ func (s *Server) RegisterRoutes() http.Handler {
 	r.Post("/api/v1/events", s.EventHandler)
 	r.Post("/api/v1/scores", s.ScoreHandler)
 	r.Post("/api/v1/batch", s.BatchHandler)
+	r.Post("/api/v1/batch/stream", s.StreamIngestHandler)
+	r.Get("/api/v1/ingestion/{id}/status", s.IngestionStatusHandler)
+
+	// sessions
+	r.Get("/api/v1/sessions", s.ListSessionsHandler)
+	r.Get("/api/v1/sessions/{id}", s.GetSessionHandler)
+
+	// end-user analytics
+	r.Get("/api/v1/users", s.ListUsersHandler)
+	r.Get("/api/v1/users/{id}/metrics", s.UserMetricsHandler)
+
+	// usage analytics
+	r.Get("/api/v1/analytics/timeseries", s.TimeseriesHandler)
+
+	// data retention
+	r.Get("/api/v1/retention", s.GetRetentionHandler)
+	r.With(s.Audited("retention.update")).Put("/api/v1/retention", s.PutRetentionHandler)
+
+	// trace enrichment
+	r.Get("/api/v1/enrichment", s.GetEnrichmentHandler)
+	r.With(s.Audited("enrichment.update")).Put("/api/v1/enrichment", s.PutEnrichmentHandler)
+
+	// webhooks
+	r.With(s.Audited("webhook.create")).Post("/api/v1/webhooks", s.CreateWebhookHandler)
+	r.Get("/api/v1/webhooks", s.ListWebhooksHandler)
+	r.Get("/api/v1/webhooks/{id}", s.GetWebhookHandler)
+	r.With(s.Audited("webhook.update")).Put("/api/v1/webhooks/{id}", s.UpdateWebhookHandler)
+	r.With(s.Audited("webhook.delete")).Delete("/api/v1/webhooks/{id}", s.DeleteWebhookHandler)
+	r.Get("/api/v1/webhooks/{id}/deliveries", s.ListWebhookDeliveriesHandler)
+	r.Post("/api/v1/webhooks/{id}/ping", s.PingWebhookHandler)
+
+	// end-user data requests
+	r.With(s.Audited("user_data.export")).Get("/api/v1/users/{id}/export", s.ExportUserDataHandler)
+	r.With(s.Audited("user_data.erase")).Post("/api/v1/users/{id}/erasure", s.EraseUserDataHandler)
+	r.Get("/api/v1/data-requests", s.ListDataRequestsHandler)
+	r.Get("/api/v1/data-requests/{id}", s.GetDataRequestHandler)
+
+	// admin API
+	r.Route("/admin/v1", func(r chi.Router) {
+		r.Use(s.AdminAuthMiddleware)
+		r.With(s.Audited("audit.list")).Get("/audit", s.ListAuditHandler)
+	})
 
 	// synchronous endpoints
 	r.Post("/api/v1/sync/traces", s.CreateTrace)
@@ -73,6 +121,29 @@ func (s *Server) RegisterRoutes() http.Handler {
 	return r
 }
 
+// RegisterWorkerRoutes serves a worker process's probes, metrics and worker
+// status, for orchestrators and Prometheus. There's no API to authenticate.
+func (s *Server) RegisterWorkerRoutes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(s.RequestIDMiddleware)
+	r.Use(s.TraceRouteMiddleware)
+	r.Use(s.RequestLoggerMiddleware)
+
+	if s.metrics != nil {
+		r.Use(s.MetricsMiddleware)
+	}
+
+	r.Get("/health", s.healthHandler)
+	r.Get("/livez", s.LivezHandler)
+	r.Get("/readyz", s.ReadyzHandler)
+	if s.metrics != nil {
+		r.Get("/metrics", s.metrics.Handler().ServeHTTP)
+	}
+	r.Get("/worker-status", s.WorkerStatusHandler)
+
+	return r
+}
+
 func (s *Server) HelloWorldHandler(w http.ResponseWriter, r *http.Request) {
 	resp := make(map[string]string)
 	resp["message"] = "Hello World"
@@ -86,6 +157,11 @@ func (s *Server) HelloWorldHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
-	jsonResp, _ := json.Marshal(s.db.Health())
-	_, _ = w.Write(jsonResp)
+	stats := s.db.Health()
+
+	status := http.StatusOK
+	if stats["status"] != "up" {
+		status = http.StatusServiceUnavailable
+	}
+	encode(w, r, status, stats)
 }
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"log/slog"
+
+	"langlite-ingestion/internal/queue"
+)
+
+// maintenanceSchedules are the recurring jobs this service enqueues for
+// itself. Whichever API instance holds the cron lock enqueues them; any
+// worker runs them.
+var maintenanceSchedules = []queue.Schedule{
+	{
+		// Purges skip rows another purge holds, so a catch-up run that
+		// overlaps the next one finds little to do.
+		Name:     "purge_retention",
+		Spec:     "@hourly",
+		Payload:  queue.PurgeRetentionPayload{},
+		Priority: queue.QueueLow,
+		Missed:   queue.MissedRunOnce,
+	},
+	{
+		// Each refresh catches up with everything since the last, so
+		// missed runs needn't be made up.
+		Name:     "refresh_rollups",
+		Spec:     "* * * * *",
+		Payload:  queue.RefreshRollupsPayload{},
+		Priority: queue.QueueMedium,
+		Missed:   queue.MissedSkip,
+	},
+}
+
+// newCron builds the Cron that enqueues maintenanceSchedules.
+func (s *Server) newCron() *queue.Cron {
+	cron := queue.NewCron(s.queueClient, s.metrics)
+	for _, schedule := range maintenanceSchedules {
+		if err := cron.Add(schedule); err != nil {
+			slog.Error("Invalid schedule", "schedule", schedule.Name, "error", err)
+		}
+	}
+	return cron
+}
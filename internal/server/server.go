@@ -3,22 +3,60 @@ package server
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
 
 	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/enrich"
+	"langlite-ingestion/internal/grpcapi"
+	"langlite-ingestion/internal/ingest"
+	"langlite-ingestion/internal/lifecycle"
 	"langlite-ingestion/internal/metrics"
 	"langlite-ingestion/internal/queue"
+	"langlite-ingestion/internal/webhook"
 )
 
+// Role selects which parts of the service a process runs, so the ingest API
+// and the queue workers can be scaled separately.
+type Role int
+
+const (
+	// RoleAll serves the API and runs the workers in one process.
+	RoleAll Role = iota
+	// RoleAPI serves the API and leaves the queue to worker processes.
+	RoleAPI
+	// RoleWorker runs the workers and serves only probes and metrics.
+	RoleWorker
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleAPI:
+		return "api"
+	case RoleWorker:
+		return "worker"
+	default:
+		return "all"
+	}
+}
+
+func (r Role) runsWorkers() bool {
+	return r != RoleAPI
+}
+
 type Server struct {
-	port int
+	port     int
+	grpcPort int
+	role     Role
 
 	db          database.Service
 	redis       *redis.Client
@@ -26,70 +64,442 @@ type Server struct {
 	queueClient *queue.Client
 	workerPool  *queue.WorkerPool
 	metrics     *metrics.Metrics
+	ingestor    *ingest.Ingestor
+	enrichers   *enrich.Registry
+	// webhooks queues webhook deliveries for ingested items; it's nil
+	// without a queue.
+	webhooks *webhook.Dispatcher
+	// allowPrivateWebhooks lets webhooks point at private addresses.
+	allowPrivateWebhooks bool
+
+	// trustedProxies are the peers whose X-Forwarded-For is believed when
+	// working out a request's client address.
+	trustedProxies []netip.Prefix
+
+	// background runs the periodic loops and fire-and-forget writes, which
+	// shutdown waits for before closing Redis and Postgres.
+	background *lifecycle.Group
+
+	// partitionRetentionMonths is how many whole months of partitions
+	// PartitionMaintainer keeps; 0 keeps them all.
+	partitionRetentionMonths int
+
+	// adminToken guards /admin/v1; empty disables the admin API.
+	adminToken string
+
+	// maxQueueDepth is the backlog at which /readyz reports the queue as
+	// saturated; 0 disables the check.
+	maxQueueDepth int64
 }
 
-func NewServer() *http.Server {
+const (
+	// defaultAdmissionQueueDepth is how many items may wait to be stored
+	// before async ingestion answers 503, unless
+	// LANGLITE_BACKPRESSURE_QUEUE_DEPTH says otherwise. A trace fans out into
+	// three jobs, so this keeps the job backlog below defaultMaxQueueDepth
+	// and SDKs back off before load balancers take instances out of rotation.
+	defaultAdmissionQueueDepth = 3000
+
+	// defaultProjectQuota is how many items one project may have waiting,
+	// unless LANGLITE_BACKPRESSURE_PROJECT_QUOTA says otherwise.
+	defaultProjectQuota = 2000
+
+	// defaultDBLatencyTarget is the database latency above which workers
+	// back off, unless LANGLITE_WORKER_DB_LATENCY_TARGET says otherwise.
+	defaultDBLatencyTarget = 250 * time.Millisecond
+)
+
+// NewServer connects to Postgres and Redis and starts the background tasks
+// and, unless role is RoleAPI, the queue workers. The HTTP and gRPC servers
+// built from it share these dependencies.
+//
+// Each dependency registers its shutdown with lc as it starts, so lc stops
+// the workers, then the background tasks, then closes Redis and Postgres.
+func NewServer(lc *lifecycle.Manager, role Role) *Server {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
 
+	grpcPort := 50051
+	if p, err := strconv.Atoi(os.Getenv("GRPC_PORT")); err == nil {
+		grpcPort = p
+	}
+
 	redisAddr := os.Getenv("REDIS_ADDR")
 	if redisAddr == "" {
 		redisAddr = "localhost:6379"
 	}
 
+	metricsInstance := metrics.NewMetrics()
+
 	redisClient := redis.NewClient(&redis.Options{
 		Addr:     redisAddr,
 		Password: os.Getenv("REDIS_PASSWORD"),
 		DB:       0,
 	})
+	redisClient.AddHook(queue.RedisHook(metricsInstance))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := redisClient.Ping(ctx).Err(); err != nil {
-		log.Printf("Warning: Redis connection failed: %v. Rate limiting will be disabled.", err)
+		slog.Warn("Redis connection failed, rate limiting and the Redis queue are disabled", "error", err)
 		redisClient = nil
 	}
 
+	latency := database.NewLatencyTracker(metricsInstance)
+	db := database.New(latency)
+	lc.OnShutdown("postgres", func(context.Context) error { return db.Close() })
+	if redisClient != nil {
+		lc.OnShutdown("redis", func(context.Context) error { return redisClient.Close() })
+	}
+
+	background := lifecycle.NewGroup()
+	lc.OnShutdown("background tasks", background.Stop)
+
+	enrichers := loadEnrichers()
+	allowPrivateWebhooks := privateWebhooksAllowed()
+
 	var rateLimiter *RateLimiter
 	var queueClient *queue.Client
 	var workerPool *queue.WorkerPool
 
 	if redisClient != nil {
 		rateLimiter = NewRateLimiter(redisClient)
-		queueClient = queue.NewClient(redisClient)
+	}
 
-		workerPool = queue.NewWorkerPool(queueClient, database.New(), 3)
+	if backend := queueBackend(db, redisClient, role); backend != nil {
+		queueClient = queue.NewClient(backend)
 
-		go func() {
-			ctx := context.Background()
-			workerPool.Start(ctx)
-		}()
+		if role.runsWorkers() {
+			workerPool = queue.NewWorkerPool(queueClient, db, metricsInstance, workerConcurrency())
+			if target := dbLatencyTarget(); target > 0 {
+				workerPool.AdaptConcurrency(latency, target)
+			}
+			workerPool.UseScheduler(queue.NewScheduler(queueWeights(), queueAging()))
+			workerPool.UseEnrichers(enrichers)
+			workerPool.UseWebhooks(webhook.NewDeliverer(queue.DefaultWebhookTimeout, allowPrivateWebhooks), webhookDisableAfter())
+			workerPool.Start(context.Background())
+			lc.OnShutdown("workers", workerPool.Shutdown)
+		}
 	}
 
-	metricsInstance := metrics.NewMetrics()
+	ingestMode := ingest.ModeFallback
+	if v := os.Getenv("LANGLITE_INGEST_MODE"); v != "" {
+		mode, err := ingest.ParseMode(v)
+		if err != nil {
+			slog.Warn("Invalid LANGLITE_INGEST_MODE", "error", err, "mode", ingestMode)
+		} else {
+			ingestMode = mode
+		}
+	}
+
+	var partitionRetentionMonths int
+	if v := os.Getenv("LANGLITE_PARTITION_RETENTION_MONTHS"); v != "" {
+		months, err := strconv.Atoi(v)
+		if err != nil || months < 0 {
+			slog.Warn("Invalid LANGLITE_PARTITION_RETENTION_MONTHS, keeping all partitions", "value", v)
+		} else {
+			partitionRetentionMonths = months
+		}
+	}
+
+	maxQueueDepth := int64(defaultMaxQueueDepth)
+	if v := os.Getenv("LANGLITE_READY_MAX_QUEUE_DEPTH"); v != "" {
+		depth, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || depth < 0 {
+			slog.Warn("Invalid LANGLITE_READY_MAX_QUEUE_DEPTH, using the default", "value", v, "default", maxQueueDepth)
+		} else {
+			maxQueueDepth = depth
+		}
+	}
+
+	admissionDepth := int64(defaultAdmissionQueueDepth)
+	if v := os.Getenv("LANGLITE_BACKPRESSURE_QUEUE_DEPTH"); v != "" {
+		depth, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || depth < 0 {
+			slog.Warn("Invalid LANGLITE_BACKPRESSURE_QUEUE_DEPTH, using the default", "value", v, "default", admissionDepth)
+		} else {
+			admissionDepth = depth
+		}
+	}
+
+	projectQuota := int64(defaultProjectQuota)
+	if v := os.Getenv("LANGLITE_BACKPRESSURE_PROJECT_QUOTA"); v != "" {
+		quota, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || quota < 0 {
+			slog.Warn("Invalid LANGLITE_BACKPRESSURE_PROJECT_QUOTA, using the default", "value", v, "default", projectQuota)
+		} else {
+			projectQuota = quota
+		}
+	}
+
+	// Pass a nil interface rather than a nil *queue.Client without a queue.
+	var enqueuer ingest.Enqueuer
+	if queueClient != nil {
+		enqueuer = queueClient
+	}
+	ingestor := ingest.New(db, enqueuer, ingestMode)
+
+	// Worker processes don't ingest, so they don't need admission.
+	var admission *queue.Admission
+	if queueClient != nil && role != RoleWorker && (admissionDepth > 0 || projectQuota > 0) {
+		admission = queue.NewAdmission(queueClient, metricsInstance, admissionDepth, projectQuota)
+		ingestor = ingestor.WithAdmission(admission)
+	}
+
+	// Webhooks fire for items this process ingests.
+	var webhooks *webhook.Dispatcher
+	if queueClient != nil && role != RoleWorker {
+		webhooks = webhook.NewDispatcher(db, queueClient)
+		ingestor = ingestor.WithNotifier(webhooks)
+	}
 
 	NewServer := &Server{
 		port:        port,
-		db:          database.New(),
+		grpcPort:    grpcPort,
+		role:        role,
+		db:          db,
 		redis:       redisClient,
 		rateLimiter: rateLimiter,
 		queueClient: queueClient,
 		workerPool:  workerPool,
 		metrics:     metricsInstance,
+		ingestor:    ingestor,
+		enrichers:   enrichers,
+		webhooks:    webhooks,
+		background:  background,
+
+		partitionRetentionMonths: partitionRetentionMonths,
+		adminToken:               os.Getenv("LANGLITE_ADMIN_TOKEN"),
+		maxQueueDepth:            maxQueueDepth,
+		allowPrivateWebhooks:     allowPrivateWebhooks,
+		trustedProxies:           trustedProxies(),
 	}
 
-	go NewServer.DatabaseMetricsCollector()
+	// Schema and data maintenance stays with the API instances; worker
+	// processes only report their own metrics.
+	background.Go(NewServer.DatabaseMetricsCollector)
 	if queueClient != nil {
-		go NewServer.QueueMetricsCollector()
+		background.Go(NewServer.QueueMetricsCollector)
+	}
+	if role != RoleWorker {
+		background.Go(NewServer.PartitionMaintainer)
+		if queueClient != nil {
+			background.Go(NewServer.newCron().Run)
+		} else {
+			// Without the queue, refresh rollups in process instead.
+			background.Go(NewServer.RollupRefresher)
+		}
+	}
+	if admission != nil {
+		background.Go(admission.Run)
+	}
+
+	return NewServer
+}
+
+// queueBackend picks where the queue keeps its jobs from
+// LANGLITE_QUEUE_BACKEND: "redis" (the default), "postgres" or "memory". It
+// returns nil, disabling the queue, when that's Redis and Redis is down.
+func queueBackend(db database.Service, redisClient *redis.Client, role Role) queue.Backend {
+	switch v := os.Getenv("LANGLITE_QUEUE_BACKEND"); v {
+	case "postgres":
+		return queue.NewPostgresBackend(db.DB())
+	case "memory":
+		if role != RoleAll {
+			slog.Warn("The memory queue only reaches workers in the same process", "role", role)
+		}
+		return queue.NewMemoryBackend()
+	default:
+		if v != "" && v != "redis" {
+			slog.Warn("Invalid LANGLITE_QUEUE_BACKEND, using Redis", "value", v)
+		}
+		if redisClient == nil {
+			return nil
+		}
+		return queue.NewRedisBackend(redisClient)
+	}
+}
+
+// loadEnrichers offers the built-in trace enrichers, with geo when there's a
+// GeoIP database: the CSV at LANGLITE_GEOIP_DB, or the one embedded in the
+// build.
+func loadEnrichers() *enrich.Registry {
+	var geo *enrich.GeoIP
+	var err error
+	if path := os.Getenv("LANGLITE_GEOIP_DB"); path != "" {
+		geo, err = enrich.OpenGeoIP(path)
+	} else {
+		geo, err = enrich.EmbeddedGeoIP()
+	}
+
+	switch {
+	case err != nil:
+		slog.Warn("Failed to load the GeoIP database, the geo enricher is disabled", "error", err)
+		return enrich.DefaultRegistry(nil)
+	case geo.Len() == 0:
+		slog.Info("No GeoIP database, the geo enricher is disabled")
+		return enrich.DefaultRegistry(nil)
+	}
+	return enrich.DefaultRegistry(geo)
+}
+
+// workerConcurrency reads how many workers to run per job type from
+// LANGLITE_WORKER_CONCURRENCY.
+func workerConcurrency() queue.Concurrency {
+	v := os.Getenv("LANGLITE_WORKER_CONCURRENCY")
+	if v == "" {
+		return queue.DefaultConcurrency
+	}
+
+	concurrency, err := queue.ParseConcurrency(v)
+	if err != nil {
+		slog.Warn("Invalid LANGLITE_WORKER_CONCURRENCY, using the default", "error", err)
+		return queue.DefaultConcurrency
+	}
+	return concurrency
+}
+
+// queueWeights reads how often workers take from each queue from
+// LANGLITE_QUEUE_WEIGHTS.
+func queueWeights() queue.Weights {
+	v := os.Getenv("LANGLITE_QUEUE_WEIGHTS")
+	if v == "" {
+		return queue.DefaultWeights
+	}
+
+	weights, err := queue.ParseWeights(v)
+	if err != nil {
+		slog.Warn("Invalid LANGLITE_QUEUE_WEIGHTS, using the default", "error", err)
+		return queue.DefaultWeights
+	}
+	return weights
+}
+
+// queueAging reads when waiting jobs are promoted from LANGLITE_QUEUE_AGING.
+func queueAging() queue.Aging {
+	v := os.Getenv("LANGLITE_QUEUE_AGING")
+	if v == "" {
+		return queue.DefaultAging
+	}
+
+	aging, err := queue.ParseAging(v)
+	if err != nil {
+		slog.Warn("Invalid LANGLITE_QUEUE_AGING, using the default", "error", err)
+		return queue.DefaultAging
+	}
+	return aging
+}
+
+// dbLatencyTarget reads LANGLITE_WORKER_DB_LATENCY_TARGET; 0 turns adaptive
+// worker concurrency off.
+func dbLatencyTarget() time.Duration {
+	v := os.Getenv("LANGLITE_WORKER_DB_LATENCY_TARGET")
+	if v == "" {
+		return defaultDBLatencyTarget
+	}
+
+	target, err := time.ParseDuration(v)
+	if err != nil || target < 0 {
+		slog.Warn("Invalid LANGLITE_WORKER_DB_LATENCY_TARGET, using the default", "value", v, "default", defaultDBLatencyTarget)
+		return defaultDBLatencyTarget
+	}
+	return target
+}
+
+// webhookDisableAfter reads LANGLITE_WEBHOOK_DISABLE_AFTER, how many failed
+// deliveries in a row disable a webhook; 0 never disables one.
+func webhookDisableAfter() int {
+	v := os.Getenv("LANGLITE_WEBHOOK_DISABLE_AFTER")
+	if v == "" {
+		return queue.DefaultWebhookDisableAfter
 	}
 
-	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", NewServer.port),
-		Handler:      NewServer.RegisterRoutes(),
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		slog.Warn("Invalid LANGLITE_WEBHOOK_DISABLE_AFTER, using the default", "value", v, "default", queue.DefaultWebhookDisableAfter)
+		return queue.DefaultWebhookDisableAfter
+	}
+	return n
+}
+
+// privateWebhooksAllowed reads LANGLITE_WEBHOOK_ALLOW_PRIVATE_NETWORKS, which
+// lets webhooks deliver to loopback, private and link-local addresses, e.g.
+// local test stubs. It's off by default: any API key can create a webhook,
+// and delivery logs show the endpoint's response.
+func privateWebhooksAllowed() bool {
+	v := os.Getenv("LANGLITE_WEBHOOK_ALLOW_PRIVATE_NETWORKS")
+	if v == "" {
+		return false
+	}
+
+	allowed, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("Invalid LANGLITE_WEBHOOK_ALLOW_PRIVATE_NETWORKS, refusing private addresses", "value", v)
+		return false
+	}
+	if allowed {
+		slog.Warn("Webhooks may deliver to private network addresses")
+	}
+	return allowed
+}
+
+// trustedProxies reads LANGLITE_TRUSTED_PROXIES, a comma-separated list of
+// addresses or CIDR ranges of the load balancers in front of the API.
+func trustedProxies() []netip.Prefix {
+	v := os.Getenv("LANGLITE_TRUSTED_PROXIES")
+	if v == "" {
+		return nil
+	}
+
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			slog.Warn("Invalid LANGLITE_TRUSTED_PROXIES entry, ignoring it", "value", entry)
+			continue
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes
+}
+
+// WorkersRunning reports whether this process runs queue workers, which a
+// RoleWorker process can't do without a queue backend.
+func (s *Server) WorkersRunning() bool {
+	return s.workerPool != nil
+}
+
+// HTTPServer returns the HTTP API server listening on PORT. A RoleWorker
+// process serves only probes and metrics.
+func (s *Server) HTTPServer() *http.Server {
+	handler := s.RegisterRoutes()
+	if s.role == RoleWorker {
+		handler = s.RegisterWorkerRoutes()
+	}
+
+	return &http.Server{
+		Addr:         fmt.Sprintf(":%d", s.port),
+		Handler:      tracedHandler(handler),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
+}
+
+// GRPCServer returns the gRPC ingestion server. It is meant to listen on
+// GRPCAddr, separately from the HTTP API.
+func (s *Server) GRPCServer() *grpc.Server {
+	return grpcapi.NewServer(s.db, s.ingestor, s.background)
+}
 
-	return server
+func (s *Server) GRPCAddr() string {
+	return fmt.Sprintf(":%d", s.grpcPort)
 }
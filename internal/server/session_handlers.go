@@ -0,0 +1,108 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"langlite-ingestion/internal/database"
+)
+
+func (s *Server) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	limit, offset, problems := pagination(r)
+	if len(problems) > 0 {
+		errorResp := database.ErrorResponse{
+			Error:    "Validation failed",
+			Message:  "The request contains invalid data",
+			Code:     http.StatusBadRequest,
+			Problems: problems,
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+
+	opts := database.SessionListOptions{
+		UserID: r.URL.Query().Get("user_id"),
+		Limit:  limit,
+		Offset: offset,
+	}
+
+	sessions, err := s.db.ListSessions(authCtx.ProjectID, opts)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list sessions", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to list sessions",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	response := database.SessionListResponse{
+		Sessions: sessions,
+		Limit:    limit,
+		Offset:   offset,
+	}
+
+	encode(w, r, http.StatusOK, response)
+}
+
+func (s *Server) GetSessionHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	sessionID := r.PathValue("id")
+	if sessionID == "" {
+		errorResp := database.ErrorResponse{
+			Error:   "Missing session ID",
+			Message: "Session ID is required in the URL path",
+			Code:    http.StatusBadRequest,
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+
+	session, err := s.db.GetSession(authCtx.ProjectID, sessionID)
+	if err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			errorResp := database.ErrorResponse{
+				Error:   "Session not found",
+				Message: "The specified session does not exist",
+				Code:    http.StatusNotFound,
+			}
+			encode(w, r, http.StatusNotFound, errorResp)
+			return
+		}
+
+		slog.ErrorContext(r.Context(), "Failed to get session", "session_id", sessionID, "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to get session",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	encode(w, r, http.StatusOK, session)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"langlite-ingestion/internal/database"
+)
+
+// sessionDB holds a single session, sess-1, in project-1.
+type sessionDB struct {
+	database.Service
+	listOpts  database.SessionListOptions
+	projectID string
+}
+
+func (f *sessionDB) ListSessions(projectID string, opts database.SessionListOptions) ([]database.Session, error) {
+	f.projectID = projectID
+	f.listOpts = opts
+	if projectID != "project-1" {
+		return []database.Session{}, nil
+	}
+	return []database.Session{{ID: "sess-1", ProjectID: projectID, TraceCount: 2}}, nil
+}
+
+func (f *sessionDB) GetSession(projectID, sessionID string) (*database.SessionDetail, error) {
+	if projectID != "project-1" || sessionID != "sess-1" {
+		return nil, database.ErrSessionNotFound
+	}
+	return &database.SessionDetail{
+		Session: database.Session{ID: sessionID, ProjectID: projectID, TraceCount: 2},
+		Traces:  []database.SessionTrace{{ID: "trace-1"}, {ID: "trace-2"}},
+	}, nil
+}
+
+func sessionRequest(t *testing.T, db *sessionDB, projectID, target string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	s := &Server{db: db}
+	r := chi.NewRouter()
+	r.Get("/api/v1/sessions", s.ListSessionsHandler)
+	r.Get("/api/v1/sessions/{id}", s.GetSessionHandler)
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if projectID != "" {
+		authCtx := database.AuthContext{ProjectID: projectID, APIKeyID: "key-1"}
+		req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestListSessions(t *testing.T) {
+	db := &sessionDB{}
+	rec := sessionRequest(t, db, "project-1", "/api/v1/sessions?limit=10&offset=20&user_id=u1")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status OK; got %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp database.SessionListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	if len(resp.Sessions) != 1 || resp.Sessions[0].ID != "sess-1" {
+		t.Errorf("expected sess-1; got %+v", resp.Sessions)
+	}
+	want := database.SessionListOptions{UserID: "u1", Limit: 10, Offset: 20}
+	if db.projectID != "project-1" || db.listOpts != want {
+		t.Errorf("expected project-1 with %+v; got %s with %+v", want, db.projectID, db.listOpts)
+	}
+}
+
+func TestListSessionsRejectsBadPagination(t *testing.T) {
+	for _, target := range []string{
+		"/api/v1/sessions?limit=0",
+		"/api/v1/sessions?limit=1000",
+		"/api/v1/sessions?limit=abc",
+		"/api/v1/sessions?offset=-1",
+	} {
+		rec := sessionRequest(t, &sessionDB{}, "project-1", target)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400; got %d", target, rec.Code)
+		}
+	}
+}
+
+func TestGetSession(t *testing.T) {
+	cases := []struct {
+		name       string
+		projectID  string
+		target     string
+		wantStatus int
+	}{
+		{name: "found", projectID: "project-1", target: "/api/v1/sessions/sess-1", wantStatus: http.StatusOK},
+		{name: "unknown session", projectID: "project-1", target: "/api/v1/sessions/sess-2", wantStatus: http.StatusNotFound},
+		{name: "other project", projectID: "project-2", target: "/api/v1/sessions/sess-1", wantStatus: http.StatusNotFound},
+		{name: "unauthenticated", target: "/api/v1/sessions/sess-1", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := sessionRequest(t, &sessionDB{}, tc.projectID, tc.target)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d; got %d: %s", tc.wantStatus, rec.Code, rec.Body)
+			}
+
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var detail database.SessionDetail
+			if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if len(detail.Traces) != 2 || detail.Traces[0].ID != "trace-1" {
+				t.Errorf("expected ordered traces; got %+v", detail.Traces)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/ingest"
+)
+
+const (
+	// maxStreamLineBytes caps a single NDJSON line after decompression.
+	maxStreamLineBytes = 1 << 20
+
+	// streamIdleTimeout is how long a stream may sit between lines before the
+	// server gives up on it. Deadlines are extended after every line, so the
+	// server's ReadTimeout/WriteTimeout don't cap the stream as a whole.
+	streamIdleTimeout = 30 * time.Second
+)
+
+// StreamIngestHandler accepts an NDJSON body of database.StreamItem lines and
+// writes one database.BatchResult line per input line as it goes, followed by
+// a database.StreamSummary line. Unlike BatchHandler, the number of items is
+// not capped.
+func (s *Server) StreamIngestHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	body, err := requestBody(r, 0)
+	if err != nil {
+		errorResp := database.ErrorResponse{
+			Error:    "Validation failed",
+			Message:  "The request contains invalid data",
+			Code:     http.StatusBadRequest,
+			Problems: bodyProblems(err),
+		}
+		if errorResp.Problems == nil {
+			errorResp.Error = "Invalid request"
+			errorResp.Message = "Could not read request body"
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+	defer body.Close()
+
+	rc := http.NewResponseController(w)
+	// Results are written while the body is still being read.
+	_ = rc.EnableFullDuplex()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamLineBytes)
+
+	enc := json.NewEncoder(w)
+	batch := s.ingestor.Batch(authCtx.ProjectID)
+	var summary database.BatchSummary
+
+	for index := 0; ; {
+		_ = rc.SetReadDeadline(time.Now().Add(streamIdleTimeout))
+		if !scanner.Scan() {
+			break
+		}
+
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		result := processStreamLine(r.Context(), batch, line, index)
+		summary.Total++
+		if result.Status == "success" {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+		index++
+
+		_ = rc.SetWriteDeadline(time.Now().Add(streamIdleTimeout))
+		if err := enc.Encode(result); err != nil {
+			// The client went away; nothing left to report to.
+			return
+		}
+		_ = rc.Flush()
+	}
+
+	final := database.StreamSummary{Summary: summary}
+	if err := scanner.Err(); err != nil {
+		final.Error = streamReadError(err)
+	}
+
+	_ = rc.SetWriteDeadline(time.Now().Add(streamIdleTimeout))
+	_ = enc.Encode(final)
+	_ = rc.Flush()
+}
+
+func streamReadError(err error) string {
+	if errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Sprintf("stream aborted: line exceeds %d bytes", maxStreamLineBytes)
+	}
+	if problems := bodyProblems(err); problems != nil {
+		return "stream aborted: " + problems["body"]
+	}
+	return "stream aborted: " + err.Error()
+}
+
+func processStreamLine(ctx context.Context, batch *ingest.Batch, line []byte, index int) database.BatchResult {
+	var item database.StreamItem
+	if err := json.Unmarshal(line, &item); err != nil {
+		return database.BatchResult{
+			Index:  index,
+			Status: "error",
+			Error:  "Invalid JSON: " + err.Error(),
+		}
+	}
+
+	var result ingest.Result
+	var err error
+	switch item.Type {
+	case "trace":
+		result, err = streamItem(ctx, batch, item.Body, (*ingest.Batch).Trace)
+	case "span":
+		result, err = streamItem(ctx, batch, item.Body, (*ingest.Batch).Span)
+	case "generation":
+		result, err = streamItem(ctx, batch, item.Body, (*ingest.Batch).Generation)
+	case "event":
+		result, err = streamItem(ctx, batch, item.Body, (*ingest.Batch).Event)
+	case "score":
+		result, err = streamItem(ctx, batch, item.Body, (*ingest.Batch).Score)
+	default:
+		err = errors.New("Invalid type: type must be one of: trace, span, generation, event, score")
+	}
+
+	return batchResult(index, result, err)
+}
+
+// streamItem decodes the body of a stream line and adds it to batch.
+func streamItem[T any](ctx context.Context, batch *ingest.Batch, raw json.RawMessage, add ingestFunc[T]) (ingest.Result, error) {
+	var req T
+	if len(raw) == 0 {
+		return ingest.Result{}, errors.New("Invalid request: body is required")
+	}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return ingest.Result{}, fmt.Errorf("Invalid request: %w", err)
+	}
+	return add(batch, ctx, req)
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/ingest"
+)
+
+// postStream sends body to StreamIngestHandler and splits the response into
+// its per-line results and the final summary line.
+func postStream(t *testing.T, s *Server, body []byte, encoding string) ([]database.BatchResult, database.StreamSummary) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/batch/stream", bytes.NewReader(body))
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	authCtx := database.AuthContext{ProjectID: "project-1", APIKeyID: "key-1"}
+	req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+
+	rec := httptest.NewRecorder()
+	s.StreamIngestHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("expected an NDJSON response, got %q", ct)
+	}
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		lines = append(lines, bytes.Clone(scanner.Bytes()))
+	}
+	if len(lines) == 0 {
+		t.Fatal("expected at least a summary line")
+	}
+
+	results := make([]database.BatchResult, len(lines)-1)
+	for i, line := range lines[:len(lines)-1] {
+		if err := json.Unmarshal(line, &results[i]); err != nil {
+			t.Fatalf("line %d: %v: %s", i, err, line)
+		}
+	}
+	var summary database.StreamSummary
+	if err := json.Unmarshal(lines[len(lines)-1], &summary); err != nil {
+		t.Fatalf("summary line: %v: %s", err, lines[len(lines)-1])
+	}
+	return results, summary
+}
+
+func newStreamServer() (*Server, *syncDB) {
+	db := &syncDB{}
+	return &Server{ingestor: ingest.New(db, nil, ingest.ModeSync)}, db
+}
+
+const streamBody = `{"type": "trace", "body": {"id": "trace-1", "name": "chat"}}
+{"type": "span", "body": {"id": "span-1", "trace_id": "trace-1", "name": "retrieve"}}
+{"type": "span", "body"
+{"type": "event", "body": {"id": "event-1", "trace_id": "trace-1", "span_id": "span-1", "name": "hit", "message": "m"}}
+
+{"type": "span", "body": {"id": "span-2", "trace_id": "trace-missing", "name": "orphan"}}
+`
+
+func TestStreamIngestResults(t *testing.T) {
+	s, db := newStreamServer()
+
+	results, summary := postStream(t, s, []byte(streamBody), "")
+
+	want := []struct {
+		id     string
+		status string
+	}{
+		{"trace-1", "success"},
+		// A child can reference a trace sent earlier in the same stream.
+		{"span-1", "success"},
+		// An invalid line is reported and the stream carries on.
+		{"", "error"},
+		{"event-1", "success"},
+		// The blank line before it doesn't use up an index, and a span
+		// whose trace was never sent is rejected.
+		{"", "error"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %d: %+v", len(want), len(results), results)
+	}
+	for i, w := range want {
+		got := results[i]
+		if got.Index != i || got.ID != w.id || got.Status != w.status {
+			t.Errorf("result %d: expected index %d id %q %s, got %+v", i, i, w.id, w.status, got)
+		}
+	}
+	if !strings.HasPrefix(results[2].Error, "Invalid JSON") {
+		t.Errorf("expected an invalid JSON error, got %q", results[2].Error)
+	}
+	if !strings.Contains(results[4].Error, "trace_id") {
+		t.Errorf("expected the orphan span to be rejected for its trace, got %q", results[4].Error)
+	}
+
+	wantSummary := database.BatchSummary{Total: 5, Succeeded: 3, Failed: 2}
+	if summary.Summary != wantSummary || summary.Error != "" {
+		t.Errorf("expected summary %+v without error, got %+v", wantSummary, summary)
+	}
+	if len(db.written) != 3 {
+		t.Errorf("expected 3 items written, got %v", db.written)
+	}
+}
+
+func TestStreamIngestGzip(t *testing.T) {
+	s, _ := newStreamServer()
+
+	results, summary := postStream(t, s, gzipBytes(t, []byte(streamBody)), "gzip")
+
+	if len(results) != 5 || results[1].ID != "span-1" || results[1].Status != "success" {
+		t.Fatalf("expected the gzip stream to be read like a plain one, got %+v", results)
+	}
+	if summary.Summary.Succeeded != 3 || summary.Summary.Failed != 2 || summary.Error != "" {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
+
+func TestStreamIngestLineTooLong(t *testing.T) {
+	s, db := newStreamServer()
+
+	var body bytes.Buffer
+	body.WriteString(`{"type": "trace", "body": {"id": "trace-1", "name": "chat"}}` + "\n")
+	body.WriteString(`{"type": "trace", "body": {"name": "` + strings.Repeat("x", maxStreamLineBytes) + `"}}` + "\n")
+	body.WriteString(`{"type": "trace", "body": {"id": "trace-2", "name": "after"}}` + "\n")
+
+	results, summary := postStream(t, s, body.Bytes(), "")
+
+	if len(results) != 1 || results[0].ID != "trace-1" {
+		t.Fatalf("expected only the lines before the long one to be processed, got %+v", results)
+	}
+	if summary.Summary.Total != 1 || summary.Summary.Succeeded != 1 {
+		t.Errorf("unexpected summary %+v", summary.Summary)
+	}
+	if !strings.Contains(summary.Error, "line exceeds") {
+		t.Errorf("expected the summary to report the long line, got %q", summary.Error)
+	}
+	if len(db.written) != 1 {
+		t.Errorf("expected only trace-1 written, got %v", db.written)
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"time"
+
+	"langlite-ingestion/internal/database"
+)
+
+// defaultUserWindow is the time range user analytics cover when from isn't
+// given.
+const defaultUserWindow = 30 * 24 * time.Hour
+
+func (s *Server) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	limit, offset, problems := pagination(r)
+	from, to, rangeProblems := timeRange(r, defaultUserWindow)
+	maps.Copy(problems, rangeProblems)
+
+	if len(problems) > 0 {
+		errorResp := database.ErrorResponse{
+			Error:    "Validation failed",
+			Message:  "The request contains invalid data",
+			Code:     http.StatusBadRequest,
+			Problems: problems,
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+
+	opts := database.UserListOptions{
+		From:   from,
+		To:     to,
+		Limit:  limit,
+		Offset: offset,
+	}
+
+	users, err := s.db.ListUsers(authCtx.ProjectID, opts)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list users", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to list users",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	response := database.UserListResponse{
+		Users:  users,
+		From:   from,
+		To:     to,
+		Limit:  limit,
+		Offset: offset,
+	}
+
+	encode(w, r, http.StatusOK, response)
+}
+
+func (s *Server) UserMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	userID := r.PathValue("id")
+	if userID == "" {
+		errorResp := database.ErrorResponse{
+			Error:   "Missing user ID",
+			Message: "User ID is required in the URL path",
+			Code:    http.StatusBadRequest,
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+
+	from, to, problems := timeRange(r, defaultUserWindow)
+	interval, intervalProblems := bucketInterval(r, from, to)
+	maps.Copy(problems, intervalProblems)
+
+	if len(problems) > 0 {
+		errorResp := database.ErrorResponse{
+			Error:    "Validation failed",
+			Message:  "The request contains invalid data",
+			Code:     http.StatusBadRequest,
+			Problems: problems,
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+
+	opts := database.UserMetricsOptions{
+		From:     from,
+		To:       to,
+		Interval: interval,
+	}
+
+	buckets, err := s.db.GetUserMetrics(authCtx.ProjectID, userID, opts)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			errorResp := database.ErrorResponse{
+				Error:   "User not found",
+				Message: "The specified user has no traces in this project",
+				Code:    http.StatusNotFound,
+			}
+			encode(w, r, http.StatusNotFound, errorResp)
+			return
+		}
+
+		slog.ErrorContext(r.Context(), "Failed to get user metrics", "user_id", userID, "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to get user metrics",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	response := database.UserMetricsResponse{
+		UserID:   userID,
+		From:     opts.From,
+		To:       opts.To,
+		Interval: opts.Interval,
+		Buckets:  buckets,
+	}
+
+	for _, bucket := range buckets {
+		response.Totals.Add(bucket.UserMetrics)
+	}
+
+	encode(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"langlite-ingestion/internal/database"
+)
+
+// userDB knows about a single end user, u1, in project-1.
+type userDB struct {
+	database.Service
+	listOpts    database.UserListOptions
+	metricsOpts database.UserMetricsOptions
+}
+
+func (f *userDB) ListUsers(projectID string, opts database.UserListOptions) ([]database.UserSummary, error) {
+	f.listOpts = opts
+	return []database.UserSummary{{UserID: "u1"}}, nil
+}
+
+func (f *userDB) GetUserMetrics(projectID, userID string, opts database.UserMetricsOptions) ([]database.UserMetricsBucket, error) {
+	f.metricsOpts = opts
+	if projectID != "project-1" || userID != "u1" {
+		return nil, database.ErrUserNotFound
+	}
+
+	high, low := 0.9, 0.3
+	return []database.UserMetricsBucket{
+		{UserMetrics: database.UserMetrics{TraceCount: 2, TotalTokens: 100, ErrorCount: 1, ScoreCount: 1, AvgScore: &high}},
+		{UserMetrics: database.UserMetrics{}},
+		{UserMetrics: database.UserMetrics{TraceCount: 1, TotalTokens: 50, ScoreCount: 2, AvgScore: &low}},
+	}, nil
+}
+
+func userRequest(t *testing.T, db *userDB, target string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	s := &Server{db: db}
+	r := chi.NewRouter()
+	r.Get("/api/v1/users", s.ListUsersHandler)
+	r.Get("/api/v1/users/{id}/metrics", s.UserMetricsHandler)
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	authCtx := database.AuthContext{ProjectID: "project-1", APIKeyID: "key-1"}
+	req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestListUsersDefaultsToLast30Days(t *testing.T) {
+	db := &userDB{}
+	rec := userRequest(t, db, "/api/v1/users")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status OK; got %d: %s", rec.Code, rec.Body)
+	}
+	if window := db.listOpts.To.Sub(db.listOpts.From); window != defaultUserWindow {
+		t.Errorf("expected a %v window; got %v", defaultUserWindow, window)
+	}
+	if db.listOpts.Limit != defaultPageLimit {
+		t.Errorf("expected limit %d; got %d", defaultPageLimit, db.listOpts.Limit)
+	}
+}
+
+func TestUserMetricsTotals(t *testing.T) {
+	db := &userDB{}
+	rec := userRequest(t, db, "/api/v1/users/u1/metrics?from=2025-01-01T00:00:00Z&to=2025-01-04T00:00:00Z")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status OK; got %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp database.UserMetricsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	if db.metricsOpts.Interval != "day" || !db.metricsOpts.From.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected options %+v", db.metricsOpts)
+	}
+
+	totals := resp.Totals
+	if totals.TraceCount != 3 || totals.TotalTokens != 150 || totals.ErrorCount != 1 || totals.ScoreCount != 3 {
+		t.Errorf("unexpected totals %+v", totals)
+	}
+	// (0.9 + 2*0.3) / 3
+	if totals.AvgScore == nil || math.Abs(*totals.AvgScore-0.5) > 1e-9 {
+		t.Errorf("expected avg_score 0.5; got %v", totals.AvgScore)
+	}
+	if len(resp.Buckets) != 3 {
+		t.Errorf("expected 3 buckets; got %d", len(resp.Buckets))
+	}
+}
+
+func TestUserMetricsRejects(t *testing.T) {
+	cases := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantField  string
+	}{
+		{name: "unknown user", target: "/api/v1/users/u2/metrics", wantStatus: http.StatusNotFound},
+		{name: "bad interval", target: "/api/v1/users/u1/metrics?interval=month", wantStatus: http.StatusBadRequest, wantField: "interval"},
+		{name: "too many buckets", target: "/api/v1/users/u1/metrics?interval=hour&from=2020-01-01T00:00:00Z&to=2025-01-01T00:00:00Z", wantStatus: http.StatusBadRequest, wantField: "interval"},
+		{name: "bad timestamp", target: "/api/v1/users/u1/metrics?from=yesterday", wantStatus: http.StatusBadRequest, wantField: "from"},
+		{name: "inverted range", target: "/api/v1/users/u1/metrics?from=2025-01-02T00:00:00Z&to=2025-01-01T00:00:00Z", wantStatus: http.StatusBadRequest, wantField: "from"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := userRequest(t, &userDB{}, tc.target)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected status %d; got %d: %s", tc.wantStatus, rec.Code, rec.Body)
+			}
+
+			if tc.wantField == "" {
+				return
+			}
+
+			var resp database.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if _, ok := resp.Problems[tc.wantField]; !ok {
+				t.Errorf("expected a %s problem; got %v", tc.wantField, resp.Problems)
+			}
+		})
+	}
+}
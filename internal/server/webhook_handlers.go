@@ -0,0 +1,342 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/webhook"
+)
+
+// CreateWebhookHandler subscribes a URL to the project's ingested items. The
+// response is the only one that carries the webhook's signing secret.
+func (s *Server) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	req, problems, err := decodeValid[database.WebhookRequest](s.webhookRequestContext(r))
+	if err != nil {
+		encodeWebhookRequestError(w, r, problems)
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to generate webhook secret", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Internal error",
+			Message: "Failed to create webhook",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	hook := database.Webhook{
+		ID:          uuid.New().String(),
+		ProjectID:   authCtx.ProjectID,
+		URL:         req.URL,
+		Description: req.Description,
+		Rules:       req.Rules,
+		Secret:      secret,
+		Enabled:     req.Enabled == nil || *req.Enabled,
+		CreatedAt:   time.Now().UTC(),
+	}
+	hook.UpdatedAt = hook.CreatedAt
+	setAuditTarget(r, "webhook:"+hook.ID)
+
+	if err := s.db.CreateWebhook(hook); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create webhook", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to create webhook",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+	s.forgetWebhooks(authCtx.ProjectID)
+
+	encode(w, r, http.StatusCreated, hook)
+}
+
+func (s *Server) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	hooks, err := s.db.ListWebhooks(authCtx.ProjectID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list webhooks", "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to list webhooks",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	encode(w, r, http.StatusOK, database.WebhookListResponse{Webhooks: hooks})
+}
+
+func (s *Server) GetWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	hook, err := s.db.GetWebhook(authCtx.ProjectID, r.PathValue("id"))
+	if err != nil {
+		encodeWebhookError(w, r, err, "Failed to get webhook")
+		return
+	}
+
+	hook.Secret = ""
+	encode(w, r, http.StatusOK, hook)
+}
+
+// UpdateWebhookHandler replaces a webhook's URL, description and rules, and
+// enables or disables it. Enabling a webhook that failing deliveries
+// disabled gives it a clean slate.
+func (s *Server) UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	webhookID := r.PathValue("id")
+	setAuditTarget(r, "webhook:"+webhookID)
+
+	req, problems, err := decodeValid[database.WebhookRequest](s.webhookRequestContext(r))
+	if err != nil {
+		encodeWebhookRequestError(w, r, problems)
+		return
+	}
+
+	hook, err := s.db.UpdateWebhook(authCtx.ProjectID, webhookID, req)
+	if err != nil {
+		encodeWebhookError(w, r, err, "Failed to update webhook")
+		return
+	}
+	s.forgetWebhooks(authCtx.ProjectID)
+
+	hook.Secret = ""
+	encode(w, r, http.StatusOK, hook)
+}
+
+func (s *Server) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	webhookID := r.PathValue("id")
+	setAuditTarget(r, "webhook:"+webhookID)
+
+	if err := s.db.DeleteWebhook(authCtx.ProjectID, webhookID); err != nil {
+		encodeWebhookError(w, r, err, "Failed to delete webhook")
+		return
+	}
+	s.forgetWebhooks(authCtx.ProjectID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWebhookDeliveriesHandler lists a webhook's delivery attempts, newest
+// first.
+func (s *Server) ListWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	limit, offset, problems := pagination(r)
+	if len(problems) > 0 {
+		errorResp := database.ErrorResponse{
+			Error:    "Validation failed",
+			Message:  "The request contains invalid data",
+			Code:     http.StatusBadRequest,
+			Problems: problems,
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+
+	webhookID := r.PathValue("id")
+	if _, err := s.db.GetWebhook(authCtx.ProjectID, webhookID); err != nil {
+		encodeWebhookError(w, r, err, "Failed to get webhook")
+		return
+	}
+
+	deliveries, err := s.db.ListWebhookDeliveries(authCtx.ProjectID, webhookID, limit, offset)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list webhook deliveries", "webhook_id", webhookID, "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Database error",
+			Message: "Failed to list webhook deliveries",
+			Code:    http.StatusInternalServerError,
+		}
+		encode(w, r, http.StatusInternalServerError, errorResp)
+		return
+	}
+
+	response := database.WebhookDeliveryListResponse{
+		Deliveries: deliveries,
+		Limit:      limit,
+		Offset:     offset,
+	}
+
+	encode(w, r, http.StatusOK, response)
+}
+
+// PingWebhookHandler queues a webhook.ping event to the webhook, even if
+// it's disabled, so its endpoint can be checked. The outcome shows in its
+// deliveries.
+func (s *Server) PingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	authCtx, ok := GetAuthContext(r)
+	if !ok {
+		errorResp := database.ErrorResponse{
+			Error:   "Authentication required",
+			Message: "Valid API key required",
+			Code:    http.StatusUnauthorized,
+		}
+		encode(w, r, http.StatusUnauthorized, errorResp)
+		return
+	}
+
+	if s.webhooks == nil {
+		errorResp := database.ErrorResponse{
+			Error:   "Queue unavailable",
+			Message: "Webhooks are delivered by the queue, which isn't configured",
+			Code:    http.StatusServiceUnavailable,
+		}
+		encode(w, r, http.StatusServiceUnavailable, errorResp)
+		return
+	}
+
+	hook, err := s.db.GetWebhook(authCtx.ProjectID, r.PathValue("id"))
+	if err != nil {
+		encodeWebhookError(w, r, err, "Failed to get webhook")
+		return
+	}
+
+	eventID, err := s.webhooks.Ping(r.Context(), *hook)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to queue webhook ping", "webhook_id", hook.ID, "error", err)
+		errorResp := database.ErrorResponse{
+			Error:   "Queue error",
+			Message: "Failed to queue the ping",
+			Code:    http.StatusServiceUnavailable,
+		}
+		encode(w, r, http.StatusServiceUnavailable, errorResp)
+		return
+	}
+
+	encode(w, r, http.StatusAccepted, map[string]string{"event_id": eventID, "status": "queued"})
+}
+
+// webhookRequestContext lets webhook URLs point at private addresses when
+// the deployment allows it.
+func (s *Server) webhookRequestContext(r *http.Request) *http.Request {
+	if !s.allowPrivateWebhooks {
+		return r
+	}
+	return r.WithContext(database.WithPrivateWebhookURLs(r.Context()))
+}
+
+// forgetWebhooks makes this instance pick up a change to the project's
+// webhooks at once; others do within the dispatcher's cache TTL.
+func (s *Server) forgetWebhooks(projectID string) {
+	if s.webhooks != nil {
+		s.webhooks.Forget(projectID)
+	}
+}
+
+func encodeWebhookRequestError(w http.ResponseWriter, r *http.Request, problems map[string]string) {
+	if len(problems) > 0 {
+		errorResp := database.ErrorResponse{
+			Error:    "Validation failed",
+			Message:  "The request contains invalid data",
+			Code:     http.StatusBadRequest,
+			Problems: problems,
+		}
+		encode(w, r, http.StatusBadRequest, errorResp)
+		return
+	}
+
+	errorResp := database.ErrorResponse{
+		Error:   "Invalid request",
+		Message: "Could not parse request body",
+		Code:    http.StatusBadRequest,
+	}
+	encode(w, r, http.StatusBadRequest, errorResp)
+}
+
+// encodeWebhookError answers 404 for a webhook that isn't in the project,
+// and 500 with message for anything else.
+func encodeWebhookError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		errorResp := database.ErrorResponse{
+			Error:   "Webhook not found",
+			Message: "The specified webhook does not exist",
+			Code:    http.StatusNotFound,
+		}
+		encode(w, r, http.StatusNotFound, errorResp)
+		return
+	}
+
+	slog.ErrorContext(r.Context(), message, "webhook_id", r.PathValue("id"), "error", err)
+	errorResp := database.ErrorResponse{
+		Error:   "Database error",
+		Message: message,
+		Code:    http.StatusInternalServerError,
+	}
+	encode(w, r, http.StatusInternalServerError, errorResp)
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"langlite-ingestion/internal/database"
+	"langlite-ingestion/internal/webhook"
+)
+
+// webhooksDB keeps webhooks in memory.
+type webhooksDB struct {
+	database.Service
+	hooks map[string]database.Webhook
+}
+
+func (f *webhooksDB) CreateWebhook(w database.Webhook) error {
+	f.hooks[w.ID] = w
+	return nil
+}
+
+func (f *webhooksDB) GetWebhook(projectID, webhookID string) (*database.Webhook, error) {
+	hook, ok := f.hooks[webhookID]
+	if !ok || hook.ProjectID != projectID {
+		return nil, database.ErrWebhookNotFound
+	}
+	return &hook, nil
+}
+
+func (f *webhooksDB) ListWebhooks(projectID string) ([]database.Webhook, error) {
+	hooks := []database.Webhook{}
+	for _, hook := range f.hooks {
+		if hook.ProjectID == projectID {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+func (f *webhooksDB) UpdateWebhook(projectID, webhookID string, req database.WebhookRequest) (*database.Webhook, error) {
+	hook, err := f.GetWebhook(projectID, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	hook.URL, hook.Description, hook.Rules = req.URL, req.Description, req.Rules
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+	f.hooks[webhookID] = *hook
+	return hook, nil
+}
+
+func (f *webhooksDB) DeleteWebhook(projectID, webhookID string) error {
+	if _, err := f.GetWebhook(projectID, webhookID); err != nil {
+		return err
+	}
+	delete(f.hooks, webhookID)
+	return nil
+}
+
+func (f *webhooksDB) ListWebhookDeliveries(projectID, webhookID string, limit, offset int) ([]database.WebhookDelivery, error) {
+	return []database.WebhookDelivery{{ID: "d1", WebhookID: webhookID, ProjectID: projectID, Success: true}}, nil
+}
+
+// pingQueue records the events queued for delivery.
+type pingQueue struct {
+	events []string
+}
+
+func (q *pingQueue) EnqueueWebhook(ctx context.Context, projectID, webhookID, eventID, eventType string, body []byte) error {
+	q.events = append(q.events, webhookID+" "+eventType)
+	return nil
+}
+
+func webhookRouter(s *Server) http.Handler {
+	r := chi.NewRouter()
+	r.Post("/api/v1/webhooks", s.CreateWebhookHandler)
+	r.Get("/api/v1/webhooks", s.ListWebhooksHandler)
+	r.Get("/api/v1/webhooks/{id}", s.GetWebhookHandler)
+	r.Put("/api/v1/webhooks/{id}", s.UpdateWebhookHandler)
+	r.Delete("/api/v1/webhooks/{id}", s.DeleteWebhookHandler)
+	r.Get("/api/v1/webhooks/{id}/deliveries", s.ListWebhookDeliveriesHandler)
+	r.Post("/api/v1/webhooks/{id}/ping", s.PingWebhookHandler)
+	return r
+}
+
+func webhookRequest(t *testing.T, h http.Handler, projectID, method, path, body string, v any) int {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	authCtx := database.AuthContext{ProjectID: projectID, APIKeyID: "key-1"}
+	req = req.WithContext(context.WithValue(req.Context(), AuthContextKey, authCtx))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if v != nil && rec.Code < 300 {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+	return rec.Code
+}
+
+func TestWebhookHandlers(t *testing.T) {
+	db := &webhooksDB{hooks: make(map[string]database.Webhook)}
+	q := &pingQueue{}
+	h := webhookRouter(&Server{db: db, webhooks: webhook.NewDispatcher(db, q)})
+
+	var created database.Webhook
+	code := webhookRequest(t, h, "project-1", http.MethodPost, "/api/v1/webhooks",
+		`{"url": "https://example.com/hook", "rules": [{"entity_type": "event", "levels": ["error"]}]}`, &created)
+	if code != http.StatusCreated || !strings.HasPrefix(created.Secret, "whsec_") || !created.Enabled {
+		t.Fatalf("expected an enabled webhook with its secret; got %d %+v", code, created)
+	}
+
+	code = webhookRequest(t, h, "project-1", http.MethodPost, "/api/v1/webhooks",
+		`{"url": "ftp://example.com", "rules": [{"entity_type": "span", "tags": ["prod"]}]}`, nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("expected a bad URL and rule rejected; got %d", code)
+	}
+
+	var list database.WebhookListResponse
+	code = webhookRequest(t, h, "project-1", http.MethodGet, "/api/v1/webhooks", "", &list)
+	if code != http.StatusOK || len(list.Webhooks) != 1 || list.Webhooks[0].Secret != "" {
+		t.Errorf("expected the webhook listed without its secret; got %d %+v", code, list)
+	}
+
+	path := "/api/v1/webhooks/" + created.ID
+	if code := webhookRequest(t, h, "project-2", http.MethodGet, path, "", nil); code != http.StatusNotFound {
+		t.Errorf("expected another project's webhook to be hidden; got %d", code)
+	}
+
+	var updated database.Webhook
+	code = webhookRequest(t, h, "project-1", http.MethodPut, path,
+		`{"url": "https://example.com/v2", "rules": [{"entity_type": "score", "score_below": 0.3}], "enabled": false}`, &updated)
+	if code != http.StatusOK || updated.Enabled || updated.URL != "https://example.com/v2" || updated.Secret != "" {
+		t.Errorf("expected the webhook replaced and disabled; got %d %+v", code, updated)
+	}
+
+	var deliveries database.WebhookDeliveryListResponse
+	code = webhookRequest(t, h, "project-1", http.MethodGet, path+"/deliveries?limit=10", "", &deliveries)
+	if code != http.StatusOK || len(deliveries.Deliveries) != 1 || deliveries.Limit != 10 {
+		t.Errorf("expected the delivery log; got %d %+v", code, deliveries)
+	}
+
+	if code := webhookRequest(t, h, "project-1", http.MethodPost, path+"/ping", "", nil); code != http.StatusAccepted {
+		t.Errorf("expected the ping queued; got %d", code)
+	}
+	if len(q.events) != 1 || q.events[0] != created.ID+" "+webhook.EventPing {
+		t.Errorf("expected a ping to the disabled webhook; got %v", q.events)
+	}
+
+	if code := webhookRequest(t, h, "project-1", http.MethodDelete, path, "", nil); code != http.StatusNoContent {
+		t.Errorf("expected the webhook deleted; got %d", code)
+	}
+	if code := webhookRequest(t, h, "project-1", http.MethodGet, path, "", nil); code != http.StatusNotFound {
+		t.Errorf("expected the deleted webhook gone; got %d", code)
+	}
+}
+
+func TestCreateWebhookRefusesPrivateURLs(t *testing.T) {
+	urls := []string{
+		"http://127.0.0.1:6379",
+		"http://localhost:8080/admin/v1/audit",
+		"http://169.254.169.254/latest/meta-data/",
+		"https://10.0.0.5/hook",
+		"http://[::1]/hook",
+		"http://[::ffff:192.168.1.1]/hook",
+	}
+
+	db := &webhooksDB{hooks: make(map[string]database.Webhook)}
+	h := webhookRouter(&Server{db: db})
+	for _, url := range urls {
+		body := `{"url": "` + url + `", "rules": [{"entity_type": "trace"}]}`
+		if code := webhookRequest(t, h, "project-1", http.MethodPost, "/api/v1/webhooks", body, nil); code != http.StatusBadRequest {
+			t.Errorf("expected %s refused; got %d", url, code)
+		}
+	}
+
+	// Deployments can opt in, to deliver to local test stubs.
+	h = webhookRouter(&Server{db: db, allowPrivateWebhooks: true})
+	body := `{"url": "http://127.0.0.1:9000/hook", "rules": [{"entity_type": "trace"}]}`
+	if code := webhookRequest(t, h, "project-1", http.MethodPost, "/api/v1/webhooks", body, nil); code != http.StatusCreated {
+		t.Errorf("expected a local stub allowed once opted in; got %d", code)
+	}
+}
+
+func TestPingWebhookWithoutQueue(t *testing.T) {
+	db := &webhooksDB{hooks: map[string]database.Webhook{"w1": {ID: "w1", ProjectID: "project-1"}}}
+	h := webhookRouter(&Server{db: db})
+
+	if code := webhookRequest(t, h, "project-1", http.MethodPost, "/api/v1/webhooks/w1/ping", "", nil); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without a queue; got %d", code)
+	}
+}
@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"langlite-ingestion/internal/database"
+)
+
+// maxResponse is how much of a response body a delivery keeps.
+const maxResponse = 1024
+
+// Result describes a delivery attempt that reached the endpoint.
+type Result struct {
+	StatusCode int
+	// Response is the start of the response body.
+	Response string
+	Duration time.Duration
+}
+
+// Deliverer sends events to webhook endpoints.
+type Deliverer struct {
+	client *http.Client
+}
+
+// errPrivateAddress is returned for endpoints that resolve to an address
+// webhooks may not reach.
+var errPrivateAddress = errors.New("webhook endpoint resolves to a private address")
+
+// NewDeliverer returns a Deliverer that gives each endpoint timeout to
+// respond. Redirects aren't followed, so a moved endpoint fails until its
+// webhook is updated.
+//
+// Unless allowPrivate is set, it refuses to connect to loopback, private,
+// link-local and unspecified addresses, so a webhook can't be used to reach
+// the service's own network. The check is made on the address dialed, after
+// DNS resolution, so names that resolve or rebind to one are refused too.
+// allowPrivate is for delivering to local test stubs.
+func NewDeliverer(timeout time.Duration, allowPrivate bool) *Deliverer {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = refusePrivate
+	}
+
+	return &Deliverer{
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				// A proxy would dial the endpoint on our behalf, out of
+				// reach of the address check.
+				Proxy:               nil,
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: timeout,
+				MaxIdleConnsPerHost: 4,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// refusePrivate is a net.Dialer Control that fails connections to addresses
+// webhooks may not reach.
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !database.PublicWebhookAddr(addr) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// Deliver POSTs body, the event eventID of eventType, to hook's URL, signed
+// with its secret. Any response other than a 2xx is an error; the Result
+// describes it all the same.
+func (d *Deliverer) Deliver(ctx context.Context, hook database.Webhook, eventID, eventType string, body []byte) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to build webhook request: %w", err)
+	}
+
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Langlite-Webhooks/1.0")
+	req.Header.Set(HeaderEventID, eventID)
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, now, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return Result{Duration: time.Since(now)}, fmt.Errorf("failed to deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	// Drain a little more so the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	result := Result{
+		StatusCode: resp.StatusCode,
+		Response:   string(response),
+		Duration:   time.Since(now),
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return result, fmt.Errorf("webhook endpoint responded %s", resp.Status)
+	}
+	return result, nil
+}
@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"langlite-ingestion/internal/database"
+)
+
+// cacheTTL is how long a Dispatcher trusts a project's webhooks without
+// reading them again. Changes made through the API are picked up at once on
+// the instance that made them, by calling Forget.
+const cacheTTL = 30 * time.Second
+
+// Enqueuer queues an event for delivery to one webhook. *queue.Client
+// implements it.
+type Enqueuer interface {
+	EnqueueWebhook(ctx context.Context, projectID, webhookID, eventID, eventType string, body []byte) error
+}
+
+// Dispatcher queues an event for each of a project's webhooks an ingested
+// item matches.
+type Dispatcher struct {
+	db    database.Service
+	queue Enqueuer
+
+	mu    sync.Mutex
+	hooks map[string]cachedWebhooks
+}
+
+type cachedWebhooks struct {
+	hooks   []database.Webhook
+	expires time.Time
+}
+
+func NewDispatcher(db database.Service, queue Enqueuer) *Dispatcher {
+	return &Dispatcher{
+		db:    db,
+		queue: queue,
+		hooks: make(map[string]cachedWebhooks),
+	}
+}
+
+// Notify queues an event for item, a database request for one ingested
+// item of projectID, to each enabled webhook it matches. Failures are
+// logged rather than returned: the item is already accepted.
+func (d *Dispatcher) Notify(ctx context.Context, projectID string, item any) {
+	entity, ok := entityType(item)
+	if !ok {
+		return
+	}
+
+	hooks, err := d.webhooks(projectID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load webhooks", "project_id", projectID, "error", err)
+		return
+	}
+
+	var eventID string
+	var body []byte
+	for _, hook := range hooks {
+		if !hook.Enabled || !matchesAny(hook, item) {
+			continue
+		}
+
+		if body == nil {
+			eventID = uuid.New().String()
+			if body, err = marshalEvent(eventID, EventType(entity), projectID, item); err != nil {
+				slog.ErrorContext(ctx, "Failed to serialize webhook event", "project_id", projectID, "error", err)
+				return
+			}
+		}
+
+		if err := d.queue.EnqueueWebhook(ctx, projectID, hook.ID, eventID, EventType(entity), body); err != nil {
+			slog.ErrorContext(ctx, "Failed to queue webhook delivery", "project_id", projectID, "webhook_id", hook.ID, "error", err)
+		}
+	}
+}
+
+// Ping queues a test event to hook, whatever its rules and even if it's
+// disabled, and returns the event's ID.
+func (d *Dispatcher) Ping(ctx context.Context, hook database.Webhook) (string, error) {
+	eventID := uuid.New().String()
+	body, err := marshalEvent(eventID, EventPing, hook.ProjectID, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize webhook event: %w", err)
+	}
+
+	if err := d.queue.EnqueueWebhook(ctx, hook.ProjectID, hook.ID, eventID, EventPing, body); err != nil {
+		return "", err
+	}
+	return eventID, nil
+}
+
+// Forget drops projectID's cached webhooks, so the next item reads them
+// again.
+func (d *Dispatcher) Forget(projectID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.hooks, projectID)
+}
+
+// webhooks returns projectID's webhooks, from the cache while it's fresh.
+// Projects without webhooks are cached too, since they're the common case.
+func (d *Dispatcher) webhooks(projectID string) ([]database.Webhook, error) {
+	now := time.Now()
+
+	d.mu.Lock()
+	cached, ok := d.hooks[projectID]
+	d.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.hooks, nil
+	}
+
+	hooks, err := d.db.ListWebhooks(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.hooks[projectID] = cachedWebhooks{hooks: hooks, expires: now.Add(cacheTTL)}
+	d.mu.Unlock()
+	return hooks, nil
+}
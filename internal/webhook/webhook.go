@@ -0,0 +1,111 @@
+// Package webhook notifies projects of ingested items over HTTP. A project's
+// webhooks each hold rules saying which items they want; a Dispatcher turns
+// every matching item into an event and hands it to the queue, whose workers
+// deliver it with a Deliverer, signed with the webhook's secret.
+package webhook
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"langlite-ingestion/internal/database"
+)
+
+// EventPing is the type of the test event sent to a webhook on request.
+// Every other event is named "<entity_type>.ingested", e.g.
+// "event.ingested".
+const EventPing = "webhook.ping"
+
+// Event is the JSON body of a delivery.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	ProjectID string    `json:"project_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Data is the ingested item as accepted, or nil for a ping.
+	Data any `json:"data,omitempty"`
+}
+
+// EventType names the event for items of entityType.
+func EventType(entityType string) string {
+	return entityType + ".ingested"
+}
+
+// entityType returns the webhook entity type of item, a database request
+// for one ingested item.
+func entityType(item any) (string, bool) {
+	switch item.(type) {
+	case database.TraceRequest:
+		return "trace", true
+	case database.SpanRequest:
+		return "span", true
+	case database.GenerationRequest:
+		return "generation", true
+	case database.EventRequest:
+		return "event", true
+	case database.ScoreRequest:
+		return "score", true
+	default:
+		return "", false
+	}
+}
+
+// Matches reports whether item satisfies rule.
+func Matches(rule database.WebhookRule, item any) bool {
+	if t, ok := entityType(item); !ok || t != rule.EntityType {
+		return false
+	}
+
+	switch item := item.(type) {
+	case database.TraceRequest:
+		for _, tag := range rule.Tags {
+			if !slices.Contains(item.Tags, tag) {
+				return false
+			}
+		}
+
+	case database.EventRequest:
+		if len(rule.Levels) > 0 {
+			level := item.Level
+			if level == "" {
+				level = "info"
+			}
+			if !slices.ContainsFunc(rule.Levels, func(l string) bool { return strings.EqualFold(l, level) }) {
+				return false
+			}
+		}
+
+	case database.ScoreRequest:
+		if rule.ScoreName != "" && rule.ScoreName != item.Name {
+			return false
+		}
+		if rule.ScoreBelow != nil && !(item.Value < *rule.ScoreBelow) {
+			return false
+		}
+		if rule.ScoreAbove != nil && !(item.Value > *rule.ScoreAbove) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesAny reports whether item satisfies any of hook's rules.
+func matchesAny(hook database.Webhook, item any) bool {
+	return slices.ContainsFunc(hook.Rules, func(rule database.WebhookRule) bool {
+		return Matches(rule, item)
+	})
+}
+
+// marshalEvent builds the body of an event of eventType carrying data.
+func marshalEvent(id, eventType, projectID string, data any) ([]byte, error) {
+	return json.Marshal(Event{
+		ID:        id,
+		Type:      eventType,
+		ProjectID: projectID,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+}
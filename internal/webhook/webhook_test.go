@@ -0,0 +1,202 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"langlite-ingestion/internal/database"
+)
+
+func float(f float64) *float64 { return &f }
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		rule database.WebhookRule
+		item any
+		want bool
+	}{
+		{"entity type", database.WebhookRule{EntityType: "trace"}, database.TraceRequest{ID: "t1"}, true},
+		{"other entity type", database.WebhookRule{EntityType: "span"}, database.TraceRequest{ID: "t1"}, false},
+		{"tags", database.WebhookRule{EntityType: "trace", Tags: []string{"prod", "chat"}}, database.TraceRequest{Tags: []string{"chat", "prod", "eu"}}, true},
+		{"missing tag", database.WebhookRule{EntityType: "trace", Tags: []string{"prod", "chat"}}, database.TraceRequest{Tags: []string{"prod"}}, false},
+		{"level", database.WebhookRule{EntityType: "event", Levels: []string{"warn", "error"}}, database.EventRequest{Level: "ERROR"}, true},
+		{"other level", database.WebhookRule{EntityType: "event", Levels: []string{"error"}}, database.EventRequest{Level: "warn"}, false},
+		{"default level", database.WebhookRule{EntityType: "event", Levels: []string{"info"}}, database.EventRequest{}, true},
+		{"score below", database.WebhookRule{EntityType: "score", ScoreName: "accuracy", ScoreBelow: float(0.5)}, database.ScoreRequest{Name: "accuracy", Value: 0.2}, true},
+		{"score not below", database.WebhookRule{EntityType: "score", ScoreBelow: float(0.5)}, database.ScoreRequest{Name: "accuracy", Value: 0.5}, false},
+		{"other score name", database.WebhookRule{EntityType: "score", ScoreName: "accuracy"}, database.ScoreRequest{Name: "toxicity", Value: 0.9}, false},
+		{"score above", database.WebhookRule{EntityType: "score", ScoreAbove: float(0.8)}, database.ScoreRequest{Name: "toxicity", Value: 0.9}, true},
+		{"not an item", database.WebhookRule{EntityType: "trace"}, "t1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Matches(tt.rule, tt.item); got != tt.want {
+				t.Errorf("expected %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"id":"e1"}`)
+	now := time.Now()
+	signature := Sign("whsec_test", now, body)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Errorf("expected a sha256 signature; got %q", signature)
+	}
+	if err := Verify("whsec_test", signature, timestamp, body, 5*time.Minute); err != nil {
+		t.Errorf("expected the signature to verify; got %v", err)
+	}
+	if err := Verify("whsec_other", signature, timestamp, body, 5*time.Minute); err == nil {
+		t.Error("expected another secret to fail")
+	}
+	if err := Verify("whsec_test", signature, timestamp, []byte(`{"id":"e2"}`), 5*time.Minute); err == nil {
+		t.Error("expected a tampered body to fail")
+	}
+
+	old := now.Add(-time.Hour)
+	if err := Verify("whsec_test", Sign("whsec_test", old, body), strconv.FormatInt(old.Unix(), 10), body, 5*time.Minute); err == nil {
+		t.Error("expected a stale delivery to fail")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+		io.WriteString(w, "nope")
+	}))
+	defer srv.Close()
+
+	hook := database.Webhook{ID: "w1", URL: srv.URL, Secret: "whsec_test"}
+	body := []byte(`{"id":"e1","type":"event.ingested"}`)
+	d := NewDeliverer(5*time.Second, true)
+
+	result, err := d.Deliver(context.Background(), hook, "e1", "event.ingested", body)
+	if err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if result.StatusCode != http.StatusNoContent {
+		t.Errorf("expected a 204; got %d", result.StatusCode)
+	}
+	if got.Header.Get(HeaderEventID) != "e1" || got.Header.Get(HeaderEvent) != "event.ingested" {
+		t.Errorf("unexpected event headers %v", got.Header)
+	}
+	if err := Verify("whsec_test", got.Header.Get(HeaderSignature), got.Header.Get(HeaderTimestamp), gotBody, time.Minute); err != nil {
+		t.Errorf("expected the delivery to verify; got %v", err)
+	}
+
+	status = http.StatusInternalServerError
+	result, err = d.Deliver(context.Background(), hook, "e1", "event.ingested", body)
+	if err == nil {
+		t.Fatal("expected a 500 to fail")
+	}
+	if result.StatusCode != http.StatusInternalServerError || result.Response != "nope" {
+		t.Errorf("expected the 500 described; got %+v", result)
+	}
+}
+
+func TestDeliverRefusesPrivateAddresses(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	port := srv.URL[strings.LastIndex(srv.URL, ":")+1:]
+	d := NewDeliverer(time.Second, false)
+	for _, url := range []string{srv.URL, "http://localhost:" + port, "http://[::1]:" + port} {
+		_, err := d.Deliver(context.Background(), database.Webhook{ID: "w1", URL: url, Secret: "whsec_test"}, "e1", EventPing, []byte(`{}`))
+		if !errors.Is(err, errPrivateAddress) {
+			t.Errorf("expected %s refused as private; got %v", url, err)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("expected the stub never reached; got %d requests", requests)
+	}
+}
+
+// webhookDB serves a fixed set of webhooks and counts the reads.
+type webhookDB struct {
+	database.Service
+	hooks []database.Webhook
+	reads int
+}
+
+func (f *webhookDB) ListWebhooks(projectID string) ([]database.Webhook, error) {
+	f.reads++
+	return f.hooks, nil
+}
+
+type delivery struct {
+	webhookID, eventID, eventType string
+	body                          []byte
+}
+
+// recordingQueue records the deliveries it's asked to queue.
+type recordingQueue struct {
+	deliveries []delivery
+}
+
+func (q *recordingQueue) EnqueueWebhook(ctx context.Context, projectID, webhookID, eventID, eventType string, body []byte) error {
+	q.deliveries = append(q.deliveries, delivery{webhookID, eventID, eventType, body})
+	return nil
+}
+
+func TestDispatcherNotify(t *testing.T) {
+	db := &webhookDB{hooks: []database.Webhook{
+		{ID: "errors", ProjectID: "p1", Enabled: true, Rules: []database.WebhookRule{{EntityType: "event", Levels: []string{"error"}}}},
+		{ID: "all-events", ProjectID: "p1", Enabled: true, Rules: []database.WebhookRule{{EntityType: "event"}}},
+		{ID: "disabled", ProjectID: "p1", Enabled: false, Rules: []database.WebhookRule{{EntityType: "event"}}},
+	}}
+	q := &recordingQueue{}
+	d := NewDispatcher(db, q)
+
+	d.Notify(context.Background(), "p1", database.EventRequest{ID: "ev1", Level: "error", Name: "boom"})
+	d.Notify(context.Background(), "p1", database.EventRequest{ID: "ev2", Level: "info", Name: "ok"})
+	d.Notify(context.Background(), "p1", database.TraceRequest{ID: "t1"})
+
+	if len(q.deliveries) != 3 {
+		t.Fatalf("expected 3 deliveries; got %+v", q.deliveries)
+	}
+	first, second := q.deliveries[0], q.deliveries[1]
+	if first.webhookID != "errors" || second.webhookID != "all-events" || first.eventID != second.eventID {
+		t.Errorf("expected ev1 sent to both matching webhooks as one event; got %+v", q.deliveries[:2])
+	}
+
+	var event Event
+	if err := json.Unmarshal(first.body, &event); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	if event.ID != first.eventID || event.Type != "event.ingested" || event.ProjectID != "p1" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if data := event.Data.(map[string]any); data["id"] != "ev1" {
+		t.Errorf("expected the event to carry ev1; got %v", event.Data)
+	}
+
+	if db.reads != 1 {
+		t.Errorf("expected the webhooks cached; read %d times", db.reads)
+	}
+	d.Forget("p1")
+	d.Notify(context.Background(), "p1", database.TraceRequest{ID: "t2"})
+	if db.reads != 2 {
+		t.Errorf("expected Forget to drop the cache; read %d times", db.reads)
+	}
+}
@@ -0,0 +1,55 @@
+-- +goose Up
+SET search_path TO langlite, public;
+
+-- Cost reported by the SDK for a generation
+ALTER TABLE generations ADD COLUMN IF NOT EXISTS total_cost NUMERIC(18,6);
+
+-- Sessions rollup, maintained incrementally as traces and generations are written
+CREATE TABLE IF NOT EXISTS sessions (
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255),
+    first_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+    trace_count INTEGER NOT NULL DEFAULT 0,
+    total_tokens BIGINT NOT NULL DEFAULT 0,
+    total_cost NUMERIC(18,6) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (project_id, id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_project_last_seen ON sessions(project_id, last_seen DESC);
+CREATE INDEX IF NOT EXISTS idx_sessions_project_user_id ON sessions(project_id, user_id);
+
+CREATE INDEX IF NOT EXISTS idx_traces_project_session ON traces(project_id, session_id, start_time);
+
+-- Backfill sessions from existing traces
+INSERT INTO sessions (project_id, id, user_id, first_seen, last_seen, trace_count, total_tokens, total_cost)
+SELECT t.project_id,
+       t.session_id,
+       (ARRAY_AGG(t.user_id ORDER BY t.start_time DESC) FILTER (WHERE t.user_id <> ''))[1],
+       MIN(t.start_time),
+       GREATEST(MAX(COALESCE(t.end_time, t.start_time)), MAX(g.last_seen)),
+       COUNT(*),
+       COALESCE(SUM(g.total_tokens), 0),
+       COALESCE(SUM(g.total_cost), 0)
+FROM traces t
+LEFT JOIN (
+    SELECT trace_id,
+           SUM(COALESCE(NULLIF(total_tokens, 0), COALESCE(prompt_tokens, 0) + COALESCE(completion_tokens, 0))) AS total_tokens,
+           SUM(COALESCE(total_cost, 0)) AS total_cost,
+           MAX(COALESCE(end_time, start_time)) AS last_seen
+    FROM generations
+    GROUP BY trace_id
+) g ON g.trace_id = t.id
+WHERE t.project_id IS NOT NULL AND t.session_id IS NOT NULL AND t.session_id <> ''
+GROUP BY t.project_id, t.session_id
+ON CONFLICT (project_id, id) DO NOTHING;
+
+-- +goose Down
+SET search_path TO langlite, public;
+
+DROP INDEX IF EXISTS idx_traces_project_session;
+DROP TABLE IF EXISTS sessions;
+ALTER TABLE generations DROP COLUMN IF EXISTS total_cost;
@@ -0,0 +1,14 @@
+-- +goose Up
+SET search_path TO langlite, public;
+
+-- Per-user analytics scan a user's traces within a time window
+CREATE INDEX IF NOT EXISTS idx_traces_project_user_start ON traces(project_id, user_id, start_time);
+
+-- Error events are counted per trace
+CREATE INDEX IF NOT EXISTS idx_events_trace_id_errors ON events(trace_id) WHERE level = 'error';
+
+-- +goose Down
+SET search_path TO langlite, public;
+
+DROP INDEX IF EXISTS idx_events_trace_id_errors;
+DROP INDEX IF EXISTS idx_traces_project_user_start;
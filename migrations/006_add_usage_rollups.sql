@@ -0,0 +1,46 @@
+-- +goose Up
+SET search_path TO langlite, public;
+
+-- Hourly usage rollups per model, span type, trace name and tag. Refreshed by
+-- the refresh_rollups job; queried by GET /api/v1/analytics/timeseries.
+CREATE TABLE IF NOT EXISTS usage_rollups (
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    dimension VARCHAR(20) NOT NULL CHECK (dimension IN ('model', 'span_type', 'trace_name', 'tag')),
+    dimension_value TEXT NOT NULL,
+    bucket_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    item_count BIGINT NOT NULL DEFAULT 0,
+    latency_count BIGINT NOT NULL DEFAULT 0,
+    latency_sum_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    latency_max_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    latency_histogram BIGINT[] NOT NULL DEFAULT '{}',
+    prompt_tokens BIGINT NOT NULL DEFAULT 0,
+    completion_tokens BIGINT NOT NULL DEFAULT 0,
+    total_tokens BIGINT NOT NULL DEFAULT 0,
+    total_cost NUMERIC(18,6) NOT NULL DEFAULT 0,
+    error_count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (project_id, dimension, dimension_value, bucket_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_rollups_project_dimension_bucket ON usage_rollups(project_id, dimension, bucket_start);
+
+-- How far each rollup has consumed source rows, by created_at/updated_at
+CREATE TABLE IF NOT EXISTS rollup_watermarks (
+    name VARCHAR(64) PRIMARY KEY,
+    watermark TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Finding the hours touched since the last refresh
+CREATE INDEX IF NOT EXISTS idx_traces_created_at ON traces(created_at);
+CREATE INDEX IF NOT EXISTS idx_generations_created_at ON generations(created_at);
+CREATE INDEX IF NOT EXISTS idx_spans_updated_at ON spans(updated_at);
+CREATE INDEX IF NOT EXISTS idx_events_created_at ON events(created_at);
+
+-- +goose Down
+SET search_path TO langlite, public;
+
+DROP INDEX IF EXISTS idx_events_created_at;
+DROP INDEX IF EXISTS idx_spans_updated_at;
+DROP INDEX IF EXISTS idx_generations_created_at;
+DROP INDEX IF EXISTS idx_traces_created_at;
+DROP TABLE IF EXISTS rollup_watermarks;
+DROP TABLE IF EXISTS usage_rollups;
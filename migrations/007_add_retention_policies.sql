@@ -0,0 +1,61 @@
+-- +goose Up
+SET search_path TO langlite, public;
+
+-- Per-project retention in days. Entities without a row are kept forever.
+CREATE TABLE IF NOT EXISTS retention_policies (
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    entity VARCHAR(20) NOT NULL CHECK (entity IN ('traces', 'spans', 'generations', 'events', 'scores')),
+    retention_days INTEGER NOT NULL CHECK (retention_days > 0),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (project_id, entity)
+);
+
+-- Scores carry their project so they can outlive the trace or generation
+-- they were given to, which is detached instead of cascading.
+ALTER TABLE scores ADD COLUMN IF NOT EXISTS project_id VARCHAR(255) REFERENCES projects(id) ON DELETE CASCADE;
+
+UPDATE scores s
+SET project_id = COALESCE(
+    (SELECT t.project_id FROM traces t WHERE t.id = s.trace_id),
+    (SELECT t.project_id FROM generations g JOIN traces t ON t.id = g.trace_id WHERE g.id = s.generation_id)
+)
+WHERE s.project_id IS NULL;
+
+ALTER TABLE scores DROP CONSTRAINT IF EXISTS scores_trace_id_fkey;
+ALTER TABLE scores ADD CONSTRAINT scores_trace_id_fkey
+    FOREIGN KEY (trace_id) REFERENCES traces(id) ON DELETE SET NULL;
+ALTER TABLE scores DROP CONSTRAINT IF EXISTS scores_generation_id_fkey;
+ALTER TABLE scores ADD CONSTRAINT scores_generation_id_fkey
+    FOREIGN KEY (generation_id) REFERENCES generations(id) ON DELETE SET NULL;
+ALTER TABLE scores DROP CONSTRAINT IF EXISTS scores_reference_check;
+ALTER TABLE scores ADD CONSTRAINT scores_reference_check CHECK (
+    trace_id IS NOT NULL OR generation_id IS NOT NULL OR project_id IS NOT NULL
+);
+
+-- Purges walk each project's oldest rows first
+CREATE INDEX IF NOT EXISTS idx_traces_project_start_time ON traces(project_id, start_time);
+CREATE INDEX IF NOT EXISTS idx_scores_project_timestamp ON scores(project_id, timestamp);
+
+-- +goose Down
+SET search_path TO langlite, public;
+
+DROP INDEX IF EXISTS idx_scores_project_timestamp;
+DROP INDEX IF EXISTS idx_traces_project_start_time;
+
+DELETE FROM scores WHERE trace_id IS NULL AND generation_id IS NULL;
+ALTER TABLE scores DROP CONSTRAINT IF EXISTS scores_reference_check;
+ALTER TABLE scores ADD CONSTRAINT scores_reference_check CHECK (
+    (trace_id IS NOT NULL AND generation_id IS NULL) OR
+    (trace_id IS NULL AND generation_id IS NOT NULL) OR
+    (trace_id IS NOT NULL AND generation_id IS NOT NULL)
+);
+ALTER TABLE scores DROP CONSTRAINT IF EXISTS scores_generation_id_fkey;
+ALTER TABLE scores ADD CONSTRAINT scores_generation_id_fkey
+    FOREIGN KEY (generation_id) REFERENCES generations(id) ON DELETE CASCADE;
+ALTER TABLE scores DROP CONSTRAINT IF EXISTS scores_trace_id_fkey;
+ALTER TABLE scores ADD CONSTRAINT scores_trace_id_fkey
+    FOREIGN KEY (trace_id) REFERENCES traces(id) ON DELETE CASCADE;
+ALTER TABLE scores DROP COLUMN IF EXISTS project_id;
+
+DROP TABLE IF EXISTS retention_policies;
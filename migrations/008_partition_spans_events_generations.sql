@@ -0,0 +1,252 @@
+-- +goose Up
+SET search_path TO langlite, public;
+
+-- Generations, spans and events become monthly range partitions on their start
+-- time, named <table>_pYYYY_MM, plus a <table>_default partition for rows
+-- outside them. The server creates future partitions and drops expired ones.
+--
+-- A partitioned table's primary key has to include the partition key, so
+-- nothing can reference generations(id) or spans(id) any more: the
+-- scores.generation_id, spans.parent_id and events.span_id foreign keys are
+-- dropped and ingestion checks those references and id uniqueness instead.
+-- References to traces(id) keep cascading.
+ALTER TABLE scores DROP CONSTRAINT IF EXISTS scores_generation_id_fkey;
+
+ALTER TABLE events RENAME TO events_legacy;
+ALTER INDEX events_pkey RENAME TO events_legacy_pkey;
+ALTER TABLE spans RENAME TO spans_legacy;
+ALTER INDEX spans_pkey RENAME TO spans_legacy_pkey;
+ALTER TABLE generations RENAME TO generations_legacy;
+ALTER INDEX generations_pkey RENAME TO generations_legacy_pkey;
+
+CREATE TABLE generations (
+    id VARCHAR(255) NOT NULL,
+    trace_id VARCHAR(255) NOT NULL REFERENCES traces(id) ON DELETE CASCADE,
+    name VARCHAR(255),
+    input TEXT NOT NULL,
+    output TEXT,
+    model VARCHAR(255) NOT NULL,
+    prompt_tokens INTEGER,
+    completion_tokens INTEGER,
+    total_tokens INTEGER,
+    total_cost NUMERIC(18,6),
+    metadata JSONB,
+    start_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    end_time TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, start_time)
+) PARTITION BY RANGE (start_time);
+
+CREATE TABLE spans (
+    id VARCHAR(255) NOT NULL,
+    trace_id VARCHAR(255) NOT NULL REFERENCES traces(id) ON DELETE CASCADE,
+    parent_id VARCHAR(255),
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(50),
+    metadata JSONB,
+    start_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    end_time TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, start_time)
+) PARTITION BY RANGE (start_time);
+
+CREATE TABLE events (
+    id VARCHAR(255) NOT NULL,
+    trace_id VARCHAR(255) NOT NULL REFERENCES traces(id) ON DELETE CASCADE,
+    span_id VARCHAR(255),
+    name VARCHAR(255) NOT NULL,
+    level VARCHAR(20) NOT NULL DEFAULT 'info',
+    message TEXT NOT NULL,
+    metadata JSONB,
+    timestamp TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, timestamp)
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE generations_default PARTITION OF generations DEFAULT;
+CREATE TABLE spans_default PARTITION OF spans DEFAULT;
+CREATE TABLE events_default PARTITION OF events DEFAULT;
+
+-- Monthly partitions for the last two years of existing data through three
+-- months ahead. Anything older lands in the default partition.
+-- +goose StatementBegin
+DO $$
+DECLARE
+    t RECORD;
+    oldest DATE;
+    bucket DATE;
+    horizon DATE := (date_trunc('month', now() AT TIME ZONE 'UTC') + interval '3 months')::date;
+BEGIN
+    FOR t IN SELECT * FROM (VALUES ('generations', 'start_time'), ('spans', 'start_time'), ('events', 'timestamp')) AS v(name, key) LOOP
+        EXECUTE format('SELECT date_trunc(''month'', MIN(%I) AT TIME ZONE ''UTC'')::date FROM %I', t.key, t.name || '_legacy')
+            INTO oldest;
+
+        bucket := GREATEST(
+            COALESCE(oldest, date_trunc('month', now() AT TIME ZONE 'UTC')::date),
+            (date_trunc('month', now() AT TIME ZONE 'UTC') - interval '24 months')::date
+        );
+
+        WHILE bucket < horizon LOOP
+            EXECUTE format('CREATE TABLE %I PARTITION OF %I FOR VALUES FROM (%L) TO (%L)',
+                t.name || '_p' || to_char(bucket, 'YYYY_MM'), t.name,
+                bucket::timestamp AT TIME ZONE 'UTC',
+                (bucket + interval '1 month')::timestamp AT TIME ZONE 'UTC');
+            bucket := (bucket + interval '1 month')::date;
+        END LOOP;
+    END LOOP;
+END $$;
+-- +goose StatementEnd
+
+INSERT INTO generations (id, trace_id, name, input, output, model, prompt_tokens, completion_tokens, total_tokens, total_cost, metadata, start_time, end_time, created_at, updated_at)
+SELECT id, trace_id, name, input, output, model, prompt_tokens, completion_tokens, total_tokens, total_cost, metadata, start_time, end_time, created_at, updated_at
+FROM generations_legacy;
+
+INSERT INTO spans (id, trace_id, parent_id, name, type, metadata, start_time, end_time, created_at, updated_at)
+SELECT id, trace_id, parent_id, name, type, metadata, start_time, end_time, created_at, updated_at
+FROM spans_legacy;
+
+INSERT INTO events (id, trace_id, span_id, name, level, message, metadata, timestamp, created_at)
+SELECT id, trace_id, span_id, name, level, message, metadata, timestamp, created_at
+FROM events_legacy;
+
+DROP TABLE events_legacy;
+DROP TABLE spans_legacy;
+DROP TABLE generations_legacy;
+
+CREATE INDEX IF NOT EXISTS idx_generations_trace_id ON generations(trace_id);
+CREATE INDEX IF NOT EXISTS idx_generations_model ON generations(model);
+CREATE INDEX IF NOT EXISTS idx_generations_start_time ON generations(start_time);
+CREATE INDEX IF NOT EXISTS idx_generations_created_at ON generations(created_at);
+
+CREATE INDEX IF NOT EXISTS idx_spans_trace_id ON spans(trace_id);
+CREATE INDEX IF NOT EXISTS idx_spans_parent_id ON spans(parent_id);
+CREATE INDEX IF NOT EXISTS idx_spans_type ON spans(type);
+CREATE INDEX IF NOT EXISTS idx_spans_start_time ON spans(start_time);
+CREATE INDEX IF NOT EXISTS idx_spans_updated_at ON spans(updated_at);
+
+CREATE INDEX IF NOT EXISTS idx_events_trace_id ON events(trace_id);
+CREATE INDEX IF NOT EXISTS idx_events_span_id ON events(span_id);
+CREATE INDEX IF NOT EXISTS idx_events_level ON events(level);
+CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events(timestamp);
+CREATE INDEX IF NOT EXISTS idx_events_trace_id_errors ON events(trace_id) WHERE level = 'error';
+CREATE INDEX IF NOT EXISTS idx_events_created_at ON events(created_at);
+
+-- +goose Down
+SET search_path TO langlite, public;
+
+ALTER TABLE events RENAME TO events_partitioned;
+ALTER INDEX events_pkey RENAME TO events_partitioned_pkey;
+ALTER TABLE spans RENAME TO spans_partitioned;
+ALTER INDEX spans_pkey RENAME TO spans_partitioned_pkey;
+ALTER TABLE generations RENAME TO generations_partitioned;
+ALTER INDEX generations_pkey RENAME TO generations_partitioned_pkey;
+
+DROP INDEX IF EXISTS idx_generations_trace_id;
+DROP INDEX IF EXISTS idx_generations_model;
+DROP INDEX IF EXISTS idx_generations_start_time;
+DROP INDEX IF EXISTS idx_generations_created_at;
+DROP INDEX IF EXISTS idx_spans_trace_id;
+DROP INDEX IF EXISTS idx_spans_parent_id;
+DROP INDEX IF EXISTS idx_spans_type;
+DROP INDEX IF EXISTS idx_spans_start_time;
+DROP INDEX IF EXISTS idx_spans_updated_at;
+DROP INDEX IF EXISTS idx_events_trace_id;
+DROP INDEX IF EXISTS idx_events_span_id;
+DROP INDEX IF EXISTS idx_events_level;
+DROP INDEX IF EXISTS idx_events_timestamp;
+DROP INDEX IF EXISTS idx_events_trace_id_errors;
+DROP INDEX IF EXISTS idx_events_created_at;
+
+CREATE TABLE generations (
+    id VARCHAR(255) PRIMARY KEY,
+    trace_id VARCHAR(255) NOT NULL REFERENCES traces(id) ON DELETE CASCADE,
+    name VARCHAR(255),
+    input TEXT NOT NULL,
+    output TEXT,
+    model VARCHAR(255) NOT NULL,
+    prompt_tokens INTEGER,
+    completion_tokens INTEGER,
+    total_tokens INTEGER,
+    total_cost NUMERIC(18,6),
+    metadata JSONB,
+    start_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    end_time TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE spans (
+    id VARCHAR(255) PRIMARY KEY,
+    trace_id VARCHAR(255) NOT NULL REFERENCES traces(id) ON DELETE CASCADE,
+    parent_id VARCHAR(255),
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(50),
+    metadata JSONB,
+    start_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    end_time TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE events (
+    id VARCHAR(255) PRIMARY KEY,
+    trace_id VARCHAR(255) NOT NULL REFERENCES traces(id) ON DELETE CASCADE,
+    span_id VARCHAR(255),
+    name VARCHAR(255) NOT NULL,
+    level VARCHAR(20) NOT NULL DEFAULT 'info',
+    message TEXT NOT NULL,
+    metadata JSONB,
+    timestamp TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Ids were only unique per start time while partitioned; keep the newest row.
+INSERT INTO generations (id, trace_id, name, input, output, model, prompt_tokens, completion_tokens, total_tokens, total_cost, metadata, start_time, end_time, created_at, updated_at)
+SELECT DISTINCT ON (id) id, trace_id, name, input, output, model, prompt_tokens, completion_tokens, total_tokens, total_cost, metadata, start_time, end_time, created_at, updated_at
+FROM generations_partitioned
+ORDER BY id, created_at DESC;
+
+INSERT INTO spans (id, trace_id, parent_id, name, type, metadata, start_time, end_time, created_at, updated_at)
+SELECT DISTINCT ON (id) id, trace_id, parent_id, name, type, metadata, start_time, end_time, created_at, updated_at
+FROM spans_partitioned
+ORDER BY id, created_at DESC;
+
+INSERT INTO events (id, trace_id, span_id, name, level, message, metadata, timestamp, created_at)
+SELECT DISTINCT ON (id) id, trace_id, span_id, name, level, message, metadata, timestamp, created_at
+FROM events_partitioned
+ORDER BY id, created_at DESC;
+
+DROP TABLE events_partitioned;
+DROP TABLE spans_partitioned;
+DROP TABLE generations_partitioned;
+
+-- Restore the references, clearing any that were left dangling.
+UPDATE spans SET parent_id = NULL WHERE parent_id IS NOT NULL AND parent_id NOT IN (SELECT id FROM spans);
+ALTER TABLE spans ADD CONSTRAINT spans_parent_id_fkey
+    FOREIGN KEY (parent_id) REFERENCES spans(id) ON DELETE CASCADE;
+DELETE FROM events WHERE span_id IS NOT NULL AND span_id NOT IN (SELECT id FROM spans);
+ALTER TABLE events ADD CONSTRAINT events_span_id_fkey
+    FOREIGN KEY (span_id) REFERENCES spans(id) ON DELETE CASCADE;
+UPDATE scores SET generation_id = NULL WHERE generation_id IS NOT NULL AND generation_id NOT IN (SELECT id FROM generations);
+ALTER TABLE scores ADD CONSTRAINT scores_generation_id_fkey
+    FOREIGN KEY (generation_id) REFERENCES generations(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_generations_trace_id ON generations(trace_id);
+CREATE INDEX IF NOT EXISTS idx_generations_model ON generations(model);
+CREATE INDEX IF NOT EXISTS idx_generations_start_time ON generations(start_time);
+CREATE INDEX IF NOT EXISTS idx_generations_created_at ON generations(created_at);
+
+CREATE INDEX IF NOT EXISTS idx_spans_trace_id ON spans(trace_id);
+CREATE INDEX IF NOT EXISTS idx_spans_parent_id ON spans(parent_id);
+CREATE INDEX IF NOT EXISTS idx_spans_type ON spans(type);
+CREATE INDEX IF NOT EXISTS idx_spans_start_time ON spans(start_time);
+CREATE INDEX IF NOT EXISTS idx_spans_updated_at ON spans(updated_at);
+
+CREATE INDEX IF NOT EXISTS idx_events_trace_id ON events(trace_id);
+CREATE INDEX IF NOT EXISTS idx_events_span_id ON events(span_id);
+CREATE INDEX IF NOT EXISTS idx_events_level ON events(level);
+CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events(timestamp);
+CREATE INDEX IF NOT EXISTS idx_events_trace_id_errors ON events(trace_id) WHERE level = 'error';
+CREATE INDEX IF NOT EXISTS idx_events_created_at ON events(created_at);
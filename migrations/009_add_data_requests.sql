@@ -0,0 +1,28 @@
+-- +goose Up
+SET search_path TO langlite, public;
+
+-- Audit trail of end-user data exports and erasures. The user_id is only kept
+-- while an erasure is outstanding; subject_hash identifies the user after.
+CREATE TABLE IF NOT EXISTS data_requests (
+    id VARCHAR(255) PRIMARY KEY,
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('export', 'erasure')),
+    mode VARCHAR(20) CHECK (mode IN ('delete', 'anonymize')),
+    user_id VARCHAR(255),
+    subject_hash VARCHAR(64) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    requested_by VARCHAR(255),
+    receipt JSONB,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_requests_project_created ON data_requests(project_id, created_at);
+
+-- +goose Down
+SET search_path TO langlite, public;
+
+DROP INDEX IF EXISTS idx_data_requests_project_created;
+DROP TABLE IF EXISTS data_requests;
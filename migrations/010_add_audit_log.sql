@@ -0,0 +1,30 @@
+-- +goose Up
+SET search_path TO langlite, public;
+
+-- Trail of administrative and destructive operations. project_id is not a
+-- foreign key so entries outlive the project they refer to.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id VARCHAR(255) PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    actor_type VARCHAR(20) NOT NULL CHECK (actor_type IN ('api_key', 'admin', 'anonymous')),
+    actor_id VARCHAR(255),
+    project_id VARCHAR(255),
+    action VARCHAR(100) NOT NULL,
+    target TEXT,
+    request_id VARCHAR(255),
+    ip VARCHAR(64),
+    outcome VARCHAR(20) NOT NULL CHECK (outcome IN ('success', 'failure', 'denied')),
+    status_code INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_project_created ON audit_log(project_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action_created ON audit_log(action, created_at);
+
+-- +goose Down
+SET search_path TO langlite, public;
+
+DROP INDEX IF EXISTS idx_audit_log_action_created;
+DROP INDEX IF EXISTS idx_audit_log_project_created;
+DROP INDEX IF EXISTS idx_audit_log_created_at;
+DROP TABLE IF EXISTS audit_log;
@@ -0,0 +1,54 @@
+-- +goose Up
+SET search_path TO langlite, public;
+
+-- Jobs of the Postgres queue backend, one row per job whatever its state.
+-- position orders each queue: jobs pushed to the back take increasing
+-- positions, jobs put back at the front decreasing ones.
+CREATE SEQUENCE IF NOT EXISTS queue_job_positions;
+
+CREATE TABLE IF NOT EXISTS queue_jobs (
+    id VARCHAR(255) PRIMARY KEY,
+    queue_name VARCHAR(255) NOT NULL,
+    state VARCHAR(20) NOT NULL CHECK (state IN ('ready', 'delayed', 'running', 'completed', 'dead')),
+    position BIGINT NOT NULL,
+    ready_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    project_id VARCHAR(255),
+    job JSONB NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_queue_jobs_ready ON queue_jobs(queue_name, position) WHERE state = 'ready';
+CREATE INDEX IF NOT EXISTS idx_queue_jobs_delayed ON queue_jobs(ready_at) WHERE state = 'delayed';
+CREATE INDEX IF NOT EXISTS idx_queue_jobs_completed ON queue_jobs(updated_at) WHERE state = 'completed';
+
+-- Workers reported by each worker pool, for /worker-status.
+CREATE TABLE IF NOT EXISTS queue_workers (
+    id VARCHAR(255) PRIMARY KEY,
+    info JSONB NOT NULL,
+    reported_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Leases such as the one held by the instance that fires schedules.
+CREATE TABLE IF NOT EXISTS queue_locks (
+    name VARCHAR(255) PRIMARY KEY,
+    token VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- When each recurring job last ran.
+CREATE TABLE IF NOT EXISTS queue_schedules (
+    name VARCHAR(255) PRIMARY KEY,
+    last_run TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- +goose Down
+SET search_path TO langlite, public;
+
+DROP TABLE IF EXISTS queue_schedules;
+DROP TABLE IF EXISTS queue_locks;
+DROP TABLE IF EXISTS queue_workers;
+DROP INDEX IF EXISTS idx_queue_jobs_completed;
+DROP INDEX IF EXISTS idx_queue_jobs_delayed;
+DROP INDEX IF EXISTS idx_queue_jobs_ready;
+DROP TABLE IF EXISTS queue_jobs;
+DROP SEQUENCE IF EXISTS queue_job_positions;
@@ -0,0 +1,22 @@
+-- +goose Up
+SET search_path TO langlite, public;
+
+-- Fields derived by the enrich_trace job, merged from every enricher that
+-- ran on the trace.
+ALTER TABLE traces ADD COLUMN IF NOT EXISTS enrichment JSONB;
+ALTER TABLE traces ADD COLUMN IF NOT EXISTS enriched_at TIMESTAMP WITH TIME ZONE;
+
+-- The enrichers a project runs on its traces. Projects without a row run
+-- every enricher the deployment offers.
+CREATE TABLE IF NOT EXISTS project_enrichers (
+    project_id VARCHAR(255) PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    enrichers TEXT[] NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+SET search_path TO langlite, public;
+
+DROP TABLE IF EXISTS project_enrichers;
+ALTER TABLE traces DROP COLUMN IF EXISTS enriched_at;
+ALTER TABLE traces DROP COLUMN IF EXISTS enrichment;
@@ -0,0 +1,11 @@
+-- +goose Up
+SET search_path TO langlite, public;
+
+-- Finds the Postgres queue backend's jobs for an ingested item, for
+-- GET /api/v1/ingestion/{id}/status.
+CREATE INDEX IF NOT EXISTS idx_queue_jobs_entity ON queue_jobs(project_id, (job->>'entity_id'));
+
+-- +goose Down
+SET search_path TO langlite, public;
+
+DROP INDEX IF EXISTS idx_queue_jobs_entity;
@@ -41,13 +41,22 @@ CREATE TABLE IF NOT EXISTS traces (
     session_id VARCHAR(255),
     start_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
     end_time TIMESTAMP WITH TIME ZONE,
+    -- Fields derived by the enrich_trace job
+    enrichment JSONB,
+    enriched_at TIMESTAMP WITH TIME ZONE,
     created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 );
 
+-- Generations, spans and events are range partitioned by month on their start
+-- time (<table>_pYYYY_MM). The server creates upcoming partitions; until then
+-- rows land in <table>_default. Partitioned tables can't be referenced by
+-- foreign keys, so ingestion checks references to generations and spans, and
+-- purges and partition drops release references to the rows they delete.
+
 -- Generations table
 CREATE TABLE IF NOT EXISTS generations (
-    id VARCHAR(255) PRIMARY KEY,
+    id VARCHAR(255) NOT NULL,
     trace_id VARCHAR(255) NOT NULL REFERENCES traces(id) ON DELETE CASCADE,
     name VARCHAR(255),
     input TEXT NOT NULL,
@@ -56,45 +65,56 @@ CREATE TABLE IF NOT EXISTS generations (
     prompt_tokens INTEGER,
     completion_tokens INTEGER,
     total_tokens INTEGER,
+    total_cost NUMERIC(18,6),
     metadata JSONB,
     start_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
     end_time TIMESTAMP WITH TIME ZONE,
     created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
-    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
-);
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, start_time)
+) PARTITION BY RANGE (start_time);
+
+CREATE TABLE IF NOT EXISTS generations_default PARTITION OF generations DEFAULT;
 
 -- Spans table
 CREATE TABLE IF NOT EXISTS spans (
-    id VARCHAR(255) PRIMARY KEY,
+    id VARCHAR(255) NOT NULL,
     trace_id VARCHAR(255) NOT NULL REFERENCES traces(id) ON DELETE CASCADE,
-    parent_id VARCHAR(255) REFERENCES spans(id) ON DELETE CASCADE,
+    parent_id VARCHAR(255),
     name VARCHAR(255) NOT NULL,
     type VARCHAR(50),
     metadata JSONB,
     start_time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
     end_time TIMESTAMP WITH TIME ZONE,
     created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
-    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
-);
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, start_time)
+) PARTITION BY RANGE (start_time);
+
+CREATE TABLE IF NOT EXISTS spans_default PARTITION OF spans DEFAULT;
 
 -- Events table
 CREATE TABLE IF NOT EXISTS events (
-    id VARCHAR(255) PRIMARY KEY,
+    id VARCHAR(255) NOT NULL,
     trace_id VARCHAR(255) NOT NULL REFERENCES traces(id) ON DELETE CASCADE,
-    span_id VARCHAR(255) REFERENCES spans(id) ON DELETE CASCADE,
+    span_id VARCHAR(255),
     name VARCHAR(255) NOT NULL,
     level VARCHAR(20) NOT NULL DEFAULT 'info',
     message TEXT NOT NULL,
     metadata JSONB,
     timestamp TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
-    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
-);
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, timestamp)
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE IF NOT EXISTS events_default PARTITION OF events DEFAULT;
 
 -- Scores table
 CREATE TABLE IF NOT EXISTS scores (
     id VARCHAR(255) PRIMARY KEY,
-    trace_id VARCHAR(255) REFERENCES traces(id) ON DELETE CASCADE,
-    generation_id VARCHAR(255) REFERENCES generations(id) ON DELETE CASCADE,
+    project_id VARCHAR(255) REFERENCES projects(id) ON DELETE CASCADE,
+    trace_id VARCHAR(255) REFERENCES traces(id) ON DELETE SET NULL,
+    generation_id VARCHAR(255),
     name VARCHAR(255) NOT NULL,
     value DECIMAL(5,4) NOT NULL CHECK (value >= 0 AND value <= 1),
     source VARCHAR(50) NOT NULL DEFAULT 'human',
@@ -102,13 +122,175 @@ CREATE TABLE IF NOT EXISTS scores (
     metadata JSONB,
     timestamp TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
     created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    -- trace_id is cleared when retention purges the trace
     CONSTRAINT scores_reference_check CHECK (
-        (trace_id IS NOT NULL AND generation_id IS NULL) OR
-        (trace_id IS NULL AND generation_id IS NOT NULL) OR
-        (trace_id IS NOT NULL AND generation_id IS NOT NULL)
+        trace_id IS NOT NULL OR generation_id IS NOT NULL OR project_id IS NOT NULL
     )
 );
 
+-- Sessions rollup, maintained incrementally as traces and generations are written
+CREATE TABLE IF NOT EXISTS sessions (
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255),
+    first_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+    trace_count INTEGER NOT NULL DEFAULT 0,
+    total_tokens BIGINT NOT NULL DEFAULT 0,
+    total_cost NUMERIC(18,6) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (project_id, id)
+);
+
+-- Hourly usage rollups per model, span type, trace name and tag
+CREATE TABLE IF NOT EXISTS usage_rollups (
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    dimension VARCHAR(20) NOT NULL CHECK (dimension IN ('model', 'span_type', 'trace_name', 'tag')),
+    dimension_value TEXT NOT NULL,
+    bucket_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    item_count BIGINT NOT NULL DEFAULT 0,
+    latency_count BIGINT NOT NULL DEFAULT 0,
+    latency_sum_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    latency_max_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    latency_histogram BIGINT[] NOT NULL DEFAULT '{}',
+    prompt_tokens BIGINT NOT NULL DEFAULT 0,
+    completion_tokens BIGINT NOT NULL DEFAULT 0,
+    total_tokens BIGINT NOT NULL DEFAULT 0,
+    total_cost NUMERIC(18,6) NOT NULL DEFAULT 0,
+    error_count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (project_id, dimension, dimension_value, bucket_start)
+);
+
+-- How far each rollup has consumed source rows
+CREATE TABLE IF NOT EXISTS rollup_watermarks (
+    name VARCHAR(64) PRIMARY KEY,
+    watermark TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Per-project retention in days. Entities without a row are kept forever.
+CREATE TABLE IF NOT EXISTS retention_policies (
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    entity VARCHAR(20) NOT NULL CHECK (entity IN ('traces', 'spans', 'generations', 'events', 'scores')),
+    retention_days INTEGER NOT NULL CHECK (retention_days > 0),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (project_id, entity)
+);
+
+-- Audit trail of end-user data exports and erasures. The user_id is only kept
+-- while an erasure is outstanding; subject_hash identifies the user after.
+CREATE TABLE IF NOT EXISTS data_requests (
+    id VARCHAR(255) PRIMARY KEY,
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('export', 'erasure')),
+    mode VARCHAR(20) CHECK (mode IN ('delete', 'anonymize')),
+    user_id VARCHAR(255),
+    subject_hash VARCHAR(64) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    requested_by VARCHAR(255),
+    receipt JSONB,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Trail of administrative and destructive operations. project_id is not a
+-- foreign key so entries outlive the project they refer to.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id VARCHAR(255) PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    actor_type VARCHAR(20) NOT NULL CHECK (actor_type IN ('api_key', 'admin', 'anonymous')),
+    actor_id VARCHAR(255),
+    project_id VARCHAR(255),
+    action VARCHAR(100) NOT NULL,
+    target TEXT,
+    request_id VARCHAR(255),
+    ip VARCHAR(64),
+    outcome VARCHAR(20) NOT NULL CHECK (outcome IN ('success', 'failure', 'denied')),
+    status_code INTEGER
+);
+
+-- The enrichers a project runs on its traces. Projects without a row run
+-- every enricher the deployment offers.
+CREATE TABLE IF NOT EXISTS project_enrichers (
+    project_id VARCHAR(255) PRIMARY KEY REFERENCES projects(id) ON DELETE CASCADE,
+    enrichers TEXT[] NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Per-project subscriptions to ingested items matching any of their rules.
+-- secret signs deliveries. consecutive_failures counts failed delivery
+-- attempts since the last success; too many disable the webhook.
+CREATE TABLE IF NOT EXISTS webhooks (
+    id VARCHAR(255) PRIMARY KEY,
+    project_id VARCHAR(255) NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    description TEXT,
+    rules JSONB NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    disabled_at TIMESTAMP WITH TIME ZONE,
+    disabled_reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- One row per delivery attempt. The purge_retention job deletes rows older
+-- than 30 days.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id VARCHAR(255) PRIMARY KEY,
+    webhook_id VARCHAR(255) NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    project_id VARCHAR(255) NOT NULL,
+    event_id VARCHAR(255) NOT NULL,
+    event_type VARCHAR(100) NOT NULL,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER,
+    success BOOLEAN NOT NULL,
+    error TEXT,
+    response TEXT,
+    duration_ms BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Jobs of the Postgres queue backend, one row per job whatever its state.
+-- position orders each queue: jobs pushed to the back take increasing
+-- positions, jobs put back at the front decreasing ones.
+CREATE SEQUENCE IF NOT EXISTS queue_job_positions;
+
+CREATE TABLE IF NOT EXISTS queue_jobs (
+    id VARCHAR(255) PRIMARY KEY,
+    queue_name VARCHAR(255) NOT NULL,
+    state VARCHAR(20) NOT NULL CHECK (state IN ('ready', 'delayed', 'running', 'completed', 'dead')),
+    position BIGINT NOT NULL,
+    ready_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    project_id VARCHAR(255),
+    job JSONB NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Workers reported by each worker pool, for /worker-status.
+CREATE TABLE IF NOT EXISTS queue_workers (
+    id VARCHAR(255) PRIMARY KEY,
+    info JSONB NOT NULL,
+    reported_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Leases such as the one held by the instance that fires schedules.
+CREATE TABLE IF NOT EXISTS queue_locks (
+    name VARCHAR(255) PRIMARY KEY,
+    token VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- When each recurring job last ran.
+CREATE TABLE IF NOT EXISTS queue_schedules (
+    name VARCHAR(255) PRIMARY KEY,
+    last_run TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
 -- Indexes for better query performance
 CREATE INDEX IF NOT EXISTS idx_projects_name ON projects(name);
 
@@ -121,22 +303,51 @@ CREATE INDEX IF NOT EXISTS idx_traces_project_id ON traces(project_id);
 CREATE INDEX IF NOT EXISTS idx_traces_user_id ON traces(user_id);
 CREATE INDEX IF NOT EXISTS idx_traces_session_id ON traces(session_id);
 CREATE INDEX IF NOT EXISTS idx_traces_start_time ON traces(start_time);
+CREATE INDEX IF NOT EXISTS idx_traces_project_session ON traces(project_id, session_id, start_time);
+CREATE INDEX IF NOT EXISTS idx_traces_project_user_start ON traces(project_id, user_id, start_time);
+CREATE INDEX IF NOT EXISTS idx_traces_created_at ON traces(created_at);
+CREATE INDEX IF NOT EXISTS idx_traces_project_start_time ON traces(project_id, start_time);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_project_last_seen ON sessions(project_id, last_seen DESC);
+CREATE INDEX IF NOT EXISTS idx_sessions_project_user_id ON sessions(project_id, user_id);
+
+CREATE INDEX IF NOT EXISTS idx_data_requests_project_created ON data_requests(project_id, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_project_created ON audit_log(project_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action_created ON audit_log(action, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_usage_rollups_project_dimension_bucket ON usage_rollups(project_id, dimension, bucket_start);
 
 CREATE INDEX IF NOT EXISTS idx_generations_trace_id ON generations(trace_id);
 CREATE INDEX IF NOT EXISTS idx_generations_model ON generations(model);
 CREATE INDEX IF NOT EXISTS idx_generations_start_time ON generations(start_time);
+CREATE INDEX IF NOT EXISTS idx_generations_created_at ON generations(created_at);
 
 CREATE INDEX IF NOT EXISTS idx_spans_trace_id ON spans(trace_id);
 CREATE INDEX IF NOT EXISTS idx_spans_parent_id ON spans(parent_id);
 CREATE INDEX IF NOT EXISTS idx_spans_type ON spans(type);
 CREATE INDEX IF NOT EXISTS idx_spans_start_time ON spans(start_time);
+CREATE INDEX IF NOT EXISTS idx_spans_updated_at ON spans(updated_at);
 
 CREATE INDEX IF NOT EXISTS idx_events_trace_id ON events(trace_id);
 CREATE INDEX IF NOT EXISTS idx_events_span_id ON events(span_id);
 CREATE INDEX IF NOT EXISTS idx_events_level ON events(level);
 CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events(timestamp);
+CREATE INDEX IF NOT EXISTS idx_events_trace_id_errors ON events(trace_id) WHERE level = 'error';
+CREATE INDEX IF NOT EXISTS idx_events_created_at ON events(created_at);
 
 CREATE INDEX IF NOT EXISTS idx_scores_trace_id ON scores(trace_id);
 CREATE INDEX IF NOT EXISTS idx_scores_generation_id ON scores(generation_id);
 CREATE INDEX IF NOT EXISTS idx_scores_name ON scores(name);
 CREATE INDEX IF NOT EXISTS idx_scores_timestamp ON scores(timestamp);
+CREATE INDEX IF NOT EXISTS idx_scores_project_timestamp ON scores(project_id, timestamp);
+
+CREATE INDEX IF NOT EXISTS idx_queue_jobs_ready ON queue_jobs(queue_name, position) WHERE state = 'ready';
+CREATE INDEX IF NOT EXISTS idx_queue_jobs_delayed ON queue_jobs(ready_at) WHERE state = 'delayed';
+CREATE INDEX IF NOT EXISTS idx_queue_jobs_completed ON queue_jobs(updated_at) WHERE state = 'completed';
+CREATE INDEX IF NOT EXISTS idx_queue_jobs_entity ON queue_jobs(project_id, (job->>'entity_id'));
+
+CREATE INDEX IF NOT EXISTS idx_webhooks_project_id ON webhooks(project_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_created ON webhook_deliveries(webhook_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_created_at ON webhook_deliveries(created_at);